@@ -0,0 +1,431 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+
+	"unit2/leprechaun/internal/ringbuffer"
+)
+
+// strategyAllocator splits the position size of an asset between the strategies trading it with an epsilon-greedy
+// bandit. The strategies with the best expectancy over their recent closed positions share most of the weight, and
+// StrategyEpsilon of it is spread evenly over all of them, so the ones behind keep being tried and can take over once
+// they improve. Strategies without closed positions yet are treated as the best, so each gets tried.
+type strategyAllocator struct {
+	mu       sync.Mutex
+	names    []string                               // descriptions of the strategies, in the order they were set
+	epsilon  float64                                // share of the weight spread evenly over the strategies
+	outcomes map[string]*ringbuffer.Buffer[float64] // profit of the recent closed positions of each strategy
+	override map[string]float64                     // weights set by the user, or nil
+}
+
+func newStrategyAllocator(names []string, epsilon float64, window int) *strategyAllocator {
+	if window <= 0 {
+		window = 1
+	}
+	a := &strategyAllocator{names: names, epsilon: epsilon, outcomes: make(map[string]*ringbuffer.Buffer[float64])}
+	for _, name := range names {
+		a.outcomes[name] = ringbuffer.New[float64](window)
+	}
+	return a
+}
+
+// expectancyLocked returns the mean profit of the recent closed positions of strategy `name`, and false if it
+// has none.
+func (a *strategyAllocator) expectancyLocked(name string) (float64, bool) {
+	outcomes := a.outcomes[name]
+	if outcomes == nil || outcomes.Len() == 0 {
+		return 0, false
+	}
+	var total float64
+	for _, profit := range outcomes.Slice() {
+		total += profit
+	}
+	return total / float64(outcomes.Len()), true
+}
+
+func (a *strategyAllocator) weightsLocked() map[string]float64 {
+	weights := make(map[string]float64, len(a.names))
+	if a.override != nil {
+		for name, weight := range a.override {
+			weights[name] = weight
+		}
+		return weights
+	}
+	var leaders, untried []string
+	best := math.Inf(-1)
+	for _, name := range a.names {
+		expectancy, ok := a.expectancyLocked(name)
+		switch {
+		case !ok:
+			untried = append(untried, name)
+		case expectancy > best:
+			leaders, best = []string{name}, expectancy
+		case expectancy == best:
+			leaders = append(leaders, name)
+		}
+	}
+	if len(untried) > 0 {
+		leaders = untried
+	}
+	for _, name := range a.names {
+		weights[name] = a.epsilon / float64(len(a.names))
+	}
+	for _, name := range leaders {
+		weights[name] += (1 - a.epsilon) / float64(len(leaders))
+	}
+	return weights
+}
+
+// weights returns the share of the position size each strategy gets, by description. The shares add up to 1.
+func (a *strategyAllocator) weights() map[string]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.weightsLocked()
+}
+
+// observe records the profit of a closed position opened on strategy `name`. It returns the weights before and
+// after, and false if `name` isn't one of the strategies.
+func (a *strategyAllocator) observe(name string, profit float64) (before, after map[string]float64, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	outcomes := a.outcomes[name]
+	if outcomes == nil {
+		return nil, nil, false
+	}
+	before = a.weightsLocked()
+	outcomes.Push(profit)
+	return before, a.weightsLocked(), true
+}
+
+// setOverride replaces the bandit's weights with `weights`, which are scaled to add up to 1. Strategies left out
+// get no weight. A nil map hands the allocation back to the bandit.
+func (a *strategyAllocator) setOverride(weights map[string]float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if weights == nil {
+		a.override = nil
+		return nil
+	}
+	var total float64
+	for name, weight := range weights {
+		if _, ok := a.outcomes[name]; !ok {
+			return fmt.Errorf("%q is not one of the strategies", name)
+		}
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return fmt.Errorf("the weight of %q must not be negative, not %v", name, weight)
+		}
+		total += weight
+	}
+	if total <= 0 {
+		return fmt.Errorf("at least one strategy needs a weight above zero")
+	}
+	a.override = make(map[string]float64, len(a.names))
+	for _, name := range a.names {
+		a.override[name] = weights[name] / total
+	}
+	return nil
+}
+
+// choose returns the index of the strategy whose signal among `signals` is acted on: the one with the most weight
+// among those not waiting. The position is sized at `size` times the purchase unit, the strategy's weight
+// relative to the heaviest one, so the leading strategy trades the full unit. `chosen` is -1 if every strategy
+// waits or only strategies without weight signal.
+func (a *strategyAllocator) choose(signals []SIGNAL) (chosen int, size float64) {
+	weights := a.weights()
+	var heaviest float64
+	for _, weight := range weights {
+		heaviest = math.Max(heaviest, weight)
+	}
+	chosen = -1
+	for i, signal := range signals {
+		weight := weights[a.names[i]]
+		if signal == SignalWait || weight <= 0 {
+			continue
+		}
+		if chosen < 0 || weight > weights[a.names[chosen]] {
+			chosen = i
+		}
+	}
+	if chosen < 0 || heaviest <= 0 {
+		return -1, 0
+	}
+	return chosen, weights[a.names[chosen]] / heaviest
+}
+
+// formatWeights describes the weight of each of `names`, e.g. "MACD crossover 90%, RSI 10%".
+func formatWeights(names []string, weights map[string]float64) string {
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", name, weights[name]*100))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// strategySet is the analyzer of an asset traded by several strategies. It passes the market data to each of them
+// and emits the signal of the one the allocator chooses. See Portfolio.SetStrategies.
+type strategySet struct {
+	strategies []Analyzer
+	names      []string
+	allocator  *strategyAllocator
+
+	mu      sync.Mutex
+	signals []SIGNAL // signal of each strategy in the last round
+	acting  int      // strategy whose signal was emitted in the last round, or -1
+	size    float64  // share of the purchase unit the acting strategy trades
+}
+
+func newStrategySet(strategies []Analyzer, epsilon float64, window int) *strategySet {
+	names := make([]string, len(strategies))
+	for i, strategy := range strategies {
+		names[i] = strategy.Description()
+	}
+	return &strategySet{strategies: strategies, names: names, allocator: newStrategyAllocator(names, epsilon, window),
+		acting: -1}
+}
+
+// Emit asks every strategy for its signal. A strategy that fails waits for the round.
+func (s *strategySet) Emit(ctx context.Context) (SIGNAL, error) {
+	signals := make([]SIGNAL, len(s.strategies))
+	for i, strategy := range s.strategies {
+		signal, err := strategy.Emit(ctx)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return SignalWait, ctxErr
+		}
+		if err != nil {
+			log.Printf("Strategy %q could not emit a signal: %v", s.names[i], err)
+			signal = SignalWait
+		}
+		signals[i] = signal
+	}
+	chosen, size := s.allocator.choose(signals)
+	s.mu.Lock()
+	s.signals, s.acting, s.size = signals, chosen, size
+	s.mu.Unlock()
+	if chosen < 0 {
+		return SignalWait, nil
+	}
+	return signals[chosen], nil
+}
+
+func (s *strategySet) SetClosingPrices(prices []float64) error {
+	return s.each(func(strategy Analyzer) error { return strategy.SetClosingPrices(prices) })
+}
+
+func (s *strategySet) SetOHLC(candles []OHLC) error {
+	return s.each(func(strategy Analyzer) error { return strategy.SetOHLC(candles) })
+}
+
+func (s *strategySet) SetCurrentPrice(price float64) error {
+	return s.each(func(strategy Analyzer) error { return strategy.SetCurrentPrice(price) })
+}
+
+func (s *strategySet) SetOptions(opts *AnalysisOptions) error {
+	return s.each(func(strategy Analyzer) error { return strategy.SetOptions(opts) })
+}
+
+// SetContext passes the market context on to the strategies that take it into account.
+func (s *strategySet) SetContext(market MarketContext) error {
+	return s.each(func(strategy Analyzer) error {
+		if cxAnalyzer, ok := strategy.(ContextAnalyzer); ok {
+			return cxAnalyzer.SetContext(market)
+		}
+		return nil
+	})
+}
+
+func (s *strategySet) Description() string {
+	return "Strategies: " + strings.Join(s.names, ", ")
+}
+
+// Lookback returns the longest lookback of the strategies.
+func (s *strategySet) Lookback() int {
+	var lookback int
+	for _, strategy := range s.strategies {
+		if l := strategy.Lookback(); l > lookback {
+			lookback = l
+		}
+	}
+	return lookback
+}
+
+// Indicators returns the indicators of the strategy whose signal was emitted, if it reports them.
+func (s *strategySet) Indicators() map[string]float64 {
+	s.mu.Lock()
+	acting := s.acting
+	s.mu.Unlock()
+	if acting < 0 {
+		return nil
+	}
+	if reporter, ok := s.strategies[acting].(IndicatorAnalyzer); ok {
+		return reporter.Indicators()
+	}
+	return nil
+}
+
+// Confidence returns the confidence of the strategy whose signal was emitted, or zero if it doesn't report it.
+func (s *strategySet) Confidence() float64 {
+	s.mu.Lock()
+	acting := s.acting
+	s.mu.Unlock()
+	if acting < 0 {
+		return 0
+	}
+	if confident, ok := s.strategies[acting].(ConfidentAnalyzer); ok {
+		return confident.Confidence()
+	}
+	return 0
+}
+
+func (s *strategySet) each(set func(strategy Analyzer) error) error {
+	for i, strategy := range s.strategies {
+		if err := set(strategy); err != nil {
+			return fmt.Errorf("strategy %q: %w", s.names[i], err)
+		}
+	}
+	return nil
+}
+
+// acted returns the strategy whose signal was emitted in the last round and the share of the purchase unit it
+// trades, or false if every strategy waited.
+func (s *strategySet) acted() (name string, size float64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.acting < 0 {
+		return "", 0, false
+	}
+	return s.names[s.acting], s.size, true
+}
+
+// signaling returns the strategies that signalled to go long or short in the last round.
+func (s *strategySet) signaling() (names []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, signal := range s.signals {
+		if signal != SignalWait {
+			names = append(names, s.names[i])
+		}
+	}
+	return
+}
+
+// SetStrategies sets several strategies to trade `asset`, e.g. a trend follower and a mean reverter. Each round
+// every strategy is given the market data and the signal of the one with the most weight is acted on. Positions
+// are sized by the strategy's weight, which shifts towards the strategies with the best expectancy over their last
+// StrategyWindow closed positions. See Session.OverrideAllocation to set the weights by hand.
+//
+// The strategies are identified by their descriptions, which must differ. Besides the data every Analyzer gets, only
+// the MarketContext is passed on to them: other optional extensions such as MultiTimeframeAnalyzer or
+// StatefulAnalyzer are not used.
+func (pf *Portfolio) SetStrategies(asset string, strategies ...Analyzer) error {
+	if len(strategies) == 0 {
+		return fmt.Errorf("no strategies given for %s", asset)
+	}
+	if len(strategies) == 1 {
+		pf.SetAnalyzer(asset, strategies[0])
+		return nil
+	}
+	seen := make(map[string]bool, len(strategies))
+	for _, strategy := range strategies {
+		if seen[strategy.Description()] {
+			return fmt.Errorf("two strategies for %s are described as %q", asset, strategy.Description())
+		}
+		seen[strategy.Description()] = true
+	}
+	pf.SetAnalyzer(asset, newStrategySet(strategies, pf.config.StrategyEpsilon, int(pf.config.StrategyWindow)))
+	return nil
+}
+
+// strategies returns the strategies trading `asset`, or false if it is traded by a single analyzer.
+func (pf *Portfolio) strategies(asset string) (*strategySet, bool) {
+	set, ok := pf.analyzers[asset].(*strategySet)
+	return set, ok
+}
+
+// allocate sets the size of a position opened on `signal` from the weight of the strategy that emitted it,
+// and logs the decision.
+func (pf *Portfolio) allocate(signal *Signal) {
+	set, ok := pf.strategies(signal.Asset)
+	if !ok {
+		return
+	}
+	name, size, ok := set.acted()
+	if !ok {
+		return
+	}
+	signal.Size = size
+	msg := fmt.Sprintf("Acting on the %v signal of %q at %.0f%% of the purchase unit (%s)", signal.Kind, name,
+		size*100, formatWeights(set.names, set.allocator.weights()))
+	log.Printf("%s: %s", signal.Asset, msg)
+	pf.events.record("allocation", signal.Asset, msg)
+}
+
+// scoreStrategy passes the profit of a closed position to the allocator of its asset, and logs the new weights if
+// they changed.
+func (pf *Portfolio) scoreStrategy(entry Entry) {
+	set, ok := pf.strategies(entry.Asset)
+	if !ok {
+		return
+	}
+	pf.mu.Lock()
+	tags, err := pf.ledger.TradeTags(pf.ctx)
+	pf.mu.Unlock()
+	if err != nil {
+		pf.errs.report(entry.Asset, "score strategy", err)
+		return
+	}
+	before, after, ok := set.allocator.observe(tags[entry.ID], entry.ClosedProfit())
+	if !ok || sameWeights(before, after) {
+		return
+	}
+	msg := fmt.Sprintf("Strategy weights changed to %s", formatWeights(set.names, after))
+	log.Printf("%s: %s", entry.Asset, msg)
+	pf.events.record("allocation", entry.Asset, msg)
+}
+
+func sameWeights(a, b map[string]float64) bool {
+	for name, weight := range a {
+		if math.Abs(b[name]-weight) > 1e-9 {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// OverrideAllocation sets the share of the position size each strategy trading `asset` gets, by description,
+// instead of the bandit's. The weights are scaled to add up to 1 and strategies left out don't trade. Passing
+// nil hands the allocation back to the bandit.
+func (s *Session) OverrideAllocation(asset string, weights map[string]float64) error {
+	set, ok := s.portfolio.strategies(asset)
+	if !ok {
+		return fmt.Errorf("%s is not traded by several strategies", asset)
+	}
+	if err := set.allocator.setOverride(weights); err != nil {
+		return err
+	}
+	msg := "Strategy weights handed back to the allocator: " + formatWeights(set.names, set.allocator.weights())
+	if weights != nil {
+		msg = "Strategy weights set by hand: " + formatWeights(set.names, set.allocator.weights())
+	}
+	log.Printf("%s: %s", asset, msg)
+	s.events.record("allocation", asset, msg)
+	return nil
+}
+
+// StrategyWeights returns the share of the position size each strategy trading `asset` gets, by description, or
+// nil if the asset is traded by a single analyzer.
+func (s *Session) StrategyWeights(asset string) map[string]float64 {
+	set, ok := s.portfolio.strategies(asset)
+	if !ok {
+		return nil
+	}
+	return set.allocator.weights()
+}
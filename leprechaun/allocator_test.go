@@ -0,0 +1,91 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"math"
+	"testing"
+)
+
+// namedAnalyzer is a strategy that is only ever described. Its other methods aren't implemented.
+type namedAnalyzer struct {
+	Analyzer
+	name string
+}
+
+func (a namedAnalyzer) Description() string { return a.name }
+
+func approxWeight(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestStrategyAllocatorTriesUntried(t *testing.T) {
+	a := newStrategyAllocator([]string{"A", "B"}, 0.1, 5)
+	a.observe("A", 100)
+	weights := a.weights()
+	if !approxWeight(weights["B"], 0.95) || !approxWeight(weights["A"], 0.05) {
+		t.Errorf("weights = %v, want the untried strategy B to lead", weights)
+	}
+	if _, _, ok := a.observe("C", 1); ok {
+		t.Error("observed the profit of a strategy that isn't in the set")
+	}
+}
+
+func TestStrategyAllocatorFollowsExpectancy(t *testing.T) {
+	a := newStrategyAllocator([]string{"A", "B"}, 0.2, 2)
+	a.observe("A", 10)
+	a.observe("B", -10)
+	if weights := a.weights(); !approxWeight(weights["A"], 0.9) || !approxWeight(weights["B"], 0.1) {
+		t.Fatalf("weights = %v, want A to lead", weights)
+	}
+	// Only the last two positions of each strategy count.
+	a.observe("A", -20)
+	a.observe("A", -20)
+	if weights := a.weights(); !approxWeight(weights["B"], 0.9) {
+		t.Errorf("weights = %v, want B to take over", weights)
+	}
+	chosen, size := a.choose([]SIGNAL{SignalLong, SignalLong})
+	if chosen != 1 || size != 1 {
+		t.Errorf("choose() = %d, %v, want the leading strategy at the full unit", chosen, size)
+	}
+	chosen, size = a.choose([]SIGNAL{SignalLong, SignalWait})
+	if chosen != 0 || !approxWeight(size, 0.1/0.9) {
+		t.Errorf("choose() = %d, %v, want the only strategy that signals, sized by its weight", chosen, size)
+	}
+}
+
+func TestStrategyAllocatorOverride(t *testing.T) {
+	a := newStrategyAllocator([]string{"A", "B"}, 0.1, 5)
+	if err := a.setOverride(map[string]float64{"A": 3, "B": 1}); err != nil {
+		t.Fatal(err)
+	}
+	a.observe("B", 100)
+	if weights := a.weights(); !approxWeight(weights["A"], 0.75) || !approxWeight(weights["B"], 0.25) {
+		t.Errorf("weights = %v, want the override", weights)
+	}
+	if err := a.setOverride(map[string]float64{"C": 1}); err == nil {
+		t.Error("overrode the weight of a strategy that isn't in the set")
+	}
+	if err := a.setOverride(map[string]float64{"A": -1}); err == nil {
+		t.Error("accepted a negative weight")
+	}
+}
+
+func TestScoreStrategyFromClosedTrades(t *testing.T) {
+	pf := newTestPortfolio(t, &Configuration{PurchaseUnit: 1000})
+	set := newStrategySet([]Analyzer{namedAnalyzer{name: "A"}, namedAnalyzer{name: "B"}}, 0.1, 5)
+	pf.analyzers["BITCOIN"] = set
+
+	winner := openTestPosition(t, pf, "winner", OpenLongTrade, 100, 10)
+	pf.tagTrade(winner.ID, Signal{Asset: "BITCOIN", Inputs: &DecisionInputs{Analyzer: "A"}})
+	loser := openTestPosition(t, pf, "loser", OpenShortTrade, 100, 10)
+	pf.tagTrade(loser.ID, Signal{Asset: "BITCOIN", Inputs: &DecisionInputs{Analyzer: "B"}})
+	pf.closeTrade(winner, "BITCOIN", 110, "", 10, "winner-exit", CloseLongTrade)
+	pf.closeTrade(loser, "BITCOIN", 110, "", 10, "loser-exit", CloseShortTrade)
+
+	if weights := set.allocator.weights(); !approxWeight(weights["A"], 0.95) {
+		t.Errorf("weights = %v, want the winning strategy A to lead", weights)
+	}
+}
@@ -0,0 +1,43 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"unit2/leprechaun/internal/atomicfile"
+)
+
+// AnalyzerStateDir returns the folder the state of stateful analyzers is kept in.
+func (c *Configuration) AnalyzerStateDir() string {
+	return filepath.Join(c.DataDir, "analyzers")
+}
+
+// analyzerStateFile returns the path of the state file of an asset's analyzer.
+func analyzerStateFile(dir, asset string) string {
+	return filepath.Join(dir, strings.ReplaceAll(asset, " ", "_")+".state")
+}
+
+// saveAnalyzerState writes the state of an asset's analyzer to `dir`. The state is written to a temporary
+// file first so a crash while saving doesn't leave a truncated state behind.
+func saveAnalyzerState(dir, asset string, analyzer StatefulAnalyzer) error {
+	return atomicfile.Write(analyzerStateFile(dir, asset), 0600, analyzer.SaveState)
+}
+
+// loadAnalyzerState restores the state of an asset's analyzer from `dir`.
+// It does nothing if no state has been saved yet.
+func loadAnalyzerState(dir, asset string, analyzer StatefulAnalyzer) error {
+	file, err := os.Open(analyzerStateFile(dir, asset))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return analyzer.LoadState(file)
+}
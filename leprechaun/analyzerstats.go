@@ -0,0 +1,222 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Tables of the ledger that keep the signals and trades of each analyzer, see AnalyzerPerformance.
+// Trades are tagged by order ID rather than joined with RECORDS, since closed records move to the archive.
+var analyzerTablesInit = []string{
+	"CREATE TABLE ANALYZER_SIGNALS (ANALYZER, ASSET, DAY, SIGNALS INTEGER DEFAULT 0, PRIMARY KEY (ANALYZER, ASSET, DAY))",
+	"CREATE TABLE ANALYZER_TRADES (ID PRIMARY KEY, ANALYZER)",
+}
+
+const (
+	signalCountOp  = "INSERT INTO ANALYZER_SIGNALS VALUES(?, ?, ?, 1) ON CONFLICT (ANALYZER, ASSET, DAY) DO UPDATE SET SIGNALS = SIGNALS + 1"
+	signalCountsOp = "SELECT ANALYZER, SUM(SIGNALS) FROM ANALYZER_SIGNALS WHERE DAY >= ? AND DAY < ? GROUP BY ANALYZER"
+	tradeTagOp     = "INSERT OR REPLACE INTO ANALYZER_TRADES VALUES(?, ?)"
+	tradeTagsOp    = "SELECT ID, ANALYZER FROM ANALYZER_TRADES"
+)
+
+// AnalyzerPerformance sums up the signals and trades of one analyzer in a TradeReport, so plugins can be
+// compared with real numbers.
+type AnalyzerPerformance struct {
+	Analyzer    string // The analyzer's Description.
+	Signals     int    // Long and short signals emitted, whether they were traded or not.
+	Trades      int    // Positions opened on its signals.
+	Closed      int    // Positions closed, which the figures below are computed from.
+	Wins        int
+	GrossProfit float64 // Sum of the profits of the winning positions.
+	GrossLoss   float64 // Sum of the losses of the other positions, as a negative number.
+}
+
+// HitRate returns the fraction of the closed positions that made a profit.
+func (p AnalyzerPerformance) HitRate() float64 {
+	if p.Closed == 0 {
+		return 0
+	}
+	return float64(p.Wins) / float64(p.Closed)
+}
+
+// AverageWin returns the average profit of the winning positions.
+func (p AnalyzerPerformance) AverageWin() float64 {
+	if p.Wins == 0 {
+		return 0
+	}
+	return p.GrossProfit / float64(p.Wins)
+}
+
+// AverageLoss returns the average loss of the other positions, as a negative number.
+func (p AnalyzerPerformance) AverageLoss() float64 {
+	if p.Closed == p.Wins {
+		return 0
+	}
+	return p.GrossLoss / float64(p.Closed-p.Wins)
+}
+
+// Expectancy returns the profit to expect from each position opened on the analyzer's signals, i.e.
+// HitRate * AverageWin + (1 - HitRate) * AverageLoss.
+func (p AnalyzerPerformance) Expectancy() float64 {
+	if p.Closed == 0 {
+		return 0
+	}
+	return (p.GrossProfit + p.GrossLoss) / float64(p.Closed)
+}
+
+// CountSignal counts a long or short signal emitted by `analyzer` for `asset` on the day of `t`.
+func (l *Ledger2) CountSignal(ctx context.Context, analyzer, asset string, t time.Time) (err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	_, err = l.db.ExecContext(ctx, signalCountOp, analyzer, asset, dayKey(t))
+	return
+}
+
+// SignalCounts returns the number of signals each analyzer emitted from the day of `from` up to (but not
+// including) the day of `to`.
+func (l *Ledger2) SignalCounts(ctx context.Context, from, to time.Time) (counts map[string]int, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	rows, err := l.db.QueryContext(ctx, signalCountsOp, dayKey(from), dayKey(to))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	counts = map[string]int{}
+	for rows.Next() {
+		var analyzer string
+		var n int
+		if err = rows.Scan(&analyzer, &n); err != nil {
+			return
+		}
+		counts[analyzer] = n
+	}
+	return counts, rows.Err()
+}
+
+// TagTrade records that the position opened by order `tradeID` was opened on a signal of `analyzer`.
+func (l *Ledger2) TagTrade(ctx context.Context, tradeID, analyzer string) (err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	_, err = l.db.ExecContext(ctx, tradeTagOp, tradeID, analyzer)
+	return
+}
+
+// TradeTags returns the analyzer each tagged position was opened on, by order ID.
+func (l *Ledger2) TradeTags(ctx context.Context) (tags map[string]string, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	rows, err := l.db.QueryContext(ctx, tradeTagsOp)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tags = map[string]string{}
+	for rows.Next() {
+		var id, analyzer string
+		if err = rows.Scan(&id, &analyzer); err != nil {
+			return
+		}
+		tags[id] = analyzer
+	}
+	return tags, rows.Err()
+}
+
+// analyzerPerformance sums up the signals counted and the positions opened in `ledgers` from `from` up to
+// (but not including) `to`, by analyzer. Positions that weren't opened on an analyzer's signal are left out.
+func analyzerPerformance(ctx context.Context, ledgers []LedgerStore, from, to time.Time) ([]AnalyzerPerformance, error) {
+	byAnalyzer := map[string]*AnalyzerPerformance{}
+	get := func(analyzer string) *AnalyzerPerformance {
+		perf, ok := byAnalyzer[analyzer]
+		if !ok {
+			perf = &AnalyzerPerformance{Analyzer: analyzer}
+			byAnalyzer[analyzer] = perf
+		}
+		return perf
+	}
+	tags := map[string]string{}
+	for _, ledger := range ledgers {
+		counts, err := ledger.SignalCounts(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for analyzer, n := range counts {
+			get(analyzer).Signals += n
+		}
+		ledgerTags, err := ledger.TradeTags(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for id, analyzer := range ledgerTags {
+			tags[id] = analyzer
+		}
+	}
+	for _, ledger := range ledgers {
+		records, err := ledger.AllRecords(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			analyzer, ok := tags[rec.ID]
+			if t, err := rec.Time(); !ok || err != nil || t.Before(from) || !t.Before(to) {
+				continue
+			}
+			perf := get(analyzer)
+			perf.Trades++
+			if rec.Status != int64(Closed) {
+				continue
+			}
+			perf.Closed++
+			if rec.Profit > 0 {
+				perf.Wins++
+				perf.GrossProfit += rec.Profit
+			} else {
+				perf.GrossLoss += rec.Profit
+			}
+		}
+	}
+	performance := make([]AnalyzerPerformance, 0, len(byAnalyzer))
+	for _, perf := range byAnalyzer {
+		performance = append(performance, *perf)
+	}
+	sort.Slice(performance, func(i, j int) bool { return performance[i].Analyzer < performance[j].Analyzer })
+	return performance, nil
+}
+
+// countSignal counts a long or short signal of the analyzer of `asset` in the ledger. When the asset is traded by
+// several strategies, the signal of each strategy that didn't wait is counted.
+func (pf *Portfolio) countSignal(asset string, analyzer Analyzer) {
+	names := []string{analyzer.Description()}
+	if set, ok := analyzer.(*strategySet); ok {
+		names = set.signaling()
+	}
+	pf.mu.Lock()
+	var err error
+	for _, name := range names {
+		if err = pf.ledger.CountSignal(pf.ctx, name, asset, pf.clock.Now()); err != nil {
+			break
+		}
+	}
+	pf.mu.Unlock()
+	pf.errs.report(asset, "count signal", err)
+}
+
+// tagTrade records in the ledger which analyzer the position opened by order `tradeID` was opened on.
+func (pf *Portfolio) tagTrade(tradeID string, signal Signal) {
+	if signal.Inputs == nil || tradeID == "" {
+		return
+	}
+	pf.mu.Lock()
+	err := pf.ledger.TagTrade(pf.ctx, tradeID, signal.Inputs.Analyzer)
+	pf.mu.Unlock()
+	pf.errs.report(signal.Asset, "tag trade", err)
+}
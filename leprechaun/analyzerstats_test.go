@@ -0,0 +1,54 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalyzerPerformance(t *testing.T) {
+	pf := newTestPortfolio(t, &Configuration{PurchaseUnit: 1000})
+	trades := []struct {
+		id        string
+		orderType Order
+		exit      float64
+	}{
+		{"long-win", OpenLongTrade, 120},
+		{"long-loss", OpenLongTrade, 90},
+		{"short-win", OpenShortTrade, 80},
+		{"short-open", OpenShortTrade, 0},
+	}
+	for _, trade := range trades {
+		entry := openTestPosition(t, pf, trade.id, trade.orderType, 100, 1)
+		pf.tagTrade(entry.ID, Signal{Asset: "BITCOIN", Inputs: &DecisionInputs{Analyzer: "MACD"}})
+		switch {
+		case trade.exit == 0:
+		case trade.orderType == OpenLongTrade:
+			pf.closeTrade(entry, "BITCOIN", trade.exit, "", 1, trade.id+"-exit", CloseLongTrade)
+		default:
+			pf.closeTrade(entry, "BITCOIN", trade.exit, "", 1, trade.id+"-exit", CloseShortTrade)
+		}
+	}
+
+	now := pf.clock.Now()
+	performance, err := analyzerPerformance(pf.ctx, []LedgerStore{pf.ledger}, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(performance) != 1 {
+		t.Fatalf("got the performance of %d analyzers, want 1", len(performance))
+	}
+	perf := performance[0]
+	if perf.Trades != 4 || perf.Closed != 3 || perf.Wins != 2 {
+		t.Errorf("trades, closed, wins = %d, %d, %d, want 4, 3, 2", perf.Trades, perf.Closed, perf.Wins)
+	}
+	if perf.GrossProfit != 40 || perf.GrossLoss != -10 {
+		t.Errorf("gross profit and loss = %v, %v, want 40, -10", perf.GrossProfit, perf.GrossLoss)
+	}
+	if got := perf.Expectancy(); got != 10 {
+		t.Errorf("Expectancy() = %v, want 10", got)
+	}
+}
@@ -0,0 +1,150 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// balanceCheckInterval is how often balances are fetched to check them against the ledger.
+const balanceCheckInterval = 10 * time.Minute
+
+// balanceBook predicts the exchange balances from the trades recorded in the ledger, so changes the ledger can't
+// explain, e.g. manual trading on the same account or a misreported order, are noticed before orders are sized
+// against the wrong balances.
+type balanceBook struct {
+	mu        sync.Mutex
+	tolerance float64            // largest unexplained change, as a fraction of the balance. Zero disables the checks.
+	observed  map[string]float64 // balance of each currency when it was last fetched, by code
+	expected  map[string]float64 // change of each balance since then, per the ledger
+	paused    bool               // trading is paused until the anomaly is acknowledged
+}
+
+func newBalanceBook(tolerance float64) *balanceBook {
+	return &balanceBook{tolerance: tolerance, observed: make(map[string]float64), expected: make(map[string]float64)}
+}
+
+// traded records how a trade recorded in the ledger changes the balances of the asset `code` and of `currency`,
+// which it is traded against. Balances are kept by currency code, so a currency that is both traded and traded
+// against, e.g. XBT when trading XBTNGN and ETHXBT, is a single balance.
+func (b *balanceBook) traded(code, currency string, orderType Order, entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch orderType {
+	case OpenLongTrade, CloseShortTrade:
+		b.expected[code] += entry.PurchaseVolume
+		b.expected[currency] -= entry.PurchaseCost
+	case CloseLongTrade, OpenShortTrade:
+		b.expected[code] -= entry.SaleVolume
+		b.expected[currency] += entry.SaleCost
+	}
+}
+
+// withdrawn records that `amount` was taken off the balance of `currency` by a withdrawal, which isn't a trade of
+// the ledger.
+func (b *balanceBook) withdrawn(currency string, amount float64) {
+	b.mu.Lock()
+	b.expected[currency] -= amount
+	b.mu.Unlock()
+}
+
+// observe compares a fetched balance with the one predicted by the ledger. It returns the predicted balance and
+// true if they differ by more than the tolerance. The fetched balance becomes the basis of the next prediction.
+func (b *balanceBook) observe(key string, balance float64) (predicted float64, anomaly bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	last, seen := b.observed[key]
+	predicted = last + b.expected[key]
+	b.observed[key], b.expected[key] = balance, 0
+	if !seen || b.tolerance <= 0 {
+		return predicted, false
+	}
+	scale := math.Max(math.Abs(predicted), math.Abs(balance))
+	return predicted, scale > 0 && math.Abs(balance-predicted) > b.tolerance*scale
+}
+
+// pause stops new positions from being opened until acknowledge is called.
+func (b *balanceBook) pause() {
+	b.mu.Lock()
+	b.paused = true
+	b.mu.Unlock()
+}
+
+// acknowledge resumes trading after an anomaly. It returns false if trading wasn't paused.
+func (b *balanceBook) acknowledge() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	paused := b.paused
+	b.paused = false
+	return paused
+}
+
+// isPaused returns true while trading is paused because of an anomaly.
+func (b *balanceBook) isPaused() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.paused
+}
+
+// checkBalances compares the balances of `asset` just fetched from the exchange with those predicted by the ledger,
+// and raises an anomaly alert for each that doesn't match.
+func (pf *Portfolio) checkBalances(asset string, balance, fiat float64) {
+	if predicted, anomaly := pf.balances.observe(assetCode(asset), balance); anomaly {
+		pf.balanceAnomaly(asset, assetCode(asset), predicted, balance)
+	}
+	currency := pf.currency(asset)
+	if predicted, anomaly := pf.balances.observe(currency, fiat); anomaly {
+		pf.balanceAnomaly(asset, currency, predicted, fiat)
+	}
+}
+
+// balanceAnomaly notifies the user that a balance changed in a way the ledger can't explain and, if
+// PauseOnBalanceAnomaly is set, stops new positions from being opened until Session.AcknowledgeAnomaly is called.
+func (pf *Portfolio) balanceAnomaly(asset, currency string, predicted, actual float64) {
+	msg := pf.messages.Sprintf(MsgBalanceAnomaly, pf.messages.FormatAmount(actual, currency),
+		pf.messages.FormatAmount(predicted, currency))
+	if pf.config.PauseOnBalanceAnomaly {
+		pf.balances.pause()
+		msg += " " + pf.messages.Sprintf(MsgBalanceAnomalyPaused)
+	}
+	log.Println(msg)
+	pf.events.record("balance-anomaly", asset, msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgBalanceAnomalySubject, currency), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+}
+
+// AcknowledgeAnomaly resumes opening positions after trading was paused because of a balance anomaly.
+// The balances fetched since are taken as correct.
+func (s *Session) AcknowledgeAnomaly() {
+	if s.portfolio.balances.acknowledge() {
+		log.Println("Balance anomaly acknowledged. New positions may be opened again.")
+		s.events.record("balance-anomaly", "", "Acknowledged")
+	}
+}
+
+// monitorBalances fetches the balances of every asset periodically until the session ends, so changes the ledger
+// can't explain are noticed even when the bot isn't trading.
+func (s *Session) monitorBalances() {
+	if s.config.BalanceTolerance <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(balanceCheckInterval):
+		}
+		if s.portfolio.maintenance.active() {
+			continue
+		}
+		for asset := range s.portfolio.assets {
+			s.errs.report(asset, "check balances", s.portfolio.refreshBalance(asset))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveSuffix is added to the name of a ledger's database to get the name of its archive.
+const archiveSuffix = "-archive"
+
+// ArchivePath returns the path of the database that closed trades are moved to by Archive.
+// The archive is a ledger database itself, so it can be opened with GetLedger2 to query old trades.
+// Ephemeral ledgers have no archive.
+func (l *Ledger2) ArchivePath() string {
+	if l.ephemeral() {
+		return ""
+	}
+	ext := filepath.Ext(l.databasePath)
+	return strings.TrimSuffix(l.databasePath, ext) + archiveSuffix + ext
+}
+
+// Archive moves the closed trades opened before `before` to the ledger's archive, keeping the ledger small.
+// It returns the number of trades moved.
+func (l *Ledger2) Archive(ctx context.Context, before time.Time) (archived int64, err error) {
+	path := l.ArchivePath()
+	if path == "" {
+		return 0, nil
+	}
+	// Opening the archive as a ledger creates it, or brings its schema up to date with the ledger's.
+	if err = GetLedger2(path).Close(); err != nil {
+		return
+	}
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	// The archive is only attached to a single connection, so every statement must use it.
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS ARCHIVE", path); err != nil {
+		return
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE ARCHIVE")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	cutoff := formatTimestamp(before)
+	where := " FROM RECORDS WHERE STATUS = ? AND TIMESTAMP < ?"
+	if _, err = tx.ExecContext(ctx, "INSERT INTO ARCHIVE.RECORDS SELECT *"+where, Closed, cutoff); err != nil {
+		return
+	}
+	res, err := tx.ExecContext(ctx, "DELETE"+where, Closed, cutoff)
+	if err != nil {
+		return
+	}
+	if archived, err = res.RowsAffected(); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
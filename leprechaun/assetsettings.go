@@ -0,0 +1,79 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import "strings"
+
+// AssetSettings override the global trading settings for a single asset, since assets differ in
+// volatility and fees. Zero values fall back to the global settings.
+type AssetSettings struct {
+	ProfitMargin float64 // as a fraction, like Configuration.ProfitMargin
+	PurchaseUnit float64
+	MinProfit    float64 // in CurrencyCode, like Configuration.MinProfit
+	// Budget is the most (in CurrencyCode) that may be tied up in the asset's open long positions, so the assets
+	// traded first in a round can't spend the balance the others need. BudgetPercent sets it as a share (in percent)
+	// of the funds in the currency the asset is traded against instead. See Portfolio.budgetLeft.
+	Budget        float64
+	BudgetPercent float64
+	// Currency is the code of the currency the asset is traded against, e.g. "XBT" to trade the ETHXBT pair.
+	// Empty means CurrencyCode. Amounts are still reported in CurrencyCode, see Portfolio.inReportingCurrency.
+	Currency string
+	// Watch puts the asset on the watchlist: its prices and signals are tracked, alerts fire and it appears in
+	// snapshots, but no orders are placed. Set it to false to trade the asset. See Session.SetWatching.
+	Watch bool
+}
+
+// assetCode returns the code of an asset given either its code (e.g. "XBT") or its name (e.g. "BITCOIN").
+func assetCode(asset string) string {
+	for _, a := range DEFAULT_ASSETS {
+		if strings.EqualFold(asset, a.name) {
+			return a.code
+		}
+	}
+	return strings.ToUpper(asset)
+}
+
+// assetSettings returns the overrides of an asset, given by code or name.
+func (c *Configuration) assetSettings(asset string) AssetSettings {
+	return c.Assets[assetCode(asset)]
+}
+
+// ProfitMarginFor returns the profit margin of an asset, given by code or name.
+func (c *Configuration) ProfitMarginFor(asset string) float64 {
+	if margin := c.assetSettings(asset).ProfitMargin; margin > 0 {
+		return margin
+	}
+	return c.ProfitMargin
+}
+
+// PurchaseUnitFor returns the amount spent on each position in an asset, given by code or name.
+// See Portfolio.purchaseUnit for the amount when PurchasePercent is set.
+func (c *Configuration) PurchaseUnitFor(asset string) float64 {
+	if unit := c.assetSettings(asset).PurchaseUnit; unit > 0 {
+		return unit
+	}
+	return c.PurchaseUnit
+}
+
+// MinProfitFor returns the smallest profit, after fees, a position in an asset given by code or name is closed for.
+func (c *Configuration) MinProfitFor(asset string) float64 {
+	if minProfit := c.assetSettings(asset).MinProfit; minProfit > 0 {
+		return minProfit
+	}
+	return c.MinProfit
+}
+
+// CurrencyFor returns the code of the currency an asset, given by code or name, is traded against.
+func (c *Configuration) CurrencyFor(asset string) string {
+	if currency := c.assetSettings(asset).Currency; currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return c.CurrencyCode
+}
+
+// Watching returns true if an asset, given by code or name, is on the watchlist.
+func (c *Configuration) Watching(asset string) bool {
+	return c.assetSettings(asset).Watch
+}
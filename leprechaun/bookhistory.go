@@ -0,0 +1,134 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Table of the ledger that keeps the order book snapshots taken as orders were placed, see OrderBookSnapshot.
+// Like the analyzer tables, it is keyed by order ID so the snapshots outlive the archiving of their records.
+var orderBookTableInit = "CREATE TABLE ORDER_BOOKS (ID PRIMARY KEY, ASSET, TIME, BIDS, ASKS)"
+
+const (
+	orderBookSaveOp  = "INSERT OR REPLACE INTO ORDER_BOOKS VALUES(?, ?, ?, ?, ?)"
+	orderBookGetOp   = "SELECT ID, ASSET, TIME, BIDS, ASKS FROM ORDER_BOOKS WHERE ID = ?"
+	orderBookPruneOp = "DELETE FROM ORDER_BOOKS WHERE TIME < ?"
+)
+
+// OrderBookLevel is a price level of the order book.
+type OrderBookLevel struct {
+	Price  float64
+	Volume float64
+}
+
+// OrderBookSnapshot is the top of an asset's order book just before an order was placed. Comparing it with the
+// price the order was filled at shows how much of the slippage the book could have predicted.
+type OrderBookSnapshot struct {
+	TradeID string // ID of the order placed after the snapshot was taken.
+	Asset   string
+	Time    time.Time
+	Bids    []OrderBookLevel // Best (highest) first.
+	Asks    []OrderBookLevel // Best (lowest) first.
+}
+
+// Snapshot returns the top `levels` levels of each side of the order book.
+func (feed *OrderBookFeed) Snapshot(levels int) (snapshot OrderBookSnapshot, err error) {
+	book := feed.stream().Snapshot()
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return snapshot, ErrOrderBookNotReady
+	}
+	snapshot.Time = feed.clock.Now()
+	for i := 0; i < levels && i < len(book.Bids); i++ {
+		snapshot.Bids = append(snapshot.Bids, OrderBookLevel{book.Bids[i].Price.Float64(), book.Bids[i].Volume.Float64()})
+	}
+	for i := 0; i < levels && i < len(book.Asks); i++ {
+		snapshot.Asks = append(snapshot.Asks, OrderBookLevel{book.Asks[i].Price.Float64(), book.Asks[i].Volume.Float64()})
+	}
+	return snapshot, nil
+}
+
+// SaveOrderBook stores the order book snapshot taken before order `snapshot.TradeID` was placed.
+func (l *Ledger2) SaveOrderBook(ctx context.Context, snapshot OrderBookSnapshot) (err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	bids, err := json.Marshal(snapshot.Bids)
+	if err != nil {
+		return
+	}
+	asks, err := json.Marshal(snapshot.Asks)
+	if err != nil {
+		return
+	}
+	_, err = l.db.ExecContext(ctx, orderBookSaveOp, snapshot.TradeID, snapshot.Asset, formatTimestamp(snapshot.Time),
+		string(bids), string(asks))
+	return
+}
+
+// OrderBook returns the order book snapshot taken before order `tradeID` was placed.
+func (l *Ledger2) OrderBook(ctx context.Context, tradeID string) (snapshot OrderBookSnapshot, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	var timestamp, bids, asks string
+	err = l.db.QueryRowContext(ctx, orderBookGetOp, tradeID).Scan(&snapshot.TradeID, &snapshot.Asset, &timestamp,
+		&bids, &asks)
+	if err != nil {
+		return
+	}
+	if snapshot.Time, err = parseTimestamp(timestamp); err != nil {
+		return
+	}
+	if err = json.Unmarshal([]byte(bids), &snapshot.Bids); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(asks), &snapshot.Asks)
+	return
+}
+
+// PruneOrderBooks deletes the order book snapshots taken before `before`, so they don't grow the ledger without
+// bound. It returns the number of snapshots deleted.
+func (l *Ledger2) PruneOrderBooks(ctx context.Context, before time.Time) (pruned int64, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	result, err := l.db.ExecContext(ctx, orderBookPruneOp, formatTimestamp(before))
+	if err != nil {
+		return
+	}
+	return result.RowsAffected()
+}
+
+// bookBefore returns the top of the order book of `asset` as an order is about to be placed, or nil if
+// OrderBookSnapshots is zero or the book isn't streamed.
+func (pf *Portfolio) bookBefore(asset string) *OrderBookSnapshot {
+	feed, ok := pf.orderBooks[asset]
+	if !ok || pf.config.OrderBookSnapshots <= 0 {
+		return nil
+	}
+	snapshot, err := feed.Snapshot(pf.config.OrderBookSnapshots)
+	if err != nil {
+		log.Printf("Could not take a snapshot of the %s order book: %v", asset, err)
+		return nil
+	}
+	snapshot.Asset = asset
+	return &snapshot
+}
+
+// saveBook stores `snapshot` in the ledger with order `orderID`, which was placed right after it was taken.
+func (pf *Portfolio) saveBook(snapshot *OrderBookSnapshot, orderID string) {
+	if snapshot == nil || orderID == "" {
+		return
+	}
+	snapshot.TradeID = orderID
+	pf.mu.Lock()
+	err := pf.ledger.SaveOrderBook(pf.ctx, *snapshot)
+	pf.mu.Unlock()
+	pf.errs.report(snapshot.Asset, "save order book", err)
+}
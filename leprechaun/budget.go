@@ -0,0 +1,119 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// budgetOf returns the most of its currency that may be tied up in the open long positions of `asset`, from its
+// Budget or BudgetPercent of `funds`, and false if it has neither.
+func (c *Configuration) budgetOf(asset string, funds, rate float64) (float64, bool) {
+	settings := c.assetSettings(asset)
+	switch {
+	case settings.Budget > 0:
+		return settings.Budget / rate, true
+	case settings.BudgetPercent > 0:
+		return funds * settings.BudgetPercent / 100, true
+	}
+	return 0, false
+}
+
+// committed returns the cost of the open long positions of `asset`, in the currency it is traded against.
+func (pf *Portfolio) committed(asset string) (cost float64, err error) {
+	positions, err := pf.ledger.OpenPositions(pf.ctx, asset)
+	if err != nil {
+		return 0, err
+	}
+	for _, position := range positions {
+		if position.Type == OpenLongTrade {
+			cost += position.PurchaseCost
+		}
+	}
+	return
+}
+
+// budgetLeft returns how much of the currency `asset` is traded against may still be spent on long positions in it,
+// and false if its spending isn't limited. The funds of a currency are its balance plus the cost of the open long
+// positions of the assets traded against it. Each asset with a Budget or BudgetPercent may tie up that much of
+// them, and the assets without one share what isn't set aside for the others: evenly if PartitionBudget is set,
+// first come first served otherwise. Short positions sell the asset itself, so they don't draw on the budgets.
+func (pf *Portfolio) budgetLeft(asset string) (left float64, limited bool, err error) {
+	currency := pf.currency(asset)
+	var peers []string
+	partitioned := pf.config.PartitionBudget
+	for _, peer := range pf.assetOrder() {
+		if pf.currency(peer) != currency || pf.watching(peer) {
+			continue
+		}
+		peers = append(peers, peer)
+		settings := pf.config.assetSettings(peer)
+		partitioned = partitioned || settings.Budget > 0 || settings.BudgetPercent > 0
+	}
+	if !partitioned {
+		return 0, false, nil
+	}
+	balance, err := pf.assets[asset].FiatBalance()
+	if err != nil {
+		return 0, true, err
+	}
+	rate, err := pf.reportingRate(asset)
+	if err != nil {
+		return 0, true, err
+	}
+	funds := balance
+	committed := make(map[string]float64, len(peers))
+	for _, peer := range peers {
+		if committed[peer], err = pf.committed(peer); err != nil {
+			return 0, true, err
+		}
+		funds += committed[peer]
+	}
+	pool, reserved, shares := funds, 0.0, 0
+	for _, peer := range peers {
+		budget, ok := pf.config.budgetOf(peer, funds, rate)
+		if !ok {
+			shares++
+			continue
+		}
+		pool -= budget
+		if peer != asset {
+			reserved += math.Max(budget-committed[peer], 0)
+		}
+	}
+	if budget, ok := pf.config.budgetOf(asset, funds, rate); ok {
+		left = budget - committed[asset]
+	} else if pf.config.PartitionBudget {
+		left = math.Max(pool, 0)/float64(shares) - committed[asset]
+	} else {
+		left = balance - reserved
+	}
+	return math.Min(math.Max(left, 0), balance), true, nil
+}
+
+// withinBudget returns the amount, in the currency `asset` is traded against, to spend on a long position of
+// `unit`: all of it, or what is left of the asset's budget if that is less. It returns false if the budget is used
+// up.
+func (pf *Portfolio) withinBudget(asset string, unit float64) (float64, bool) {
+	left, limited, err := pf.budgetLeft(asset)
+	if err != nil {
+		pf.errs.report(asset, "check budget", err)
+		return 0, false
+	}
+	if !limited || left >= unit {
+		return unit, true
+	}
+	if left <= 0 {
+		msg := fmt.Sprintf("Skipped a %v signal: the budget of %s is used up", SignalLong, asset)
+		log.Println(msg)
+		pf.events.record("budget", asset, msg)
+		return 0, false
+	}
+	log.Printf("Sizing the position in %s down to the %s left of its budget", asset,
+		pf.messages.FormatAmount(left, pf.currency(asset)))
+	return left, true
+}
@@ -0,0 +1,55 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"unit2/leprechaun/internal/atomicfile"
+)
+
+// CandleCacheFile returns the file the candle cache is saved to when a session ends, so reports can draw
+// price charts without a running session.
+func (c *Configuration) CandleCacheFile() string {
+	return filepath.Join(c.DataDir, "candles.json")
+}
+
+// Save writes the cached candles of every pair to `path`. The candles are written to a temporary file first
+// so a crash while saving doesn't leave a truncated file behind.
+func (cache *CandleCache) Save(path string) error {
+	cache.mu.RLock()
+	candles := make(map[string][]OHLC, len(cache.candles))
+	for pair, buf := range cache.candles {
+		candles[pair] = buf.Slice()
+	}
+	cache.mu.RUnlock()
+	return atomicfile.Write(path, 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(candles)
+	})
+}
+
+// LoadCandles reads the candles saved to `path` by CandleCache.Save, by pair. It returns no candles if none
+// have been saved yet.
+func LoadCandles(path string) (candles map[string][]OHLC, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return map[string][]OHLC{}, nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if err = json.NewDecoder(file).Decode(&candles); err != nil {
+		return
+	}
+	for _, series := range candles {
+		sort.Slice(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+	}
+	return
+}
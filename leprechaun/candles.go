@@ -0,0 +1,268 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"unit2/leprechaun/internal/ringbuffer"
+)
+
+// GapPolicy determines how intervals missing from a candle series are handled.
+// Exchanges may return no candle for an interval during outages or when nothing was traded.
+type GapPolicy string
+
+const (
+	// GapForwardFill fills a gap with flat candles at the last known closing price.
+	GapForwardFill GapPolicy = "forward-fill"
+	// GapInterpolate fills a gap with candles whose prices move linearly between the candles on either side.
+	GapInterpolate GapPolicy = "interpolate"
+	// GapMarkMissing fills a gap with placeholder candles marked as `Missing` so analyzers can skip them.
+	GapMarkMissing GapPolicy = "mark-missing"
+)
+
+// Valid returns true if `p` is a known gap policy.
+func (p GapPolicy) Valid() bool {
+	switch p {
+	case GapForwardFill, GapInterpolate, GapMarkMissing:
+		return true
+	}
+	return false
+}
+
+// CandleGap is a run of consecutive candles missing from a series.
+type CandleGap struct {
+	Start   time.Time // Start time of the first missing candle.
+	Missing int       // Number of missing candles.
+}
+
+// CandleCache holds the candles of each trading pair so they don't have to be fetched from
+// the exchange every round. Candles are kept sorted by time with gaps filled per the cache's `GapPolicy`.
+// Only the most recent `capacity` candles of each pair are kept, so the cache doesn't grow in long sessions.
+type CandleCache struct {
+	mu         sync.RWMutex
+	interval   time.Duration
+	intervals  map[string]time.Duration // intervals of pairs that don't use the default
+	policy     GapPolicy
+	capacity   int
+	capacities map[string]int // capacities of pairs that need more candles than the default
+	candles    map[string]*ringbuffer.Buffer[OHLC]
+}
+
+// NewCandleCache returns an empty cache for candles of the given interval, holding at most `capacity` candles
+// of each pair. A capacity of zero or less uses defaultHistoryLength.
+func NewCandleCache(interval time.Duration, policy GapPolicy, capacity int) *CandleCache {
+	if !policy.Valid() {
+		policy = GapForwardFill
+	}
+	if capacity <= 0 {
+		capacity = defaultHistoryLength
+	}
+	return &CandleCache{interval: interval, intervals: map[string]time.Duration{}, policy: policy, capacity: capacity,
+		capacities: map[string]int{}, candles: map[string]*ringbuffer.Buffer[OHLC]{}}
+}
+
+// Reserve makes room for at least `n` candles of `pair`, e.g. the lookback of its analyzer.
+func (cache *CandleCache) Reserve(pair string, n int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if n <= cache.capacityOf(pair) {
+		return
+	}
+	cache.capacities[pair] = n
+	if buf, ok := cache.candles[pair]; ok {
+		buf.Resize(n)
+	}
+}
+
+// capacityOf must be called with cache.mu held.
+func (cache *CandleCache) capacityOf(pair string) int {
+	if capacity, ok := cache.capacities[pair]; ok {
+		return capacity
+	}
+	return cache.capacity
+}
+
+// buffer returns the candles of `pair`. It must be called with cache.mu held.
+func (cache *CandleCache) buffer(pair string) *ringbuffer.Buffer[OHLC] {
+	buf, ok := cache.candles[pair]
+	if !ok {
+		buf = ringbuffer.New[OHLC](cache.capacityOf(pair))
+		cache.candles[pair] = buf
+	}
+	return buf
+}
+
+// SetInterval changes the interval of the candles cached for `pair`. Cached candles of another interval are dropped.
+func (cache *CandleCache) SetInterval(pair string, interval time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.intervalOf(pair) != interval {
+		delete(cache.candles, pair)
+	}
+	cache.intervals[pair] = interval
+}
+
+// Interval returns the interval of the candles cached for `pair`.
+func (cache *CandleCache) Interval(pair string) time.Duration {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return cache.intervalOf(pair)
+}
+
+// intervalOf must be called with cache.mu held.
+func (cache *CandleCache) intervalOf(pair string) time.Duration {
+	if interval, ok := cache.intervals[pair]; ok {
+		return interval
+	}
+	return cache.interval
+}
+
+// Add merges `candles` into the cached series of `pair`. Candles replace cached ones with the same start time.
+func (cache *CandleCache) Add(pair string, candles ...OHLC) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	buf, interval := cache.buffer(pair), cache.intervalOf(pair)
+	if follows(buf, candles, interval) {
+		buf.Push(candles...)
+		return
+	}
+	byTime := map[time.Time]OHLC{}
+	for _, candle := range buf.Slice() {
+		if !candle.Missing && !candle.Filled {
+			byTime[candle.Time] = candle
+		}
+	}
+	for _, candle := range candles {
+		byTime[candle.Time] = candle
+	}
+	series := make([]OHLC, 0, len(byTime))
+	for _, candle := range byTime {
+		series = append(series, candle)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+	if gaps := DetectGaps(series, interval); len(gaps) > 0 {
+		log.Printf("Found %d gap(s) in the %s candle data. Filling them using the %q policy.", len(gaps), pair, cache.policy)
+		series = FillGaps(series, interval, cache.policy)
+	}
+	buf.Reset()
+	buf.Push(series...)
+}
+
+// follows returns true if `candles` carry on from the newest candle in `buf` without gaps or overlaps,
+// so they can simply be appended.
+func follows(buf *ringbuffer.Buffer[OHLC], candles []OHLC, interval time.Duration) bool {
+	last, ok := buf.Last()
+	if !ok || interval <= 0 {
+		return false
+	}
+	for _, candle := range candles {
+		if !candle.Time.Equal(last.Time.Add(interval)) {
+			return false
+		}
+		last = candle
+	}
+	return true
+}
+
+// Candles returns a copy of the cached series of `pair`.
+func (cache *CandleCache) Candles(pair string) []OHLC {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if buf, ok := cache.candles[pair]; ok {
+		return buf.Slice()
+	}
+	return []OHLC{}
+}
+
+// Resampled returns the cached series of `pair` merged into candles of `interval`. See Resample.
+func (cache *CandleCache) Resampled(pair string, interval time.Duration) ([]OHLC, error) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	var candles []OHLC
+	if buf, ok := cache.candles[pair]; ok {
+		candles = buf.Slice()
+	}
+	return Resample(candles, cache.intervalOf(pair), interval)
+}
+
+// DetectGaps returns the runs of candles missing from a time sorted series.
+func DetectGaps(candles []OHLC, interval time.Duration) (gaps []CandleGap) {
+	if interval <= 0 {
+		return
+	}
+	for i := 1; i < len(candles); i++ {
+		expected := candles[i-1].Time.Add(interval)
+		if missing := int(candles[i].Time.Sub(expected) / interval); missing > 0 {
+			gaps = append(gaps, CandleGap{Start: expected, Missing: missing})
+		}
+	}
+	return
+}
+
+// FillGaps returns a copy of a time sorted series with every gap filled according to `policy`.
+func FillGaps(candles []OHLC, interval time.Duration, policy GapPolicy) []OHLC {
+	if len(candles) == 0 || interval <= 0 {
+		return candles
+	}
+	filled := []OHLC{candles[0]}
+	for i := 1; i < len(candles); i++ {
+		prev, next := candles[i-1], candles[i]
+		missing := int(next.Time.Sub(prev.Time.Add(interval)) / interval)
+		for n := 1; n <= missing; n++ {
+			start := prev.Time.Add(time.Duration(n) * interval)
+			switch policy {
+			case GapInterpolate:
+				step := (next.Open - prev.Close) / float64(missing+1)
+				open := prev.Close + step*float64(n-1)
+				filled = append(filled, newFilledCandle(start, interval, open, open+step))
+			case GapMarkMissing:
+				candle := newFilledCandle(start, interval, prev.Close, prev.Close)
+				candle.Missing = true
+				filled = append(filled, candle)
+			default:
+				filled = append(filled, newFilledCandle(start, interval, prev.Close, prev.Close))
+			}
+		}
+		filled = append(filled, next)
+	}
+	return filled
+}
+
+// newFilledCandle creates a synthetic candle with no trading volume to stand in for a missing one.
+func newFilledCandle(start time.Time, period time.Duration, open, close float64) OHLC {
+	candle := OHLC{Open: open, Close: close, High: math.Max(open, close), Low: math.Min(open, close),
+		Time: start, Period: period, Filled: true}
+	candle.setTrend()
+	return candle
+}
+
+// candlesToOHLC converts candles returned by an exchange into `OHLC` values of `period`.
+func candlesToOHLC(candles []Candle, period time.Duration) []OHLC {
+	converted := make([]OHLC, 0, len(candles))
+	for _, c := range candles {
+		candle := OHLC{Open: c.Open, High: c.High, Low: c.Low, Close: c.Close, TotalVolume: c.Volume, Time: c.Time,
+			Period: period}
+		candle.setTrend()
+		converted = append(converted, candle)
+	}
+	return converted
+}
+
+// parseGapPolicy converts a configured policy name to a `GapPolicy`.
+func parseGapPolicy(name string) (GapPolicy, error) {
+	if name == "" {
+		return GapForwardFill, nil
+	}
+	if p := GapPolicy(name); p.Valid() {
+		return p, nil
+	}
+	return GapForwardFill, fmt.Errorf("unknown candle gap policy %q", name)
+}
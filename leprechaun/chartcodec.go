@@ -0,0 +1,144 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"unit2/leprechaun/internal/ringbuffer"
+)
+
+// Charts are encoded with their exported fields, which include the computed indicators and detected patterns,
+// plus the capacity of the candles or prices they hold. Decoding a chart restores the capacity, so a chart loaded
+// from a cache keeps dropping its oldest values the way the original did. JSON is meant for the dashboard and
+// test fixtures, the binary (gob) encoding for caching charts on disk.
+
+// candleChartData is a CandleChart without its methods, so it can be encoded without calling them recursively.
+type candleChartData CandleChart
+
+// encodedCandleChart is the encoded form of a CandleChart.
+type encodedCandleChart struct {
+	candleChartData
+	Capacity int
+}
+
+// binaryChart is the binary encoded form of a chart. Gob skips embedded fields of unexported types, so
+// the chart is held in a named field.
+type binaryChart[T any] struct {
+	Chart    T
+	Capacity int
+}
+
+// encode returns the chart in the form it is encoded in.
+func (cht CandleChart) encode() encodedCandleChart {
+	capacity := historyCapacity(len(cht.Candles))
+	if cht.history != nil {
+		capacity = cht.history.Cap()
+	}
+	return encodedCandleChart{candleChartData(cht), capacity}
+}
+
+// decode sets the chart from its encoded form. The candles' percentage changes aren't encoded and are recomputed.
+func (cht *CandleChart) decode(data encodedCandleChart) {
+	*cht = CandleChart(data.candleChartData)
+	for i := range cht.Candles {
+		cht.Candles[i].setTrend()
+	}
+	cht.history = ringbuffer.New[OHLC](historyCapacity(data.Capacity))
+	cht.AddCandles(cht.Candles...)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (cht CandleChart) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cht.encode())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (cht *CandleChart) UnmarshalJSON(b []byte) error {
+	var data encodedCandleChart
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	cht.decode(data)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (cht CandleChart) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	data := cht.encode()
+	err := gob.NewEncoder(&buf).Encode(binaryChart[candleChartData]{data.candleChartData, data.Capacity})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (cht *CandleChart) UnmarshalBinary(b []byte) error {
+	var data binaryChart[candleChartData]
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		return err
+	}
+	cht.decode(encodedCandleChart{data.Chart, data.Capacity})
+	return nil
+}
+
+// lineChartData is a LineChart without its methods, see candleChartData.
+type lineChartData LineChart
+
+// encodedLineChart is the encoded form of a LineChart.
+type encodedLineChart struct {
+	lineChartData
+	Capacity int
+}
+
+// encode returns the chart in the form it is encoded in.
+func (chart LineChart) encode() encodedLineChart {
+	capacity := historyCapacity(len(chart.Prices))
+	if chart.history != nil {
+		capacity = chart.history.Cap()
+	}
+	return encodedLineChart{lineChartData(chart), capacity}
+}
+
+// decode sets the chart from its encoded form.
+func (chart *LineChart) decode(data encodedLineChart) {
+	*chart = LineChart(data.lineChartData)
+	chart.history = ringbuffer.New[float64](historyCapacity(data.Capacity))
+	chart.AddPrices(chart.Prices...)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (chart LineChart) MarshalJSON() ([]byte, error) {
+	return json.Marshal(chart.encode())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (chart *LineChart) UnmarshalJSON(b []byte) error {
+	var data encodedLineChart
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	chart.decode(data)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (chart LineChart) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	data := chart.encode()
+	err := gob.NewEncoder(&buf).Encode(binaryChart[lineChartData]{data.lineChartData, data.Capacity})
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (chart *LineChart) UnmarshalBinary(b []byte) error {
+	var data binaryChart[lineChartData]
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		return err
+	}
+	chart.decode(encodedLineChart{data.Chart, data.Capacity})
+	return nil
+}
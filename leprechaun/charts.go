@@ -6,10 +6,13 @@ package leprechaun
  */
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"io"
 	"math"
 	"time"
+
+	"unit2/leprechaun/internal/ringbuffer"
 )
 
 // Analyzer defines the interface for an arbitrary analysis pipeline.
@@ -19,7 +22,8 @@ import (
 //	and the `Emit` function returns the signal based on the analysis done.
 type Analyzer interface {
 	// Emit returns the final market signal based on the analysis done by the analyzer plugin.
-	Emit() (SIGNAL, error)
+	// Plugins should give up and return ctx.Err() once the context is done.
+	Emit(ctx context.Context) (SIGNAL, error)
 	// GetClosingPrices recieves the closing prices over a time period from the bot.
 	SetClosingPrices(prices []float64) error
 	// GetOHLC receives the OHLC data of trades from the bot. The number of data points and time range is
@@ -31,6 +35,31 @@ type Analyzer interface {
 	SetOptions(opts *AnalysisOptions) error
 	// Description returns a short explanation of the plugins functionality.
 	Description() string
+	// Lookback returns the number of candles the plugin needs before its signals can be trusted.
+	// No signals are emitted until the bot has fetched that many candles.
+	Lookback() int
+}
+
+// MultiTimeframeAnalyzer is implemented by analyzer plugins that look at the market at several intervals at once,
+// e.g. hourly candles for entries and daily candles for the overall trend. The candles of each interval are
+// resampled from the fetched ones, so every interval must be a multiple of the fetched interval.
+type MultiTimeframeAnalyzer interface {
+	Analyzer
+	// Timeframes returns the candle intervals the plugin needs.
+	Timeframes() []time.Duration
+	// SetTimeframes receives the candles of each interval returned by Timeframes.
+	SetTimeframes(candles map[time.Duration][]OHLC) error
+}
+
+// StatefulAnalyzer is implemented by analyzer plugins that need warm-up (e.g. EMA seeds or a model's hidden state).
+// Their state is saved under the data directory when a session ends and loaded again when the analyzer is set,
+// so a restart doesn't begin from cold indicators.
+type StatefulAnalyzer interface {
+	Analyzer
+	// SaveState writes the analyzer's state to `w`.
+	SaveState(w io.Writer) error
+	// LoadState restores state written by SaveState.
+	LoadState(r io.Reader) error
 }
 
 type timeInterval time.Duration
@@ -116,6 +145,7 @@ type LineChart struct {
 	Interval      time.Duration
 	MovingAverage map[string]int
 	LinesData     [3]float64
+	history       *ringbuffer.Buffer[float64] // holds Prices, see AddPrices
 }
 
 // NewLineChart creates a price chart with the closing price of each time interval
@@ -123,12 +153,43 @@ type LineChart struct {
 func NewLineChart(prices []float64) LineChart {
 	chart := LineChart{
 		MovingAverage: map[string]int{"PERIOD": 20, "WINDOW": 2},
+		history:       ringbuffer.New[float64](historyCapacity(len(prices))),
 	}
-	chart.Prices = prices
+	chart.AddPrices(prices...)
 	// chart.DetectTrend()
 	return chart
 }
 
+// AddPrices adds the latest prices to the chart. Once the chart holds its capacity of prices
+// (see SetCapacity), the oldest ones are dropped.
+func (chart *LineChart) AddPrices(prices ...float64) {
+	if chart.history == nil {
+		chart.history = ringbuffer.New[float64](historyCapacity(len(chart.Prices)))
+		chart.history.Push(chart.Prices...)
+	}
+	chart.history.Push(prices...)
+	chart.Prices = chart.history.Slice()
+}
+
+// SetCapacity changes the number of prices the chart holds, dropping the oldest ones if it holds more.
+func (chart *LineChart) SetCapacity(capacity int) {
+	chart.AddPrices()
+	chart.history.Resize(capacity)
+	chart.Prices = chart.history.Slice()
+}
+
+// defaultHistoryLength is the number of candles (or prices) of an asset kept in memory when none is configured.
+const defaultHistoryLength = 2000
+
+// historyCapacity returns the capacity of a chart created with `n` values: enough for all of them, and
+// at least defaultHistoryLength.
+func historyCapacity(n int) int {
+	if n < defaultHistoryLength {
+		return defaultHistoryLength
+	}
+	return n
+}
+
 // DetectTrend tries to detect the overall sentiment of the chart.
 // If the price at any point is higher than its next price it
 // signifies a drop in price, and vice versa.
@@ -170,7 +231,9 @@ type OHLC struct {
 	TotalVolume          float64              // Total traded volume of the period in question
 	Patterns             []CandlestickPattern // Patterns that the most recent candles in the chart form.
 	UpperTail, LowerTail float64
-	ID                   int // A unique number that identifies a candle in a series
+	ID                   int  // A unique number that identifies a candle in a series
+	Filled               bool // The candle was made up to fill a gap in the data. See `GapPolicy`
+	Missing              bool // The candle stands in for missing data and its prices should be ignored
 }
 
 // doOHLC to extract OHLC info from a list of prices for a given time range
@@ -178,30 +241,39 @@ func doOHLC(startTime time.Time, prices []float64, volume float64) OHLC {
 	candle := OHLC{Prices: &prices, TotalVolume: volume, Time: startTime.Truncate(time.Hour).Truncate(time.Minute), Period: time.Hour}
 	candle.Close = prices[len(prices)-1]
 	candle.Open = prices[0]
-	candle.High = Max64(prices)
-	candle.Low = Min64(prices)
+	candle.Low, candle.High = MinMax64(prices)
+	candle.setTrend()
+	// candle.Period = time.Hour
+	return candle
+}
+
+// trendEpsilon is the smallest price move, as a fraction of the opening price, that gives a candle a trend.
+// Candles that move less are Indifferent.
+const trendEpsilon = 1e-4
+
+// setTrend computes the range, trend and tails of a candle from its prices.
+func (candle *OHLC) setTrend() {
 	candle.Range = candle.Close - candle.Open
-	candle.percentChange = (candle.Range * 100) / candle.Open
-	if candle.Range < 1.0 {
-		// Negative price movement
-		candle.Trend = Bearish
-	} else {
+	if candle.Open != 0 {
+		candle.percentChange = (candle.Range * 100) / candle.Open
+	}
+	switch epsilon := trendEpsilon * math.Abs(candle.Open); {
+	case candle.Range > epsilon:
 		// Positive price movement
 		candle.Trend = Bullish
+	case candle.Range < -epsilon:
+		// Negative price movement
+		candle.Trend = Bearish
+	default:
+		candle.Trend = Indifferent
 	}
-	switch candle.Trend {
-	case Bullish:
-		candle.UpperTail = candle.High - candle.Close
-		candle.LowerTail = candle.Open - candle.Low
-	case Bearish:
-		candle.UpperTail = candle.High - candle.Open
-		candle.LowerTail = candle.Close - candle.Low
-	}
-	// candle.Period = time.Hour
-	return candle
+	candle.UpperTail = candle.High - math.Max(candle.Open, candle.Close)
+	candle.LowerTail = math.Min(candle.Open, candle.Close) - candle.Low
 }
 
 // BB calculates the bollinger bands for a time series
+//
+// Deprecated: it was never implemented. Use BollingerBands.
 func BB(prices float64, SMA, deviation int64) {
 	// Calculate the simple moving average
 	// window = 1
@@ -217,10 +289,74 @@ func (candle OHLC) IsBearish() bool {
 	return candle.Trend == Bearish
 }
 
-// IsDoji returns true if a candles opening price is virtually the same with its closing price.
-// See `https://www.investopedia.com/terms/d/doji.asp`
+// PatternThresholds are the sizes candlestick pattern detectors compare candles with. They are relative to the
+// price (or its volatility), so patterns are detected the same way for assets priced near 1 and near 10^7.
+type PatternThresholds struct {
+	// DojiBody is the largest body, as a fraction of the candle's price, a doji may have.
+	DojiBody float64
+	// DojiATR is the largest body, as a multiple of the average true range of the chart, a doji may have.
+	// A candle is a doji if it passes either test. Zero disables this test.
+	DojiATR float64
+	// ATRPeriod is the number of candles the average true range is taken over.
+	ATRPeriod int
+	// StarBody is the largest body the middle candle of a star pattern may have, as a fraction of the body
+	// of the last candle.
+	StarBody float64
+	// Gap is the smallest distance between the bodies of consecutive candles, as a fraction of the price,
+	// that counts as a gap. See OHLC.GapFrom.
+	Gap float64
+}
+
+// DefaultPatternThresholds are the thresholds charts use unless they are given others.
+var DefaultPatternThresholds = PatternThresholds{DojiBody: 0.001, DojiATR: 0.1, ATRPeriod: 14, StarBody: 0.5,
+	Gap: 0.0005}
+
+// Body returns the size of the candle's body, the difference between its opening and closing prices.
+func (candle OHLC) Body() float64 {
+	return math.Abs(candle.Close - candle.Open)
+}
+
+// bodyTop and bodyBottom return the upper and lower ends of the candle's body.
+func (candle OHLC) bodyTop() float64    { return math.Max(candle.Open, candle.Close) }
+func (candle OHLC) bodyBottom() float64 { return math.Min(candle.Open, candle.Close) }
+
+// bodyMiddle returns the price halfway through the candle's body.
+func (candle OHLC) bodyMiddle() float64 { return (candle.Open + candle.Close) / 2 }
+
+// GapFrom returns the direction the price gapped in between `previous` and this candle: Bullish if this candle's
+// body starts above the body of `previous`, Bearish if it starts below, and Indifferent if the bodies overlap or
+// are closer than `t.Gap` (a fraction of the previous close). The size of the gap is returned as a fraction of the
+// previous close, and is zero if there is no gap. Star and island patterns depend on these gaps.
+func (candle OHLC) GapFrom(previous OHLC, t PatternThresholds) (direction ChartTrend, size float64) {
+	if previous.Close <= 0 {
+		return Indifferent, 0
+	}
+	if up := (candle.bodyBottom() - previous.bodyTop()) / previous.Close; up > t.Gap {
+		return Bullish, up
+	}
+	if down := (previous.bodyBottom() - candle.bodyTop()) / previous.Close; down > t.Gap {
+		return Bearish, down
+	}
+	return Indifferent, 0
+}
+
+// IsDoji returns true if a candles opening price is virtually the same with its closing price, using
+// DefaultPatternThresholds. See `https://www.investopedia.com/terms/d/doji.asp`
 func (candle OHLC) IsDoji() bool {
-	return math.Floor(candle.Open) == math.Floor(candle.Close)
+	return candle.isDoji(DefaultPatternThresholds, 0)
+}
+
+// isDoji returns true if the candle's body is small relative to its price or to `atr`, the average
+// true range of the candles around it. An `atr` of zero skips the second test.
+func (candle OHLC) isDoji(t PatternThresholds, atr float64) bool {
+	body, price := candle.Body(), (candle.High+candle.Low)/2
+	if price <= 0 {
+		price = math.Max(candle.Open, candle.Close)
+	}
+	if body <= t.DojiBody*price {
+		return true
+	}
+	return t.DojiATR > 0 && atr > 0 && body <= t.DojiATR*atr
 }
 
 // IsHammer returns true if the candle is a hammer.
@@ -395,12 +531,23 @@ var (
 type BullishChartPattern struct {
 	Pattern         BullishCandlestickPattern
 	PreceedingTrend ChartTrend
+	Position        int // ID of the candle that completes the pattern.
+	Age             int // Number of candles that have closed since the pattern completed. Zero for the last candle.
 }
 
 // BearishChartPattern is a bearish candlestick pattern detected in the chart
 type BearishChartPattern struct {
 	Pattern         BearishCandlestickPattern
 	PreceedingTrend ChartTrend
+	Position        int // ID of the candle that completes the pattern.
+	Age             int // Number of candles that have closed since the pattern completed. Zero for the last candle.
+}
+
+// PriceGap is a jump in price between two consecutive candles in a chart. See OHLC.GapFrom.
+type PriceGap struct {
+	Position  int        // ID of the candle after the gap.
+	Direction ChartTrend // Bullish for a gap up, Bearish for a gap down.
+	Size      float64    // Distance between the bodies, as a fraction of the close before the gap.
 }
 
 // CandleChart is a chart that holds the OHLC data against time
@@ -414,8 +561,10 @@ type CandleChart struct {
 	MA90              float64
 	Lines             [3]float64
 	MaxPatternCandles int // Maximum number of most recent candles to check for common candlestick patterns.
+	Thresholds        PatternThresholds
 	BullishPatterns   []BullishChartPattern
-	BearishPatterns   []BearishChartPattern // These are the bearish patterns that have been detected in the most recent candles of the chart.
+	BearishPatterns   []BearishChartPattern    // These are the bearish patterns that have been detected in the most recent candles of the chart.
+	history           *ringbuffer.Buffer[OHLC] // holds Candles, see AddCandles
 }
 
 // NewCandleChart returns a candlestick chart initialized with the provided values.
@@ -423,16 +572,43 @@ func NewCandleChart(candles []OHLC) CandleChart {
 	c := CandleChart{
 		Candles:           []OHLC{},
 		MaxPatternCandles: 5,
+		Thresholds:        DefaultPatternThresholds,
 		BearishPatterns:   []BearishChartPattern{},
 		BullishPatterns:   []BullishChartPattern{},
+		history:           ringbuffer.New[OHLC](historyCapacity(len(candles))),
 	}
-	for i, candle := range candles {
-		candle.ID = i
-		c.Candles = append(c.Candles, candle)
-	}
+	c.AddCandles(candles...)
 	return c
 }
 
+// AddCandles adds the latest candles to the chart. Once the chart holds its capacity of candles
+// (see SetCapacity), the oldest ones are dropped.
+func (cht *CandleChart) AddCandles(candles ...OHLC) {
+	if cht.history == nil {
+		cht.history = ringbuffer.New[OHLC](historyCapacity(len(cht.Candles)))
+		cht.history.Push(cht.Candles...)
+	}
+	cht.history.Push(candles...)
+	cht.refresh()
+}
+
+// SetCapacity changes the number of candles the chart holds, dropping the oldest ones if it holds more.
+func (cht *CandleChart) SetCapacity(capacity int) {
+	cht.AddCandles()
+	cht.history.Resize(capacity)
+	cht.refresh()
+}
+
+// refresh copies the held candles to Candles. Candles are numbered by their position in the chart,
+// which shifts as old candles are dropped.
+func (cht *CandleChart) refresh() {
+	cht.Candles = cht.history.Slice()
+	for i := range cht.Candles {
+		cht.Candles[i].ID = i
+	}
+	cht.Length = len(cht.Candles)
+}
+
 func (cht CandleChart) nextCandle(current OHLC) (candle OHLC, err error) {
 	if len(cht.Candles) >= current.ID+1 {
 		return OHLC{}, ErrLastCandle
@@ -458,7 +634,7 @@ func (cht CandleChart) previousCandle(current OHLC) (candle OHLC, err error) {
 }
 
 func (cht CandleChart) previousCandles(num int, current OHLC) (candles []OHLC, err error) {
-	if current.ID == 0 {
+	if current.ID < num {
 		return nil, ErrLastCandle
 	}
 	for i := 1; i <= num; i++ {
@@ -469,7 +645,7 @@ func (cht CandleChart) previousCandles(num int, current OHLC) (candles []OHLC, e
 
 // AddBearishPattern adds a detected bearish pattern to the chart struct as well as the trend
 // of the candles preceeding the detect pattern.
-func (cht CandleChart) AddBearishPattern(earliestCandle OHLC, pattern BearishCandlestickPattern) {
+func (cht *CandleChart) AddBearishPattern(earliestCandle OHLC, pattern BearishCandlestickPattern) {
 	if previousThreeCandles, err := cht.previousCandles(3, earliestCandle); err != ErrLastCandle {
 		cht.BearishPatterns = append(cht.BearishPatterns, BearishChartPattern{Pattern: pattern,
 			PreceedingTrend: cht.DetectTrend(previousThreeCandles)})
@@ -478,7 +654,7 @@ func (cht CandleChart) AddBearishPattern(earliestCandle OHLC, pattern BearishCan
 
 // AddBullishPattern adds a detected bullish pattern to the chart struct as well as the trend
 // of the candles preceeding the detected pattern.
-func (cht CandleChart) AddBullishPattern(earliestCandle OHLC, pattern BullishCandlestickPattern) {
+func (cht *CandleChart) AddBullishPattern(earliestCandle OHLC, pattern BullishCandlestickPattern) {
 	if previousThreeCandles, err := cht.previousCandles(3, earliestCandle); err != ErrLastCandle {
 		cht.BullishPatterns = append(cht.BullishPatterns, BullishChartPattern{Pattern: pattern,
 			PreceedingTrend: cht.DetectTrend(previousThreeCandles)})
@@ -507,12 +683,60 @@ func (cht CandleChart) DetectTrend(candles []OHLC) ChartTrend {
 	return Indifferent
 }
 
-// DetectPatterns tries to match the most recent price data to common candlestick patterns
-func (cht CandleChart) DetectPatterns() {
-	fmt.Println(len(cht.Candles), cht.Candles)
-	patternCandles := cht.Candles[len(cht.Candles)-cht.MaxPatternCandles : len(cht.Candles)]
-	lastIdx := len(patternCandles) - 1
-	lastCandle := patternCandles[lastIdx]
+// DetectPriceGaps returns the gaps between the bodies of consecutive candles among the last `window` candles,
+// oldest first. A `window` of zero or less checks the last MaxPatternCandles candles.
+func (cht CandleChart) DetectPriceGaps(window int) (gaps []PriceGap) {
+	if window <= 0 {
+		window = cht.MaxPatternCandles
+	}
+	start := len(cht.Candles) - window
+	if start < 1 {
+		start = 1
+	}
+	for i := start; i < len(cht.Candles); i++ {
+		if direction, size := cht.Candles[i].GapFrom(cht.Candles[i-1], cht.Thresholds); direction != Indifferent {
+			gaps = append(gaps, PriceGap{Position: cht.Candles[i].ID, Direction: direction, Size: size})
+		}
+	}
+	return
+}
+
+// DetectPatterns tries to match the most recent price data to common candlestick patterns.
+// Only patterns completed by the last candle are found, see ScanPatterns for older ones.
+func (cht *CandleChart) DetectPatterns() {
+	cht.ScanPatterns(1)
+}
+
+// ScanPatterns finds the common candlestick patterns completed by any of the last `window` candles, so analyzers
+// can weigh recent formations against older ones using their Position and Age. Patterns found by a previous scan
+// are replaced. A `window` of zero or less scans the last MaxPatternCandles candles.
+func (cht *CandleChart) ScanPatterns(window int) {
+	cht.BullishPatterns, cht.BearishPatterns = []BullishChartPattern{}, []BearishChartPattern{}
+	if window <= 0 {
+		window = cht.MaxPatternCandles
+	}
+	start := len(cht.Candles) - window
+	if start < 0 {
+		start = 0
+	}
+	atr := AverageTrueRange(cht.Candles, cht.Thresholds.ATRPeriod)
+	for _, candle := range cht.Candles[start:] {
+		bullish, bearish := len(cht.BullishPatterns), len(cht.BearishPatterns)
+		cht.detectPatternsAt(candle, atr)
+		age := len(cht.Candles) - 1 - candle.ID
+		for i := bullish; i < len(cht.BullishPatterns); i++ {
+			cht.BullishPatterns[i].Position, cht.BullishPatterns[i].Age = candle.ID, age
+		}
+		for i := bearish; i < len(cht.BearishPatterns); i++ {
+			cht.BearishPatterns[i].Position, cht.BearishPatterns[i].Age = candle.ID, age
+		}
+	}
+}
+
+// detectPatternsAt adds the patterns completed by `lastCandle` to the chart. `atr` is the average true range
+// of the chart, which doji are measured against.
+func (cht *CandleChart) detectPatternsAt(lastCandle OHLC, atr float64) {
+	t := cht.Thresholds
 	// Check for patterns that end with a bearish candle, for example the bearish engulfing pattern
 	if lastCandle.IsBearish() {
 		if previousCandle, err := cht.previousCandle(lastCandle); err != ErrLastCandle {
@@ -536,23 +760,15 @@ func (cht CandleChart) DetectPatterns() {
 			}
 			// Check for bearish evening star
 			if thirdCandle, err := cht.previousCandle(previousCandle); err != ErrLastCandle {
-				if thirdCandle.IsBullish() {
-					if previousCandle.IsDoji() {
-						if previousCandle.Low > thirdCandle.Close && lastCandle.Open < previousCandle.Close {
-							if lastCandle.Close > thirdCandle.Open {
-								// conditions for an evening doji star has been met.
-								cht.AddBearishPattern(thirdCandle, EveningDojiStar)
-							}
-						}
-					} else { // Next to last candle is Not a doji
-						// Previous candle is relatively small and gaps above the previous (third to last) candle
-						if previousCandle.Range <= (lastCandle.Range/2) && previousCandle.Open > thirdCandle.Open {
-							// Last candle opens below previous smaller candle and closes deep into the candle two periods before
-							if lastCandle.Open > previousCandle.Close && lastCandle.Close > thirdCandle.Open {
-								// Conditions for an evening star have been met
-								cht.AddBearishPattern(thirdCandle, BearishEveningStar)
-							}
-						}
+				// The star gaps above the third to last candle, and the last candle closes deep into its body.
+				gap, _ := previousCandle.GapFrom(thirdCandle, t)
+				if thirdCandle.IsBullish() && gap == Bullish && lastCandle.Close < thirdCandle.bodyMiddle() {
+					if previousCandle.isDoji(t, atr) {
+						// conditions for an evening doji star has been met.
+						cht.AddBearishPattern(thirdCandle, EveningDojiStar)
+					} else if previousCandle.Body() <= t.StarBody*lastCandle.Body() {
+						// Conditions for an evening star have been met
+						cht.AddBearishPattern(thirdCandle, BearishEveningStar)
 					}
 				}
 			}
@@ -565,11 +781,7 @@ func (cht CandleChart) DetectPatterns() {
 				if fifthCandle, err := cht.previousCandle(previousThreeCandles[len(previousThreeCandles)-1]); err != ErrLastCandle {
 					// fifthCandle is the one that preceedes the three bullish candles and of course our bearish current candle
 					if fifthCandle.IsBearish() {
-						highestPrices := []float64{}
-						for _, candle := range previousThreeCandles {
-							highestPrices = append(highestPrices, candle.High)
-						}
-						if Max64(highestPrices) < fifthCandle.High {
+						if _, highest := candleExtremes(previousThreeCandles, candleHigh); highest < fifthCandle.High {
 							cht.AddBearishPattern(fifthCandle, BearishFallingThree)
 						}
 					}
@@ -583,11 +795,7 @@ func (cht CandleChart) DetectPatterns() {
 			if bothBullish {
 				if fourthCandle, err := cht.previousCandle(previousTwoCandles[1]); err != ErrLastCandle {
 					if fourthCandle.IsBearish() {
-						highestPrices := []float64{}
-						for _, candle := range previousTwoCandles {
-							highestPrices = append(highestPrices, candle.High)
-						}
-						if Max64(highestPrices) < fourthCandle.High {
+						if _, highest := candleExtremes(previousTwoCandles, candleHigh); highest < fourthCandle.High {
 							cht.AddBearishPattern(fourthCandle, BearishFallingTwo)
 						}
 					}
@@ -624,25 +832,15 @@ func (cht CandleChart) DetectPatterns() {
 			}
 			// Check for bullish morning star
 			if thirdCandle, err := cht.previousCandle(previousCandle); err != ErrLastCandle {
-				if thirdCandle.IsBearish() {
-					if previousCandle.IsDoji() { // Check for morning doji star
-						if previousCandle.High < thirdCandle.Close && lastCandle.Open > previousCandle.Close {
-							if lastCandle.Close < thirdCandle.Open {
-								// conditions for an evening doji star has been met.
-								cht.AddBearishPattern(thirdCandle, EveningDojiStar)
-							}
-						}
-					} else {
-						// Previous candle is relatively small and gaps below the previous (third to last) candle
-						// if previousCandle.Range <= (lastCandle.Range/2) && previousCandle.Open < thirdCandle.Close {
-						if previousCandle.Range <= (lastCandle.Range/2) && previousCandle.Close < thirdCandle.Close {
-							// Last candle closes above previous smaller candle and oens deep into the candle two periods before
-							// if lastCandle.Close > previousCandle.Open && lastCandle.Open < thirdCandle.Open {
-							if lastCandle.Open > previousCandle.Close && lastCandle.Close < thirdCandle.Open {
-								// Conditions for a morning star have been met
-								cht.AddBullishPattern(thirdCandle, BullishMorningStar)
-							}
-						}
+				// The star gaps below the third to last candle, and the last candle closes deep into its body.
+				gap, _ := previousCandle.GapFrom(thirdCandle, t)
+				if thirdCandle.IsBearish() && gap == Bearish && lastCandle.Close > thirdCandle.bodyMiddle() {
+					if previousCandle.isDoji(t, atr) {
+						// conditions for a morning doji star has been met.
+						cht.AddBullishPattern(thirdCandle, MorningDojiStar)
+					} else if previousCandle.Body() <= t.StarBody*lastCandle.Body() {
+						// Conditions for a morning star have been met
+						cht.AddBullishPattern(thirdCandle, BullishMorningStar)
 					}
 				}
 			}
@@ -655,11 +853,7 @@ func (cht CandleChart) DetectPatterns() {
 				if fifthCandle, err := cht.previousCandle(previousThreeCandles[len(previousThreeCandles)-1]); err != ErrLastCandle {
 					// fifthCandle is the one that preceedes the three bearish candles and of course our bullish current candle
 					if fifthCandle.IsBullish() {
-						lowestPrices := []float64{}
-						for _, candle := range previousThreeCandles {
-							lowestPrices = append(lowestPrices, candle.High)
-						}
-						if Min64(lowestPrices) > fifthCandle.Low {
+						if lowest, _ := candleExtremes(previousThreeCandles, candleHigh); lowest > fifthCandle.Low {
 							cht.AddBullishPattern(fifthCandle, BullishRisingThree)
 						}
 					}
@@ -672,11 +866,7 @@ func (cht CandleChart) DetectPatterns() {
 			if bothBearish {
 				if fourthCandle, err := cht.previousCandle(previousTwoCandles[1]); err != ErrLastCandle {
 					if fourthCandle.IsBullish() {
-						lowestPrices := []float64{}
-						for _, candle := range previousTwoCandles {
-							lowestPrices = append(lowestPrices, candle.Low)
-						}
-						if Max64(lowestPrices) > fourthCandle.Low {
+						if _, highest := candleExtremes(previousTwoCandles, candleLow); highest > fourthCandle.Low {
 							cht.AddBearishPattern(fourthCandle, BearishFallingTwo)
 						}
 					}
@@ -692,7 +882,7 @@ func (cht CandleChart) DetectPatterns() {
 	}
 
 	// Check for patterns that end in  a doji
-	if lastCandle.IsDoji() {
+	if lastCandle.isDoji(t, atr) {
 		// Check for bullish harami cross
 		if previousCandle, err := cht.previousCandle(lastCandle); err != ErrLastCandle {
 			if previousCandle.IsBearish() && lastCandle.High < previousCandle.High && lastCandle.Low > previousCandle.Low {
@@ -709,6 +899,10 @@ func (cht CandleChart) DetectPatterns() {
 
 }
 
+// candleHigh and candleLow select a price of a candle, see candleExtremes.
+func candleHigh(candle OHLC) float64 { return candle.High }
+func candleLow(candle OHLC) float64  { return candle.Low }
+
 // Min64 returns the smallest value in a float64 list
 func Min64(a []float64) float64 {
 	if len(a) == 0 {
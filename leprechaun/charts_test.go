@@ -0,0 +1,80 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCandleTrendAcrossPriceScales(t *testing.T) {
+	scales := []struct {
+		name string
+		open float64
+	}{
+		{"XRP/USD", 0.62},
+		{"XRP/NGN", 512},
+		{"ETH/NGN", 2.1e6},
+		{"BTC/NGN", 5.6e7},
+	}
+	moves := []struct {
+		name string
+		move float64 // of the close from the open, as a multiple of the trend epsilon
+		want ChartTrend
+	}{
+		{"flat", 0, Indifferent},
+		{"just above epsilon", 1.01, Bullish},
+		{"just below epsilon", 0.99, Indifferent},
+		{"just above -epsilon", -0.99, Indifferent},
+		{"just below -epsilon", -1.01, Bearish},
+		{"large rise", 100, Bullish},
+		{"large fall", -100, Bearish},
+	}
+	for _, scale := range scales {
+		for _, move := range moves {
+			t.Run(fmt.Sprintf("%s/%s", scale.name, move.name), func(t *testing.T) {
+				closing := scale.open * (1 + move.move*trendEpsilon)
+				candle := doOHLC(time.Date(2023, 11, 15, 10, 0, 0, 0, time.UTC), []float64{scale.open, closing}, 1)
+				if candle.Trend != move.want {
+					t.Errorf("a candle from %v to %v is %v, want %v", scale.open, closing, candle.Trend, move.want)
+				}
+				if (candle.Trend == Bullish) != candle.IsBullish() || (candle.Trend == Bearish) != candle.IsBearish() {
+					t.Errorf("IsBullish() = %v and IsBearish() = %v for a %v candle", candle.IsBullish(),
+						candle.IsBearish(), candle.Trend)
+				}
+			})
+		}
+	}
+}
+
+func TestSmallBullishCandleIsBullish(t *testing.T) {
+	// A rise of less than 1 in absolute terms used to make a candle bearish.
+	candle := doOHLC(time.Now(), []float64{0.5, 0.45, 0.58, 0.55}, 1)
+	if candle.Trend != Bullish {
+		t.Errorf("a candle rising from 0.5 to 0.55 is %v, want Bullish", candle.Trend)
+	}
+}
+
+func TestCandleTails(t *testing.T) {
+	tests := []struct {
+		name                 string
+		prices               []float64
+		wantUpper, wantLower float64
+	}{
+		{"bullish", []float64{100, 95, 120, 110}, 10, 5},
+		{"bearish", []float64{110, 120, 95, 100}, 10, 5},
+		{"no tails", []float64{100, 110}, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candle := doOHLC(time.Now(), test.prices, 1)
+			if candle.UpperTail != test.wantUpper || candle.LowerTail != test.wantLower {
+				t.Errorf("tails are %v and %v, want %v and %v", candle.UpperTail, candle.LowerTail, test.wantUpper,
+					test.wantLower)
+			}
+		})
+	}
+}
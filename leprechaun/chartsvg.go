@@ -0,0 +1,132 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// equityCurveSVG draws the total profit over time as an SVG line chart `width` by `height` pixels.
+func equityCurveSVG(points []EquityPoint, width, height int) template.HTML {
+	if len(points) < 2 {
+		return ""
+	}
+	minProfit, maxProfit := 0.0, 0.0
+	for _, p := range points {
+		if p.Profit < minProfit {
+			minProfit = p.Profit
+		}
+		if p.Profit > maxProfit {
+			maxProfit = p.Profit
+		}
+	}
+	if maxProfit == minProfit {
+		maxProfit = minProfit + 1
+	}
+	start, span := points[0].Time, points[len(points)-1].Time.Sub(points[0].Time)
+	x := func(t time.Time) float64 {
+		if span <= 0 {
+			return 0
+		}
+		return float64(t.Sub(start)) / float64(span) * float64(width)
+	}
+	y := func(profit float64) float64 {
+		return float64(height) - (profit-minProfit)/(maxProfit-minProfit)*float64(height)
+	}
+	var line strings.Builder
+	for _, p := range points {
+		fmt.Fprintf(&line, "%.1f,%.1f ", x(p.Time), y(p.Profit))
+	}
+	// Everything written here is numbers, so the markup is safe.
+	return template.HTML(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %[1]d %[2]d">`+
+		`<line x1="0" y1="%.1f" x2="%[1]d" y2="%.1[3]f" stroke="#999" stroke-dasharray="4"/>`+
+		`<polyline fill="none" stroke="#2a7" stroke-width="2" points="%s"/></svg>`, width, height, y(0), line.String()))
+}
+
+// barChartSVG draws one bar per value as an SVG chart `width` by `height` pixels, with gains above
+// and losses below the zero line.
+func barChartSVG(labels []string, values []float64, width, height int) template.HTML {
+	if len(values) == 0 {
+		return ""
+	}
+	const labelHeight = 20
+	minValue, maxValue := 0.0, 0.0
+	for _, v := range values {
+		if v < minValue {
+			minValue = v
+		}
+		if v > maxValue {
+			maxValue = v
+		}
+	}
+	if maxValue == minValue {
+		maxValue = minValue + 1
+	}
+	plotHeight := float64(height - labelHeight)
+	y := func(v float64) float64 {
+		return plotHeight - (v-minValue)/(maxValue-minValue)*plotHeight
+	}
+	slot := float64(width) / float64(len(values))
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %[1]d %[2]d">`, width, height)
+	for i, v := range values {
+		top, bottom := y(v), y(0)
+		color := "#2a7"
+		if v < 0 {
+			top, bottom, color = y(0), y(v), "#c33"
+		}
+		x := float64(i)*slot + slot*0.2
+		fmt.Fprintf(&svg, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, top, slot*0.6, bottom-top, color)
+		fmt.Fprintf(&svg, `<text x="%.1f" y="%d" font-size="12" text-anchor="middle">%s</text>`,
+			x+slot*0.3, height-5, template.HTMLEscapeString(labels[i]))
+	}
+	fmt.Fprintf(&svg, `<line x1="0" y1="%.1f" x2="%d" y2="%.1[1]f" stroke="#999"/></svg>`, y(0), width)
+	return template.HTML(svg.String())
+}
+
+// priceChartSVG draws the closing prices of time ordered `candles` as an SVG line chart `width` by `height`
+// pixels, with the range between each candle's low and high shaded behind it.
+func priceChartSVG(candles []OHLC, width, height int) template.HTML {
+	if len(candles) < 2 {
+		return ""
+	}
+	low, high := candles[0].Low, candles[0].High
+	for _, candle := range candles {
+		if candle.Low < low {
+			low = candle.Low
+		}
+		if candle.High > high {
+			high = candle.High
+		}
+	}
+	if high == low {
+		high = low + 1
+	}
+	start, span := candles[0].Time, candles[len(candles)-1].Time.Sub(candles[0].Time)
+	x := func(t time.Time) float64 {
+		if span <= 0 {
+			return 0
+		}
+		return float64(t.Sub(start)) / float64(span) * float64(width)
+	}
+	y := func(price float64) float64 {
+		return float64(height) - (price-low)/(high-low)*float64(height)
+	}
+	var line, band strings.Builder
+	for _, candle := range candles {
+		fmt.Fprintf(&line, "%.1f,%.1f ", x(candle.Time), y(candle.Close))
+		fmt.Fprintf(&band, "%.1f,%.1f ", x(candle.Time), y(candle.High))
+	}
+	for i := len(candles) - 1; i >= 0; i-- {
+		fmt.Fprintf(&band, "%.1f,%.1f ", x(candles[i].Time), y(candles[i].Low))
+	}
+	// Everything written here is numbers, so the markup is safe.
+	return template.HTML(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %[1]d %[2]d">`+
+		`<polygon fill="#dde" points="%s"/><polyline fill="none" stroke="#27a" stroke-width="2" points="%s"/></svg>`,
+		width, height, band.String(), line.String()))
+}
@@ -0,0 +1,104 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// pricePoint is a price seen at some time.
+type pricePoint struct {
+	time  time.Time
+	price float64
+}
+
+// circuitBreaker pauses new entries on an asset whose price moves more than `limit` (a fraction) within `window`,
+// e.g. during a flash crash or pump, until `cooldown` has passed since the move.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	limit    float64 // zero disables the breaker
+	window   time.Duration
+	cooldown time.Duration
+	prices   map[string][]pricePoint // prices of each asset seen within `window`, oldest first
+	tripped  map[string]time.Time    // when the breaker of each asset resets
+}
+
+func newCircuitBreaker(limit float64, window, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{limit: limit, window: window, cooldown: cooldown,
+		prices: make(map[string][]pricePoint), tripped: make(map[string]time.Time)}
+}
+
+// observe records the price of `asset` at `now` and returns how far it has moved from the highest or lowest price
+// within the window, as a fraction. `trip` is true if the move trips a breaker that wasn't already tripped.
+func (b *circuitBreaker) observe(asset string, price float64, now time.Time) (move float64, trip bool) {
+	if b.limit <= 0 || price <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	points := b.prices[asset]
+	for len(points) > 0 && now.Sub(points[0].time) > b.window {
+		points = points[1:]
+	}
+	points = append(points, pricePoint{now, price})
+	b.prices[asset] = points
+	low, high := price, price
+	for _, p := range points {
+		low, high = math.Min(low, p.price), math.Max(high, p.price)
+	}
+	move = math.Max((price-low)/low, (high-price)/high)
+	if move <= b.limit {
+		return
+	}
+	until, ok := b.tripped[asset]
+	b.tripped[asset] = now.Add(b.cooldown)
+	return move, !ok || !now.Before(until)
+}
+
+// paused returns how long new entries on `asset` stay paused, or zero if they aren't.
+func (b *circuitBreaker) paused(asset string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.tripped[asset]
+	if !ok {
+		return 0
+	}
+	if !now.Before(until) {
+		delete(b.tripped, asset)
+		log.Printf("The circuit breaker of %s has reset. New positions may be opened again.", asset)
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// checkCircuitBreaker feeds the latest price of `asset` to the circuit breaker. When it trips, the user is notified
+// and, if CircuitBreakerLiquidate is set, the asset's open positions are closed.
+func (pf *Portfolio) checkCircuitBreaker(asset string, price float64) {
+	move, trip := pf.breaker.observe(asset, price, pf.clock.Now())
+	if !trip {
+		return
+	}
+	window := time.Duration(pf.config.CircuitBreakerWindow) * time.Second
+	cooldown := time.Duration(pf.config.CircuitBreakerCooldown) * time.Second
+	msg := pf.messages.Sprintf(MsgCircuitBreaker, asset, move*100, window, cooldown)
+	log.Println(msg)
+	pf.events.record("circuit-breaker", asset, msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgCircuitBreakerSubject, asset), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+	if pf.config.CircuitBreakerLiquidate && !pf.watchOnly {
+		go func() {
+			report, err := pf.liquidate(asset, pf.config.MaxSlippage)
+			if err != nil {
+				pf.errs.report(asset, "liquidate position", err)
+				return
+			}
+			log.Printf("The circuit breaker closed %d %s position(s), %d were left open", report.Closed, asset, report.Skipped)
+		}()
+	}
+}
@@ -0,0 +1,149 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Everything that timestamps records or buckets candles should ask
+// a Clock rather than calling time.Now directly, so the bot's notion of time can be
+// corrected for skew against the exchange and reported in the user's time zone.
+// Waits go through the Clock too, so tests can run on a VirtualClock instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once the duration has elapsed.
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a call scheduled with Clock.AfterFunc.
+type ClockTimer interface {
+	// Stop prevents the call from happening. It returns false if the call has already happened or been stopped.
+	Stop() bool
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+// wallClock tells the time of the commands that run without a session, e.g. `leprechaun report`, and the real
+// time the soak test gives the session's goroutines.
+var wallClock Clock = realClock{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// skewClock is the local clock adjusted by the last measured offset from the exchange's clock.
+type skewClock struct {
+	mu     sync.RWMutex
+	base   Clock // the local clock
+	offset time.Duration
+	loc    *time.Location
+}
+
+func newSkewClock() *skewClock {
+	return &skewClock{base: realClock{}, loc: time.Local}
+}
+
+// Now returns the local time adjusted by the current offset, in the clock's time zone.
+func (c *skewClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.base.Now().Add(c.offset).In(c.loc)
+}
+
+// After waits on the local clock.
+func (c *skewClock) After(d time.Duration) <-chan time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.base.After(d)
+}
+
+// AfterFunc schedules a call on the local clock.
+func (c *skewClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.base.AfterFunc(d, f)
+}
+
+func (c *skewClock) setBase(base Clock) {
+	c.mu.Lock()
+	c.base = base
+	c.mu.Unlock()
+}
+
+// Location returns the time zone of the times returned by Now.
+func (c *skewClock) Location() *time.Location {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loc
+}
+
+func (c *skewClock) setLocation(loc *time.Location) {
+	c.mu.Lock()
+	c.loc = loc
+	c.mu.Unlock()
+}
+
+// Offset returns the duration added to the local time.
+func (c *skewClock) Offset() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offset
+}
+
+func (c *skewClock) setOffset(offset time.Duration) {
+	c.mu.Lock()
+	c.offset = offset
+	c.mu.Unlock()
+}
+
+// local returns the local clock the offset is added to.
+func (c *skewClock) local() Clock {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.base
+}
+
+// measureClockSkew estimates how far the exchange's clock is ahead of (positive) or
+// behind (negative) the `local` clock. The request latency is split evenly on both sides.
+func measureClockSkew(local Clock, handler ExchangeHandler) (skew time.Duration, err error) {
+	before := local.Now()
+	serverTime, err := handler.ServerTime()
+	if err != nil {
+		return
+	}
+	after := local.Now()
+	return serverTime.Sub(before.Add(after.Sub(before) / 2)), nil
+}
+
+// syncClock measures the clock skew against the exchange and warns if it exceeds `threshold`.
+// When `compensate` is true the clock is adjusted so that it matches the exchange's time.
+func syncClock(clock *skewClock, handler ExchangeHandler, threshold time.Duration, compensate bool) (skew time.Duration, err error) {
+	skew, err = measureClockSkew(clock.local(), handler)
+	if err != nil {
+		return
+	}
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs <= threshold {
+		clock.setOffset(0)
+		return
+	}
+	log.Printf("WARNING: Your system clock differs from %s's by %s. Timestamps and price data may be inaccurate. Please synchronize your system time.", handler, skew.Round(time.Millisecond))
+	if compensate {
+		clock.setOffset(skew)
+		log.Printf("Compensating for clock skew of %s.", skew.Round(time.Millisecond))
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// longPausePeriods is the number of analysis periods an asset may go without being analyzed before its data is
+// considered stale, e.g. after the machine slept or the analysis loop was stuck.
+const longPausePeriods = 3
+
+// coldStart keeps orders from being placed on an asset until the session has fresh data of it: analyses spanning
+// at least one full analysis period, and a balance fetched since they started. Cached candles and balances may be
+// stale on startup, after a maintenance pause and after a long pause in the analysis.
+type coldStart struct {
+	mu       sync.Mutex
+	since    map[string]time.Time // first analysis of each asset since its data went stale
+	last     map[string]time.Time // last analysis of each asset
+	balanced map[string]bool      // the balances of each asset have been fetched since its data went stale
+}
+
+func newColdStart() *coldStart {
+	return &coldStart{since: make(map[string]time.Time), last: make(map[string]time.Time), balanced: make(map[string]bool)}
+}
+
+// analyzed records that `asset` was analyzed at `now`. If it hadn't been analyzed for longPausePeriods analysis
+// periods, its data is considered stale and the cold start begins again.
+func (c *coldStart) analyzed(asset string, now time.Time, interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, ok := c.last[asset]
+	if ok && now.Sub(last) > longPausePeriods*interval {
+		log.Printf("%s wasn't analyzed for %s. No orders will be placed until its data is fresh again.", asset,
+			now.Sub(last).Round(time.Second))
+		delete(c.since, asset)
+		c.balanced[asset] = false
+	}
+	if _, ok := c.since[asset]; !ok {
+		c.since[asset] = now
+	}
+	c.last[asset] = now
+}
+
+// balanceChecked records that the balances of `asset` were fetched.
+func (c *coldStart) balanceChecked(asset string) {
+	c.mu.Lock()
+	c.balanced[asset] = true
+	c.mu.Unlock()
+}
+
+// reset makes every asset start cold again, e.g. after the exchange was down for maintenance.
+func (c *coldStart) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.since = make(map[string]time.Time)
+	c.balanced = make(map[string]bool)
+}
+
+// fresh returns true if `asset` has been analyzed over at least one full analysis period since its data went stale.
+func (c *coldStart) fresh(asset string, interval time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	since, ok := c.since[asset]
+	return ok && c.last[asset].Sub(since) >= interval
+}
+
+// ready returns true if both the data and the balances of `asset` are fresh.
+func (c *coldStart) ready(asset string, interval time.Duration) bool {
+	if !c.fresh(asset, interval) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.balanced[asset]
+}
+
+// warm returns true if orders may be placed on `asset`. Once its data is fresh, its balances are fetched
+// here if they haven't been since.
+func (pf *Portfolio) warm(asset string) bool {
+	interval := pf.analysisInterval(asset)
+	if !pf.coldStart.fresh(asset, interval) {
+		return false
+	}
+	if !pf.coldStart.ready(asset, interval) {
+		if err := pf.refreshBalance(asset); err != nil {
+			pf.errs.report(asset, "check balances", err)
+			return false
+		}
+	}
+	return true
+}
+
+// Ready returns true once every asset has fresh enough data for orders to be placed on it: analyses spanning a
+// full analysis period and a balance check since the session started, or since the data last went stale.
+func (s *Session) Ready() bool {
+	for asset := range s.portfolio.assets {
+		if !s.portfolio.coldStart.ready(asset, s.portfolio.analysisInterval(asset)) {
+			return false
+		}
+	}
+	return true
+}
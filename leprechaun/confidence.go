@@ -0,0 +1,59 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// ConfidentAnalyzer is implemented by analyzer plugins that say how sure they are of each signal, so strong
+// signals can be traded with bigger positions than weak ones. See Configuration.ConfidenceTiers.
+type ConfidentAnalyzer interface {
+	Analyzer
+	// Confidence returns how sure the plugin is of the signal it emitted last, from 0 (a guess) to 1 (certain).
+	Confidence() float64
+}
+
+// ConfidenceTier sizes the positions opened on signals of at least MinConfidence. The tier with the highest
+// MinConfidence a signal reaches applies; signals below every tier are skipped, as are those of a tier with
+// a Size of zero.
+type ConfidenceTier struct {
+	MinConfidence float64 // from 0 to 1
+	Size          float64 // share of the purchase unit
+}
+
+// defaultConfidenceTiers skip the weakest signals and trade the moderately confident ones at half size.
+func defaultConfidenceTiers() []ConfidenceTier {
+	return []ConfidenceTier{{MinConfidence: 0.3, Size: 0.5}, {MinConfidence: 0.7, Size: 1}}
+}
+
+// confidenceSize returns the share of the purchase unit a signal of `confidence` is traded with, and false if it
+// should be skipped. A confidence of zero means the analyzer doesn't report it, so the signal is traded in full.
+func (c *Configuration) confidenceSize(confidence float64) (size float64, ok bool) {
+	if confidence <= 0 || len(c.ConfidenceTiers) == 0 {
+		return 1, true
+	}
+	best := math.Inf(-1)
+	for _, tier := range c.ConfidenceTiers {
+		if confidence >= tier.MinConfidence && tier.MinConfidence > best {
+			best, size = tier.MinConfidence, tier.Size
+		}
+	}
+	return size, size > 0
+}
+
+// confident returns false, and logs why, if the confidence of `signal` is too low for it to be traded.
+func (pf *Portfolio) confident(signal Signal) bool {
+	if _, ok := pf.config.confidenceSize(signal.Confidence); ok {
+		return true
+	}
+	log.Printf("Skipping the %v signal for %s: its confidence of %.2f is below every ConfidenceTiers size", signal.Kind,
+		signal.Asset, signal.Confidence)
+	pf.events.record("skipped", signal.Asset, fmt.Sprintf("Skipped a %v signal of low confidence (%.2f)", signal.Kind,
+		signal.Confidence))
+	return false
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/Tkanos/gonfig"
+	"unit2/leprechaun/internal/atomicfile"
 )
 
 func init() {
@@ -19,12 +22,22 @@ func init() {
 }
 
 var (
-	apiKeyID                  = flag.String("api-key-id", "89p3njup22kr", "Your Luno API key ID (*required)")
-	apiKeySecret              = flag.String("api-key-secret", "awPiuPhKw9AWFR4K95AHwI_kchmMMhJ257fkZ-HJa6o", "Your Luno API key secret (*required)")
+	apiKeyID                  = flag.String("api-key-id", "", `Your Luno API key ID. Defaults to the key saved by "leprechaun config init".`)
+	apiKeySecret              = flag.String("api-key-secret", "", `Your Luno API key secret. Defaults to the key saved by "leprechaun config init".`)
 	assetsToTrade             = flag.String("assets", "xrp", `Specify assets you want Leprechaun to trade for you. Use the three-letter code of each asset seperated by a "+". e.g. To trade bitcoin and ripple coin, use "btc+xrp". Note that you must already have created a luno wallet for each asset you want to trade.`)
 	purchaseUnit              = flag.Float64("purchase-unit", 600, "Specify how much you want to spend for each of Leprechaun's purchase")
+	purchasePercent           = flag.Float64("purchase-percent", 0, `Spend this percentage of your fiat balance on each purchase instead of a fixed "purchase-unit", e.g. 5 for 5%.`)
+	sandbox                   = flag.Bool("sandbox", false, "Trade on the exchange's sandbox (testnet) where it has one, or on a simulated account with real prices where it doesn't. No real money is used.")
+	compound                  = flag.Bool("compound", false, "Reinvest realized profits in later purchases. By default the amount spent on each purchase stays fixed and profits are set aside.")
 	profitMargin              = flag.Float64("profit-margin", 3.0, "Minimum profit margin at which to sell assets. Refer to the help file for more information. Default is 1%")
 	verbose                   = flag.Bool("verbose", true, `Setting -verbose to "true" prints the bot's output to the command line (screen). Set it to "false" to prevent this behaviour. Note that some messages will still be written to the screen. The bot's output messages are always written to a log file anyway.`)
+	locale                    = flag.String("locale", "en", `Language and number format of Leprechaun's messages, e.g. "en" or "fr".`)
+	timeZone                  = flag.String("timezone", "", `The time zone used for reports and to decide when a trading day starts, e.g. "Africa/Lagos". Defaults to the time zone of your computer.`)
+	configDir                 = flag.String("config-dir", "", "Folder the settings file is kept in. Defaults to a Leprechaun folder in your user configuration folder.")
+	dataDir                   = flag.String("data-dir", "", "Folder the ledger, keystore and models are kept in. Defaults to the data folder next to the settings.")
+	logDir                    = flag.String("log-dir", "", "Folder log files are written to. Defaults to a Leprechaun folder in your user cache folder.")
+	templateDir               = flag.String("templates", "", "Folder of templates, e.g. report.html, that replace the ones built into Leprechaun. Defaults to the built-in templates.")
+	traceFile                 = flag.String("trace", "", "Write every request made to the exchange and its response to this file, with your API keys removed. Attach it when you report a bug in a trade.")
 	exitIfNoClientInitialized = flag.Bool("exit-on-init-error", false, `Setting the "exit-on-init-error" flag to true causes Leprechaun to exit immediately if it cannot connect to the exhange on startup (Ususally due to a bad internet connection). Setting it to false will cause Leprechaun to wait for some time before trying again and again. This can be useful if the user intends to let the bot run for long periods without supervision.`)
 )
 
@@ -46,7 +59,16 @@ type TradeSettings struct {
 		StopLossPercentage float64
 	}
 	AnalysisPlugin struct {
-		Name string
+		Name     string
+		Features []string // Features extracted for machine learning plugins. See `FeaturePipeline`.
+		// HitRateWindow is the number of signals a machine learning plugin's hit rate is measured over. See `DriftGuard`.
+		HitRateWindow int
+		// MinHitRate is the hit rate below which a machine learning plugin is replaced by a rules-based one.
+		MinHitRate float64
+		// UseGPU runs machine learning models on the GPU. Requires building with the `cuda` tag.
+		UseGPU bool
+		// InferenceWorkers is the number of assets whose models are run concurrently. See `RunInference`.
+		InferenceWorkers int
 	}
 }
 
@@ -64,18 +86,31 @@ func (field *ConfigField) Update(val ...interface{}) {
 
 // Configuration object holds settings for Leprechaun.
 type Configuration struct {
+	Version              int // Schema version of the saved settings. See `configMigrations`.
 	Name                 string
+	Profile              string  // Name of the preset the trading settings were taken from, if any. See `Profiles`.
+	Exchange             string  // Name of the exchange traded on. See `SupportedExchanges`.
+	Sandbox              bool    // Trade on the exchange's sandbox, or on a simulated account if it has none. See `PaperExchangeHandler`.
+	PaperBalance         float64 // Fiat balance (in CurrencyCode) the simulated account starts with.
 	SupportedAssets      []string
 	ExitOnInitFailed     bool
 	APIKeyID             string
-	APIKeySecret         string
+	APIKeySecret         string `json:"-"` // Kept in the keystore, see SaveAPIKeys.
 	PurchaseUnit         float64
+	PurchasePercent      float64 // Percentage of the fiat balance spent on each position instead of PurchaseUnit. Zero uses PurchaseUnit.
+	Compound             bool    // Reinvest realized profits in later positions instead of keeping the stake fixed. See Portfolio.purchaseUnit.
+	PartitionBudget      bool    // Split the funds of each currency evenly between the assets traded against it that have no budget of their own. See AssetSettings.Budget.
 	AssetsToTrade        []string
 	EmailAddress         string
 	ProfitMargin         float64
+	MinProfit            float64                  // Smallest profit (in CurrencyCode, after fees) a position is closed for, on top of ProfitMargin. Zero disables it.
+	Assets               map[string]AssetSettings // Per-asset overrides of the trading settings, keyed by asset code, e.g. "XBT".
+	ConfidenceTiers      []ConfidenceTier         // How positions are sized by the confidence of their signal. See ConfidentAnalyzer.
+	FeeTiers             []FeeTier                // The exchange's fee schedule. Empty uses the fees the exchange reports. See FeeTier.
+	AssetPriority        []string                 // Assets (by name or code) in the order they are traded each round. Unlisted assets follow by name.
 	LedgerDatabase       string
-	SnoozeTimes          []int32
-	SnoozePeriod         int32
+	SnoozeTimes          []int32 // Snoozes (in minutes) between trading rounds that one is picked from at random when RandomSnooze is set.
+	SnoozePeriod         int32   // Snooze (in minutes) between trading rounds.
 	Verbose              bool
 	Debug                bool
 	AdjustedPurchaseUnit float64
@@ -83,11 +118,64 @@ type Configuration struct {
 	CurrencyCode         string
 	CurrencyName         string
 	RandomSnooze         bool
+	MaxClockSkew         int32   // Maximum tolerated difference (in seconds) between the local and exchange clocks.
+	CompensateClockSkew  bool    // Adjust timestamps by the measured clock skew when it exceeds MaxClockSkew.
+	Locale               string  // Language and number format of user-facing messages, e.g. "en". See `Localizer`.
+	TemplateDir          string  // Folder of templates, e.g. report.html, that replace the ones built into the binary. Empty uses the built-in templates.
+	TraceFile            string  // File every exchange request and its response are written to, with secrets redacted. Empty disables tracing. See APITrace.
+	TelemetryEndpoint    string  // OpenTelemetry collector the spans of the trade pipeline are exported to over OTLP/HTTP, e.g. "http://localhost:4318" for Jaeger. Empty disables it.
+	TimeZone             string  // IANA name of the time zone used for reports and day boundaries. Empty means local time.
+	CandleGapPolicy      string  // How missing candles are filled: "forward-fill", "interpolate" or "mark-missing". See `GapPolicy`.
+	ExitPrecedence       string  // Which rule closes a position when several want to: "earliest" or "conservative". See `ExitPolicy`.
+	AssetOrder           string  // Order assets are traded in each round: "priority" or "confidence". See `AssetOrder`.
+	MaxTickDeviation     float64 // Price jumps larger than this fraction that revert immediately are discarded as bad ticks. Zero disables the filter.
+	StreamOrderBook      bool    // Stream the order book of each asset so analyzers can use order book features.
+	OrderBookDepth       int     // Number of order book levels used to compute order book features.
+	RequestsPerMinute    int     // Exchange API requests allowed per minute, shared by all modules. See `RateBudget`.
+	MinEntryInterval     int32   // Minimum time (in seconds) between two positions opened on the same asset. Zero disables it.
+	MinGlobalInterval    int32   // Minimum time (in seconds) between two positions opened on any assets. Zero disables it.
+	DailyFeeBudget       float64 // Maximum exchange fees (in CurrencyCode) paid per day before no new positions are opened. Zero means no limit.
+	FeeTierNotice        float64 // Share (as a fraction) of the next fee tier's volume from which the user is told it is close. Zero disables it.
+	ArchiveAfterDays     int32   // Closed trades older than this many days are moved to the ledger's archive. Zero disables archiving.
+	SignalExpiry         int32   // Age (in seconds) after which a signal's price is checked again before acting on it.
+	PriceTolerance       float64 // Largest price move (as a fraction) since a stale signal was generated that still allows acting on it.
+	MaxSlippage          float64 // Largest price move (as a fraction) during a liquidation that positions keep being closed through. See Session.Liquidate.
+	HistoryLength        int     // Candles of each asset kept in memory. Analyzers that look further back get more.
+	AnalysisWorkers      int     // Assets analyzed at the same time each round. Zero uses the default of 4, see defaultAnalysisWorkers.
+	AnalyzerTimeout      int32   // Time (in seconds) an analyzer has to emit a signal before its asset is skipped for the round. Zero disables it.
+	OrderTimeout         int32   // Time (in seconds) to wait for an order to complete before recording the trade with the details known so far.
+	CancelOrdersOnExit   bool    // Cancel the orders that haven't been filled yet when the session ends, so none are left on the exchange.
+	MaxHoldingHours      int32   // Positions open longer than this many hours are closed at market, whatever their profit. Zero disables it.
+	StaleAfterHours      int32   // Positions open longer than this many hours are reported to the user. Zero disables it.
+	StopLatencyWarning   int32   // Latency (in milliseconds) of price and order requests, at the 90th percentile, above which the user is warned that stops may execute late. Zero disables it.
+	StrategyEpsilon      float64 // Share (as a fraction) of the position size spread evenly over an asset's strategies, so the ones behind keep being tried. See Portfolio.SetStrategies.
+	StrategyWindow       int32   // Closed positions of each strategy its recent expectancy is computed from.
 	AppDir               string
 	DataDir              string
 	LogDir               string
 	keyStore             string
 	configFile           string
+
+	// Order book history. See Ledger2.SaveOrderBook.
+	OrderBookSnapshots     int   // Levels of each side of the order book saved with each order placed, to analyze slippage and timing later. Needs StreamOrderBook. Zero disables it.
+	OrderBookRetentionDays int32 // Order book snapshots older than this many days are deleted from the ledger. Zero keeps them.
+
+	// The circuit breaker pauses new positions on an asset whose price moves too far too fast.
+	CircuitBreakerMove      float64 // Price move (as a fraction) within CircuitBreakerWindow that pauses new positions on an asset. Zero disables the circuit breaker.
+	CircuitBreakerWindow    int32   // Time (in seconds) the circuit breaker looks back over.
+	CircuitBreakerCooldown  int32   // Time (in seconds) new positions stay paused after the circuit breaker trips.
+	CircuitBreakerLiquidate bool    // Close the asset's open positions too when the circuit breaker trips. See Session.Liquidate.
+
+	// Checks of the balances against the trades in the ledger.
+	BalanceTolerance      float64 // Largest change (as a fraction) of a balance the ledger can't explain before it is reported as an anomaly. Zero disables the check.
+	PauseOnBalanceAnomaly bool    // Stop opening positions after a balance anomaly until it is acknowledged. See Session.AcknowledgeAnomaly.
+
+	// Profit withdrawals. See ProfitWithdrawer.
+	WithdrawalThreshold   float64 // Realized profit (in CurrencyCode) not yet withdrawn from which it is withdrawn to WithdrawalDestination. Zero disables withdrawals.
+	WithdrawalDestination string  // Where profits are withdrawn to: "account:<id>" for another account on the exchange, "beneficiary:<id>" for a bank account.
+	WithdrawalInterval    int32   // Time (in hours) between two checks of the profits to withdraw.
+	WithdrawalDryRun      bool    // Only tell the user what would have been withdrawn.
+
 	// TradingMode          TradeMode
 	Trade TradeSettings
 }
@@ -104,9 +192,11 @@ var (
 )
 
 // DefaultSettings updates the Configuration struct to their default values.
+// An empty `appDir` uses the platform's default, see DefaultAppDir.
 func (c *Configuration) DefaultSettings(appDir string) error {
 	conf := &Configuration{
 		Name:            os.Getenv("USERPROFILE"),
+		Exchange:        "luno",
 		SupportedAssets: []string{"XBT", "ETH", "XRP", "LTC"},
 		CurrencyCode:    "NGN", CurrencyName: "Naira",
 
@@ -120,17 +210,51 @@ func (c *Configuration) DefaultSettings(appDir string) error {
 		SnoozePeriod:  5,
 		Verbose:       true,
 		Debug:         false,
+
+		MaxClockSkew:        5,
+		CompensateClockSkew: true,
+		Locale:              defaultLanguage,
+		CandleGapPolicy:     string(GapForwardFill),
+		ExitPrecedence:      string(ExitEarliest),
+		AssetOrder:          string(OrderByPriority),
+		MaxTickDeviation:    0.5,
+		StreamOrderBook:     true,
+		OrderBookDepth:      10,
+		RequestsPerMinute:   defaultRequestsPerMinute,
+		MinEntryInterval:    15 * 60,
+		MinGlobalInterval:   60,
+		SignalExpiry:        60,
+		PaperBalance:        100000,
+		ArchiveAfterDays:    90,
+		PriceTolerance:      0.005,
+		MaxSlippage:         0.05,
+		HistoryLength:       defaultHistoryLength,
+		AnalysisWorkers:     defaultAnalysisWorkers,
+		AnalyzerTimeout:     30,
+		OrderTimeout:        60,
+		StaleAfterHours:     48,
+		BalanceTolerance:    0.02,
+		StopLatencyWarning:  2000,
+		StrategyEpsilon:     0.1,
+		StrategyWindow:      20,
+		ConfidenceTiers:     defaultConfidenceTiers(),
+		FeeTierNotice:       0.9,
+		WithdrawalInterval:  24,
+
+		OrderBookRetentionDays: 30,
+		CircuitBreakerMove:     0.1,
+		CircuitBreakerWindow:   15 * 60,
+		CircuitBreakerCooldown: 60 * 60,
 	}
 
 	err := c.Update(conf, true)
 	if err != nil {
 		return err
 	}
-	if appDir != "" {
-		c.SetAppDir(appDir)
-	} else {
-		return errors.New("app dir is not provided")
+	if err = c.applyProfileFlag(); err != nil {
+		return err
 	}
+	c.SetAppDir(appDir)
 	err = c.Save()
 	if err != nil {
 		log.Printf("Save err: %v", err)
@@ -145,19 +269,50 @@ func (c *Configuration) TestConfig(appDir string) error {
 	c.APIKeyID, c.APIKeySecret = *apiKeyID, *apiKeySecret
 	c.ExitOnInitFailed = *exitIfNoClientInitialized
 	c.ProfitMargin, c.PurchaseUnit = *profitMargin/100, *purchaseUnit
+	c.PurchasePercent, c.Compound = *purchasePercent, *compound
+	c.Sandbox, c.PaperBalance = *sandbox, 100000
 	c.CurrencyCode, c.CurrencyName = "NGN", "Naira"
+	c.Exchange = "luno"
 	c.AssetsToTrade = []string{"XRP"}
 	c.SupportedAssets = []string{"XBT", "ETH", "XRP", "LTC"}
 	c.Name = os.Getenv("USERPROFILE")
 	c.SnoozeTimes = []int32{1, 2, 3, 5, 7, 9, 11, 13, 15, 21, 25, 30}
 	c.RandomSnooze = true
 	c.SnoozePeriod = 5
+	c.MaxClockSkew, c.CompensateClockSkew = 5, true
+	c.TimeZone = *timeZone
+	c.Locale = *locale
+	c.TraceFile = *traceFile
+	c.TemplateDir = *templateDir
+	c.CandleGapPolicy = string(GapForwardFill)
+	c.ExitPrecedence = string(ExitEarliest)
+	c.AssetOrder = string(OrderByPriority)
+	c.MaxTickDeviation = 0.5
+	c.StreamOrderBook, c.OrderBookDepth = true, 10
+	c.OrderBookRetentionDays = 30
+	c.RequestsPerMinute = defaultRequestsPerMinute
+	c.MinEntryInterval, c.MinGlobalInterval = 15*60, 60
+	c.SignalExpiry, c.PriceTolerance, c.MaxSlippage = 60, 0.005, 0.05
+	c.CircuitBreakerMove, c.CircuitBreakerWindow, c.CircuitBreakerCooldown = 0.1, 15*60, 60*60
+	c.ArchiveAfterDays = 90
+	c.HistoryLength, c.AnalysisWorkers = defaultHistoryLength, defaultAnalysisWorkers
+	c.AnalyzerTimeout, c.OrderTimeout = 30, 60
+	c.StaleAfterHours, c.BalanceTolerance = 48, 0.02
+	c.StrategyEpsilon, c.StrategyWindow = 0.1, 20
+	c.ConfidenceTiers = defaultConfidenceTiers()
+	c.FeeTierNotice = 0.9
+	c.WithdrawalInterval = 24
+	c.StopLatencyWarning = 2000
 	c.Verbose = true
 	c.Debug = true
-	if appDir != "" {
-		c.SetAppDir(appDir)
-	} else {
-		return errors.New("app dir is not provided")
+	if err := c.applyProfileFlag(); err != nil {
+		return err
+	}
+	c.SetAppDir(appDir)
+	if c.APIKeyID == "" && c.APIKeySecret == "" {
+		if keyID, keySecret, err := c.LoadAPIKeys(); err == nil {
+			c.APIKeyID, c.APIKeySecret = keyID, keySecret
+		}
 	}
 	err := c.Save()
 	if err != nil {
@@ -176,24 +331,58 @@ func (c *Configuration) Save() error {
 			return err
 		}
 	}
-	f, err := os.OpenFile(c.configFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	defer f.Close()
+	c.Version = len(configMigrations)
+	data, err := json.Marshal(c)
 	if err != nil {
-		log.Printf("%v", err)
+		log.Printf("Json encode error in c.Save() :%v", err)
 		return err
 	}
-	// Create a copy of the `Configuration` object for Saving.
-	conf := c
-	e := json.NewEncoder(f).Encode(conf)
-	if e != nil {
-		log.Printf("Json decode error in c.Save() :%v", e)
+	// Keep the previous settings as a backup, unless they are the ones that got corrupted.
+	if previous, err := os.ReadFile(c.configFile); err == nil && json.Valid(previous) {
+		if err = atomicfile.WriteFile(c.backupConfigFile(), previous, 0644); err != nil {
+			log.Printf("Could not back up the settings: %v", err)
+		}
+	}
+	if err = atomicfile.WriteFile(c.configFile, data, 0644); err != nil {
+		log.Printf("%v", err)
 		return err
 	}
 	return nil
 }
 
+// backupConfigFile returns the path of the previous generation of the settings file, see Save.
+func (c *Configuration) backupConfigFile() string {
+	return c.configFile + ".bak"
+}
+
+// readConfigFile decodes the settings saved at `path`, upgrading them from older versions.
+func (c *Configuration) readConfigFile(path string) (conf *Configuration, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", path, err)
+	}
+	if err = c.migrateConfig(fields); err != nil {
+		return nil, fmt.Errorf("could not upgrade %s: %w", path, err)
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	conf = &Configuration{keyStore: c.keyStore} // the one the migrations moved the API keys to
+	if err = json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", path, err)
+	}
+	return conf, nil
+}
+
 // Update the config struct with user defined values and disregard invalid values
 func (c *Configuration) Update(copy *Configuration, isDefault bool) (err error) {
+	if isSupportedExchange(copy.Exchange) || isDefault {
+		c.Exchange = copy.Exchange
+	}
 	if copy.APIKeyID != "" || isDefault {
 		c.APIKeyID = copy.APIKeyID
 	}
@@ -221,9 +410,161 @@ func (c *Configuration) Update(copy *Configuration, isDefault bool) (err error)
 	c.RandomSnooze, c.SnoozePeriod = copy.RandomSnooze, copy.SnoozePeriod
 	c.RandomSnooze = copy.RandomSnooze
 	c.SupportedAssets = DefaultSupportedAssets
-	c.SnoozeTimes, c.CurrencyName = DefaultSnoozeTimes, DefaultCurrencyName
+	c.CurrencyName = DefaultCurrencyName
+	if len(copy.SnoozeTimes) > 0 || isDefault {
+		c.SnoozeTimes = copy.SnoozeTimes
+	}
 	c.CurrencyCode, c.Verbose = DefaultCurrencyCode, copy.Verbose
 	c.keyStore, c.ExitOnInitFailed = copy.keyStore, copy.ExitOnInitFailed
+	if copy.MaxClockSkew > 0 || isDefault {
+		c.MaxClockSkew = copy.MaxClockSkew
+	}
+	c.CompensateClockSkew = copy.CompensateClockSkew
+	if copy.Locale != "" || isDefault {
+		c.Locale = copy.Locale
+	}
+	c.TemplateDir = copy.TemplateDir
+	c.TraceFile = copy.TraceFile
+	c.TelemetryEndpoint = copy.TelemetryEndpoint
+	if _, err := time.LoadLocation(copy.TimeZone); err == nil || isDefault {
+		c.TimeZone = copy.TimeZone
+	}
+	if _, err := parseGapPolicy(copy.CandleGapPolicy); err == nil || isDefault {
+		c.CandleGapPolicy = copy.CandleGapPolicy
+	}
+	if _, err := parseExitPolicy(copy.ExitPrecedence); err == nil || isDefault {
+		c.ExitPrecedence = copy.ExitPrecedence
+	}
+	if _, err := parseAssetOrder(copy.AssetOrder); err == nil || isDefault {
+		c.AssetOrder = copy.AssetOrder
+	}
+	if copy.MaxTickDeviation >= 0 || isDefault {
+		c.MaxTickDeviation = copy.MaxTickDeviation
+	}
+	c.StreamOrderBook = copy.StreamOrderBook
+	if copy.OrderBookDepth > 0 || isDefault {
+		c.OrderBookDepth = copy.OrderBookDepth
+	}
+	if copy.OrderBookSnapshots >= 0 || isDefault {
+		c.OrderBookSnapshots = copy.OrderBookSnapshots
+	}
+	if copy.OrderBookRetentionDays >= 0 || isDefault {
+		c.OrderBookRetentionDays = copy.OrderBookRetentionDays
+	}
+	if _, ok := Profiles[copy.Profile]; ok || copy.Profile == "" {
+		c.Profile = copy.Profile
+	}
+	c.Compound = copy.Compound
+	c.PartitionBudget = copy.PartitionBudget
+	c.CancelOrdersOnExit = copy.CancelOrdersOnExit
+	if copy.MaxHoldingHours >= 0 || isDefault {
+		c.MaxHoldingHours = copy.MaxHoldingHours
+	}
+	if copy.StaleAfterHours >= 0 || isDefault {
+		c.StaleAfterHours = copy.StaleAfterHours
+	}
+	if copy.BalanceTolerance >= 0 || isDefault {
+		c.BalanceTolerance = copy.BalanceTolerance
+	}
+	c.PauseOnBalanceAnomaly = copy.PauseOnBalanceAnomaly
+	if copy.StopLatencyWarning >= 0 || isDefault {
+		c.StopLatencyWarning = copy.StopLatencyWarning
+	}
+	if (copy.StrategyEpsilon >= 0 && copy.StrategyEpsilon <= 1) || isDefault {
+		c.StrategyEpsilon = copy.StrategyEpsilon
+	}
+	if copy.StrategyWindow > 0 || isDefault {
+		c.StrategyWindow = copy.StrategyWindow
+	}
+	if copy.ConfidenceTiers != nil || isDefault {
+		c.ConfidenceTiers = append([]ConfidenceTier(nil), copy.ConfidenceTiers...)
+	}
+	c.Sandbox = copy.Sandbox
+	if copy.PaperBalance > 0 || isDefault {
+		c.PaperBalance = copy.PaperBalance
+	}
+	if copy.MinProfit >= 0 || isDefault {
+		c.MinProfit = copy.MinProfit
+	}
+	if (copy.PurchasePercent >= 0 && copy.PurchasePercent <= 100) || isDefault {
+		c.PurchasePercent = copy.PurchasePercent
+	}
+	if copy.Assets != nil {
+		c.Assets = make(map[string]AssetSettings, len(copy.Assets))
+		for code, settings := range copy.Assets {
+			if settings.ProfitMargin < 0 || settings.PurchaseUnit < 0 || settings.MinProfit < 0 {
+				log.Printf("Ignoring the negative settings of %s", code)
+				continue
+			}
+			c.Assets[strings.ToUpper(code)] = settings
+		}
+	}
+	if copy.LedgerDatabase != "" {
+		c.LedgerDatabase = copy.LedgerDatabase
+	}
+	if copy.RequestsPerMinute > 0 || isDefault {
+		c.RequestsPerMinute = copy.RequestsPerMinute
+	}
+	if copy.MinEntryInterval >= 0 || isDefault {
+		c.MinEntryInterval = copy.MinEntryInterval
+	}
+	if copy.MinGlobalInterval >= 0 || isDefault {
+		c.MinGlobalInterval = copy.MinGlobalInterval
+	}
+	if copy.DailyFeeBudget >= 0 || isDefault {
+		c.DailyFeeBudget = copy.DailyFeeBudget
+	}
+	if copy.FeeTierNotice >= 0 || isDefault {
+		c.FeeTierNotice = copy.FeeTierNotice
+	}
+	if copy.FeeTiers != nil || isDefault {
+		c.FeeTiers = append([]FeeTier(nil), copy.FeeTiers...)
+	}
+	if copy.AssetPriority != nil || isDefault {
+		c.AssetPriority = append([]string(nil), copy.AssetPriority...)
+	}
+	if copy.WithdrawalThreshold >= 0 || isDefault {
+		c.WithdrawalThreshold = copy.WithdrawalThreshold
+	}
+	c.WithdrawalDestination = copy.WithdrawalDestination
+	if copy.WithdrawalInterval > 0 || isDefault {
+		c.WithdrawalInterval = copy.WithdrawalInterval
+	}
+	c.WithdrawalDryRun = copy.WithdrawalDryRun
+	if copy.ArchiveAfterDays >= 0 || isDefault {
+		c.ArchiveAfterDays = copy.ArchiveAfterDays
+	}
+	if copy.SignalExpiry > 0 || isDefault {
+		c.SignalExpiry = copy.SignalExpiry
+	}
+	if copy.PriceTolerance > 0 || isDefault {
+		c.PriceTolerance = copy.PriceTolerance
+	}
+	if copy.MaxSlippage >= 0 || isDefault {
+		c.MaxSlippage = copy.MaxSlippage
+	}
+	if copy.CircuitBreakerMove >= 0 || isDefault {
+		c.CircuitBreakerMove = copy.CircuitBreakerMove
+	}
+	if copy.CircuitBreakerWindow > 0 || isDefault {
+		c.CircuitBreakerWindow = copy.CircuitBreakerWindow
+	}
+	if copy.CircuitBreakerCooldown >= 0 || isDefault {
+		c.CircuitBreakerCooldown = copy.CircuitBreakerCooldown
+	}
+	c.CircuitBreakerLiquidate = copy.CircuitBreakerLiquidate
+	if copy.HistoryLength > 0 || isDefault {
+		c.HistoryLength = copy.HistoryLength
+	}
+	if copy.AnalysisWorkers > 0 || isDefault {
+		c.AnalysisWorkers = copy.AnalysisWorkers
+	}
+	if copy.AnalyzerTimeout >= 0 || isDefault {
+		c.AnalyzerTimeout = copy.AnalyzerTimeout
+	}
+	if copy.OrderTimeout >= 0 || isDefault {
+		c.OrderTimeout = copy.OrderTimeout
+	}
 	if copy.AppDir != "" && !isDefault {
 		c.SetAppDir(filepath.Dir(copy.AppDir))
 	}
@@ -235,21 +576,25 @@ func (c *Configuration) LoadConfig(appDir string) (err error) {
 	if c.AppDir == "" && appDir != "" {
 		c.SetAppDir(appDir)
 	}
-	if !exists(c.configFile) {
+	backup := c.backupConfigFile()
+	if !exists(c.configFile) && !exists(backup) {
 		// No settings were saved. usually happens the first time the app is run in a new location
 		return ErrNoSavedSettings
 	}
-	f, err := os.OpenFile(c.configFile, os.O_RDWR, 0644)
-	gonfig.GetConf(c.configFile, &c)
-	defer f.Close()
-	if err != nil {
-		return err
-	}
-	// Load filenames into `files`
-	conf := &Configuration{}
-	err = json.NewDecoder(f).Decode(&conf)
+	conf, err := c.readConfigFile(c.configFile)
 	if err != nil {
-		return err
+		// The settings file is missing or was corrupted, e.g. by a crash while it was written by an
+		// older version. Fall back to the previous generation and put it back in place.
+		var bErr error
+		if conf, bErr = c.readConfigFile(backup); bErr != nil {
+			return err
+		}
+		log.Printf("Could not load the settings (%v). Restored them from %s.", err, backup)
+		if data, err := os.ReadFile(backup); err == nil {
+			if err = atomicfile.WriteFile(c.configFile, data, 0644); err != nil {
+				log.Printf("Could not restore the settings file: %v", err)
+			}
+		}
 	}
 	err = c.Update(conf, false)
 	if err != nil {
@@ -259,6 +604,19 @@ func (c *Configuration) LoadConfig(appDir string) (err error) {
 
 }
 
+// Location returns the time zone reports and day boundaries are computed in.
+func (c *Configuration) Location() *time.Location {
+	if c.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		log.Printf("Unknown time zone %q. Using local time instead.", c.TimeZone)
+		return time.Local
+	}
+	return loc
+}
+
 // ExportAPIVars sets the api key id and key secret environment variables
 func (c *Configuration) ExportAPIVars(keyID, keySecret string) (err error) {
 	// Put the keys into an env var while app is running
@@ -281,11 +639,58 @@ func (c *Configuration) ImportAPIVars() (keyID, keySecret string, err error) {
 	return
 }
 
-// SetAppDir uses the platform-aware values from `gioui.org/app`
+// SetAppDir sets where Leprechaun keeps its files. An empty `dir` uses the platform's default, see DefaultAppDir.
+// The -config-dir, -data-dir and -log-dir flags take precedence.
 func (c *Configuration) SetAppDir(dir string) {
+	if dir == "" {
+		dir = DefaultAppDir()
+	}
 	c.AppDir = filepath.Join(dir, "Leprechaun")
 	c.DataDir = filepath.Join(c.AppDir, "data")
+	c.LogDir = defaultLogDir(c.AppDir)
+	c.configFile = filepath.Join(c.DataDir, "config.json")
+	if *dataDir != "" {
+		c.DataDir = *dataDir
+	}
+	if *configDir != "" {
+		c.configFile = filepath.Join(*configDir, "config.json")
+	}
+	if *logDir != "" {
+		c.LogDir = *logDir
+	}
 	c.keyStore = filepath.Join(c.DataDir, "keystore.db")
 	c.LedgerDatabase = filepath.Join(c.DataDir, "ledger.db")
-	c.configFile = filepath.Join(c.DataDir, "config.json")
+}
+
+// DefaultAppDir returns the platform's folder for per-user settings: %AppData% on Windows,
+// ~/Library/Application Support on macOS and $XDG_CONFIG_HOME or ~/.config on Linux.
+// The working directory is used if it can't be determined.
+func DefaultAppDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "."
+	}
+	return dir
+}
+
+// defaultLogDir returns the platform's per-user cache folder for logs, or a folder in `appDir` if there is none.
+func defaultLogDir(appDir string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(appDir, "logs")
+	}
+	return filepath.Join(dir, "Leprechaun", "logs")
+}
+
+// ConfigFile returns the path of the settings file.
+func (c *Configuration) ConfigFile() string {
+	return c.configFile
+}
+
+// openLogFile opens the bot's log file in the log folder for appending.
+func (c *Configuration) openLogFile() (*os.File, error) {
+	if err := os.MkdirAll(c.LogDir, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(c.LogDir, "leprechaun.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 }
@@ -0,0 +1,6 @@
+//go:build cuda
+
+package leprechaun
+
+// cudaEnabled is true when Leprechaun is built with the `cuda` tag.
+const cudaEnabled = true
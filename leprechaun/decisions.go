@@ -0,0 +1,257 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"unit2/leprechaun/internal/atomicfile"
+)
+
+// ErrDecisionTampered is returned when the candles of a decision record don't match the hash taken when it was made.
+var ErrDecisionTampered = errors.New("the decision's candles don't match their recorded hash")
+
+// IndicatorAnalyzer is implemented by analyzer plugins that can report the indicator values behind their
+// last signal, e.g. moving averages or a model's score. They are saved with every trade so it can be debugged later.
+type IndicatorAnalyzer interface {
+	Analyzer
+	// Indicators returns the indicator values the last signal was based on, by name.
+	Indicators() map[string]float64
+}
+
+// DecisionInputs are the exact inputs an analyzer was given when it emitted a signal, and the signal itself.
+type DecisionInputs struct {
+	Asset         string
+	Analyzer      string // The analyzer's Description.
+	Time          time.Time
+	Interval      time.Duration // Interval of the candles.
+	Candles       []OHLC
+	CandlesHash   string // SHA-256 of the candles, see hashCandles.
+	ClosingPrices []float64
+	Price         float64 // Current price passed to SetCurrentPrice.
+	// Timeframes are the candles of each interval passed to a MultiTimeframeAnalyzer.
+	Timeframes map[time.Duration][]OHLC `json:",omitempty"`
+	OrderBook  *OrderBookFeatures       `json:",omitempty"`
+	Market     *MarketContext           `json:",omitempty"` // Passed to a ContextAnalyzer.
+	Indicators map[string]float64       `json:",omitempty"` // See IndicatorAnalyzer.
+	Confidence float64                  `json:",omitempty"` // See ConfidentAnalyzer.
+	State      []byte                   `json:",omitempty"` // State of a StatefulAnalyzer before the signal was emitted.
+	Signal     SIGNAL
+}
+
+// DecisionRecord is the audit record of a trade opened on an analyzer's signal. It holds everything needed to
+// replay the decision with ReplayDecision.
+type DecisionRecord struct {
+	TradeID    string // ID of the order that opened the trade.
+	Type       Order
+	EntryPrice float64
+	Config     json.RawMessage // The settings at the time of the trade, without the API key.
+	Inputs     DecisionInputs
+}
+
+// DecisionDir returns the folder the audit records of trades are kept in.
+func (c *Configuration) DecisionDir() string {
+	return filepath.Join(c.DataDir, "decisions")
+}
+
+// decisionFile returns the path of the audit record of a trade.
+func decisionFile(dir, tradeID string) string {
+	return filepath.Join(dir, strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(tradeID)+".json")
+}
+
+// hashCandles returns a hex encoded SHA-256 hash of `candles`, so a replayed decision can be checked to
+// use the same candles as the original.
+func hashCandles(candles []OHLC) string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(candles)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// configSnapshot encodes the settings for a decision record, leaving out the API key.
+func configSnapshot(c *Configuration) json.RawMessage {
+	copy := *c
+	copy.APIKeyID, copy.APIKeySecret = "", ""
+	data, err := json.Marshal(&copy)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// captureInputs records the inputs about to be passed to the analyzer of `asset`, along with the analyzer's state.
+// The signal is filled in by completeInputs.
+func captureInputs(asset string, analyzer Analyzer, interval time.Duration, candles []OHLC, closingPrices []float64,
+	price float64) *DecisionInputs {
+	inputs := &DecisionInputs{Asset: asset, Analyzer: analyzer.Description(), Interval: interval, Candles: candles,
+		ClosingPrices: closingPrices, Price: price}
+	if stateful, ok := analyzer.(StatefulAnalyzer); ok {
+		var buf bytes.Buffer
+		if err := stateful.SaveState(&buf); err == nil {
+			inputs.State = buf.Bytes()
+		}
+	}
+	return inputs
+}
+
+// completeInputs adds the signal the analyzer emitted, and the indicators it was based on, to `inputs`.
+func completeInputs(inputs *DecisionInputs, analyzer Analyzer, signal SIGNAL, now time.Time) {
+	inputs.Signal, inputs.Time = signal, now
+	if set, ok := analyzer.(*strategySet); ok {
+		// The decision is the strategy's whose signal was acted on, not the whole set's.
+		if name, _, ok := set.acted(); ok {
+			inputs.Analyzer = name
+		}
+	}
+	if reporter, ok := analyzer.(IndicatorAnalyzer); ok {
+		inputs.Indicators = reporter.Indicators()
+	}
+	if confident, ok := analyzer.(ConfidentAnalyzer); ok && signal != SignalWait {
+		inputs.Confidence = confident.Confidence()
+	}
+}
+
+// saveDecision writes the audit record of a trade to `dir`. Like the analyzer states, it is written to a
+// temporary file first so a crash doesn't leave a truncated record behind.
+func saveDecision(dir string, record DecisionRecord) error {
+	return atomicfile.Write(decisionFile(dir, record.TradeID), 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(record)
+	})
+}
+
+// LoadDecision reads the audit record of the trade opened by order `tradeID` from `dir`.
+func LoadDecision(dir, tradeID string) (record DecisionRecord, err error) {
+	data, err := os.ReadFile(decisionFile(dir, tradeID))
+	if os.IsNotExist(err) {
+		return record, fmt.Errorf("no decision has been recorded for trade %s", tradeID)
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &record)
+	return
+}
+
+// recordDecision saves the inputs behind `signal` along with the trade opened on it. Failing to save them
+// doesn't stop trading.
+func (pf *Portfolio) recordDecision(tradeID string, orderType Order, signal Signal) {
+	if signal.Inputs == nil || tradeID == "" {
+		return
+	}
+	record := DecisionRecord{TradeID: tradeID, Type: orderType, EntryPrice: signal.EntryPrice,
+		Config: configSnapshot(pf.config), Inputs: *signal.Inputs}
+	// The candles are only hashed for the rounds that lead to a trade.
+	record.Inputs.CandlesHash = hashCandles(record.Inputs.Candles)
+	if err := saveDecision(pf.config.DecisionDir(), record); err != nil {
+		pf.errs.report(signal.Asset, "record decision", err)
+	}
+}
+
+// ReplayDecision feeds the recorded inputs of a decision to `analyzer` and returns the signal it emits now.
+// The analyzer is set up the way it was when the decision was made, including the state of a StatefulAnalyzer,
+// so a different signal means the analyzer's logic has changed (or isn't deterministic).
+func ReplayDecision(ctx context.Context, record DecisionRecord, analyzer Analyzer) (signal SIGNAL, err error) {
+	inputs := record.Inputs
+	if hashCandles(inputs.Candles) != inputs.CandlesHash {
+		return SignalWait, ErrDecisionTampered
+	}
+	if stateful, ok := analyzer.(StatefulAnalyzer); ok && len(inputs.State) > 0 {
+		if err = stateful.LoadState(bytes.NewReader(inputs.State)); err != nil {
+			return SignalWait, err
+		}
+	}
+	if err = analyzer.SetOHLC(inputs.Candles); err != nil {
+		return SignalWait, err
+	}
+	if err = analyzer.SetClosingPrices(inputs.ClosingPrices); err != nil {
+		return SignalWait, err
+	}
+	if err = analyzer.SetCurrentPrice(inputs.Price); err != nil {
+		return SignalWait, err
+	}
+	if mtAnalyzer, ok := analyzer.(MultiTimeframeAnalyzer); ok && inputs.Timeframes != nil {
+		if err = mtAnalyzer.SetTimeframes(inputs.Timeframes); err != nil {
+			return SignalWait, err
+		}
+	}
+	if obAnalyzer, ok := analyzer.(OrderBookAnalyzer); ok && inputs.OrderBook != nil {
+		if err = obAnalyzer.SetOrderBookFeatures(*inputs.OrderBook); err != nil {
+			return SignalWait, err
+		}
+	}
+	if cxAnalyzer, ok := analyzer.(ContextAnalyzer); ok && inputs.Market != nil {
+		if err = cxAnalyzer.SetContext(*inputs.Market); err != nil {
+			return SignalWait, err
+		}
+	}
+	return analyzer.Emit(ctx)
+}
+
+// RunReplayDecision implements the `replay-decision <trade-id>` command. It replays the decision behind a trade
+// with a linear policy, as used by the soak command, and shows whether the policy still makes it. Analyzer plugins
+// can be replayed from Go with ReplayDecision.
+func RunReplayDecision(ctx context.Context, args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("replay-decision", flag.ContinueOnError)
+	flags.SetOutput(out)
+	policyFile := flags.String("policy", "", "Linear policy to replay the decision with, as saved by LinearPolicy.Save.")
+	window := flags.Int("window", 50, "Number of candles the policy looks at.")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: leprechaun replay-decision [--policy file] [--window n] <trade-id>")
+	}
+	c := &Configuration{}
+	if err = c.TestConfig(""); err != nil {
+		return
+	}
+	record, err := LoadDecision(c.DecisionDir(), flags.Arg(0))
+	if err != nil {
+		return
+	}
+	messages := NewLocalizer(c.Locale)
+	inputs := record.Inputs
+	fmt.Fprintln(out, messages.Sprintf(MsgReplayRecorded, record.TradeID, inputs.Asset, inputs.Signal,
+		inputs.Time.Format(time.RFC3339), inputs.Analyzer))
+	fmt.Fprintln(out, messages.Sprintf(MsgReplayInputs, len(inputs.Candles), inputs.Interval, inputs.CandlesHash[:12],
+		messages.FormatMoney(inputs.Price, c.CurrencyCode)))
+	names := make([]string, 0, len(inputs.Indicators))
+	for name := range inputs.Indicators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-16s %g\n", name, inputs.Indicators[name])
+	}
+	if *policyFile == "" {
+		return nil
+	}
+	policy, err := LoadLinearPolicy(*policyFile)
+	if err != nil {
+		return
+	}
+	signal, err := ReplayDecision(ctx, record, NewPolicyAnalyzer(policy, *window))
+	if err != nil {
+		return
+	}
+	if signal == inputs.Signal {
+		fmt.Fprintln(out, messages.Sprintf(MsgReplayMatch, signal))
+	} else {
+		fmt.Fprintln(out, messages.Sprintf(MsgReplayMismatch, signal, inputs.Signal))
+	}
+	return nil
+}
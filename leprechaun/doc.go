@@ -0,0 +1,24 @@
+// Package leprechaun is a trading bot for cryptocurrency exchanges. Besides the command line program, it can
+// be embedded in other Go programs, which may run several sessions side by side with different settings.
+//
+// The public API is:
+//
+//   - Configuration holds the settings of a session. Configuration.TestConfig loads them from the settings file
+//     and command line flags; a program may also fill one in itself.
+//   - NewSession creates a session from a Configuration. SessionOption values such as WithLedger,
+//     WithExchange, WithNotifier, WithClock and WithTracerProvider replace its parts, e.g. with fakes in tests.
+//     Session.Initialize connects to the exchange, Session.Start trades until Session.Stop is called.
+//   - Analyzer emits the trading signals. MultiTimeframeAnalyzer, OrderBookAnalyzer, ContextAnalyzer,
+//     ConfidentAnalyzer, StatefulAnalyzer and IndicatorAnalyzer are optional extensions of it.
+//   - ExchangeHandler trades an Asset on an exchange. NewAsset creates an asset and an ExchangeFactory
+//     registered with WithExchange creates its handlers. NewLunoExchangeHandler and NewPaperExchangeHandler
+//     are the built-in handlers. ProfitWithdrawer is an optional extension of it.
+//   - Candle and Tick are the market data handlers return, whatever the exchange. OHLC is a candle on a chart.
+//   - LedgerStore records the trades. NewLedger opens the built-in SQLite ledger.
+//   - Notifier delivers important messages to the user.
+//
+// Everything else is an implementation detail that may change between releases. Details that don't depend on
+// the rest of the package live under internal/: the ring buffers of the price and candle histories
+// (internal/ringbuffer), the sliding window statistics behind RollingStats (internal/rolling) and the atomic
+// replacement of settings and state files (internal/atomicfile).
+package leprechaun
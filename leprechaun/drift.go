@@ -0,0 +1,166 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// DriftGuard wraps a (machine learning) analyzer and tracks how often its signals turn out right.
+// A long signal is a hit if the next price passed to the analyzer is higher, a short signal is a hit
+// if it is lower. When the rolling hit rate falls below a threshold, the model is assumed to have drifted
+// away from the market and the guard switches to a fallback (rules-based) analyzer and notifies the user.
+// The primary analyzer keeps being evaluated while the fallback is in use.
+type DriftGuard struct {
+	mu            sync.Mutex
+	primary       Analyzer
+	fallback      Analyzer
+	window        int
+	minHitRate    float64
+	notifier      Notifier
+	messages      *Localizer
+	lastPrice     float64
+	lastSignal    SIGNAL
+	lastPriceSeen float64 // price at the time of `lastSignal`
+	outcomes      []bool
+	usingFallback bool
+}
+
+// NewDriftGuard returns an analyzer that uses `primary` until its hit rate over the last `window`
+// evaluated signals drops below `minHitRate`, after which `fallback` is used.
+func NewDriftGuard(primary, fallback Analyzer, window int, minHitRate float64, notifier Notifier) *DriftGuard {
+	if notifier == nil {
+		notifier = LogNotifier{}
+	}
+	return &DriftGuard{primary: primary, fallback: fallback, window: window, minHitRate: minHitRate,
+		notifier: notifier, messages: NewLocalizer(defaultLanguage), lastSignal: SignalWait}
+}
+
+// Emit returns the signal of the primary analyzer, or that of the fallback if the primary has drifted.
+func (g *DriftGuard) Emit(ctx context.Context) (SIGNAL, error) {
+	signal, err := g.primary.Emit(ctx)
+	g.mu.Lock()
+	if err == nil {
+		g.lastSignal, g.lastPriceSeen = signal, g.lastPrice
+	}
+	usingFallback := g.usingFallback
+	g.mu.Unlock()
+	if usingFallback {
+		return g.fallback.Emit(ctx)
+	}
+	return signal, err
+}
+
+// SetCurrentPrice evaluates the previous signal of the primary analyzer against the new price.
+func (g *DriftGuard) SetCurrentPrice(price float64) error {
+	g.evaluate(price)
+	if err := g.fallback.SetCurrentPrice(price); err != nil {
+		return err
+	}
+	return g.primary.SetCurrentPrice(price)
+}
+
+func (g *DriftGuard) evaluate(price float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastPrice = price
+	if !g.lastSignal.opens() || g.lastPriceSeen == 0 || price == g.lastPriceSeen {
+		return
+	}
+	hit := (g.lastSignal == SignalLong) == (price > g.lastPriceSeen)
+	g.lastSignal = SignalWait
+	if len(g.outcomes) >= g.window {
+		g.outcomes = g.outcomes[1:]
+	}
+	g.outcomes = append(g.outcomes, hit)
+	if len(g.outcomes) < g.window {
+		return
+	}
+	rate := g.hitRate()
+	log.Printf("%s hit rate over the last %d signals: %.1f%%", g.primary.Description(), len(g.outcomes), rate*100)
+	if rate < g.minHitRate && !g.usingFallback {
+		g.usingFallback = true
+		g.notifier.Notify(g.messages.Sprintf(MsgDriftSubject), g.messages.Sprintf(MsgDrift,
+			g.primary.Description(), rate*100, g.fallback.Description()))
+	}
+}
+
+// hitRate must be called with g.mu held.
+func (g *DriftGuard) hitRate() float64 {
+	hits := 0
+	for _, hit := range g.outcomes {
+		if hit {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(g.outcomes))
+}
+
+// HitRate returns the hit rate of the primary analyzer over the evaluated signals and the number of signals evaluated.
+func (g *DriftGuard) HitRate() (rate float64, evaluated int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.outcomes) == 0 {
+		return 0, 0
+	}
+	return g.hitRate(), len(g.outcomes)
+}
+
+// UsingFallback returns true if the guard has switched to the fallback analyzer.
+func (g *DriftGuard) UsingFallback() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.usingFallback
+}
+
+// Reset switches back to the primary analyzer, e.g. after the model has been retrained, and forgets past outcomes.
+func (g *DriftGuard) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.usingFallback, g.outcomes, g.lastSignal = false, nil, SignalWait
+}
+
+// SetClosingPrices passes the closing prices to both analyzers.
+func (g *DriftGuard) SetClosingPrices(prices []float64) error {
+	if err := g.fallback.SetClosingPrices(prices); err != nil {
+		return err
+	}
+	return g.primary.SetClosingPrices(prices)
+}
+
+// SetOHLC passes the candles to both analyzers.
+func (g *DriftGuard) SetOHLC(candles []OHLC) error {
+	if err := g.fallback.SetOHLC(candles); err != nil {
+		return err
+	}
+	return g.primary.SetOHLC(candles)
+}
+
+// SetOptions passes the options to both analyzers.
+func (g *DriftGuard) SetOptions(opts *AnalysisOptions) error {
+	if err := g.fallback.SetOptions(opts); err != nil {
+		return err
+	}
+	return g.primary.SetOptions(opts)
+}
+
+// Lookback returns the larger lookback of the primary and fallback analyzers, so either can take over.
+func (g *DriftGuard) Lookback() int {
+	if g.primary.Lookback() > g.fallback.Lookback() {
+		return g.primary.Lookback()
+	}
+	return g.fallback.Lookback()
+}
+
+// Description describes the analyzer currently in use.
+func (g *DriftGuard) Description() string {
+	if g.UsingFallback() {
+		return fmt.Sprintf("%s (fallback for %s)", g.fallback.Description(), g.primary.Description())
+	}
+	return g.primary.Description()
+}
@@ -0,0 +1,231 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Table of the ledger that keeps the dust sold by Session.ConsolidateDust. It is keyed by order ID, like the
+// order book snapshots.
+var dustTableInit = "CREATE TABLE DUST_CONSOLIDATIONS (ID PRIMARY KEY, ASSET, TIME, VOLUME, PRICE)"
+
+const (
+	dustSaveOp = "INSERT OR REPLACE INTO DUST_CONSOLIDATIONS VALUES(?, ?, ?, ?, ?)"
+	dustGetOp  = "SELECT ID, ASSET, TIME, VOLUME, PRICE FROM DUST_CONSOLIDATIONS"
+)
+
+// DustBalance is the part of an asset's balance that no open position holds, e.g. what is left of the volumes
+// rounded to the exchange's volume step (see OrderRounding). It can only be sold once it reaches the exchange's
+// minimum order volume.
+type DustBalance struct {
+	Asset          string
+	Balance        float64 // on the exchange
+	Held           float64 // by the open long positions, per the ledger
+	Residual       float64 // Balance - Held
+	MinOrderVolume float64
+}
+
+// Tradable returns true if the residual is large enough to be sold.
+func (d DustBalance) Tradable() bool {
+	return d.Residual > 0 && d.Residual >= d.MinOrderVolume
+}
+
+// DustConsolidation is the sale of an asset's residual balance by Session.ConsolidateDust.
+type DustConsolidation struct {
+	OrderID string
+	Asset   string
+	Time    time.Time
+	Volume  float64
+	Price   float64
+}
+
+// dust returns the residual balance of `asset`.
+func (pf *Portfolio) dust(asset string) (dust DustBalance, err error) {
+	dust.Asset = asset
+	info := pf.assetInfo[asset]
+	dust.MinOrderVolume = info.minOrderVol
+	if dust.Balance, err = pf.assets[asset].GetBalance(info); err != nil {
+		return
+	}
+	pf.mu.Lock()
+	positions, err := pf.ledger.OpenPositions(pf.ctx, asset)
+	pf.mu.Unlock()
+	if err != nil {
+		return
+	}
+	for _, position := range positions {
+		if position.Type == OpenLongTrade {
+			dust.Held += position.PurchaseVolume
+		}
+	}
+	if dust.Residual = dust.Balance - dust.Held; dust.Residual < 0 {
+		dust.Residual = 0
+	}
+	return dust, nil
+}
+
+// selectAssets returns the assets of the portfolio named `asset`, or all of them, by name, if `asset` is empty.
+func (pf *Portfolio) selectAssets(asset string) ([]string, error) {
+	assets := []string{}
+	for name := range pf.assets {
+		if asset == "" || strings.EqualFold(name, asset) {
+			assets = append(assets, name)
+		}
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("%s is not in the portfolio", asset)
+	}
+	sort.Strings(assets)
+	return assets, nil
+}
+
+// Dust returns the residual balance of `asset`, or of all assets if `asset` is empty.
+func (s *Session) Dust(asset string) (balances []DustBalance, err error) {
+	assets, err := s.portfolio.selectAssets(asset)
+	if err != nil {
+		return
+	}
+	for _, name := range assets {
+		dust, err := s.portfolio.dust(name)
+		if err != nil {
+			return balances, err
+		}
+		balances = append(balances, dust)
+	}
+	return balances, nil
+}
+
+// ConsolidateDust sells the residual balance of `asset`, or of all assets if `asset` is empty, at market once it
+// has grown large enough to trade. Each sale is recorded in the ledger. Residuals below the exchange's minimum
+// order volume are left to grow.
+func (s *Session) ConsolidateDust(asset string) (sales []DustConsolidation, err error) {
+	pf := s.portfolio
+	if pf.watchOnly {
+		return nil, ErrWatchOnly
+	}
+	balances, err := s.Dust(asset)
+	if err != nil {
+		return
+	}
+	for _, dust := range balances {
+		if !dust.Tradable() {
+			continue
+		}
+		sale, err := pf.consolidate(dust)
+		if err != nil {
+			return sales, err
+		}
+		sales = append(sales, sale)
+	}
+	return sales, nil
+}
+
+// consolidate sells the residual balance `dust` and records the sale.
+func (pf *Portfolio) consolidate(dust DustBalance) (sale DustConsolidation, err error) {
+	info := pf.assetInfo[dust.Asset]
+	volume := info.roundVolume(dust.Residual)
+	if volume < dust.MinOrderVolume || volume <= 0 {
+		return sale, fmt.Errorf("%s of %s left after rounding is below the minimum order",
+			pf.messages.FormatAmount(volume, info.code), dust.Asset)
+	}
+	order, err := pf.assets[dust.Asset].GoShort(volume)
+	if err != nil {
+		return
+	}
+	sale = DustConsolidation{OrderID: order.OrderID, Asset: dust.Asset, Time: pf.clock.Now(), Volume: order.Volume,
+		Price: order.Price}
+	// The sale isn't a trade of the ledger, so the balances it changes must be predicted here.
+	pf.balances.traded(info.code, info.currency, CloseLongTrade, Entry{SaleVolume: sale.Volume,
+		SaleCost: sale.Volume * sale.Price})
+	pf.mu.Lock()
+	err = pf.ledger.RecordConsolidation(pf.ctx, sale)
+	pf.mu.Unlock()
+	msg := pf.messages.Sprintf(MsgDustConsolidated, pf.messages.FormatAmount(sale.Volume, info.code), dust.Asset,
+		pf.formatPrice(dust.Asset, sale.Volume*sale.Price))
+	pf.events.record("dust", dust.Asset, msg)
+	return sale, err
+}
+
+// RecordConsolidation stores the sale of an asset's residual balance.
+func (l *Ledger2) RecordConsolidation(ctx context.Context, sale DustConsolidation) (err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	_, err = l.db.ExecContext(ctx, dustSaveOp, sale.OrderID, sale.Asset, formatTimestamp(sale.Time), sale.Volume,
+		sale.Price)
+	return
+}
+
+// Consolidations returns every sale of residual balances.
+func (l *Ledger2) Consolidations(ctx context.Context) (sales []DustConsolidation, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	rows, err := l.db.QueryContext(ctx, dustGetOp)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sale DustConsolidation
+		var timestamp string
+		if err = rows.Scan(&sale.OrderID, &sale.Asset, &timestamp, &sale.Volume, &sale.Price); err != nil {
+			return
+		}
+		if sale.Time, err = parseTimestamp(timestamp); err != nil {
+			return
+		}
+		sales = append(sales, sale)
+	}
+	return sales, rows.Err()
+}
+
+// RunDust implements the `dust` command, e.g. `dust --consolidate`, which prints the residual balance of each
+// asset to `out` and, if asked to, sells the residuals large enough to trade.
+func RunDust(ctx context.Context, args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("dust", flag.ContinueOnError)
+	flags.SetOutput(out)
+	asset := flags.String("asset", "", "Asset whose residual balance is checked, e.g. XRP. Defaults to all assets.")
+	consolidate := flags.Bool("consolidate", false, "Sell the residual balances that are large enough to trade.")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	c := &Configuration{}
+	if err = c.TestConfig(""); err != nil {
+		return
+	}
+	s := NewSession(ctx, c)
+	defer s.Stop()
+	if err = s.Initialize(); err != nil {
+		return
+	}
+	defer s.portfolio.closeOrderBooks()
+	defer s.ledger.Close()
+	balances, err := s.Dust(*asset)
+	if err != nil {
+		return
+	}
+	for _, dust := range balances {
+		code := assetCode(dust.Asset)
+		fmt.Fprintln(out, s.messages.Sprintf(MsgDustBalance, dust.Asset, s.messages.FormatAmount(dust.Residual, code),
+			s.messages.FormatAmount(dust.Balance, code), s.messages.FormatAmount(dust.MinOrderVolume, code)))
+	}
+	if !*consolidate {
+		return
+	}
+	sales, err := s.ConsolidateDust(*asset)
+	for _, sale := range sales {
+		fmt.Fprintln(out, s.messages.Sprintf(MsgDustConsolidated, s.messages.FormatAmount(sale.Volume, assetCode(sale.Asset)),
+			sale.Asset, s.portfolio.formatPrice(sale.Asset, sale.Volume*sale.Price)))
+	}
+	return
+}
@@ -0,0 +1,255 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Observation is what a trading agent sees before each step.
+type Observation struct {
+	Candles  []OHLC  // The most recent candles, oldest first.
+	Position int     // 1 when holding a long position, -1 when holding a short position, 0 otherwise.
+	Equity   float64 // Value of the account relative to its starting value of 1.
+}
+
+// TradingEnv replays historical candles as a gym-like environment so reinforcement learning agents can be
+// trained on past data. At each step the agent picks a position for the next candle: `SignalLong` holds a long
+// position, `SignalShort` a short position and `SignalWait` keeps the current one. `SignalCloseLong` and
+// `SignalCloseShort` close a long (or short) position and keep any other. The reward is the return of the
+// position over the candle, minus the exchange fee whenever the position changes.
+type TradingEnv struct {
+	candles  []OHLC
+	window   int
+	fee      float64
+	step     int
+	position int
+	equity   float64
+	reward   float64
+}
+
+// NewTradingEnv returns an environment over time sorted `candles`. Agents see the last `window` candles and pay `fee`
+// (a fraction of the position) every time they open or close a position. To train on a longer interval than the
+// candles were fetched at, Resample them first.
+func NewTradingEnv(candles []OHLC, window int, fee float64) (*TradingEnv, error) {
+	if window < 1 || len(candles) <= window {
+		return nil, fmt.Errorf("need more than %d candles to create an environment", window)
+	}
+	env := &TradingEnv{candles: candles, window: window, fee: fee}
+	env.Reset()
+	return env, nil
+}
+
+// Reset starts a new episode at the beginning of the data and returns the first observation.
+func (env *TradingEnv) Reset() Observation {
+	env.step, env.position, env.equity, env.reward = env.window, 0, 1, 0
+	return env.observe()
+}
+
+func (env *TradingEnv) observe() Observation {
+	return Observation{Candles: env.candles[env.step-env.window : env.step], Position: env.position, Equity: env.equity}
+}
+
+// Step applies an action and advances the environment by one candle. `done` is true once the data runs out, after
+// which Step leaves the environment as it is until Reset is called.
+func (env *TradingEnv) Step(action SIGNAL) (obs Observation, reward float64, done bool) {
+	if env.step >= len(env.candles) {
+		return env.observe(), 0, true
+	}
+	position := env.position
+	switch action {
+	case SignalLong:
+		position = 1
+	case SignalShort:
+		position = -1
+	case SignalCloseLong:
+		if position > 0 {
+			position = 0
+		}
+	case SignalCloseShort:
+		if position < 0 {
+			position = 0
+		}
+	}
+	if position != env.position {
+		// Switching from a long to a short position (or back) closes one position and opens another.
+		changes := position - env.position
+		if changes < 0 {
+			changes = -changes
+		}
+		reward -= env.fee * float64(changes)
+		env.position = position
+	}
+	current, next := env.candles[env.step-1], env.candles[env.step]
+	reward += float64(env.position) * (next.Close - current.Close) / current.Close
+	env.equity *= 1 + reward
+	env.reward += reward
+	env.step++
+	return env.observe(), reward, env.step >= len(env.candles)
+}
+
+// Reward returns the sum of the rewards of the current episode.
+func (env *TradingEnv) Reward() float64 {
+	return env.reward
+}
+
+// Policy decides which action to take given an observation. It is what a reinforcement learning agent learns.
+type Policy interface {
+	Act(obs Observation) SIGNAL
+}
+
+// LinearPolicy scores the features of the most recent candle with a set of weights and goes long when
+// the score is above `Threshold` and short when it is below `-Threshold`.
+type LinearPolicy struct {
+	Features  []string
+	Weights   []float64
+	Threshold float64
+	pipeline  *FeaturePipeline
+}
+
+// Act returns the action for an observation.
+func (p *LinearPolicy) Act(obs Observation) SIGNAL {
+	_, score, ok := p.score(obs)
+	if !ok {
+		return SignalWait
+	}
+	switch {
+	case score > p.Threshold:
+		return SignalLong
+	case score < -p.Threshold:
+		return SignalShort
+	}
+	return SignalWait
+}
+
+// Indicators returns the features of the most recent candle of an observation and the score they add up to.
+func (p *LinearPolicy) Indicators(obs Observation) map[string]float64 {
+	features, score, ok := p.score(obs)
+	if !ok {
+		return nil
+	}
+	indicators := map[string]float64{"score": score}
+	for i, column := range p.pipeline.Columns() {
+		if i < len(features) {
+			indicators[column] = features[i]
+		}
+	}
+	return indicators
+}
+
+// score returns the features of the most recent candle of an observation and their weighted sum.
+// `ok` is false if the features can't be computed.
+func (p *LinearPolicy) score(obs Observation) (features []float64, score float64, ok bool) {
+	if p.pipeline == nil {
+		pipeline, err := NewFeaturePipeline(p.Features)
+		if err != nil {
+			return
+		}
+		p.pipeline = pipeline
+	}
+	rows, _ := p.pipeline.Extract(obs.Candles)
+	if len(rows) == 0 {
+		return
+	}
+	features = rows[len(rows)-1]
+	for i, v := range features {
+		if i < len(p.Weights) {
+			score += p.Weights[i] * v
+		}
+	}
+	return features, score, true
+}
+
+// Save writes the policy to a JSON file.
+func (p *LinearPolicy) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadLinearPolicy reads a policy saved with LinearPolicy.Save.
+func LoadLinearPolicy(path string) (*LinearPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &LinearPolicy{}
+	if err = json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// IndicatorPolicy is implemented by policies that can report the values their actions are based on.
+type IndicatorPolicy interface {
+	Policy
+	Indicators(obs Observation) map[string]float64
+}
+
+// PolicyAnalyzer lets a trained policy be used as an analysis plugin.
+type PolicyAnalyzer struct {
+	policy  Policy
+	window  int
+	candles []OHLC
+}
+
+// NewPolicyAnalyzer returns an analyzer that shows `policy` the last `window` candles (the window it was trained with).
+func NewPolicyAnalyzer(policy Policy, window int) *PolicyAnalyzer {
+	return &PolicyAnalyzer{policy: policy, window: window}
+}
+
+// Emit returns the policy's action for the latest candles.
+func (a *PolicyAnalyzer) Emit(ctx context.Context) (SIGNAL, error) {
+	if err := ctx.Err(); err != nil {
+		return SignalWait, err
+	}
+	if len(a.candles) < a.window {
+		return SignalWait, errors.New("not enough candles for the policy")
+	}
+	return a.policy.Act(a.observe()), nil
+}
+
+// observe returns what the policy is shown of the latest candles.
+func (a *PolicyAnalyzer) observe() Observation {
+	return Observation{Candles: a.candles[len(a.candles)-a.window:], Equity: 1}
+}
+
+// Indicators returns the values behind the policy's last action, if the policy is an IndicatorPolicy.
+func (a *PolicyAnalyzer) Indicators() map[string]float64 {
+	policy, ok := a.policy.(IndicatorPolicy)
+	if !ok || len(a.candles) < a.window {
+		return nil
+	}
+	return policy.Indicators(a.observe())
+}
+
+// SetClosingPrices is not used by policies.
+func (a *PolicyAnalyzer) SetClosingPrices(prices []float64) error { return nil }
+
+// SetOHLC receives the candles the policy acts on.
+func (a *PolicyAnalyzer) SetOHLC(candles []OHLC) error {
+	a.candles = candles
+	return nil
+}
+
+// SetCurrentPrice is not used by policies.
+func (a *PolicyAnalyzer) SetCurrentPrice(float64) error { return nil }
+
+// SetOptions is not used by policies.
+func (a *PolicyAnalyzer) SetOptions(opts *AnalysisOptions) error { return nil }
+
+// Lookback returns the window of candles the policy acts on.
+func (a *PolicyAnalyzer) Lookback() int { return a.window }
+
+// Description describes the analyzer.
+func (a *PolicyAnalyzer) Description() string {
+	return fmt.Sprintf("Reinforcement learning policy (%d candle window)", a.window)
+}
@@ -0,0 +1,213 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luno/luno-go"
+)
+
+// ErrorPolicy is what the session does about an error reported by one of its components.
+type ErrorPolicy int
+
+const (
+	// PolicyRetry logs the error. The component tries again on its next round.
+	PolicyRetry ErrorPolicy = iota
+	// PolicySkipAsset stops trading the asset the error is about for `skipAssetDuration`.
+	PolicySkipAsset
+	// PolicyHaltSession ends the session.
+	PolicyHaltSession
+	// PolicyNotify sends the error to the user through the session's Notifier.
+	PolicyNotify
+	// PolicyPause pauses the session until the exchange is back from maintenance. See Session.monitorExchange.
+	PolicyPause
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case PolicyRetry:
+		return "retry"
+	case PolicySkipAsset:
+		return "skip asset"
+	case PolicyHaltSession:
+		return "halt session"
+	case PolicyNotify:
+		return "notify"
+	case PolicyPause:
+		return "pause"
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+}
+
+const (
+	// errChanSize is the number of reported errors that can wait to be handled before reports are dropped to the log.
+	errChanSize = 64
+	// skipAssetDuration is how long an asset is left alone after an error with the PolicySkipAsset policy.
+	skipAssetDuration = 30 * time.Minute
+	// maxRetries is the number of errors in a row a component may report before the user is notified.
+	maxRetries = 5
+)
+
+// BotError is an error reported by a component of the bot.
+type BotError struct {
+	Asset string // Empty when the error isn't about a single asset.
+	Op    string // What the component was doing, e.g. "open trade".
+	Err   error
+}
+
+func (e *BotError) Error() string {
+	if e.Asset == "" {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Asset, e.Err)
+}
+
+func (e *BotError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError decides the policy for an error.
+func classifyError(err error) ErrorPolicy {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrInvalidAPICredentials),
+		luno.IsErrorCode(err, "ErrAPIKeyNotFound"), luno.IsErrorCode(err, "ErrAPIKeyRevoked"):
+		return PolicyHaltSession
+	case isMaintenanceError(err):
+		return PolicyPause
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr), errors.Is(err, ErrAnalyzerTimeout),
+		strings.Contains(err.Error(), "too many requests"):
+		return PolicyRetry
+	case luno.IsErrorCode(err, "ErrInsufficientBalance"), luno.IsErrorCode(err, "ErrMarketUnavailable"),
+		errors.Is(err, ErrOrderBookNotReady):
+		return PolicySkipAsset
+	}
+	return PolicyNotify
+}
+
+// errorHandler consumes the errors reported by the session's components and applies the matching policy.
+type errorHandler struct {
+	mu       sync.Mutex
+	errChan  chan error
+	skipped  map[string]time.Time // assets being skipped and when to resume trading them
+	retries  map[string]int       // errors in a row per operation
+	notifier Notifier
+	messages *Localizer
+	events   *eventLog
+	halt     func()
+	pause    func(reason error) // called for errors with the PolicyPause policy
+	clock    Clock
+}
+
+func newErrorHandler(notifier Notifier, messages *Localizer, halt func(), clock Clock) *errorHandler {
+	return &errorHandler{
+		errChan:  make(chan error, errChanSize),
+		skipped:  make(map[string]time.Time),
+		retries:  make(map[string]int),
+		notifier: notifier,
+		messages: messages,
+		halt:     halt,
+		clock:    clock,
+	}
+}
+
+// report queues an error to be handled. It never blocks.
+func (h *errorHandler) report(asset, op string, err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case h.errChan <- &BotError{Asset: asset, Op: op, Err: err}:
+	default:
+		raise(err)
+	}
+}
+
+// resolve marks an operation as successful, resetting its retry count.
+func (h *errorHandler) resolve(asset, op string) {
+	h.mu.Lock()
+	delete(h.retries, op+asset)
+	h.mu.Unlock()
+}
+
+// isSkipped returns true if `asset` shouldn't be traded because of a recent error.
+func (h *errorHandler) isSkipped(asset string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	until, ok := h.skipped[asset]
+	if ok && !h.clock.Now().Before(until) {
+		delete(h.skipped, asset)
+		return false
+	}
+	return ok
+}
+
+// run handles reported errors and debug messages until the context is done.
+func (h *errorHandler) run(ctx context.Context, debugChan <-chan string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-debugChan:
+			log.Println(msg)
+		case err := <-h.errChan:
+			h.handle(err)
+		}
+	}
+}
+
+func (h *errorHandler) handle(err error) {
+	botErr, ok := err.(*BotError)
+	if !ok {
+		botErr = &BotError{Err: err}
+	}
+	policy := classifyError(botErr.Err)
+	if policy == PolicyPause && h.pause != nil {
+		// Every request fails during maintenance. The pause is reported once instead.
+		h.pause(botErr.Err)
+		return
+	}
+	if policy == PolicyRetry {
+		h.mu.Lock()
+		h.retries[botErr.Op+botErr.Asset]++
+		if h.retries[botErr.Op+botErr.Asset] >= maxRetries {
+			policy = PolicyNotify
+		}
+		h.mu.Unlock()
+	}
+	log.Printf("ERROR:: %v (%s)", botErr, policy)
+	if h.events != nil {
+		h.events.record("error", botErr.Asset, botErr.Error())
+	}
+	switch policy {
+	case PolicySkipAsset:
+		if botErr.Asset == "" {
+			break
+		}
+		h.mu.Lock()
+		h.skipped[botErr.Asset] = h.clock.Now().Add(skipAssetDuration)
+		h.mu.Unlock()
+		h.notify(h.messages.Sprintf(MsgSkipAsset, botErr.Asset, skipAssetDuration), botErr)
+	case PolicyHaltSession:
+		h.notify(h.messages.Sprintf(MsgHaltSubject), botErr)
+		h.halt()
+	case PolicyNotify:
+		h.notify(h.messages.Sprintf(MsgErrorSubject), botErr)
+	}
+}
+
+func (h *errorHandler) notify(subject string, err error) {
+	if nErr := h.notifier.Notify(subject, err.Error()); nErr != nil {
+		log.Printf("Could not send notification: %v", nErr)
+	}
+}
@@ -0,0 +1,127 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// ExitPolicy decides which rule closes a position when more than one wants to, e.g. the analyzer's close signal
+// and the profit margin.
+type ExitPolicy string
+
+const (
+	// ExitEarliest lets the first rule that asks close the position.
+	ExitEarliest ExitPolicy = "earliest"
+	// ExitConservative also lets the first rule close the position, but the analyzer may only close positions that
+	// are in profit after fees or past their stop loss. Otherwise the position is left to the profit margin and
+	// holding period rules, so a noisy exit signal doesn't realize a loss the stop loss would have ridden out.
+	ExitConservative ExitPolicy = "conservative"
+)
+
+// Valid returns true if `p` is a known exit policy.
+func (p ExitPolicy) Valid() bool {
+	switch p {
+	case ExitEarliest, ExitConservative:
+		return true
+	}
+	return false
+}
+
+func parseExitPolicy(name string) (ExitPolicy, error) {
+	if name == "" {
+		return ExitEarliest, nil
+	}
+	if p := ExitPolicy(name); p.Valid() {
+		return p, nil
+	}
+	return ExitEarliest, fmt.Errorf("unknown exit policy %q", name)
+}
+
+// exitRule is a rule that closes positions.
+type exitRule string
+
+const (
+	exitAnalyzer     exitRule = "analyzer signal"
+	exitProfitMargin exitRule = "profit margin"
+	exitHolding      exitRule = "holding period"
+	exitLiquidation  exitRule = "liquidation"
+)
+
+var (
+	// errExitClaimed is returned when another rule is already closing the position, or has closed it.
+	errExitClaimed = errors.New("the position is already being closed")
+	// errExitHeld is returned when the exit policy keeps the analyzer from closing the position.
+	errExitHeld = errors.New("the exit policy keeps the position open")
+)
+
+// exitGuard keeps track of which rule is closing each position, so a position is never closed twice by rules
+// running in different loops. A position moves from open to closing when a rule claims it, and on to closed
+// once its closing order is recorded, or back to open if the order fails.
+type exitGuard struct {
+	mu     sync.Mutex
+	claims map[string]exitRule // rule closing (or that closed) each position, by position ID
+}
+
+func newExitGuard() *exitGuard {
+	return &exitGuard{claims: make(map[string]exitRule)}
+}
+
+// claim lets `rule` close the position `id`. It returns the rule that holds the position and false if another
+// rule got there first.
+func (g *exitGuard) claim(id string, rule exitRule) (holder exitRule, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if holder, claimed := g.claims[id]; claimed {
+		return holder, false
+	}
+	g.claims[id] = rule
+	return rule, true
+}
+
+// release reopens the position `id` to every rule after its closing order failed.
+func (g *exitGuard) release(id string) {
+	g.mu.Lock()
+	delete(g.claims, id)
+	g.mu.Unlock()
+}
+
+// exitAllowed applies the conservative exit policy to an analyzer's request to close `position`.
+func (pf *Portfolio) exitAllowed(handler ExchangeHandler, position Entry, rule exitRule) bool {
+	if rule != exitAnalyzer || ExitPolicy(pf.config.ExitPrecedence) != ExitConservative {
+		return true
+	}
+	price, err := pf.exitPrice(handler, position)
+	if err != nil {
+		log.Printf("Could not get the exit price of %s: %v", position.ID, err)
+		return false
+	}
+	if position.ProfitAt(price) > 0 {
+		return true
+	}
+	stopLoss := pf.config.stopLossPercentage(position.Type)
+	return stopLoss > 0 && position.lossPercentage(price) > stopLoss
+}
+
+// exit closes `position` at market on behalf of `rule`, unless another rule is already closing it or the exit
+// policy keeps it open, in which case errExitClaimed or errExitHeld is returned.
+func (pf *Portfolio) exit(asset string, position *Entry, rule exitRule) (stop *StopOrderEntry, orderType Order, err error) {
+	if !pf.exitAllowed(pf.assets[asset], *position, rule) {
+		log.Printf("Keeping the %s position %s of %s open: the %s exit policy doesn't let the analyzer close it at a loss",
+			orderTypeName(position.Type), position.ID, asset, ExitConservative)
+		return nil, orderType, errExitHeld
+	}
+	if holder, ok := pf.exits.claim(position.ID, rule); !ok {
+		log.Printf("Not closing position %s of %s on the %s: it is closed by the %s", position.ID, asset, rule, holder)
+		return nil, orderType, errExitClaimed
+	}
+	if stop, orderType, err = pf.closePosition(asset, position); err != nil {
+		pf.exits.release(position.ID)
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gonum/stat"
+	"gorgonia.org/tensor"
+)
+
+// featureWindow is the number of candles used by windowed features such as moving averages.
+const featureWindow = 20
+
+// Feature is a named group of values extracted from each candle in a series, for use as inputs to a machine learning model.
+type Feature struct {
+	Name    string
+	Columns []string // Names of the values the feature produces, in order.
+	// Lookback is the number of earlier candles the feature needs. Candles without enough history are skipped.
+	Lookback int
+	// Extract returns the values of the feature for the candle at index `i`.
+	Extract func(candles []OHLC, i int) []float64
+}
+
+// DefaultFeatures are the features used when none are configured.
+var DefaultFeatures = []string{"returns", "sma_ratio", "range", "patterns", "volume_z"}
+
+var features = map[string]Feature{
+	"returns": {Name: "returns", Columns: []string{"return"}, Lookback: 1,
+		Extract: func(candles []OHLC, i int) []float64 {
+			return []float64{(candles[i].Close - candles[i-1].Close) / candles[i-1].Close}
+		}},
+	"sma_ratio": {Name: "sma_ratio", Columns: []string{"sma_ratio"}, Lookback: featureWindow - 1,
+		Extract: func(candles []OHLC, i int) []float64 {
+			sum := 0.0
+			for _, candle := range candles[i-featureWindow+1 : i+1] {
+				sum += candle.Close
+			}
+			return []float64{candles[i].Close/(sum/featureWindow) - 1}
+		}},
+	"range": {Name: "range", Columns: []string{"range", "upper_tail", "lower_tail"},
+		Extract: func(candles []OHLC, i int) []float64 {
+			c := candles[i]
+			return []float64{(c.High - c.Low) / c.Close, c.UpperTail / c.Close, c.LowerTail / c.Close}
+		}},
+	"patterns": {Name: "patterns", Columns: []string{"bullish", "bearish", "doji", "hammer"},
+		Extract: func(candles []OHLC, i int) []float64 {
+			c := candles[i]
+			return []float64{oneHot(c.IsBullish()), oneHot(c.IsBearish()), oneHot(c.IsDoji()), oneHot(c.IsHammer())}
+		}},
+	"volume_z": {Name: "volume_z", Columns: []string{"volume_z"}, Lookback: featureWindow - 1,
+		Extract: func(candles []OHLC, i int) []float64 {
+			volumes := make([]float64, 0, featureWindow)
+			for _, candle := range candles[i-featureWindow+1 : i+1] {
+				volumes = append(volumes, candle.TotalVolume)
+			}
+			mean, sd := stat.MeanStdDev(volumes, nil)
+			if sd == 0 {
+				return []float64{0}
+			}
+			return []float64{(candles[i].TotalVolume - mean) / sd}
+		}},
+}
+
+// RegisterFeature makes a custom feature available to feature pipelines under its name.
+func RegisterFeature(feature Feature) {
+	features[feature.Name] = feature
+}
+
+func oneHot(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// FeaturePipeline extracts a configured list of features from candle data.
+type FeaturePipeline struct {
+	features []Feature
+	lookback int
+}
+
+// NewFeaturePipeline returns a pipeline that extracts the named features, in order.
+func NewFeaturePipeline(names []string) (*FeaturePipeline, error) {
+	if len(names) == 0 {
+		names = DefaultFeatures
+	}
+	p := &FeaturePipeline{}
+	for _, name := range names {
+		feature, ok := features[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		p.features = append(p.features, feature)
+		if feature.Lookback > p.lookback {
+			p.lookback = feature.Lookback
+		}
+	}
+	return p, nil
+}
+
+// Columns returns the names of the extracted values.
+func (p *FeaturePipeline) Columns() (columns []string) {
+	for _, feature := range p.features {
+		columns = append(columns, feature.Columns...)
+	}
+	return
+}
+
+// Lookback returns the number of candles at the start of a series that are skipped for lack of history.
+func (p *FeaturePipeline) Lookback() int {
+	return p.lookback
+}
+
+// Extract returns one row of feature values for each candle that has enough history.
+// Candles marked as `Missing` are skipped.
+func (p *FeaturePipeline) Extract(candles []OHLC) (rows [][]float64, times []time.Time) {
+	for i := p.lookback; i < len(candles); i++ {
+		if candles[i].Missing {
+			continue
+		}
+		row := make([]float64, 0, len(p.Columns()))
+		for _, feature := range p.features {
+			row = append(row, feature.Extract(candles, i)...)
+		}
+		rows = append(rows, row)
+		times = append(times, candles[i].Time)
+	}
+	return
+}
+
+// Tensor returns the extracted features as a (rows x columns) matrix for use with gorgonia models.
+func (p *FeaturePipeline) Tensor(candles []OHLC) (*tensor.Dense, error) {
+	rows, _ := p.Extract(candles)
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("need more than %d candles to extract features", p.lookback)
+	}
+	numColumns := len(rows[0])
+	backing := make([]float64, 0, len(rows)*numColumns)
+	for _, row := range rows {
+		backing = append(backing, row...)
+	}
+	return tensor.New(tensor.WithShape(len(rows), numColumns), tensor.WithBacking(backing)), nil
+}
+
+// WriteCSV writes the extracted features to `w` as CSV, with a header row and the time of each candle in the first column.
+func (p *FeaturePipeline) WriteCSV(w io.Writer, candles []OHLC) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{"time"}, p.Columns()...)); err != nil {
+		return err
+	}
+	rows, times := p.Extract(candles)
+	for i, row := range rows {
+		record := []string{formatTimestamp(times[i])}
+		for _, v := range row {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
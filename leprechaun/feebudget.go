@@ -0,0 +1,145 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/luno/luno-go"
+)
+
+// pendingFee is an order whose fee hasn't been counted yet because it hadn't been filled.
+type pendingFee struct {
+	asset string
+	price float64 // used to convert fees charged in the base asset
+}
+
+// feeBudget tracks the exchange fees paid on the current day, so a strategy that overtrades
+// stops opening positions once it has spent `limit` on fees. Orders are placed by the trade loop, the position
+// monitors and the circuit breaker, so it is guarded by `mu`.
+type feeBudget struct {
+	mu       sync.Mutex
+	limit    float64 // zero means no limit
+	day      string  // the day `spent` is counted for, see dayKey
+	spent    float64
+	notified bool
+	pending  map[string]pendingFee // by order ID
+}
+
+func newFeeBudget(limit float64) *feeBudget {
+	return &feeBudget{limit: limit, pending: make(map[string]pendingFee)}
+}
+
+// dayKey identifies the calendar day of `t` in its own time zone.
+func dayKey(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// add counts `fee` towards the budget of the day of `now`.
+func (b *feeBudget) add(fee float64, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	b.spent += fee
+}
+
+// exhausted reports whether the fees paid on the day of `now` have reached the limit. `first` is true the first
+// time it is reported each day, so the user is notified once.
+func (b *feeBudget) exhausted(now time.Time) (exhausted, first bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(now)
+	if b.limit <= 0 || b.spent < b.limit {
+		return false, false
+	}
+	first, b.notified = !b.notified, true
+	return true, first
+}
+
+// track adds an order whose fee is counted once it has been filled.
+func (b *feeBudget) track(orderID string, fee pendingFee) {
+	b.mu.Lock()
+	b.pending[orderID] = fee
+	b.mu.Unlock()
+}
+
+// unsettled returns a copy of the orders whose fees haven't been counted yet.
+func (b *feeBudget) unsettled() map[string]pendingFee {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending := make(map[string]pendingFee, len(b.pending))
+	for orderID, fee := range b.pending {
+		pending[orderID] = fee
+	}
+	return pending
+}
+
+// settle stops tracking `orderID`. It returns false if the order was settled already, e.g. by another goroutine.
+func (b *feeBudget) settle(orderID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.pending[orderID]
+	delete(b.pending, orderID)
+	return ok
+}
+
+// rollover starts a new budget when the day changes. b.mu must be held.
+func (b *feeBudget) rollover(now time.Time) {
+	if day := dayKey(now); day != b.day {
+		b.day, b.spent, b.notified = day, 0, false
+	}
+}
+
+// trackFee counts the fee of `orderID` towards the daily fee budget once the order has been filled.
+// `price` is used to convert fees charged in the asset into the fiat currency.
+func (pf *Portfolio) trackFee(asset, orderID string, price float64) {
+	pf.fees.track(orderID, pendingFee{asset: asset, price: price})
+	pf.settleFees()
+}
+
+// settleFees counts the fees of filled orders towards the daily fee budget. Orders that are still
+// pending are checked again on the next call.
+func (pf *Portfolio) settleFees() {
+	for orderID, order := range pf.fees.unsettled() {
+		details, err := pf.assets[order.asset].GetOrderDetails(orderID)
+		if err != nil || details.State != luno.OrderStateComplete {
+			continue
+		}
+		if !pf.fees.settle(orderID) {
+			continue
+		}
+		rate, err := pf.reportingRate(order.asset)
+		if err != nil {
+			pf.errs.report(order.asset, "count fee", err)
+			continue
+		}
+		fee := details.FeeCounter.Float64() + details.FeeBase.Float64()*order.price
+		pf.fees.add(fee*rate, pf.clock.Now())
+	}
+}
+
+// feeBudgetExhausted reports whether no new positions may be opened today because the daily fee
+// budget has been used up. The user is notified the first time it happens each day.
+func (pf *Portfolio) feeBudgetExhausted() bool {
+	exhausted, first := pf.fees.exhausted(pf.clock.Now())
+	if !exhausted {
+		return false
+	}
+	if first {
+		pf.fees.mu.Lock()
+		spent := pf.messages.FormatMoney(pf.fees.spent, pf.config.CurrencyCode)
+		limit := pf.messages.FormatMoney(pf.fees.limit, pf.config.CurrencyCode)
+		pf.fees.mu.Unlock()
+		msg := pf.messages.Sprintf(MsgFeeBudget, spent, limit)
+		log.Println(msg)
+		pf.events.record("fees", "", msg)
+		if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgFeeBudgetSubject), msg); err != nil {
+			log.Printf("Could not send notification: %v", err)
+		}
+	}
+	return true
+}
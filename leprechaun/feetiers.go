@@ -0,0 +1,156 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// feeTierCheckInterval is how often the 30-day volume is checked against the fee tiers.
+	feeTierCheckInterval = time.Hour
+	// feeTierWindow is the period exchanges add the trading volume up over to pick the fee tier.
+	feeTierWindow = 30 * 24 * time.Hour
+)
+
+// FeeTier is a level of the exchange's fee schedule. Exchanges charge lower fees as the account's trading volume
+// over the last 30 days grows.
+type FeeTier struct {
+	MinVolume float64 // 30-day trading volume (in CurrencyCode) from which the tier applies.
+	MakerFee  float64 // as a fraction
+	TakerFee  float64
+}
+
+// feeTierFor returns the index of the tier of `tiers` (sorted by MinVolume) that applies at `volume` and of the
+// tier after it. Either is -1 if there is no such tier.
+func feeTierFor(tiers []FeeTier, volume float64) (current, next int) {
+	current, next = -1, -1
+	for i, tier := range tiers {
+		if volume >= tier.MinVolume {
+			current = i
+		} else {
+			return current, i
+		}
+	}
+	return current, next
+}
+
+// takerFeeSetter is implemented by the exchange handlers whose fee can be set from the fee schedule.
+type takerFeeSetter interface {
+	setTakerFee(fee float64)
+}
+
+// setTakerFee sets the fee used to estimate entry prices, instead of the one the exchange reports.
+func (handler *LunoExchangeHandler) setTakerFee(fee float64) {
+	handler.takerFee, handler.feesLoaded = fee, true
+}
+
+// feeTierState is the fee tier applied last and the tier the user was last told is close.
+type feeTierState struct {
+	current  int
+	notified int
+}
+
+// thirtyDayVolume adds up the trading volume (in CurrencyCode) of the trades in the ledger over the last 30 days.
+// Both legs of a position count from the day it was opened, since the ledger doesn't record when it was closed.
+func (pf *Portfolio) thirtyDayVolume() (volume float64, err error) {
+	pf.mu.Lock()
+	records, err := pf.ledger.AllRecords(pf.ctx)
+	pf.mu.Unlock()
+	if err != nil {
+		return
+	}
+	since := pf.clock.Now().Add(-feeTierWindow)
+	for _, rec := range records {
+		opened, err := rec.Time()
+		if err != nil || opened.Before(since) {
+			continue
+		}
+		rate, err := pf.reportingRate(rec.Asset)
+		if err != nil {
+			log.Printf("Leaving trade %s out of the 30-day volume: %v", rec.ID, err)
+			continue
+		}
+		volume += (rec.PurchaseCost + rec.SaleCost) * rate
+	}
+	return volume, nil
+}
+
+// updateFeeTier applies the fees of the tier the 30-day volume has reached to the exchange handlers, so entry
+// prices and profits are estimated with the fees that will actually be charged. The user is notified once the
+// volume comes within FeeTierNotice of the next tier.
+func (pf *Portfolio) updateFeeTier() error {
+	tiers := append([]FeeTier(nil), pf.config.FeeTiers...)
+	if len(tiers) == 0 {
+		return nil
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+	volume, err := pf.thirtyDayVolume()
+	if err != nil {
+		return err
+	}
+	current, next := feeTierFor(tiers, volume)
+	if current >= 0 {
+		tier := tiers[current]
+		pf.mu.Lock()
+		for name, handler := range pf.assets {
+			if setter, ok := handler.(takerFeeSetter); ok {
+				setter.setTakerFee(tier.TakerFee)
+			}
+			if info, ok := pf.assetInfo[name]; ok {
+				info.makerFee, info.takerFee = tier.MakerFee, tier.TakerFee
+			}
+		}
+		changed := pf.feeTier.current != current
+		pf.feeTier.current = current
+		pf.mu.Unlock()
+		if changed {
+			msg := fmt.Sprintf("30-day volume of %s: trading at the %.2f%% maker and %.2f%% taker fees of tier %d",
+				pf.messages.FormatMoney(volume, pf.config.CurrencyCode), tier.MakerFee*100, tier.TakerFee*100, current+1)
+			log.Println(msg)
+			pf.events.record("fee-tier", "", msg)
+		}
+	}
+	if next < 0 || pf.config.FeeTierNotice <= 0 || volume < pf.config.FeeTierNotice*tiers[next].MinVolume {
+		return nil
+	}
+	pf.mu.Lock()
+	notify := pf.feeTier.notified != next
+	pf.feeTier.notified = next
+	pf.mu.Unlock()
+	if !notify {
+		return nil
+	}
+	fee := 0.0
+	if current >= 0 {
+		fee = tiers[current].TakerFee
+	}
+	msg := pf.messages.Sprintf(MsgFeeTierNear, pf.messages.FormatMoney(volume, pf.config.CurrencyCode),
+		pf.messages.FormatMoney(tiers[next].MinVolume-volume, pf.config.CurrencyCode), fee*100, tiers[next].TakerFee*100)
+	log.Println(msg)
+	pf.events.record("fee-tier", "", msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgFeeTierNearSubject), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+	return nil
+}
+
+// monitorFeeTiers keeps the fees in line with the fee schedule until the session ends.
+func (s *Session) monitorFeeTiers() {
+	if len(s.config.FeeTiers) == 0 {
+		return
+	}
+	for {
+		s.errs.report("", "update fee tier", s.portfolio.updateFeeTier())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(feeTierCheckInterval):
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package leprechaun
 
 import (
+	"context"
+	"time"
+
 	luno "github.com/luno/luno-go"
 )
 
@@ -11,11 +14,31 @@ type ExchangeHandler interface {
 	StopShort(rec *Entry) (shortOrder *StopOrderEntry, err error)
 	String() string
 	CurrentPrice() (float64, error)
+	ExpectedEntryPrice(kind SIGNAL) (float64, error)
+	ServerTime() (time.Time, error)
 	GetBalance(asset *Asset) (float64, error)
-	CheckBalanceSufficiency(asset *Asset) (canPurchase bool, err error)
+	FiatBalance() (float64, error)
+	// CheckBalanceSufficiency reports whether the fiat balance covers a purchase of `purchaseUnit`.
+	CheckBalanceSufficiency(asset *Asset, purchaseUnit float64) (canPurchase bool, err error)
 	ConfirmOrder(rec *Entry) (done bool, err error)
-	PreviousTrades(numDays int64) (data map[luno.Time][]luno.Candle, err error)
+	// PreviousTrades returns the candles of `interval` over the past `numDays` days, oldest first.
+	PreviousTrades(numDays int64, interval time.Duration) (candles []Candle, err error)
+	// SupportedIntervals returns the candle durations the exchange can return, shortest first.
+	SupportedIntervals() []time.Duration
 	GetOrderDetails(orderID string) (orderDetails *luno.GetOrderResponse, err error)
+	// WaitForOrder polls the order until it is complete (or cancelled) or `timeout` expires.
+	// It returns ErrOrderPending if the order is still pending by then.
+	WaitForOrder(orderID string, timeout time.Duration) (orderDetails *luno.GetOrderResponse, err error)
+	// PendingOrders returns the IDs of the orders on the handler's pair that haven't been filled yet.
+	PendingOrders(ctx context.Context) (orderIDs []string, err error)
+	// StopPendingOrder cancels an order that hasn't been filled yet. It returns false if it couldn't.
+	StopPendingOrder(ctx context.Context, orderID string) (ok bool)
+	// Pairs lists every pair traded on the exchange, with its order limits and the account's fees.
+	Pairs(ctx context.Context) ([]PairInfo, error)
+	// MarketStatus reports whether the exchange is trading the handler's pair, e.g. false during maintenance.
+	MarketStatus() (active bool, err error)
+	// CanTrade reports whether the API key is allowed to place orders, without placing any.
+	CanTrade() (bool, error)
 }
 
 type Exchange struct {
@@ -0,0 +1,256 @@
+//go:build integration
+
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+// The integration tests run the exchange handlers against recorded exchange responses, so they need neither
+// API keys nor a network connection:
+//
+//	go test -tags integration ./leprechaun
+//
+// The fixtures in testdata/fixtures are trace files, see Configuration.TraceFile. To record new ones, run
+// Leprechaun with `-trace testdata/fixtures/<name>.jsonl` and keep the exchanges the test needs.
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// volatileParams are the query parameters left out when matching a request with a recorded one, since they
+// depend on when the request is made.
+var volatileParams = []string{"since"}
+
+// replayTransport answers requests with the responses of a trace file. Requests are matched by method, path
+// and query. Recorded responses to the same request are returned in turn, and the last one is repeated once
+// they run out, e.g. for the ticker.
+type replayTransport struct {
+	mu        sync.Mutex
+	responses map[string][]APITrace
+	requests  []string // keys of the requests made, in order
+}
+
+func replayKey(method string, u *url.URL) string {
+	query := u.Query()
+	for _, param := range volatileParams {
+		query.Del(param)
+	}
+	return method + " " + u.Path + "?" + query.Encode()
+}
+
+// loadFixture returns a transport replaying the trace file testdata/fixtures/`name`.jsonl.
+func loadFixture(t *testing.T, name string) *replayTransport {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", "fixtures", name+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	replay := &replayTransport{responses: map[string][]APITrace{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 2*maxTracedBody)
+	for scanner.Scan() {
+		var trace APITrace
+		if err := json.Unmarshal(scanner.Bytes(), &trace); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		u, err := url.Parse(trace.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		key := replayKey(trace.Method, u)
+		replay.responses[key] = append(replay.responses[key], trace)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return replay
+}
+
+func (r *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := replayKey(req.Method, req.URL)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, key)
+	recorded := r.responses[key]
+	if len(recorded) == 0 {
+		return nil, fmt.Errorf("no recorded response to %s", key)
+	}
+	trace := recorded[0]
+	if len(recorded) > 1 {
+		r.responses[key] = recorded[1:]
+	}
+	header := http.Header{}
+	for name, values := range trace.Headers {
+		header[name] = values
+	}
+	return &http.Response{StatusCode: trace.Status, Status: http.StatusText(trace.Status), Header: header,
+		Body: io.NopCloser(strings.NewReader(trace.Response)), Request: req}, nil
+}
+
+// made returns how many requests matching `method` and `path` were made.
+func (r *replayTransport) made(method, path string) (n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.requests {
+		if strings.HasPrefix(key, method+" "+path+"?") {
+			n++
+		}
+	}
+	return
+}
+
+// approx returns true if `a` and `b` differ only by rounding.
+func approx(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Max(math.Abs(a), math.Abs(b)), 1)
+}
+
+// newReplayHandler returns a handler trading XBTNGN through `replay`.
+func newReplayHandler(t *testing.T, replay *replayTransport) *LunoExchangeHandler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := newLunoClient("key-id", "key-secret", &http.Client{Transport: replay})
+	handler := NewLunoExchangeHandler(client, NewAsset("BITCOIN", "XBT", "NGN", 0.0005), realClock{}, ctx)
+	// Replayed responses don't count against the exchange's limits, so there is no need to pace them.
+	handler.budget = NewRateBudget(6000)
+	return handler
+}
+
+func TestLunoHandlerIntegration(t *testing.T) {
+	replay := loadFixture(t, "luno")
+	handler := newReplayHandler(t, replay)
+
+	balance, err := handler.GetBalance(handler.asset)
+	if err != nil || balance != 0.0125 {
+		t.Fatalf("GetBalance() = %v, %v, want 0.0125", balance, err)
+	}
+	if fiat, err := handler.FiatBalance(); err != nil || fiat != 250000 {
+		t.Errorf("FiatBalance() = %v, %v, want 250000", fiat, err)
+	}
+	if price, err := handler.CurrentPrice(); err != nil || price != 56000000 {
+		t.Errorf("CurrentPrice() = %v, %v, want 56000000", price, err)
+	}
+	if price, err := handler.ExpectedEntryPrice(SignalLong); err != nil || !approx(price, 56000000*1.001) {
+		t.Errorf("ExpectedEntryPrice(long) = %v, %v, want %v", price, err, 56000000*1.001)
+	}
+	if price, err := handler.ExpectedEntryPrice(SignalShort); err != nil || !approx(price, 55990000*0.999) {
+		t.Errorf("ExpectedEntryPrice(short) = %v, %v, want %v", price, err, 55990000*0.999)
+	}
+	if replay.made("GET", "/api/1/fee_info") != 1 {
+		t.Errorf("the fees were fetched %d times, want once", replay.made("GET", "/api/1/fee_info"))
+	}
+	if active, err := handler.MarketStatus(); err != nil || !active {
+		t.Errorf("MarketStatus() = %v, %v, want true", active, err)
+	}
+	if canTrade, err := handler.CanTrade(); err != nil || !canTrade {
+		t.Errorf("CanTrade() = %v, %v, want true", canTrade, err)
+	}
+
+	candles, err := handler.PreviousTrades(1, time.Hour)
+	if err != nil {
+		t.Fatalf("PreviousTrades() failed: %v", err)
+	}
+	if len(candles) != 3 {
+		t.Fatalf("PreviousTrades() returned %d candles, want the 3 of both windows without the overlap", len(candles))
+	}
+	for i := 1; i < len(candles); i++ {
+		if !candles[i].Time.After(candles[i-1].Time) {
+			t.Errorf("candle %d at %v is not after candle %d at %v", i, candles[i].Time, i-1, candles[i-1].Time)
+		}
+	}
+
+	order, err := handler.GoLong(0.000178)
+	if err != nil {
+		t.Fatalf("GoLong() failed: %v", err)
+	}
+	if order.OrderID != "BXMC2CJ7HNB88U4" || order.Price != 56000000 {
+		t.Errorf("GoLong() = %+v, want order BXMC2CJ7HNB88U4 at 56000000", order)
+	}
+	if _, err := handler.GetOrderDetails(order.OrderID); !errors.Is(err, ErrOrderPending) {
+		t.Errorf("GetOrderDetails() of a pending order returned %v, want ErrOrderPending", err)
+	}
+	details, err := handler.GetOrderDetails(order.OrderID)
+	if err != nil {
+		t.Fatalf("GetOrderDetails() failed: %v", err)
+	}
+	if details.Base.Float64() != 0.000178 || details.FeeBase.Float64() != 0.000000178 {
+		t.Errorf("GetOrderDetails() = base %v, fee %v, want 0.000178 and 0.000000178", details.Base, details.FeeBase)
+	}
+
+	reference, err := handler.Withdraw(context.Background(), 5000, "account:8472937", "leprechaun-1")
+	if err != nil || reference != "18563829047" {
+		t.Errorf("Withdraw() = %q, %v, want 18563829047", reference, err)
+	}
+	if _, err := handler.Withdraw(context.Background(), 5000, "wallet:1", "leprechaun-2"); !errors.Is(err, ErrWithdrawalUnsupported) {
+		t.Errorf("Withdraw() to an unknown destination returned %v, want ErrWithdrawalUnsupported", err)
+	}
+}
+
+func TestPaperHandlerIntegration(t *testing.T) {
+	replay := loadFixture(t, "luno")
+	account := NewPaperAccount(100000)
+	handler := NewPaperExchangeHandler(newReplayHandler(t, replay), account)
+
+	order, err := handler.GoLong(0.001)
+	if err != nil {
+		t.Fatalf("GoLong() failed: %v", err)
+	}
+	if replay.made("POST", "/api/1/marketorder") != 0 {
+		t.Fatal("a simulated order reached the exchange")
+	}
+	details, err := handler.GetOrderDetails(order.OrderID)
+	if err != nil {
+		t.Fatalf("GetOrderDetails() failed: %v", err)
+	}
+	if details.Base.Float64() != 0.001 {
+		t.Errorf("the order filled %v, want 0.001", details.Base)
+	}
+	cost := details.Counter.Float64() + details.FeeCounter.Float64()
+	if fiat, err := handler.FiatBalance(); err != nil || !approx(fiat, 100000-cost) {
+		t.Errorf("FiatBalance() = %v, %v, want %v", fiat, err, 100000-cost)
+	}
+	if balance, err := handler.GetBalance(handler.asset); err != nil || balance != 0.001 {
+		t.Errorf("GetBalance() = %v, %v, want 0.001", balance, err)
+	}
+
+	sale, err := handler.GoShort(0.001)
+	if err != nil {
+		t.Fatalf("GoShort() failed: %v", err)
+	}
+	if sale.Price >= order.Price {
+		t.Errorf("sold at %v, want below the purchase price %v since it is filled at the bid", sale.Price, order.Price)
+	}
+	if _, err := handler.GoLong(1); !errors.Is(err, ErrPaperInsufficientBalance) {
+		t.Errorf("GoLong() beyond the balance returned %v, want ErrPaperInsufficientBalance", err)
+	}
+
+	fiat, _ := handler.FiatBalance()
+	if _, err := handler.Withdraw(context.Background(), fiat/2, "account:8472937", "leprechaun-1"); err != nil {
+		t.Fatalf("Withdraw() failed: %v", err)
+	}
+	if left, _ := handler.FiatBalance(); !approx(left, fiat/2) {
+		t.Errorf("%v left after withdrawing half of %v", left, fiat)
+	}
+	if replay.made("POST", "/api/exchange/1/move") != 0 {
+		t.Error("a simulated withdrawal reached the exchange")
+	}
+}
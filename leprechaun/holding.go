@@ -0,0 +1,127 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// holdingCheckInterval is how often open positions are checked against MaxHoldingHours.
+const holdingCheckInterval = 5 * time.Minute
+
+// expiredPositions returns the positions among `positions` that have been open longer than `maxHolding` at `now`.
+func expiredPositions(positions []Entry, maxHolding time.Duration, now time.Time) (expired []Entry) {
+	for _, position := range positions {
+		opened, err := position.Time()
+		if err != nil {
+			log.Printf("Could not tell when position %s was opened: %v", position.ID, err)
+			continue
+		}
+		if now.Sub(opened) > maxHolding {
+			expired = append(expired, position)
+		}
+	}
+	return
+}
+
+// closePosition closes an open position at market and records the trade. It returns the order that closed it.
+func (pf *Portfolio) closePosition(asset string, position *Entry) (stop *StopOrderEntry, orderType Order, err error) {
+	handler := pf.assets[asset]
+	book := pf.bookBefore(asset)
+	orderType = CloseLongTrade
+	if position.Type == OpenShortTrade {
+		orderType = CloseShortTrade
+		stop, err = handler.StopShort(position)
+	} else {
+		stop, err = handler.StopLong(position)
+	}
+	if err != nil {
+		return
+	}
+	pf.trackFee(asset, stop.OrderID, stop.Price)
+	pf.saveBook(book, stop.OrderID)
+	pf.closeTrade(position, asset, stop.Price, stop.Timestamp, stop.Volume, stop.OrderID, orderType)
+	return
+}
+
+// closeOnSignal closes the open positions a SignalCloseLong or SignalCloseShort asks to close, at market.
+func (pf *Portfolio) closeOnSignal(signal Signal) {
+	positionType := OpenLongTrade
+	if signal.Kind == SignalCloseShort {
+		positionType = OpenShortTrade
+	}
+	positions, err := pf.ledger.OpenPositions(pf.ctx, signal.Asset)
+	if err != nil {
+		pf.errs.report(signal.Asset, "close positions on signal", err)
+		return
+	}
+	closed := 0
+	for _, position := range positions {
+		if position.Type != positionType {
+			continue
+		}
+		if _, _, err := pf.exit(signal.Asset, &position, exitAnalyzer); err != nil {
+			if !errors.Is(err, errExitClaimed) && !errors.Is(err, errExitHeld) {
+				pf.errs.report(signal.Asset, "close position on signal", err)
+			}
+			continue
+		}
+		closed++
+	}
+	if closed > 0 {
+		log.Printf("Closed %d %s positions of %s on the analyzer's %v signal", closed, orderTypeName(positionType),
+			signal.Asset, signal.Kind)
+	}
+}
+
+// closeExpiredPositions closes the positions held longer than MaxHoldingHours, whatever their profit,
+// so the bot doesn't hold on to them through a long downtrend.
+func (pf *Portfolio) closeExpiredPositions() {
+	if pf.watchOnly || pf.maintenance.active() {
+		return
+	}
+	maxHolding := time.Duration(pf.config.MaxHoldingHours) * time.Hour
+	for _, asset := range pf.assetOrder() {
+		if pf.watching(asset) {
+			continue
+		}
+		positions, err := pf.ledger.OpenPositions(pf.ctx, asset)
+		if err != nil {
+			pf.errs.report(asset, "check holding periods", err)
+			continue
+		}
+		now := pf.clock.Now()
+		for _, position := range expiredPositions(positions, maxHolding, now) {
+			opened, _ := position.Time()
+			if _, _, err := pf.exit(asset, &position, exitHolding); err != nil {
+				if !errors.Is(err, errExitClaimed) {
+					pf.errs.report(asset, "close expired position", err)
+				}
+				continue
+			}
+			msg := pf.messages.Sprintf(MsgHoldingExpired, asset, orderTypeName(position.Type), position.ID,
+				now.Sub(opened).Round(time.Minute))
+			log.Println(msg)
+			pf.events.record("holding-expired", asset, msg)
+		}
+	}
+}
+
+// monitorHoldingPeriods closes positions held longer than MaxHoldingHours until the session ends.
+func (s *Session) monitorHoldingPeriods() {
+	if s.config.MaxHoldingHours <= 0 {
+		return
+	}
+	for {
+		s.portfolio.closeExpiredPositions()
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(holdingCheckInterval):
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"net/http"
+
+	luno "github.com/luno/luno-go"
+)
+
+// newLunoClient returns a Luno API client authenticated with the given key. Requests are sent through
+// `httpClient`, or through luno's default client if it is nil.
+func newLunoClient(keyID, keySecret string, httpClient *http.Client) *luno.Client {
+	client := luno.NewClient()
+	if httpClient != nil {
+		client.SetHTTPClient(httpClient)
+	}
+	client.SetAuth(keyID, keySecret)
+	return client
+}
+
+// SetHTTPClient sets the HTTP client the session's exchange handlers send their requests through, e.g. one
+// whose transport replays recorded responses in tests. It must be called before the session is initialized.
+func (s *Session) SetHTTPClient(client *http.Client) {
+	s.portfolio.httpClient = client
+}
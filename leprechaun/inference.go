@@ -0,0 +1,82 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	gg "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// NewModelVM returns a machine to run a model's graph on. If `useGPU` is set and Leprechaun was
+// built with the `cuda` tag (`go build -tags cuda`), supported operations are run on the GPU.
+func NewModelVM(g *gg.ExprGraph, useGPU bool) gg.VM {
+	if !useGPU {
+		return gg.NewTapeMachine(g)
+	}
+	if !cudaEnabled {
+		log.Println("GPU inference was requested but Leprechaun was not built with the `cuda` tag. Using the CPU instead.")
+		return gg.NewTapeMachine(g)
+	}
+	return gg.NewTapeMachine(g, gg.UseCudaFor())
+}
+
+// Predictor runs a model on the feature matrix of one asset. See `FeaturePipeline.Tensor`.
+type Predictor func(ctx context.Context, input *tensor.Dense) (tensor.Tensor, error)
+
+// InferenceJob is the input of a model for one asset.
+type InferenceJob struct {
+	Asset string
+	Input *tensor.Dense
+}
+
+// InferenceResult is the output of a model for one asset.
+type InferenceResult struct {
+	Asset  string
+	Output tensor.Tensor
+	Err    error
+}
+
+// RunInference runs a model on every job using a pool of `workers` goroutines and returns the results
+// in the order of the jobs. gorgonia graphs and machines must not be shared between goroutines, so
+// `newPredictor` is called once per worker to build its own copy of the model.
+func RunInference(ctx context.Context, jobs []InferenceJob, workers int, newPredictor func() (Predictor, error)) []InferenceResult {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	results := make([]InferenceResult, len(jobs))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			predict, err := newPredictor()
+			for i := range indices {
+				results[i].Asset = jobs[i].Asset
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+				if results[i].Err = ctx.Err(); results[i].Err != nil {
+					continue
+				}
+				results[i].Output, results[i].Err = predict(ctx, jobs[i].Input)
+			}
+		}()
+	}
+	for i := range jobs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
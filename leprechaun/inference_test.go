@@ -0,0 +1,102 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	gg "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// Shape of the benchmark model: a dense layer over a window of features, about the size of the bundled models.
+const (
+	benchWindow   = 64
+	benchFeatures = 16
+	benchHidden   = 128
+	benchAssets   = 8
+)
+
+// newBenchPredictor builds a model with a single dense layer and a sigmoid on a graph of its own.
+func newBenchPredictor() (Predictor, error) {
+	g := gg.NewGraph()
+	x := gg.NewMatrix(g, tensor.Float64, gg.WithShape(benchWindow, benchFeatures), gg.WithName("x"))
+	w := gg.NewMatrix(g, tensor.Float64, gg.WithShape(benchFeatures, benchHidden), gg.WithName("w"),
+		gg.WithInit(gg.GlorotU(1)))
+	product, err := gg.Mul(x, w)
+	if err != nil {
+		return nil, err
+	}
+	out, err := gg.Sigmoid(product)
+	if err != nil {
+		return nil, err
+	}
+	var output gg.Value
+	gg.Read(out, &output)
+	vm := NewModelVM(g, false)
+	return func(ctx context.Context, input *tensor.Dense) (tensor.Tensor, error) {
+		defer vm.Reset()
+		if err := gg.Let(x, input); err != nil {
+			return nil, err
+		}
+		if err := vm.RunAll(); err != nil {
+			return nil, err
+		}
+		return output.(tensor.Tensor).Clone().(tensor.Tensor), nil
+	}, nil
+}
+
+func benchJobs() []InferenceJob {
+	jobs := make([]InferenceJob, benchAssets)
+	for i := range jobs {
+		data := make([]float64, benchWindow*benchFeatures)
+		for j := range data {
+			data[j] = float64((i+j)%7) / 7
+		}
+		jobs[i] = InferenceJob{Asset: fmt.Sprintf("ASSET%d", i),
+			Input: tensor.New(tensor.WithShape(benchWindow, benchFeatures), tensor.WithBacking(data))}
+	}
+	return jobs
+}
+
+func TestRunInference(t *testing.T) {
+	jobs := benchJobs()
+	for _, result := range RunInference(context.Background(), jobs, 3, newBenchPredictor) {
+		if result.Err != nil {
+			t.Fatalf("%s: %v", result.Asset, result.Err)
+		}
+		if shape := result.Output.Shape(); !shape.Eq(tensor.Shape{benchWindow, benchHidden}) {
+			t.Errorf("%s: output shape %v, want (%d, %d)", result.Asset, shape, benchWindow, benchHidden)
+		}
+	}
+}
+
+func TestRunInferenceKeepsOrder(t *testing.T) {
+	jobs := benchJobs()
+	results := RunInference(context.Background(), jobs, 4, newBenchPredictor)
+	for i, result := range results {
+		if result.Asset != jobs[i].Asset {
+			t.Errorf("result %d is for %s, want %s", i, result.Asset, jobs[i].Asset)
+		}
+	}
+}
+
+// BenchmarkRunInference compares running the model of every asset on one worker with spreading them over a pool.
+func BenchmarkRunInference(b *testing.B) {
+	jobs := benchJobs()
+	for _, workers := range []int{1, 2, 4, benchAssets} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, result := range RunInference(context.Background(), jobs, workers, newBenchPredictor) {
+					if result.Err != nil {
+						b.Fatal(result.Err)
+					}
+				}
+			}
+		})
+	}
+}
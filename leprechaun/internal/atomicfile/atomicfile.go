@@ -0,0 +1,49 @@
+// Package atomicfile replaces files in a way that a crash while writing never leaves a half-written file behind.
+package atomicfile
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Write replaces the file at `path` with what `write` writes. It is written to a temporary file next to `path`
+// first, which is then renamed over it. The folder of `path` is created if it doesn't exist.
+func Write(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+	if err = write(file); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(file.Name(), perm); err != nil {
+		return
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// WriteFile replaces the file at `path` with `data`. See Write.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return Write(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
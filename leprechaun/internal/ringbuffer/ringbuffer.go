@@ -0,0 +1,92 @@
+// Package ringbuffer holds the fixed size buffers that keep the price, candle and record histories of
+// long running sessions from growing.
+package ringbuffer
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+// Buffer holds the last `Cap()` values pushed to it. Once it is full, each push overwrites the oldest value,
+// so price and candle histories of long running sessions use a fixed amount of memory.
+type Buffer[T any] struct {
+	items []T
+	start int // index of the oldest value
+	size  int
+}
+
+// New returns an empty buffer holding at most `capacity` values (at least one).
+func New[T any](capacity int) *Buffer[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Buffer[T]{items: make([]T, capacity)}
+}
+
+// Push adds `values` after the newest value, dropping the oldest ones if the buffer is full.
+func (r *Buffer[T]) Push(values ...T) {
+	for _, v := range values {
+		if r.size < len(r.items) {
+			r.items[(r.start+r.size)%len(r.items)] = v
+			r.size++
+			continue
+		}
+		r.items[r.start] = v
+		r.start = (r.start + 1) % len(r.items)
+	}
+}
+
+// Len returns the number of values held.
+func (r *Buffer[T]) Len() int { return r.size }
+
+// Cap returns the most values the buffer holds.
+func (r *Buffer[T]) Cap() int { return len(r.items) }
+
+// At returns the `i`th oldest value. It panics if `i` is out of range, like indexing a slice.
+func (r *Buffer[T]) At(i int) T {
+	if i < 0 || i >= r.size {
+		panic("leprechaun: ring buffer index out of range")
+	}
+	return r.items[(r.start+i)%len(r.items)]
+}
+
+// Last returns the newest value, or false if the buffer is empty.
+func (r *Buffer[T]) Last() (v T, ok bool) {
+	if r.size == 0 {
+		return
+	}
+	return r.At(r.size - 1), true
+}
+
+// Slice returns a copy of the values, oldest first.
+func (r *Buffer[T]) Slice() []T {
+	values := make([]T, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		values = append(values, r.At(i))
+	}
+	return values
+}
+
+// Reset empties the buffer.
+func (r *Buffer[T]) Reset() {
+	var zero T
+	for i := range r.items {
+		r.items[i] = zero // let the garbage collector have them
+	}
+	r.start, r.size = 0, 0
+}
+
+// Resize changes the capacity of the buffer, keeping the newest values that fit.
+func (r *Buffer[T]) Resize(capacity int) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if capacity == len(r.items) {
+		return
+	}
+	values := r.Slice()
+	if len(values) > capacity {
+		values = values[len(values)-capacity:]
+	}
+	r.items, r.start, r.size = make([]T, capacity), 0, 0
+	r.Push(values...)
+}
@@ -0,0 +1,111 @@
+// Package rolling keeps statistics of a sliding window of values, updating them as values are pushed instead of
+// rescanning the window.
+package rolling
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"math"
+
+	"unit2/leprechaun/internal/ringbuffer"
+)
+
+// Stats keeps the minimum, maximum, mean and standard deviation of the last `window` values pushed to it,
+// updating them in constant (amortized) time per value instead of rescanning the window. The minimum and maximum
+// are kept in monotonic deques and the mean and variance in running sums.
+type Stats struct {
+	window     int
+	values     *ringbuffer.Buffer[float64]
+	pushed     int // number of values ever pushed; numbers the values in the deques
+	mins, maxs []seqValue
+	sum, sumSq float64
+}
+
+// seqValue is a value in a monotonic deque, numbered by when it was pushed.
+type seqValue struct {
+	seq   int
+	value float64
+}
+
+// New returns empty statistics over a window of `window` values (at least one).
+func New(window int) *Stats {
+	if window < 1 {
+		window = 1
+	}
+	return &Stats{window: window, values: ringbuffer.New[float64](window)}
+}
+
+// Push adds a value to the window, dropping the oldest one if the window is full.
+func (r *Stats) Push(v float64) {
+	if r.values.Len() == r.window {
+		oldest := r.values.At(0)
+		r.sum -= oldest
+		r.sumSq -= oldest * oldest
+	}
+	r.values.Push(v)
+	r.sum += v
+	r.sumSq += v * v
+
+	first := r.pushed - r.window + 1 // the oldest value still in the window
+	r.pushed++
+	for len(r.mins) > 0 && r.mins[len(r.mins)-1].value >= v {
+		r.mins = r.mins[:len(r.mins)-1]
+	}
+	r.mins = append(r.mins, seqValue{r.pushed - 1, v})
+	for len(r.mins) > 0 && r.mins[0].seq < first {
+		r.mins = r.mins[1:]
+	}
+	for len(r.maxs) > 0 && r.maxs[len(r.maxs)-1].value <= v {
+		r.maxs = r.maxs[:len(r.maxs)-1]
+	}
+	r.maxs = append(r.maxs, seqValue{r.pushed - 1, v})
+	for len(r.maxs) > 0 && r.maxs[0].seq < first {
+		r.maxs = r.maxs[1:]
+	}
+}
+
+// Len returns the number of values in the window.
+func (r *Stats) Len() int { return r.values.Len() }
+
+// Full returns true once the window holds `window` values.
+func (r *Stats) Full() bool { return r.values.Len() == r.window }
+
+// Min returns the smallest value in the window, or 0 if it is empty.
+func (r *Stats) Min() float64 {
+	if len(r.mins) == 0 {
+		return 0
+	}
+	return r.mins[0].value
+}
+
+// Max returns the largest value in the window, or 0 if it is empty.
+func (r *Stats) Max() float64 {
+	if len(r.maxs) == 0 {
+		return 0
+	}
+	return r.maxs[0].value
+}
+
+// Mean returns the mean of the values in the window, or 0 if it is empty.
+func (r *Stats) Mean() float64 {
+	if r.values.Len() == 0 {
+		return 0
+	}
+	return r.sum / float64(r.values.Len())
+}
+
+// StdDev returns the population standard deviation of the values in the window.
+func (r *Stats) StdDev() float64 {
+	n := float64(r.values.Len())
+	if n == 0 {
+		return 0
+	}
+	mean := r.sum / n
+	variance := r.sumSq/n - mean*mean
+	if variance < 0 {
+		variance = 0 // rounding
+	}
+	return math.Sqrt(variance)
+}
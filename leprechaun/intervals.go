@@ -0,0 +1,77 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrUnsupportedInterval is returned when candles of an interval can't be fetched from the exchange or resampled from
+// any interval it supports.
+var ErrUnsupportedInterval = fmt.Errorf("unsupported candle interval")
+
+// chooseInterval returns the interval candles should be fetched at so they can be analyzed at `want`. That is `want`
+// itself if the exchange supports it, otherwise the longest supported interval that `want` is a multiple of.
+func chooseInterval(supported []time.Duration, want time.Duration) (fetch time.Duration, err error) {
+	if want <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedInterval, want)
+	}
+	for _, interval := range supported {
+		if interval == want {
+			return want, nil
+		}
+		if interval < want && want%interval == 0 && interval > fetch {
+			fetch = interval
+		}
+	}
+	if fetch == 0 {
+		return 0, fmt.Errorf("%w: %s can't be made from any of %v", ErrUnsupportedInterval, want, supported)
+	}
+	return fetch, nil
+}
+
+// Resample merges time sorted candles of the `from` interval into candles of the longer `to` interval, e.g. hourly
+// candles into 4 hour candles. Candles standing in for missing data don't affect the prices of the merged candle
+// unless all of its candles are missing. Candles can't be split into shorter ones, so an error is returned if `to`
+// isn't a multiple of `from`.
+func Resample(candles []OHLC, from, to time.Duration) (resampled []OHLC, err error) {
+	if from <= 0 || to < from || to%from != 0 {
+		return nil, fmt.Errorf("%w: can't resample %s candles to %s", ErrUnsupportedInterval, from, to)
+	}
+	if to == from {
+		return append([]OHLC{}, candles...), nil
+	}
+	interval := to
+	for _, candle := range candles {
+		start := candle.Time.Truncate(interval)
+		n := len(resampled)
+		if n == 0 || !resampled[n-1].Time.Equal(start) {
+			merged := candle
+			merged.Time, merged.Period, merged.Prices, merged.Patterns = start, interval, nil, nil
+			resampled = append(resampled, merged)
+			continue
+		}
+		merged := &resampled[n-1]
+		merged.TotalVolume += candle.TotalVolume
+		merged.Filled = merged.Filled && candle.Filled
+		switch {
+		case candle.Missing:
+			continue
+		case merged.Missing:
+			// Only missing candles so far; the first real one sets the prices.
+			merged.Open, merged.High, merged.Low, merged.Missing = candle.Open, candle.High, candle.Low, false
+		default:
+			merged.High = math.Max(merged.High, candle.High)
+			merged.Low = math.Min(merged.Low, candle.Low)
+		}
+		merged.Close = candle.Close
+	}
+	for i := range resampled {
+		resampled[i].setTrend()
+	}
+	return resampled, nil
+}
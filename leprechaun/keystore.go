@@ -0,0 +1,80 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+)
+
+var (
+	keyStoreInit   = "CREATE TABLE IF NOT EXISTS KEYS (NAME TEXT PRIMARY KEY, VALUE TEXT)"
+	keyStoreInsert = "INSERT OR REPLACE INTO KEYS VALUES(?, ?)"
+	keyStoreSearch = "SELECT VALUE FROM KEYS WHERE NAME = ?"
+)
+
+// openKeyStore opens the keystore database, creating it if needed. The file is only readable by the current user.
+func (c *Configuration) openKeyStore() (db *sql.DB, err error) {
+	if err = os.MkdirAll(filepath.Dir(c.keyStore), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(c.keyStore, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return
+	}
+	f.Close()
+	if err = os.Chmod(c.keyStore, 0600); err != nil {
+		return
+	}
+	db, err = sql.Open("sqlite3", c.keyStore)
+	if err != nil {
+		return
+	}
+	if _, err = db.Exec(keyStoreInit); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return
+}
+
+// SaveAPIKeys stores the exchange API key ID and secret in the keystore, so they don't have to be
+// passed on the command line or written to the settings file.
+func (c *Configuration) SaveAPIKeys(keyID, keySecret string) (err error) {
+	db, err := c.openKeyStore()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(keyStoreInsert, keyIDValue, keyID); err != nil {
+		return
+	}
+	if _, err = tx.Exec(keyStoreInsert, keySecretValue, keySecret); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// LoadAPIKeys returns the API key ID and secret saved with SaveAPIKeys.
+func (c *Configuration) LoadAPIKeys() (keyID, keySecret string, err error) {
+	if !exists(c.keyStore) {
+		return "", "", ErrNoSavedSettings
+	}
+	db, err := c.openKeyStore()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	if err = db.QueryRow(keyStoreSearch, keyIDValue).Scan(&keyID); err != nil {
+		return
+	}
+	err = db.QueryRow(keyStoreSearch, keySecretValue).Scan(&keySecret)
+	return
+}
@@ -0,0 +1,196 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"unit2/leprechaun/internal/ringbuffer"
+)
+
+const (
+	// latencySamples is the number of recent requests to each endpoint the latency percentiles are computed from.
+	latencySamples = 200
+	// minLatencySamples is the number of requests to an endpoint needed before its latency is checked.
+	minLatencySamples = 10
+	// latencyCheckInterval is how often the latency of the endpoints stops depend on is checked.
+	latencyCheckInterval = time.Minute
+	// defaultClientTimeout is the timeout of luno's default HTTP client, kept when the latency of its requests is measured.
+	defaultClientTimeout = 10 * time.Second
+)
+
+// Endpoint is a group of exchange API endpoints whose latency is measured together.
+type Endpoint string
+
+const (
+	EndpointTicker      Endpoint = "ticker"       // Prices, e.g. the ticker and the top of the order book.
+	EndpointOrderPost   Endpoint = "order post"   // Placing market and limit orders.
+	EndpointOrderStatus Endpoint = "order status" // Fetching and listing orders.
+	EndpointOrderCancel Endpoint = "order cancel" // Cancelling orders.
+	EndpointOther       Endpoint = "other"        // Balances, candles and everything else.
+)
+
+// endpointOf returns the endpoint group of a request to the Luno API.
+func endpointOf(req *http.Request) Endpoint {
+	path := req.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/1/ticker"), strings.HasPrefix(path, "/api/1/orderbook_top"):
+		return EndpointTicker
+	case path == "/api/1/marketorder", path == "/api/1/postorder":
+		return EndpointOrderPost
+	case path == "/api/1/stoporder":
+		return EndpointOrderCancel
+	case strings.HasPrefix(path, "/api/1/orders/"), strings.HasPrefix(path, "/api/exchange/2/orders/"),
+		path == "/api/exchange/3/order", strings.HasSuffix(path, "/listorders"):
+		return EndpointOrderStatus
+	}
+	return EndpointOther
+}
+
+// LatencyStats are the latency percentiles of the recent requests to an endpoint.
+type LatencyStats struct {
+	Samples int // Requests the percentiles are computed from.
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+}
+
+// latencyTracker keeps the latency of the recent requests to each endpoint.
+type latencyTracker struct {
+	mu       sync.Mutex
+	samples  map[Endpoint]*ringbuffer.Buffer[time.Duration]
+	degraded map[Endpoint]bool // endpoints the user has been warned about
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{samples: make(map[Endpoint]*ringbuffer.Buffer[time.Duration]),
+		degraded: make(map[Endpoint]bool)}
+}
+
+// observe records the latency of a request to `endpoint`.
+func (t *latencyTracker) observe(endpoint Endpoint, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples, ok := t.samples[endpoint]
+	if !ok {
+		samples = ringbuffer.New[time.Duration](latencySamples)
+		t.samples[endpoint] = samples
+	}
+	samples.Push(latency)
+}
+
+// stats returns the latency percentiles of each endpoint requested so far.
+func (t *latencyTracker) stats() map[Endpoint]LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make(map[Endpoint]LatencyStats, len(t.samples))
+	for endpoint, samples := range t.samples {
+		sorted := samples.Slice()
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats[endpoint] = LatencyStats{Samples: len(sorted), P50: percentile(sorted, 50), P90: percentile(sorted, 90),
+			P99: percentile(sorted, 99)}
+	}
+	return stats
+}
+
+// setDegraded records whether the user has been warned about `endpoint`. It returns true if that changed.
+func (t *latencyTracker) setDegraded(endpoint Endpoint, degraded bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := t.degraded[endpoint] != degraded
+	t.degraded[endpoint] = degraded
+	return changed
+}
+
+// percentile returns the `p`th percentile (nearest rank) of `sorted`, which is in ascending order.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// latencyTransport measures the time each request takes until the response headers arrive.
+type latencyTransport struct {
+	next    http.RoundTripper
+	tracker *latencyTracker
+	clock   Clock
+}
+
+func (t *latencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := t.clock.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.tracker.observe(endpointOf(req), t.clock.Now().Sub(start))
+	return resp, err
+}
+
+// client returns a copy of `base` whose requests are measured on `clock`. A nil `base` stands for luno's default
+// client.
+func (t *latencyTracker) client(base *http.Client, clock Clock) *http.Client {
+	if base == nil {
+		base = &http.Client{Timeout: defaultClientTimeout}
+	}
+	client := *base
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &latencyTransport{next: next, tracker: t, clock: clock}
+	return &client
+}
+
+// checkLatency warns the user when the requests stop losses depend on, fetching the price and placing the
+// order that closes the position, are slow enough at the 90th percentile that stops may execute late.
+func (pf *Portfolio) checkLatency() {
+	limit := time.Duration(pf.config.StopLatencyWarning) * time.Millisecond
+	stats := pf.latency.stats()
+	for _, endpoint := range []Endpoint{EndpointTicker, EndpointOrderPost} {
+		stat := stats[endpoint]
+		if stat.Samples < minLatencySamples {
+			continue
+		}
+		degraded := stat.P90 > limit
+		if !pf.latency.setDegraded(endpoint, degraded) {
+			continue
+		}
+		if !degraded {
+			log.Printf("The latency of %s requests is back to %s at the 90th percentile", endpoint, stat.P90.Round(time.Millisecond))
+			pf.events.record("latency", "", "Recovered: "+string(endpoint))
+			continue
+		}
+		msg := pf.messages.Sprintf(MsgLatencyDegraded, endpoint, stat.P90.Round(time.Millisecond), stat.Samples, limit)
+		log.Println(msg)
+		pf.events.record("latency", "", msg)
+		if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgLatencyDegradedSubject), msg); err != nil {
+			log.Printf("Could not send notification: %v", err)
+		}
+	}
+}
+
+// monitorLatency checks the latency of the exchange's endpoints until the session ends.
+func (s *Session) monitorLatency() {
+	if s.config.StopLatencyWarning <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(latencyCheckInterval):
+		}
+		s.portfolio.checkLatency()
+	}
+}
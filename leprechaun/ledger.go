@@ -4,248 +4,467 @@ package leprechaun
 *  @author: Michael Lormann
  */
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"database/sql"
 	// go-sqlite3 is imported for its side-effect of loading the sqlite3 driver.
 	_ "github.com/mattn/go-sqlite3"
+	"unit2/leprechaun/internal/ringbuffer"
 )
 
 // SQLITE operations.
 var (
 	sqlDatabaseName        = "Leprechaun.Ledger"
-	databaseInit    string = "CREATE TABLE RECORDS (ASSET, COST, ID, PRICE, SALE_ID, SOLD, STATUS, TIMESTAMP, VOLUME, TYPE, TRIGGER_PRICE)"
-	recordInsert           = "INSERT INTO RECORDS VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
-	idSearch        string = "SELECT * FROM RECORDS WHERE ID = ?"
+	databaseInit    string = "CREATE TABLE RECORDS (ASSET, PURCHASE_COST, SALE_COST, ID, PURCHASE_PRICE, SALE_PRICE, SALE_ID, STATUS, TIMESTAMP, PURCHASE_VOLUME, SALE_VOLUME, PROFIT, TYPE, TRIGGER_PRICE, UPDATED, VERSION INTEGER DEFAULT 0)"
+	recordInsert           = "INSERT INTO RECORDS VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	// recordUpdate only matches the record if it hasn't been changed since it was read, see UpdateRecord.
+	recordUpdate = "UPDATE RECORDS SET ASSET = ?, PURCHASE_COST = ?, SALE_COST = ?, PURCHASE_PRICE = ?, SALE_PRICE = ?, SALE_ID = ?, STATUS = ?, " +
+		"TIMESTAMP = ?, PURCHASE_VOLUME = ?, SALE_VOLUME = ?, PROFIT = ?, TYPE = ?, TRIGGER_PRICE = ?, UPDATED = ?, VERSION = VERSION + 1 WHERE ID = ? AND VERSION = ?"
+	idSearch string = "SELECT * FROM RECORDS WHERE ID = ?"
 	// abs(PRICE) + abs(PRICE) * `margin` adjusts the price by profit margin provided.
 	// E.g. to adjust a price of 2_000_000 by a 1% margin, we have 2_000_000 + (2_000_000 * 0.01)
 	// giving an adjusted price of 2_020_000
-	viableRecordSearch = "SELECT * FROM RECORDS WHERE ASSET = ? AND abs(PRICE) + abs(PRICE) * ? < ?"
+	viableRecordSearch = "SELECT * FROM RECORDS WHERE ASSET = ? AND abs(PURCHASE_PRICE) + abs(PURCHASE_PRICE) * ? < ?"
 	getAllRecordsOp    = "SELECT * FROM RECORDS"
 	typeSearchOp       = "SELECT * FROM RECORDS WHERE ASSET = ? AND TYPE = ?"
+	openPositionsOp    = "SELECT * FROM RECORDS WHERE ASSET = ? AND STATUS = ? AND TYPE IN (?, ?)"
 	deleteRecordOp     = "DELETE FROM RECORDS WHERE ID = ?"
 )
 
+var (
+	// ErrRecordNotFound is returned when no ledger record has the given order ID.
+	ErrRecordNotFound = errors.New("no ledger record with this ID")
+	// ErrRecordConflict is returned when a ledger record was changed by someone else since it was read.
+	ErrRecordConflict = errors.New("the ledger record was changed since it was read")
+)
+
+// Special values of Configuration.LedgerDatabase for ledgers that don't outlive the process,
+// e.g. for tests and throwaway paper-trading runs.
+const (
+	// MemoryLedger keeps the ledger in memory only.
+	MemoryLedger = ":memory:"
+	// TempLedger keeps the ledger in a temporary file that is deleted when the ledger is closed.
+	TempLedger = ":temp:"
+)
+
+// LedgerStore keeps the records of the bot's trades. Every call takes a context so that
+// slow database work can be abandoned when the session shuts down.
+//
+// The ledger is opened from Configuration.LedgerDatabase, which is either the path of a database
+// file, MemoryLedger or TempLedger. A file ledger is written to disk by Save; the records of the
+// other two are kept until Close is called and then discarded.
+type LedgerStore interface {
+	// AddRecord adds a new record to the ledger.
+	AddRecord(ctx context.Context, rec Entry) error
+	// GetRecordByID returns the record with the given order ID.
+	GetRecordByID(ctx context.Context, id string) (Entry, error)
+	// UpdateRecord replaces the record with the order ID of `rec`. It fails with ErrRecordConflict if the
+	// record has been changed since `rec` was read; otherwise rec.Version is advanced to the stored version.
+	UpdateRecord(ctx context.Context, rec *Entry) error
+	// CloseRecord marks the record with the order ID of `rec` as closed and stores it like UpdateRecord.
+	CloseRecord(ctx context.Context, rec *Entry) error
+	// DeleteRecord removes the record with the given order ID.
+	DeleteRecord(ctx context.Context, id string) error
+	// GetRecordsByType returns all records of an asset with the given order type.
+	GetRecordsByType(ctx context.Context, asset string, orderType Order) ([]Entry, error)
+	// OpenPositions returns the positions of an asset that haven't been closed yet.
+	OpenPositions(ctx context.Context, asset string) ([]Entry, error)
+	// ViableRecords returns the records of an asset that can be sold at `price` for a profit of at least
+	// `margin` (a fraction of the purchase price).
+	ViableRecords(ctx context.Context, asset string, price, margin float64) ([]Entry, error)
+	// AllRecords returns every record in the ledger.
+	AllRecords(ctx context.Context) ([]Entry, error)
+	// CountSignal counts a long or short signal emitted by `analyzer` for `asset` on the day of `t`.
+	CountSignal(ctx context.Context, analyzer, asset string, t time.Time) error
+	// SignalCounts returns the number of signals each analyzer emitted from the day of `from` up to (but not
+	// including) the day of `to`.
+	SignalCounts(ctx context.Context, from, to time.Time) (map[string]int, error)
+	// TagTrade records that the position opened by order `tradeID` was opened on a signal of `analyzer`.
+	TagTrade(ctx context.Context, tradeID, analyzer string) error
+	// TradeTags returns the analyzer each tagged position was opened on, by order ID.
+	TradeTags(ctx context.Context) (map[string]string, error)
+	// SaveOrderBook stores the order book snapshot taken before order `snapshot.TradeID` was placed.
+	SaveOrderBook(ctx context.Context, snapshot OrderBookSnapshot) error
+	// OrderBook returns the order book snapshot taken before order `tradeID` was placed.
+	OrderBook(ctx context.Context, tradeID string) (OrderBookSnapshot, error)
+	// RecordRounding stores an order whose volume was rounded.
+	RecordRounding(ctx context.Context, rounding OrderRounding) error
+	// Roundings returns the orders placed from `from` up to (but not including) `to` whose volume was rounded.
+	Roundings(ctx context.Context, from, to time.Time) ([]OrderRounding, error)
+	// RecordConsolidation stores the sale of an asset's residual balance.
+	RecordConsolidation(ctx context.Context, sale DustConsolidation) error
+	// Consolidations returns every sale of residual balances.
+	Consolidations(ctx context.Context) ([]DustConsolidation, error)
+	// RecordWithdrawal stores a withdrawal of realized profits.
+	RecordWithdrawal(ctx context.Context, w Withdrawal) error
+	// Withdrawals returns every withdrawal of realized profits.
+	Withdrawals(ctx context.Context) ([]Withdrawal, error)
+	// Save flushes the ledger. It stays open for the goroutines still using it until Close is called.
+	Save() error
+	// Close closes the ledger for good. Ephemeral ledgers are discarded.
+	Close() error
+}
+
 // Ledger2 object stores records of purchased assets in a sql database.
 type Ledger2 struct {
 	databasePath string
+	tempDir      string // holds the database of a TempLedger
 	db           *sql.DB
 	isOpen       bool
 }
 
-func GetLedger2() *Ledger2 {
-	l := &Ledger2{databasePath: "."}
+// NewLedger opens the SQLite ledger at `dsn`, e.g. to pass to WithLedger. See LedgerStore for the values it can take.
+func NewLedger(dsn string) LedgerStore {
+	return GetLedger2(dsn)
+}
+
+// GetLedger2 opens the ledger at `dsn`. See LedgerStore for the values it can take.
+func GetLedger2(dsn string) *Ledger2 {
+	l := &Ledger2{databasePath: dsn}
+	if dsn == TempLedger {
+		dir, err := os.MkdirTemp("", "leprechaun-ledger-")
+		if err != nil {
+			log.Fatal("Could not create a temporary ledger ", err)
+		}
+		l.databasePath, l.tempDir = filepath.Join(dir, "ledger.db"), dir
+	}
 	l.loadDatabase()
 	return l
 }
 
+// ephemeral returns true if the ledger is discarded when it is closed.
+func (l *Ledger2) ephemeral() bool {
+	return l.databasePath == MemoryLedger || l.tempDir != ""
+}
+
 // ViableRecords checks the database for any records whose prices are lower
 // (beyond a certain `margin`) than the value of `price`.
-func (l *Ledger2) ViableRecords(asset string, price float64) (records []Entry, err error) {
-	// TODO:: Include margin test in viable records check
+func (l *Ledger2) ViableRecords(ctx context.Context, asset string, price, margin float64) (records []Entry, err error) {
 	if !l.isOpen {
 		l.loadDatabase()
 	}
-	tx, err := l.db.Begin()
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	stmt, err := l.db.Prepare(viableRecordSearch)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, viableRecordSearch)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	margin := globalConfig.ProfitMargin
-	rows, err := stmt.Query(asset, margin, price)
+	rows, err := stmt.QueryContext(ctx, asset, margin, price)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		rec := Entry{}
-		err = scanEntryRows(rows, rec)
+		err = scanEntryRows(rows, &rec)
 		if err != nil {
 			return
 		}
 		records = append(records, rec)
 	}
-	tx.Commit()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	err = tx.Commit()
 	return
 }
 
-func scanEntryRows(rows *sql.Rows, rec Entry) (err error) {
+func scanEntryRows(rows *sql.Rows, rec *Entry) (err error) {
 	err = rows.Scan(&rec.Asset, &rec.PurchaseCost, &rec.SaleCost, &rec.ID, &rec.PurchasePrice, &rec.SalePrice, &rec.SaleID,
-		&rec.Status, &rec.Timestamp, &rec.PurchaseVolume, &rec.SaleVolume, &rec.Profit, &rec.Type, &rec.TriggerPrice, &rec.Updated)
+		&rec.Status, &rec.Timestamp, &rec.PurchaseVolume, &rec.SaleVolume, &rec.Profit, &rec.Type, &rec.TriggerPrice, &rec.Updated, &rec.Version)
 	return err
 }
 
 // GetRecordByID returns a record from the database with the `id` provided.
-func (l *Ledger2) GetRecordByID(id string) (rec Entry, err error) {
+func (l *Ledger2) GetRecordByID(ctx context.Context, id string) (rec Entry, err error) {
 	rec = Entry{}
 	if !l.isOpen {
 		l.loadDatabase()
 	}
-	tx, err := l.db.Begin()
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	stmt, err := l.db.Prepare(idSearch)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, idSearch)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	err = stmt.QueryRow(id).Scan(&rec.Asset, &rec.PurchaseCost, &rec.SaleCost, &rec.ID, &rec.PurchasePrice, &rec.SalePrice, &rec.SaleID,
-		&rec.Status, &rec.Timestamp, &rec.PurchaseVolume, &rec.SaleVolume, &rec.Profit, &rec.Type, &rec.TriggerPrice, &rec.Updated)
+	err = stmt.QueryRowContext(ctx, id).Scan(&rec.Asset, &rec.PurchaseCost, &rec.SaleCost, &rec.ID, &rec.PurchasePrice, &rec.SalePrice, &rec.SaleID,
+		&rec.Status, &rec.Timestamp, &rec.PurchaseVolume, &rec.SaleVolume, &rec.Profit, &rec.Type, &rec.TriggerPrice, &rec.Updated, &rec.Version)
 	if err != nil {
 		return
 	}
-	tx.Commit()
-
+	err = tx.Commit()
 	return
 }
 
 // DeleteRecord removes the record with the provided `ID` from the ledger.
-func (l *Ledger2) DeleteRecord(id string) (err error) {
+func (l *Ledger2) DeleteRecord(ctx context.Context, id string) (err error) {
 	if !l.isOpen {
 		l.loadDatabase()
 	}
-	tx, err := l.db.Begin()
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	stmt, err := l.db.Prepare(deleteRecordOp)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, deleteRecordOp)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return
+	}
+	log.Printf("delete op: %v for record with id %s", res, id)
+	err = tx.Commit()
+	return
+}
+
+// GetRecordsByType retrieves records in the ledger by order type
+func (l *Ledger2) GetRecordsByType(ctx context.Context, asset string, orderType Order) (records []Entry, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	res, err := stmt.Exec(id)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, typeSearchOp)
 	if err != nil {
 		return
 	}
-	log.Printf("delete op: %v for record with id %s", res, id)
-	tx.Commit()
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, asset, orderType)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec := Entry{}
+		err = scanEntryRows(rows, &rec)
+		if err != nil {
+			return
+		}
+		records = append(records, rec)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	err = tx.Commit()
 	return
 }
 
-// GetRecordsByType retrieves records in the ledger by order type
-func (l *Ledger2) GetRecordsByType(asset string, orderType Order) (records []Entry, err error) {
+// OpenPositions returns the long and short positions of `asset` whose status is still `Open`.
+func (l *Ledger2) OpenPositions(ctx context.Context, asset string) (records []Entry, err error) {
 	if !l.isOpen {
 		l.loadDatabase()
 	}
-	tx, err := l.db.Begin()
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	stmt, err := l.db.Prepare(typeSearchOp)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, openPositionsOp)
 	if err != nil {
-		return records, nil
+		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(asset, orderType)
+	rows, err := stmt.QueryContext(ctx, asset, Open, OpenLongTrade, OpenShortTrade)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		rec := Entry{}
-		err = scanEntryRows(rows, rec)
+		err = scanEntryRows(rows, &rec)
 		if err != nil {
 			return
 		}
 		records = append(records, rec)
 	}
-	tx.Commit()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	err = tx.Commit()
 	return
 }
 
 // AllRecords returns all purchase records stored in the ledger.
-func (l *Ledger2) AllRecords() (records []Entry, err error) {
+func (l *Ledger2) AllRecords(ctx context.Context) (records []Entry, err error) {
 	if !l.isOpen {
 		l.loadDatabase()
 	}
-	tx, err := l.db.Begin()
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	stmt, err := l.db.Prepare(getAllRecordsOp)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, getAllRecordsOp)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		rec := Entry{}
-		err = scanEntryRows(rows, rec)
+		err = scanEntryRows(rows, &rec)
 		if err != nil {
 			return
 		}
 		records = append(records, rec)
 	}
-	tx.Commit()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	err = tx.Commit()
 	return
 }
 
 // AddRecord adds a `Entry` to the database.
-func (l *Ledger2) AddRecord(rec Entry) (err error) {
+func (l *Ledger2) AddRecord(ctx context.Context, rec Entry) (err error) {
 	if !l.isOpen {
 		l.loadDatabase()
 	}
-	tx, err := l.db.Begin()
+	tx, err := l.db.BeginTx(ctx, nil)
 	if err != nil {
 		return
 	}
-	stmt, err := tx.Prepare(recordInsert)
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, recordInsert)
 	if err != nil {
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(&rec.Asset, &rec.PurchaseCost, &rec.SaleCost, &rec.ID, &rec.PurchasePrice, &rec.SalePrice, &rec.SaleID,
-		&rec.Status, &rec.Timestamp, &rec.PurchaseVolume, &rec.SaleVolume, &rec.Profit, &rec.Type, &rec.TriggerPrice, &rec.Updated)
+	_, err = stmt.ExecContext(ctx, &rec.Asset, &rec.PurchaseCost, &rec.SaleCost, &rec.ID, &rec.PurchasePrice, &rec.SalePrice, &rec.SaleID,
+		&rec.Status, &rec.Timestamp, &rec.PurchaseVolume, &rec.SaleVolume, &rec.Profit, &rec.Type, &rec.TriggerPrice, &rec.Updated, &rec.Version)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Could not add record %s to the ledger: %v", rec.ID, err)
 		return err
 	}
-	tx.Commit()
+	err = tx.Commit()
 	return
 }
 
-// Save closese the database. Must be called by any external user of the ledger.
-func (l *Ledger2) Save() (err error) {
+// UpdateRecord replaces the record with the same order ID as `rec`, provided it is still at rec.Version.
+func (l *Ledger2) UpdateRecord(ctx context.Context, rec *Entry) (err error) {
 	if !l.isOpen {
-		l.db.Close()
+		l.loadDatabase()
+	}
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, recordUpdate, rec.Asset, rec.PurchaseCost, rec.SaleCost, rec.PurchasePrice, rec.SalePrice, rec.SaleID,
+		rec.Status, rec.Timestamp, rec.PurchaseVolume, rec.SaleVolume, rec.Profit, rec.Type, rec.TriggerPrice, rec.Updated, rec.ID, rec.Version)
+	if err != nil {
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if n == 0 {
+		// Tell a missing record from one that has moved on to a newer version.
+		var count int
+		if err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM RECORDS WHERE ID = ?", rec.ID).Scan(&count); err != nil {
+			return
+		}
+		if count == 0 {
+			return ErrRecordNotFound
+		}
+		return ErrRecordConflict
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	rec.Version++
+	return
+}
+
+// CloseRecord marks `rec` as closed and updates it in the ledger.
+func (l *Ledger2) CloseRecord(ctx context.Context, rec *Entry) (err error) {
+	status := rec.Status
+	rec.Status = int64(Closed)
+	if err = l.UpdateRecord(ctx, rec); err != nil {
+		rec.Status = status
+	}
+	return
+}
+
+// Save leaves the database open: every write is committed by its own transaction, and closing the database would
+// fail the queries other goroutines are running on it. It is closed by Close at the end of the session.
+func (l *Ledger2) Save() error {
+	return nil
+}
+
+// Close closes the database and deletes an ephemeral ledger.
+func (l *Ledger2) Close() (err error) {
+	if l.isOpen {
+		err = l.db.Close()
 	}
 	l.isOpen = false
+	if l.tempDir != "" {
+		if rmErr := os.RemoveAll(l.tempDir); err == nil {
+			err = rmErr
+		}
+		l.tempDir = ""
+	}
 	return
 }
 
 func (l *Ledger2) loadDatabase() {
-	dataDir := filepath.Dir(l.databasePath)
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		// log.Println("Data folder already exists.")
+	alreadyExists := false
+	if l.databasePath != MemoryLedger {
+		dataDir := filepath.Dir(l.databasePath)
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			// log.Println("Data folder already exists.")
+		}
+		// first check if ledger db already exists
+		alreadyExists = exists(l.databasePath)
 	}
-	// first check if ledger db already exists
-	alreadyExists := exists(l.databasePath)
 
 	// open the database
 	db, err := sql.Open("sqlite3", l.databasePath)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if l.databasePath == MemoryLedger {
+		// Every connection to ":memory:" gets a database of its own, so only one may be opened.
+		db.SetMaxOpenConns(1)
+	}
 	if !alreadyExists {
 		// We are just creating a new ledger
-		_, err = db.Exec(databaseInit)
+		for _, init := range append([]string{databaseInit, orderBookTableInit, orderRoundingTableInit, dustTableInit, withdrawalTableInit}, analyzerTablesInit...) {
+			if _, err = db.Exec(init); err != nil {
+				log.Fatal("Could not initialize ledger database", err)
+			}
+		}
+		_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(ledgerMigrations)))
 		if err != nil {
 			log.Fatal("Could not initialize ledger database", err)
 		}
+	} else if err = migrateLedger(db); err != nil {
+		log.Fatal("Could not upgrade ledger database", err)
 	}
 	l.db = db
 	l.isOpen = true
@@ -255,7 +474,7 @@ func (l *Ledger2) loadDatabase() {
 type OrderEntry struct {
 	AssetName string
 	OrderID   string
-	Timestamp string
+	Timestamp string // RFC3339, see formatTimestamp
 	Price     float64
 	Volume    float64
 }
@@ -274,24 +493,17 @@ type EntryStats struct {
 	AllTimeProfit         string
 }
 
-// RecordStack holds a FIFO stack of at most `maxRecordsToSave` `Entry` elements.
+// EntryStack holds a FIFO stack of at most `maxRecordsToSave` `Entry` elements.
 type EntryStack struct {
-	records []Entry
+	records *ringbuffer.Buffer[Entry]
 }
 
 var maxRecordsToSave int = 100
 
-// appendRecord appends a value of type T to a FIFO stack (actually a slice),
-//
-//	with a max capacity of `maxRecordsToSave`
-//
-// If the stacked is filled, it's first value is popped. Note, the slice
-// shouldn't be created with make(), but initialized like so: stack := []T
+// appendRecord appends a record to the stack. If the stack is full, its oldest record is dropped.
 func (st *EntryStack) appendRecord(rec Entry) {
-	lnt := len(st.records)
-	if lnt >= maxRecordsToSave {
-		x := lnt - maxRecordsToSave
-		st.records = st.records[x+1 : lnt] // pop the first `x` elements
+	if st.records == nil {
+		st.records = ringbuffer.New[Entry](maxRecordsToSave)
 	}
-	st.records = append(st.records, rec)
+	st.records.Push(rec)
 }
@@ -0,0 +1,138 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+)
+
+// ErrWatchOnly is returned when an order is requested from a session that only watches the markets.
+var ErrWatchOnly = errors.New("the session is in watch-only mode and can't place orders")
+
+// LiquidationReport describes how the open positions of an asset were closed by Liquidate.
+type LiquidationReport struct {
+	Asset          string
+	ReferencePrice float64 // Price when the liquidation started, which slippage is measured from.
+	Closed         int     // Positions closed.
+	Proceeds       float64 // Fiat received for closed long positions, less the fiat spent closing short positions.
+	// Skipped are the positions left open, because the price slipped more than MaxSlippage or an order failed.
+	Skipped int
+	Errors  []string `json:",omitempty"`
+}
+
+// Liquidate closes every open position of `asset` at market, or of all assets if `asset` is empty. Positions are
+// closed one at a time and the price is checked before each, so if it slips more than `maxSlippage` (a fraction)
+// from where it was when the liquidation started, the remaining positions of the asset are left open rather than
+// sold into a collapsing market. A `maxSlippage` of zero or less closes every position whatever the price.
+func (s *Session) Liquidate(asset string, maxSlippage float64) (reports []LiquidationReport, err error) {
+	pf := s.portfolio
+	if pf.watchOnly {
+		return nil, ErrWatchOnly
+	}
+	assets, err := pf.selectAssets(asset)
+	if err != nil {
+		return
+	}
+	for _, name := range assets {
+		report, err := pf.liquidate(name, maxSlippage)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// liquidate closes the open positions of one asset. See Session.Liquidate.
+func (pf *Portfolio) liquidate(asset string, maxSlippage float64) (report LiquidationReport, err error) {
+	report.Asset = asset
+	handler := pf.assets[asset]
+	positions, err := pf.ledger.OpenPositions(pf.ctx, asset)
+	if err != nil || len(positions) == 0 {
+		return
+	}
+	if report.ReferencePrice, err = handler.CurrentPrice(); err != nil {
+		return
+	}
+	for i := range positions {
+		position := &positions[i]
+		price, err := handler.CurrentPrice()
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			report.Skipped += len(positions) - i
+			break
+		}
+		if slip := math.Abs(price-report.ReferencePrice) / report.ReferencePrice; maxSlippage > 0 && slip > maxSlippage {
+			log.Printf("Stopped liquidating %s: the price has slipped %.2f%% from %s to %s", asset, slip*100,
+				pf.formatPrice(asset, report.ReferencePrice), pf.formatPrice(asset, price))
+			report.Skipped += len(positions) - i
+			break
+		}
+		stop, orderType, err := pf.exit(asset, position, exitLiquidation)
+		if errors.Is(err, errExitClaimed) {
+			continue
+		}
+		if err != nil {
+			pf.errs.report(asset, "liquidate position", err)
+			report.Errors = append(report.Errors, err.Error())
+			report.Skipped++
+			continue
+		}
+		if orderType == CloseLongTrade {
+			report.Proceeds += stop.Price * stop.Volume
+		} else {
+			report.Proceeds -= stop.Price * stop.Volume
+		}
+		report.Closed++
+	}
+	pf.events.record("liquidation", asset, fmt.Sprintf("Liquidated %d position(s), %d left open", report.Closed, report.Skipped))
+	return report, nil
+}
+
+// RunLiquidate implements the `liquidate` command, e.g. `liquidate --asset XRP`, which closes the open positions
+// of an asset (or of all assets) at market and prints what was done to `out`.
+func RunLiquidate(ctx context.Context, args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("liquidate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	asset := flags.String("asset", "", "Asset whose positions are closed, e.g. XRP. Defaults to all assets.")
+	maxSlippage := flags.Float64("max-slippage", -1, "Largest price move (as a fraction) to keep liquidating through. Defaults to the MaxSlippage setting.")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	c := &Configuration{}
+	if err = c.TestConfig(""); err != nil {
+		return
+	}
+	s := NewSession(ctx, c)
+	defer s.Stop()
+	if err = s.Initialize(); err != nil {
+		return
+	}
+	defer s.portfolio.closeOrderBooks()
+	defer s.ledger.Close()
+	if *maxSlippage < 0 {
+		*maxSlippage = s.config.MaxSlippage
+	}
+	reports, err := s.Liquidate(*asset, *maxSlippage)
+	for _, report := range reports {
+		fmt.Fprintln(out, s.messages.Sprintf(MsgLiquidated, report.Asset, report.Closed,
+			s.messages.FormatMoney(report.Proceeds, s.config.CurrencyCode)))
+		if report.Skipped > 0 {
+			reason := strings.Join(report.Errors, "; ")
+			if reason == "" {
+				reason = s.messages.Sprintf(MsgSlippageExceeded, *maxSlippage*100)
+			}
+			fmt.Fprintln(out, s.messages.Sprintf(MsgLiquidationSkipped, report.Skipped, reason))
+		}
+	}
+	return
+}
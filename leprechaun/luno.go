@@ -5,18 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
-	"github.com/gonum/stat"
 
 	luno "github.com/luno/luno-go"
 	luno_decimal "github.com/luno/luno-go/decimal"
 )
 
+// candleDuration is the default period of the candles retrieved by PreviousTrades.
+const candleDuration = 8 * time.Hour
+
+// Bounds of the backoff between the polls of WaitForOrder.
+const (
+	minOrderPoll = time.Second
+	maxOrderPoll = 15 * time.Second
+)
+
 var (
-	timeFormat = "15:08:14"
+	// ErrOrderPending is returned by GetOrderDetails and WaitForOrder when an order hasn't completed yet.
+	ErrOrderPending = errors.New("order is still pending")
+	// ErrOrderCancelled is returned by WaitForOrder when an order completed without anything being traded.
+	ErrOrderCancelled = errors.New("order was cancelled without being filled")
 )
 
+// lunoCandleIntervals are the candle durations supported by the luno API.
+var lunoCandleIntervals = []time.Duration{time.Minute, 5 * time.Minute, M15, M30, H1, H3, H4, 8 * time.Hour, H24, H72, 7 * H24}
+
 // LunoExchangeHandler
 type LunoExchangeHandler struct {
 	asset          *Asset
@@ -27,16 +42,28 @@ type LunoExchangeHandler struct {
 	sessionBalance float64
 	currency       string
 	spread         float64
+	takerFee       float64
+	feesLoaded     bool
 	retries        int64
 	signalChan     chan SIGNAL
 	debugChan      chan string
+	clock          Clock
+	tickFilter     *TickFilter
+	budget         *RateBudget
+	messages       *Localizer
+	progress       ProgressReporter // told how far downloads of historical candles have got
 	ctx            context.Context
 }
 
-func NewLunoExchangeHandler(client *luno.Client, asset *Asset, ctx context.Context) *LunoExchangeHandler {
+func NewLunoExchangeHandler(client *luno.Client, asset *Asset, clock Clock, ctx context.Context) *LunoExchangeHandler {
 	return &LunoExchangeHandler{
 		asset:      asset,
 		client:     client,
+		clock:      clock,
+		tickFilter: NewTickFilter(asset.name, 0),
+		budget:     NewRateBudget(defaultRequestsPerMinute),
+		messages:   NewLocalizer(defaultLanguage),
+		currency:   asset.currency,
 		signalChan: make(chan SIGNAL),
 		debugChan:  make(chan string),
 		ctx:        ctx}
@@ -49,7 +76,7 @@ func (handler *LunoExchangeHandler) String() string {
 func (handler *LunoExchangeHandler) handle429(e error) (retry bool) {
 	if e.Error() == "luno: too many requests" {
 		retry = true
-		time.Sleep(1 * time.Second) // wait a bit
+		<-handler.clock.After(1 * time.Second) // wait a bit
 	}
 	return
 }
@@ -68,43 +95,63 @@ func (handler *LunoExchangeHandler) profitAndLoss(open, close *Entry) {
 
 }
 
+// accountIDs returns the IDs of the asset and fiat wallets orders are placed from, as the API takes them.
+func (handler *LunoExchangeHandler) accountIDs() (base, counter int64, err error) {
+	if base, err = handler.asset.accountID.Int64(); err != nil {
+		return
+	}
+	counter, err = handler.asset.fiatAccountID.Int64()
+	return
+}
+
 // bid places an order to buys a specified amount of an asset on the exchange
 // It executes immediately.
-func (handler *LunoExchangeHandler) bid(price float64, volume float64) (orderID string, err error) {
-	sleep() // Error 429 safety
+func (handler *LunoExchangeHandler) bid(price float64, volume float64, class RequestClass) (orderID string, err error) {
+	if err = handler.budget.Acquire(handler.ctx, class); err != nil {
+		return
+	}
 	cost := price * volume
-	handler.debugf("Placing bid order for NGN %.2f worth of %s (approx. %.2f %s) on the exchange...\n", cost, handler.asset.name, volume, handler.asset.code)
+	base, counter, err := handler.accountIDs()
+	if err != nil {
+		return
+	}
+	handler.debug(handler.messages.Sprintf(MsgPlacingBid, handler.messages.FormatMoney(cost, handler.asset.currency), handler.asset.name,
+		handler.messages.FormatAmount(volume, handler.asset.code)))
 	//Place bid order on the exchange
 	req := luno.PostMarketOrderRequest{Pair: handler.asset.Pair, Type: luno.OrderTypeBuy,
-		BaseAccountId: stringToInt(handler.asset.accountID), CounterAccountId: stringToInt(handler.asset.fiatAccountID),
-		CounterVolume: decimal(cost)}
+		BaseAccountId: base, CounterAccountId: counter, CounterVolume: decimal(cost)}
 	res, err := handler.client.PostMarketOrder(handler.ctx, &req)
 	if err != nil {
 		return
 	}
 	orderID = res.OrderId
-	handler.debugf("Bid order for %.4f %s has been placed on the exchange.\n", volume, handler.asset.name)
+	handler.debug(handler.messages.Sprintf(MsgBidPlaced, handler.messages.FormatAmount(volume, handler.asset.code)))
 	return
 }
 
 // ask places a bid order on the excahnge to sell `volume` worth of Client.asset in exhange for fiat currency.
-func (handler *LunoExchangeHandler) ask(price, volume float64) (orderID string, err error) {
-	sleep() // Error 429 safety
+func (handler *LunoExchangeHandler) ask(price, volume float64, class RequestClass) (orderID string, err error) {
+	if err = handler.budget.Acquire(handler.ctx, class); err != nil {
+		return
+	}
 	cost := price * volume
+	base, counter, err := handler.accountIDs()
+	if err != nil {
+		return
+	}
 	//Place ask order on the exchange
-	log.Printf("Placing ask order for ~NGN %.2f worth of %s on the exchange...\n", cost, handler.asset.name)
-	log.Printf("Current price is %4f\n", price)
-	log.Printf("Order Volume: %v", volume)
+	log.Println(handler.messages.Sprintf(MsgPlacingAsk, handler.messages.FormatMoney(cost, handler.asset.currency), handler.asset.name))
+	log.Printf("Current price is %s", handler.messages.FormatMoney(price, handler.asset.currency))
+	log.Printf("Order Volume: %s", handler.messages.FormatAmount(volume, handler.asset.code))
 	req := luno.PostMarketOrderRequest{Pair: handler.asset.Pair, Type: luno.OrderTypeSell,
-		BaseAccountId: stringToInt(handler.asset.accountID), BaseVolume: decimal(volume),
-		CounterAccountId: stringToInt(handler.asset.fiatAccountID)}
+		BaseAccountId: base, BaseVolume: decimal(volume), CounterAccountId: counter}
 	res, err := handler.client.PostMarketOrder(handler.ctx, &req)
 	if err != nil {
 		log.Printf("(in `Client.ask`) %v", err.Error())
 		return
 	}
 	orderID = res.OrderId
-	log.Printf("Ask order for %.4f %s has been placed on the exchange.\n", volume, handler.asset.code)
+	log.Printf("Ask order for %s has been placed on the exchange.", handler.messages.FormatAmount(volume, handler.asset.code))
 	return
 }
 
@@ -112,13 +159,13 @@ func (handler *LunoExchangeHandler) ask(price, volume float64) (orderID string,
 // later be sold at a higher price to realize a profit.
 func (handler *LunoExchangeHandler) GoLong(volume float64) (longOrder *OrderEntry, err error) {
 	// goLong
-	price, err := handler.CurrentPrice()
+	price, err := handler.currentPrice(ClassOrder)
 	if err != nil {
 		return nil, err
 	}
-	ts := time.Now().Format(timeFormat)
+	ts := formatTimestamp(handler.clock.Now())
 	// Place market bid order.
-	purchaseOrderID, err := handler.bid(price, volume)
+	purchaseOrderID, err := handler.bid(price, volume, ClassOrder)
 	if err != nil {
 		log.Printf("An error occured while going long!")
 		return nil, err
@@ -127,17 +174,17 @@ func (handler *LunoExchangeHandler) GoLong(volume float64) (longOrder *OrderEntr
 	handler.debug("New Long Trade Initiated. Order ID:", purchaseOrderID)
 	handler.sessionVolume += volume
 
-	return &OrderEntry{handler.asset.code, purchaseOrderID, ts, price, volume}, nil
+	return &OrderEntry{handler.asset.name, purchaseOrderID, ts, price, volume}, nil
 }
 
 // Stop Long closes a long order
 func (handler *LunoExchangeHandler) StopLong(entry *Entry) (longOrder *StopOrderEntry, err error) {
-	price, err := handler.CurrentPrice()
+	price, err := handler.currentPrice(ClassStop)
 	if err != nil {
 		return nil, err
 	}
-	ts := time.Now().Format(timeFormat)
-	saleOrderID, err := handler.ask(price, entry.PurchaseVolume)
+	ts := formatTimestamp(handler.clock.Now())
+	saleOrderID, err := handler.ask(price, entry.PurchaseVolume, ClassStop)
 	if err != nil {
 		log.Printf("An error occured while executing a stop long order! Reason: %s", err.Error())
 		if strings.Contains(err.Error(), "ErrInsufficientBalance") {
@@ -159,13 +206,13 @@ func (handler *LunoExchangeHandler) StopLong(entry *Entry) (longOrder *StopOrder
 // TODO: Make short-selling an  option
 func (handler *LunoExchangeHandler) GoShort(volume float64) (shortOrder *OrderEntry, err error) {
 	// goShort
-	price, err := handler.CurrentPrice()
+	price, err := handler.currentPrice(ClassOrder)
 	if err != nil {
 		log.Println("Could not retrieve price info from the exchange. (in `Client.GoShort`)")
 		return nil, err
 	}
-	ts := time.Now().Format(timeFormat)
-	saleOrderID, err := handler.ask(price, volume)
+	ts := formatTimestamp(handler.clock.Now())
+	saleOrderID, err := handler.ask(price, volume, ClassOrder)
 	if err != nil {
 		log.Printf("An error occured while executing a short order! Reason: %s", err.Error())
 		if strings.Contains(err.Error(), "ErrInsufficientBalance") {
@@ -181,13 +228,13 @@ func (handler *LunoExchangeHandler) GoShort(volume float64) (shortOrder *OrderEn
 }
 
 func (handler *LunoExchangeHandler) StopShort(entry *Entry) (*StopOrderEntry, error) {
-	price, err := handler.CurrentPrice()
+	price, err := handler.currentPrice(ClassStop)
 	if err != nil {
 		return nil, err
 	}
-	ts := time.Now().Format(timeFormat)
+	ts := formatTimestamp(handler.clock.Now())
 	// Place market bid order.
-	purchaseOrderID, err := handler.bid(price, entry.SaleVolume)
+	purchaseOrderID, err := handler.bid(price, entry.SaleVolume, ClassStop)
 	if err != nil {
 		log.Printf("An error occured (handler.StopShort)")
 		return nil, err
@@ -196,12 +243,14 @@ func (handler *LunoExchangeHandler) StopShort(entry *Entry) (*StopOrderEntry, er
 	handler.debug("Order ID:", purchaseOrderID)
 	handler.sessionVolume += entry.SaleVolume
 
-	return &StopOrderEntry{OrderEntry{handler.asset.name, purchaseOrderID, ts, entry.SaleVolume, price}}, nil
+	return &StopOrderEntry{OrderEntry{handler.asset.name, purchaseOrderID, ts, price, entry.SaleVolume}}, nil
 }
 
 // CheckOrder tries to confirm if an order is still pending or not
 func (handler *LunoExchangeHandler) GetOrderDetails(orderID string) (orderDetails *luno.GetOrderResponse, err error) {
-	sleep() // Error 429 safety
+	if err = handler.budget.Acquire(handler.ctx, ClassStop); err != nil {
+		return
+	}
 	req := luno.GetOrderRequest{Id: orderID}
 	orderDetails, err = handler.client.GetOrder(handler.ctx, &req)
 	if err != nil {
@@ -209,22 +258,58 @@ func (handler *LunoExchangeHandler) GetOrderDetails(orderID string) (orderDetail
 		return orderDetails, err
 	}
 	if orderDetails.State == luno.OrderStatePending {
-		return &luno.GetOrderResponse{}, errors.New("Order is still pending")
+		return &luno.GetOrderResponse{}, ErrOrderPending
 	}
 	return
 }
 
+// WaitForOrder polls an order with an exponential backoff until it completes or `timeout` expires.
+// Luno marks cancelled orders as complete too, so an order that completes without a fill returns ErrOrderCancelled.
+func (handler *LunoExchangeHandler) WaitForOrder(orderID string, timeout time.Duration) (orderDetails *luno.GetOrderResponse, err error) {
+	deadline := handler.clock.Now().Add(timeout)
+	for wait := minOrderPoll; ; wait *= 2 {
+		orderDetails, err = handler.GetOrderDetails(orderID)
+		if err == nil {
+			if orderDetails.Base.Sign() == 0 {
+				err = ErrOrderCancelled
+			}
+			return
+		}
+		if !errors.Is(err, ErrOrderPending) {
+			return
+		}
+		left := deadline.Sub(handler.clock.Now())
+		if left <= 0 {
+			return
+		}
+		if wait > maxOrderPoll {
+			wait = maxOrderPoll
+		}
+		if wait > left {
+			wait = left
+		}
+		select {
+		case <-handler.ctx.Done():
+			return orderDetails, handler.ctx.Err()
+		case <-handler.clock.After(wait):
+		}
+	}
+}
+
 // ConfirmOrder checks if an order placed on the exchange has been executed
 func (handler *LunoExchangeHandler) ConfirmOrder(rec *Entry) (done bool, err error) {
 	// Make this method a goroutine
 	if rec.Status == 0 {
-		sleep() // Error 429 safety
+		if err = handler.budget.Acquire(handler.ctx, ClassStop); err != nil {
+			return
+		}
 		req := luno.GetOrderRequest{Id: rec.SaleID}
 		res, err := handler.client.GetOrder(handler.ctx, &req)
 		if err != nil {
 			handler.debug("Error! Could not confirm order: ", rec.SaleID)
 			handler.debug("Please check your network connectivity")
 			handler.debug(err.Error())
+			return false, err
 		}
 		if res.State == luno.OrderStateComplete {
 			rec.Status = 1
@@ -236,40 +321,65 @@ func (handler *LunoExchangeHandler) ConfirmOrder(rec *Entry) (done bool, err err
 	return
 }
 
+// GetBalance retrieves the balance of `asset` from the exchange. The balance of the fiat
+// currency the asset is traded against is refreshed in the same request.
 func (handler *LunoExchangeHandler) GetBalance(asset *Asset) (balance float64, err error) {
-	sleep() // Error 429 safety
-	assetBalanceReq := luno.GetBalancesRequest{Assets: []string{asset.Pair}}
-	assetBalance, err := handler.client.GetBalances(handler.ctx, &assetBalanceReq)
+	if err = handler.budget.Acquire(handler.ctx, ClassReporting); err != nil {
+		return
+	}
+	req := luno.GetBalancesRequest{Assets: []string{asset.code, asset.currency}}
+	res, err := handler.client.GetBalances(handler.ctx, &req)
 	if err != nil {
 		return balance, err
 	}
-	if assetBalance != nil && len(assetBalance.Balance) > 0 {
-		for _, astBal := range assetBalance.Balance {
-			if astBal.Asset == handler.asset.name {
-				handler.asset.accountID = astBal.AccountId
-				asset.assetBalance = astBal.Balance.Float64()
-			}
-			if astBal.Asset == handler.currency {
-				handler.asset.fiatAccountID = astBal.AccountId
-				handler.asset.fiatBalance = astBal.Balance.Float64()
-			}
+	found := false
+	for _, bal := range res.Balance {
+		switch bal.Asset {
+		case asset.code:
+			asset.accountID = AccountID(bal.AccountId)
+			asset.assetBalance = bal.Balance.Float64()
+			balance, found = asset.assetBalance, true
+		case asset.currency:
+			asset.fiatAccountID = AccountID(bal.AccountId)
+			asset.fiatBalance = bal.Balance.Float64()
 		}
 	}
-	log.Printf("%#v \n", assetBalance)
-	handler.debug(handler.asset.fiatBalance)
-	err = nil
+	if !found {
+		return 0, fmt.Errorf("could not find a %s wallet on the exchange", asset.code)
+	}
 	return
 }
 
+// FiatBalance retrieves the balance of the fiat currency the handler's asset is traded against.
+func (handler *LunoExchangeHandler) FiatBalance() (balance float64, err error) {
+	if err = handler.budget.Acquire(handler.ctx, ClassReporting); err != nil {
+		return
+	}
+	req := luno.GetBalancesRequest{Assets: []string{handler.currency}}
+	res, err := handler.client.GetBalances(handler.ctx, &req)
+	if err != nil {
+		return balance, err
+	}
+	for _, bal := range res.Balance {
+		if bal.Asset == handler.currency {
+			handler.asset.fiatAccountID = AccountID(bal.AccountId)
+			handler.asset.fiatBalance = bal.Balance.Float64()
+			return handler.asset.fiatBalance, nil
+		}
+	}
+	return 0, fmt.Errorf("could not find a %s wallet on the exchange", handler.currency)
+}
+
 // CheckBalanceSufficiency determines whether the client has purchasing power
-func (handler *LunoExchangeHandler) CheckBalanceSufficiency(asset *Asset) (canPurchase bool, err error) {
+func (handler *LunoExchangeHandler) CheckBalanceSufficiency(asset *Asset, purchaseUnit float64) (canPurchase bool, err error) {
 	// Luno charges a 1% taker fee
-	purchaseUnit := globalConfig.AdjustedPurchaseUnit
 	if handler.asset.fiatBalance <= 0.0 {
-		handler.GetBalance(asset)
+		if _, err = handler.FiatBalance(); err != nil {
+			return false, err
+		}
 	}
 	if handler.asset.fiatBalance < purchaseUnit {
-		// `AdjustedPurchaseUnit` is more than available balance (NGN)
+		// The purchase unit is more than the available balance (NGN)
 		canPurchase = false
 	} else {
 		canPurchase = true
@@ -277,11 +387,29 @@ func (handler *LunoExchangeHandler) CheckBalanceSufficiency(asset *Asset) (canPu
 	return
 }
 
+// PendingOrders returns the IDs of the handler's orders that haven't been filled yet.
+func (handler *LunoExchangeHandler) PendingOrders(ctx context.Context) (orderIDs []string, err error) {
+	if err = handler.budget.Acquire(ctx, ClassReporting); err != nil {
+		return
+	}
+	req := luno.ListOrdersRequest{Pair: handler.asset.Pair, State: luno.OrderStatePending}
+	res, err := handler.client.ListOrders(ctx, &req)
+	if err != nil {
+		return
+	}
+	for _, order := range res.Orders {
+		orderIDs = append(orderIDs, order.OrderId)
+	}
+	return
+}
+
 // StopPendingOrder tries to remove a pending order from the order book
-func (handler *LunoExchangeHandler) StopPendingOrder(orderID string) (ok bool) {
-	sleep() // Error 429 safety
+func (handler *LunoExchangeHandler) StopPendingOrder(ctx context.Context, orderID string) (ok bool) {
+	if err := handler.budget.Acquire(ctx, ClassStop); err != nil {
+		return false
+	}
 	req := luno.StopOrderRequest{OrderId: orderID}
-	res, err := handler.client.StopOrder(handler.ctx, &req)
+	res, err := handler.client.StopOrder(ctx, &req)
 	if err != nil {
 		handler.debug(err)
 		return false
@@ -292,9 +420,86 @@ func (handler *LunoExchangeHandler) StopPendingOrder(orderID string) (ok bool) {
 	return
 }
 
+// MarketStatus asks the exchange whether the handler's market is fully trading. Markets are suspended or
+// limited to post-only orders during maintenance and periods of extreme volatility.
+func (handler *LunoExchangeHandler) MarketStatus() (active bool, err error) {
+	if err = handler.budget.Acquire(handler.ctx, ClassReporting); err != nil {
+		return
+	}
+	req := luno.MarketsRequest{Pair: []string{handler.asset.Pair}}
+	res, err := handler.client.Markets(handler.ctx, &req)
+	if err != nil {
+		return
+	}
+	for _, market := range res.Markets {
+		if market.MarketId == handler.asset.Pair {
+			return market.TradingStatus == luno.TradingStatusActive, nil
+		}
+	}
+	return false, fmt.Errorf("the exchange doesn't list the %s market", handler.asset.Pair)
+}
+
+// Pairs lists the markets of the exchange. The fees are those of the API key's account.
+func (handler *LunoExchangeHandler) Pairs(ctx context.Context) (pairs []PairInfo, err error) {
+	if err = handler.budget.Acquire(ctx, ClassReporting); err != nil {
+		return
+	}
+	res, err := handler.client.Markets(ctx, &luno.MarketsRequest{})
+	if err != nil {
+		return
+	}
+	for _, market := range res.Markets {
+		pair := PairInfo{Pair: market.MarketId, Base: market.BaseCurrency, Counter: market.CounterCurrency,
+			MinVolume: market.MinVolume.Float64(), MaxVolume: market.MaxVolume.Float64(),
+			PriceScale: int(market.PriceScale), VolumeScale: int(market.VolumeScale),
+			Active: market.TradingStatus == luno.TradingStatusActive}
+		if err = handler.budget.Acquire(ctx, ClassReporting); err != nil {
+			return
+		}
+		fees, err := handler.client.GetFeeInfo(ctx, &luno.GetFeeInfoRequest{Pair: market.MarketId})
+		if err == nil {
+			pair.MakerFee, _ = strconv.ParseFloat(fees.MakerFee, 64)
+			pair.TakerFee, _ = strconv.ParseFloat(fees.TakerFee, 64)
+		} else {
+			handler.debugf("Could not get the %s fees: %v", market.MarketId, err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// probeOrderID is an order ID that can't exist on the exchange. See CanTrade.
+const probeOrderID = "LEPRECHAUN-PERMISSION-CHECK"
+
+// CanTrade checks whether the API key has trading permission by asking the exchange to cancel an order that doesn't
+// exist. A key that may trade gets a "not found" error, a read-only key gets a permission error. Nothing is traded.
+func (handler *LunoExchangeHandler) CanTrade() (canTrade bool, err error) {
+	if err = handler.budget.Acquire(handler.ctx, ClassReporting); err != nil {
+		return
+	}
+	_, err = handler.client.StopOrder(handler.ctx, &luno.StopOrderRequest{OrderId: probeOrderID})
+	var apiErr luno.Error
+	if err == nil || !errors.As(err, &apiErr) {
+		return err == nil, err
+	}
+	code := strings.ToLower(apiErr.Code)
+	if strings.Contains(code, "perm") || strings.Contains(code, "scope") || strings.Contains(code, "unauthorised") {
+		return false, nil
+	}
+	// Any other API error (usually ErrOrderNotFound) means the request was authorized.
+	return true, nil
+}
+
 // CurrentPrice retrieves the ask price for the client's asset.
 func (handler *LunoExchangeHandler) CurrentPrice() (price float64, err error) {
-	sleep() // Error 429 safety
+	return handler.currentPrice(ClassPricePolling)
+}
+
+// currentPrice retrieves the ask price, counting the request against the rate limit budget of `class`.
+func (handler *LunoExchangeHandler) currentPrice(class RequestClass) (price float64, err error) {
+	if err = handler.budget.Acquire(handler.ctx, class); err != nil {
+		return
+	}
 	// TODO: UPDATE PRICE AUTOMATICALLY EVERY 180 SECS and return that value to any callers until the next update.
 	// No need to connect everytime
 	req := luno.GetTickerRequest{Pair: handler.asset.Pair}
@@ -302,73 +507,97 @@ func (handler *LunoExchangeHandler) CurrentPrice() (price float64, err error) {
 	if err != nil {
 		return
 	}
-	price = res.Ask.Float64()
+	price, _ = handler.tickFilter.Filter(res.Ask.Float64(), time.Time(res.Timestamp))
 	handler.spread = res.Ask.Float64() - res.Bid.Float64()
 	return
 }
 
-type mDate struct {
-	day   int
-	month time.Month
-	year  int
-	str   string
-}
-
-func (d *mDate) newDate(year int, month time.Month, day int) string {
-	return fmt.Sprintf("%v-%d-%v", year, month, day)
-}
-
-type Hour4Trades struct {
-	start, end time.Time
-	candles    []luno.Candle
+// ServerTime returns the exchange's time, as reported by the timestamp of the ticker.
+func (handler *LunoExchangeHandler) ServerTime() (serverTime time.Time, err error) {
+	if err = handler.budget.Acquire(handler.ctx, ClassReporting); err != nil {
+		return
+	}
+	req := luno.GetTickerRequest{Pair: handler.asset.Pair}
+	res, err := handler.client.GetTicker(handler.ctx, &req)
+	if err != nil {
+		return
+	}
+	return time.Time(res.Timestamp), nil
 }
 
-// PreviousTrades retreives past trades/prices from the exchange. Trades are grouped at specified intervals.
-// It is targeted for use in a candlestick chart. It is important to note that the data is
-// returned in reverse form. i.e. The most recent price is last in the list and the earliest is first.
-func (handler *LunoExchangeHandler) PreviousTrades(numDays int64) (data map[luno.Time][]luno.Candle, err error) {
-	now := time.Now()
-	// numDays = 3
-	midnight := toMidnight(now)
-	seconds := 28800 // 8 hours
-	var D = mDate{}
-	var dates = map[luno.Time]string{}
-	var startTimes = []luno.Time{}
-	var dailyTrades = map[luno.Time][]luno.Candle{}
-	var Trades = map[int64][]map[luno.Time][]luno.Candle{}
-
-	for h := 0.0; h <= float64(8*numDays); h += 8 {
-		t := luno.Time(midnight.Add(time.Duration(-h) * time.Hour))
-		startTimes = append(startTimes, t)
-		dailyTrades[t] = []luno.Candle{}
-		if dates[t] == "" {
-			dates[t] = D.newDate(time.Time(t).Date())
+// ExpectedEntryPrice estimates the price a market order opening a position of `kind` would execute at
+// right now. Long positions are bought at the ask and short positions are sold at the bid, and in both
+// cases the taker fee makes the effective price worse.
+func (handler *LunoExchangeHandler) ExpectedEntryPrice(kind SIGNAL) (price float64, err error) {
+	if !handler.feesLoaded {
+		info, err := handler.FeeInfo()
+		if err != nil {
+			return 0, err
+		}
+		if handler.takerFee, err = strconv.ParseFloat(info.TakerFee, 64); err != nil {
+			return 0, err
 		}
+		handler.feesLoaded = true
+	}
+	ask, err := handler.CurrentPrice()
+	if err != nil {
+		return
+	}
+	switch kind {
+	case SignalLong:
+		price = ask * (1 + handler.takerFee)
+	case SignalShort:
+		bid := ask - handler.spread
+		price = bid * (1 - handler.takerFee)
+	default:
+		err = fmt.Errorf("cannot estimate an entry price for signal %v", kind)
 	}
-	for i := int64(0); i < numDays; i++ {
-		Trades[i] = []map[luno.Time][]luno.Candle{}
+	return
+}
+
+// PreviousTrades retreives past trades/prices from the exchange as candles of `interval`, oldest first.
+// They are fetched in 8 hour windows, one request per window, and windows that overlap are merged.
+func (handler *LunoExchangeHandler) PreviousTrades(numDays int64, interval time.Duration) (candles []Candle, err error) {
+	midnight := toMidnight(handler.clock.Now())
+	seconds := int64(interval / time.Second)
+	var startTimes []time.Time
+	// The earliest window should be first in the list and the latest should come last.
+	for h := float64(8 * numDays); h >= 0; h -= 8 {
+		startTimes = append(startTimes, midnight.Add(time.Duration(-h)*time.Hour))
 	}
-	// Reverse the order of the timestamps. The earliest should be first in the list and the latest should come last.
-	reverseTimestamps(startTimes)
-	log.Println("STARTTIMES", startTimes)
-	// log.Println("DAILYTRADES", dailyTrades)
-	// log.Println("DATES", dates)
 	// Retrieve past trades from the exchange.
+	progress := trackProgress(handler.progress, handler.clock, "Downloading "+handler.asset.Pair+" candles", len(startTimes))
+	defer progress.finish()
 	for _, start := range startTimes {
-		sleep2()
-		req := luno.GetCandlesRequest{Pair: handler.asset.Pair, Since: start, Duration: int64(seconds)}
+		progress.step("since " + start.Format("2006-01-02 15:04"))
+		if err = handler.budget.Acquire(handler.ctx, ClassPricePolling); err != nil {
+			return nil, err
+		}
+		req := luno.GetCandlesRequest{Pair: handler.asset.Pair, Since: luno.Time(start), Duration: seconds}
 		res, err := handler.client.GetCandles(handler.ctx, &req)
 		if err != nil {
-			log.Fatal(handler.asset.Pair, err)
+			return nil, err
+		}
+		for _, candle := range candlesFromLuno(res.Candles) {
+			if len(candles) == 0 || candle.Time.After(candles[len(candles)-1].Time) {
+				candles = append(candles, candle)
+			}
 		}
-		dailyTrades[start] = append(dailyTrades[start], res.Candles...)
+		progress.done()
 	}
-	return dailyTrades, nil
+	return candles, nil
+}
+
+// SupportedIntervals returns the candle durations supported by luno.
+func (handler *LunoExchangeHandler) SupportedIntervals() []time.Duration {
+	return lunoCandleIntervals
 }
 
 // FeeInfo retrieves taker/maker fee information for this client
 func (handler *LunoExchangeHandler) FeeInfo() (info luno.GetFeeInfoResponse, err error) {
-	sleep() // Error 429 safety
+	if err = handler.budget.Acquire(handler.ctx, ClassReporting); err != nil {
+		return
+	}
 	req := luno.GetFeeInfoRequest{Pair: handler.asset.Pair}
 	res, err := handler.client.GetFeeInfo(handler.ctx, &req)
 	if err != nil {
@@ -380,11 +609,15 @@ func (handler *LunoExchangeHandler) FeeInfo() (info luno.GetFeeInfoResponse, err
 
 // TopOrders retrieves the top ask and bid orders on the exchange
 func (handler *LunoExchangeHandler) TopOrders() (orders map[string]luno.OrderBookEntry) {
-	sleep() // Error 429 safety
+	orders = map[string]luno.OrderBookEntry{}
+	if err := handler.budget.Acquire(handler.ctx, ClassPricePolling); err != nil {
+		return
+	}
 	req := luno.GetOrderBookRequest{Pair: handler.asset.Pair}
 	orderBook, err := handler.client.GetOrderBook(handler.ctx, &req)
-	if err != nil {
+	if err != nil || len(orderBook.Asks) == 0 || len(orderBook.Bids) == 0 {
 		handler.debug(err)
+		return
 	}
 	topAsk := orderBook.Asks[0]
 	topBid := orderBook.Bids[0]
@@ -393,37 +626,20 @@ func (handler *LunoExchangeHandler) TopOrders() (orders map[string]luno.OrderBoo
 	return
 }
 
-// PendingOrders retrieves unexecuted orders still in the order book.
-func (handler *LunoExchangeHandler) PendingOrders() (pendingOrders interface{}) {
-	sleep() // Error 429 safety
-	accID := stringToInt(handler.asset.fiatAccountID)
-	req := luno.ListPendingTransactionsRequest{Id: accID}
-	res, err := handler.client.ListPendingTransactions(handler.ctx, &req)
-	if err != nil {
-		handler.debug(err)
-	}
-	pending := res.Pending
-	numPending := len(pending)
-	if numPending == 0 {
-		handler.debug("There are no pending transactions associated with", handler)
-		pendingOrders = []string{}
-	}
-	handler.debug("There are", numPending, "transactions associated with", handler)
-
-	pendingOrders = pending
-	return
-}
-
 // Decimal converts a float64 value to a Decimal representation of scale 10
 func decimal(val float64) (dec luno_decimal.Decimal) {
 	dec = luno_decimal.NewFromFloat64(val, 4)
 	return
 }
 
-func reverseTimestamps(stamps []luno.Time) {
-	for i, j := 0, len(stamps)-1; i < j; i, j = i+1, j-1 {
-		stamps[i], stamps[j] = stamps[j], stamps[i]
+// candlesFromLuno converts candles returned by the luno API into `Candle` values.
+func candlesFromLuno(candles []luno.Candle) []Candle {
+	converted := make([]Candle, 0, len(candles))
+	for _, c := range candles {
+		converted = append(converted, Candle{Time: time.Time(c.Timestamp), Open: c.Open.Float64(), High: c.High.Float64(),
+			Low: c.Low.Float64(), Close: c.Close.Float64(), Volume: c.Volume.Float64()})
 	}
+	return converted
 }
 
 func reverseSlice(slice []luno.PublicTrade) {
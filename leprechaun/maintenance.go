@@ -0,0 +1,182 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/luno/luno-go"
+)
+
+const (
+	// statusCheckInterval is how often the exchange's status is checked while it is healthy.
+	statusCheckInterval = 5 * time.Minute
+	// maintenanceCheckInterval is how often the exchange's status is checked during maintenance.
+	maintenanceCheckInterval = time.Minute
+)
+
+// ErrExchangeMaintenance is returned when the exchange is down for maintenance.
+var ErrExchangeMaintenance = errors.New("the exchange is down for maintenance")
+
+// gatewayError is returned for a request the gateway in front of the exchange answered with 502, 503 or 504,
+// since luno-go only reports the status of such responses in its error message.
+type gatewayError struct {
+	status int
+}
+
+func (e *gatewayError) Error() string {
+	return fmt.Sprintf("the exchange answered %d %s", e.status, http.StatusText(e.status))
+}
+
+// gatewayTransport turns 502, 503 and 504 responses into a gatewayError.
+type gatewayTransport struct {
+	next http.RoundTripper
+}
+
+func (t *gatewayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		resp.Body.Close()
+		return nil, &gatewayError{status: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// gatewayClient returns a copy of `base` whose 502, 503 and 504 responses are returned as a gatewayError.
+func gatewayClient(base *http.Client) *http.Client {
+	if base == nil {
+		base = &http.Client{Timeout: defaultClientTimeout}
+	}
+	client := *base
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &gatewayTransport{next: next}
+	return &client
+}
+
+// isMaintenanceError returns true if `err` means the exchange is down for maintenance rather than broken:
+// it answers with 502, 503 or 504, or one of the error codes it uses during maintenance.
+func isMaintenanceError(err error) bool {
+	var gateway *gatewayError
+	return errors.Is(err, ErrExchangeMaintenance) || errors.As(err, &gateway) ||
+		luno.IsErrorCode(err, "ErrUnderMaintenance") || luno.IsErrorCode(err, "ErrServiceUnavailable")
+}
+
+// maintenance tracks whether the session is paused because the exchange is down for maintenance.
+type maintenance struct {
+	mu     sync.Mutex
+	paused bool
+	since  time.Time
+}
+
+// pause starts a maintenance pause at `now`. It returns false if the session was paused already.
+func (m *maintenance) pause(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.paused {
+		return false
+	}
+	m.paused, m.since = true, now
+	return true
+}
+
+// resume ends the maintenance pause and returns how long it lasted, or false if the session wasn't paused.
+func (m *maintenance) resume(now time.Time) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.paused {
+		return 0, false
+	}
+	m.paused = false
+	return now.Sub(m.since), true
+}
+
+// active returns true while the session is paused for maintenance.
+func (m *maintenance) active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// pauseForMaintenance stops analysis and trading until the exchange is healthy again. The user is notified
+// once, instead of once for every request that fails during the maintenance.
+func (pf *Portfolio) pauseForMaintenance(reason error) {
+	if !pf.maintenance.pause(pf.clock.Now()) {
+		return
+	}
+	msg := pf.messages.Sprintf(MsgMaintenance, reason)
+	log.Println(msg)
+	pf.events.record("maintenance", "", msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgMaintenanceSubject), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+}
+
+// resumeAfterMaintenance resumes analysis and trading after a maintenance pause.
+func (pf *Portfolio) resumeAfterMaintenance() {
+	lasted, ok := pf.maintenance.resume(pf.clock.Now())
+	if !ok {
+		return
+	}
+	// Prices moved and orders may have filled while the exchange was down.
+	pf.coldStart.reset()
+	msg := pf.messages.Sprintf(MsgMaintenanceOver, lasted.Round(time.Second))
+	log.Println(msg)
+	pf.events.record("maintenance", "", msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgMaintenanceOverSubject), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+}
+
+// exchangeHealthy asks the exchange for the status of every market traded. It returns ErrExchangeMaintenance
+// if any of them isn't trading.
+func (pf *Portfolio) exchangeHealthy() error {
+	for asset, handler := range pf.assets {
+		active, err := handler.MarketStatus()
+		if err != nil {
+			return err
+		}
+		if !active {
+			log.Printf("The %s market is not trading", asset)
+			return ErrExchangeMaintenance
+		}
+	}
+	return nil
+}
+
+// monitorExchange polls the exchange's status until the session ends, pausing the session while the
+// exchange is down for maintenance and resuming it once the exchange reports healthy again.
+func (s *Session) monitorExchange() {
+	for {
+		interval := statusCheckInterval
+		if s.portfolio.maintenance.active() {
+			interval = maintenanceCheckInterval
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(interval):
+		}
+		switch err := s.portfolio.exchangeHealthy(); {
+		case err == nil:
+			s.portfolio.resumeAfterMaintenance()
+		case isMaintenanceError(err):
+			s.portfolio.pauseForMaintenance(err)
+		default:
+			s.errs.report("", "check exchange status", err)
+		}
+	}
+}
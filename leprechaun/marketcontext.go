@@ -0,0 +1,59 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+// volatilityPeriod is the number of candles the volatility in a MarketContext is measured over.
+const volatilityPeriod = 14
+
+// MarketContext is what the bot knows about an asset besides its prices: the cost of trading it, the money
+// available and the positions already open. It lets a plugin hold back signals that can't or shouldn't be traded,
+// e.g. when the balance is too small for an order or the spread and fees would eat the expected move.
+type MarketContext struct {
+	Asset       string
+	Pair        string
+	Price       float64 // Current price passed to SetCurrentPrice.
+	Spread      float64 // Ask minus bid, when the order book is streamed. Zero otherwise.
+	MakerFee    float64 // as a fraction. Fees are zero if they couldn't be fetched from the exchange.
+	TakerFee    float64
+	Balance     float64 // Balance of the asset, as last fetched.
+	FiatBalance float64 // Balance of the currency the asset is traded against, as last fetched.
+	// PurchaseUnit is the amount the next position would be opened with, in the currency the asset is traded
+	// against. It is zero if it couldn't be worked out.
+	PurchaseUnit   float64
+	MinOrderVolume float64 // Smallest volume of the asset the exchange accepts in an order.
+	OpenPositions  []Entry
+	// Volatility is the average true range of the last 14 candles, as a fraction of the price.
+	Volatility float64
+}
+
+// ContextAnalyzer is implemented by analyzer plugins that take the MarketContext into account, so they don't
+// signal when a trade would be impossible or irrational.
+type ContextAnalyzer interface {
+	Analyzer
+	// SetContext passes the asset's market context to the analysis plugin before each signal.
+	SetContext(market MarketContext) error
+}
+
+// marketContext collects the market context of `asset`, analyzed on `candles` at `price`.
+func (pf *Portfolio) marketContext(asset string, candles []OHLC, price float64) (market MarketContext, err error) {
+	market.Asset, market.Price = asset, price
+	if price > 0 {
+		market.Volatility = AverageTrueRange(candles, volatilityPeriod) / price
+	}
+	// purchaseUnit takes the lock itself.
+	if unit, err := pf.purchaseUnit(asset); err == nil {
+		market.PurchaseUnit = unit
+	}
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	state := pf.market[asset]
+	market.Spread, market.Balance, market.FiatBalance = state.Spread, state.Balance, state.FiatBalance
+	if info, ok := pf.assetInfo[asset]; ok {
+		market.Pair, market.MinOrderVolume = info.Pair, info.minOrderVol
+		market.MakerFee, market.TakerFee = info.makerFee, info.takerFee
+	}
+	market.OpenPositions, err = pf.ledger.OpenPositions(pf.ctx, asset)
+	return
+}
@@ -0,0 +1,38 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import "time"
+
+// Candle is a candle as returned by an exchange. Each ExchangeHandler converts its exchange's candles into
+// Candles, so the rest of the package, e.g. the charts (see OHLC) and the backtester, doesn't depend on
+// the exchange's SDK.
+type Candle struct {
+	Time                   time.Time // start of the candle
+	Open, High, Low, Close float64
+	Volume                 float64
+}
+
+// Tick is a price quote of a pair at one point in time, e.g. recorded from the exchange and replayed by soak tests.
+type Tick struct {
+	Time     time.Time
+	Pair     string
+	Bid, Ask float64
+	Volume   float64 // traded since the previous tick
+}
+
+// PairInfo describes a pair listed by an exchange, see ExchangeHandler.Pairs.
+type PairInfo struct {
+	Pair        string // e.g. XBTNGN
+	Base        string // code of the asset traded, e.g. XBT
+	Counter     string // code of the currency it is traded against, e.g. NGN
+	MinVolume   float64
+	MaxVolume   float64
+	PriceScale  int     // decimal places of prices
+	VolumeScale int     // decimal places of volumes
+	MakerFee    float64 // as a fraction. Fees are zero if they couldn't be fetched.
+	TakerFee    float64
+	Active      bool // orders may be placed, i.e. trading isn't suspended
+}
@@ -0,0 +1,328 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MessageID identifies a user-facing message in a Catalog.
+type MessageID string
+
+// User-facing messages. The English text of each is in the "en" catalog.
+const (
+	MsgSessionStarted      MessageID = "session-started"
+	MsgSessionDuration     MessageID = "session-duration"
+	MsgTotalSold           MessageID = "total-sold"
+	MsgTotalPurchased      MessageID = "total-purchased"
+	MsgProfitReinvested    MessageID = "profit-reinvested"
+	MsgProfitSetAside      MessageID = "profit-set-aside"
+	MsgReadOnlyKeySubject  MessageID = "read-only-key-subject"
+	MsgReadOnlyKey         MessageID = "read-only-key"
+	MsgCrashingSubject     MessageID = "crashing-subject"
+	MsgCrashing            MessageID = "crashing"
+	MsgDriftSubject        MessageID = "drift-subject"
+	MsgDrift               MessageID = "drift"
+	MsgErrorSubject        MessageID = "error-subject"
+	MsgHaltSubject         MessageID = "halt-subject"
+	MsgSkipAsset           MessageID = "skip-asset"
+	MsgPlacingBid          MessageID = "placing-bid"
+	MsgBidPlaced           MessageID = "bid-placed"
+	MsgPlacingAsk          MessageID = "placing-ask"
+	MsgWizardIntro         MessageID = "wizard-intro"
+	MsgWizardExchange      MessageID = "wizard-exchange"
+	MsgWizardUnsupported   MessageID = "wizard-unsupported"
+	MsgWizardKeyID         MessageID = "wizard-key-id"
+	MsgWizardKeySecret     MessageID = "wizard-key-secret"
+	MsgWizardCheckingKey   MessageID = "wizard-checking-key"
+	MsgWizardKeyRejected   MessageID = "wizard-key-rejected"
+	MsgWizardAssets        MessageID = "wizard-assets"
+	MsgWizardPurchaseUnit  MessageID = "wizard-purchase-unit"
+	MsgWizardMargin        MessageID = "wizard-margin"
+	MsgWizardPositive      MessageID = "wizard-positive"
+	MsgWizardDone          MessageID = "wizard-done"
+	MsgWhatIfFill          MessageID = "what-if-fill"
+	MsgWhatIfFee           MessageID = "what-if-fee"
+	MsgWhatIfBreakEven     MessageID = "what-if-break-even"
+	MsgWhatIfTarget        MessageID = "what-if-target"
+	MsgFeeBudgetSubject    MessageID = "fee-budget-subject"
+	MsgFeeBudget           MessageID = "fee-budget"
+	MsgDailySummarySubject MessageID = "daily-summary-subject"
+	MsgRealizedProfit      MessageID = "realized-profit"
+	MsgUnrealizedProfit    MessageID = "unrealized-profit"
+	MsgOpenPosition        MessageID = "open-position"
+	MsgReportTitle         MessageID = "report-title"
+	MsgReportTotal         MessageID = "report-total"
+	MsgOrdersCancelled     MessageID = "orders-cancelled"
+	MsgOrdersNotCancelled  MessageID = "orders-not-cancelled"
+	MsgLiquidated          MessageID = "liquidated"
+	MsgLiquidationSkipped  MessageID = "liquidation-skipped"
+	MsgSlippageExceeded    MessageID = "slippage-exceeded"
+	MsgReplayRecorded      MessageID = "replay-recorded"
+	MsgReplayInputs        MessageID = "replay-inputs"
+	MsgReplayMatch         MessageID = "replay-match"
+	MsgReplayMismatch      MessageID = "replay-mismatch"
+
+	MsgCircuitBreakerSubject  MessageID = "circuit-breaker-subject"
+	MsgCircuitBreaker         MessageID = "circuit-breaker"
+	MsgMaintenanceSubject     MessageID = "maintenance-subject"
+	MsgMaintenance            MessageID = "maintenance"
+	MsgMaintenanceOverSubject MessageID = "maintenance-over-subject"
+	MsgMaintenanceOver        MessageID = "maintenance-over"
+	MsgHoldingExpired         MessageID = "holding-expired"
+	MsgStalePositionSubject   MessageID = "stale-position-subject"
+	MsgStalePosition          MessageID = "stale-position"
+	MsgUnderwaterPosition     MessageID = "underwater-position"
+	MsgStaleAction            MessageID = "stale-action"
+	MsgBalanceAnomalySubject  MessageID = "balance-anomaly-subject"
+	MsgBalanceAnomaly         MessageID = "balance-anomaly"
+	MsgBalanceAnomalyPaused   MessageID = "balance-anomaly-paused"
+	MsgLatencyDegradedSubject MessageID = "latency-degraded-subject"
+	MsgLatencyDegraded        MessageID = "latency-degraded"
+	MsgFeeTierNearSubject     MessageID = "fee-tier-near-subject"
+	MsgFeeTierNear            MessageID = "fee-tier-near"
+	MsgDustBalance            MessageID = "dust-balance"
+	MsgDustConsolidated       MessageID = "dust-consolidated"
+	MsgProfitWithdrawnSubject MessageID = "profit-withdrawn-subject"
+	MsgProfitWithdrawn        MessageID = "profit-withdrawn"
+	MsgProfitWithdrawalDryRun MessageID = "profit-withdrawal-dry-run"
+)
+
+// Catalog maps message IDs to fmt format strings in one language.
+type Catalog map[MessageID]string
+
+// defaultLanguage is used for messages missing from a catalog.
+const defaultLanguage = "en"
+
+var (
+	catalogsMu sync.RWMutex
+	catalogs   = map[string]Catalog{
+		"en": {
+			MsgSessionStarted:      "Session started at %s",
+			MsgSessionDuration:     "Session duration: %s",
+			MsgTotalSold:           "Total sold: %s",
+			MsgTotalPurchased:      "Total purchased: %s",
+			MsgProfitReinvested:    "Realized profit: %s (reinvested)",
+			MsgProfitSetAside:      "Realized profit: %s (set aside, the stake stayed fixed)",
+			MsgReadOnlyKeySubject:  "Read-only API key",
+			MsgReadOnlyKey:         "The API key is read-only. Leprechaun will watch the markets but won't place any orders. Create a key with trading permission to trade.",
+			MsgCrashingSubject:     "Leprechaun keeps crashing",
+			MsgCrashing:            "The %s has crashed %d times in a row and keeps being restarted. See the log for details.",
+			MsgDriftSubject:        "Analyzer drift",
+			MsgDrift:               "The hit rate of %s has dropped to %.1f%%. Switched to %s.",
+			MsgErrorSubject:        "Trading error",
+			MsgHaltSubject:         "Trading session halted",
+			MsgSkipAsset:           "%s will not be traded for %s",
+			MsgPlacingBid:          "Placing bid order for %s worth of %s (approx. %s) on the exchange...",
+			MsgBidPlaced:           "Bid order for %s has been placed on the exchange.",
+			MsgPlacingAsk:          "Placing ask order for ~%s worth of %s on the exchange...",
+			MsgWizardIntro:         "Setting up Leprechaun. Settings will be saved to %s",
+			MsgWizardExchange:      "Exchange (%s)",
+			MsgWizardUnsupported:   "%q is not supported.",
+			MsgWizardKeyID:         "API key ID",
+			MsgWizardKeySecret:     "API key secret",
+			MsgWizardCheckingKey:   "Checking the API key...",
+			MsgWizardKeyRejected:   "The exchange rejected the API key: %v",
+			MsgWizardAssets:        `Assets to trade, separated by "+"`,
+			MsgWizardPurchaseUnit:  "Amount to spend on each purchase (%s)",
+			MsgWizardMargin:        "Profit margin in percent",
+			MsgWizardPositive:      "Please enter a number greater than zero.",
+			MsgWizardDone:          "Done.",
+			MsgWhatIfFill:          "A market %s of %s would fill at an average price of %s (%s in total).",
+			MsgWhatIfFee:           "Taker fee: %.2f%% (%s)",
+			MsgWhatIfBreakEven:     "Close the position at %s to break even after fees.",
+			MsgWhatIfTarget:        "To make your %.2f%% profit margin, close the position at %s.",
+			MsgFeeBudgetSubject:    "Daily fee budget used up",
+			MsgFeeBudget:           "%s has been paid in fees today, reaching the daily budget of %s. No new positions will be opened until tomorrow.",
+			MsgDailySummarySubject: "Leprechaun summary for %s",
+			MsgRealizedProfit:      "Realized profit (closed trades): %s",
+			MsgUnrealizedProfit:    "Unrealized profit (%[2]d open positions): %[1]s",
+			MsgOpenPosition:        "  %s %s: %s",
+			MsgReportTitle:         "Trading report %s to %s",
+			MsgReportTotal:         "Profit: %s from %d trades, %d of them profitable",
+			MsgOrdersCancelled:     "Pending orders cancelled: %d",
+			MsgOrdersNotCancelled:  "Could not cancel %d pending order(s). Check the exchange for orders left open.",
+			MsgLiquidated:          "%s: closed %d position(s) for %s",
+			MsgLiquidationSkipped:  "  %d position(s) left open: %s",
+			MsgSlippageExceeded:    "the price moved more than %.2f%%",
+			MsgReplayRecorded:      "Trade %s on %s: %v signal at %s from %s",
+			MsgReplayInputs:        "Inputs: %d candles of %s (hash %s...), price %s",
+			MsgReplayMatch:         "Replayed signal: %v (same decision)",
+			MsgReplayMismatch:      "Replayed signal: %v, but the recorded signal was %v",
+
+			MsgCircuitBreakerSubject:  "Circuit breaker tripped on %s",
+			MsgCircuitBreaker:         "%s moved %.2f%% within %s. No new positions will be opened on it for %s.",
+			MsgMaintenanceSubject:     "Exchange maintenance",
+			MsgMaintenance:            "The exchange seems to be down for maintenance (%v). Trading is paused until it is back.",
+			MsgMaintenanceOverSubject: "Exchange back from maintenance",
+			MsgMaintenanceOver:        "The exchange is healthy again after %s. Trading has resumed.",
+			MsgHoldingExpired:         "%s: closed %s position %s after holding it for %s, longer than MaxHoldingHours allows",
+			MsgStalePositionSubject:   "Check your open %s position",
+			MsgStalePosition:          "%s: the %s position %s has been open for %s.",
+			MsgUnderwaterPosition:     "%s: the %s position %s is down %.2f%%, past its %.2f%% stop loss, but hasn't been closed.",
+			MsgStaleAction:            "The loop that closes positions may have stopped. Check the logs, then close it on the exchange or with `leprechaun liquidate --asset %s`.",
+			MsgBalanceAnomalySubject:  "Unexpected %s balance",
+			MsgBalanceAnomaly:         "The exchange reports a balance of %s, but the trades in the ledger add up to %s. Was the account traded on manually, or did an order go wrong?",
+			MsgBalanceAnomalyPaused:   "No new positions will be opened until the anomaly is acknowledged.",
+			MsgLatencyDegradedSubject: "The exchange is responding slowly",
+			MsgLatencyDegraded:        "1 in 10 of the exchange's %s requests took longer than %s over the last %d requests (the warning is set at %s). Stop losses may be executed late.",
+			MsgFeeTierNearSubject:     "A lower fee tier is close",
+			MsgDustBalance:            "%s: %s not held by any position, of a balance of %s (the minimum order is %s)",
+			MsgDustConsolidated:       "Sold %s of %s left over from earlier trades for %s",
+			MsgProfitWithdrawnSubject: "Profits withdrawn",
+			MsgProfitWithdrawn:        "Withdrew %s of realized profits to %s (reference %s).",
+			MsgProfitWithdrawalDryRun: "Would have withdrawn %s of realized profits to %s, but withdrawals are a dry run.",
+			MsgFeeTierNear:            "Your 30-day trading volume is %s, %s short of the next fee tier, where the taker fee drops from %.2f%% to %.2f%%.",
+		},
+	}
+)
+
+// RegisterCatalog adds (or replaces) the messages of a language, e.g. "fr". Messages missing
+// from the catalog are shown in English.
+func RegisterCatalog(language string, catalog Catalog) {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+	catalogs[strings.ToLower(language)] = catalog
+}
+
+// numberFormat is how a locale writes numbers.
+type numberFormat struct {
+	group, decimal string
+	symbolAfter    bool // the currency symbol follows the amount
+}
+
+var numberFormats = map[string]numberFormat{
+	"en": {group: ",", decimal: "."},
+	"fr": {group: " ", decimal: ",", symbolAfter: true},
+	"de": {group: ".", decimal: ",", symbolAfter: true},
+	"es": {group: ".", decimal: ",", symbolAfter: true},
+	"pt": {group: ".", decimal: ","},
+}
+
+// currencySymbols holds the symbols of fiat currencies traded against. Other currencies are shown by their code.
+var currencySymbols = map[string]string{
+	"NGN": "₦",
+	"ZAR": "R",
+	"EUR": "€",
+	"GBP": "£",
+	"USD": "$",
+	"IDR": "Rp",
+	"MYR": "RM",
+	"UGX": "USh",
+	"ZMW": "K",
+}
+
+// currencyDecimals holds the decimal places amounts of each currency are shown and rounded to, as the exchange
+// counts them. Other currencies get defaultDecimals.
+var currencyDecimals = map[string]int{
+	"NGN": 2, "ZAR": 2, "EUR": 2, "GBP": 2, "USD": 2, "MYR": 2, "ZMW": 2, "USDC": 2,
+	"IDR": 0, "UGX": 0,
+	"XBT": 8, "BTC": 8, "ETH": 8, "LTC": 8, "BCH": 8,
+	"XRP": 6,
+}
+
+// defaultDecimals is the number of decimal places of currencies missing from currencyDecimals.
+const defaultDecimals = 2
+
+// CurrencyDecimals returns the number of decimal places amounts of `currency` are shown with, e.g. 2 for NGN
+// and 8 for XBT.
+func CurrencyDecimals(currency string) int {
+	if decimals, ok := currencyDecimals[strings.ToUpper(currency)]; ok {
+		return decimals
+	}
+	return defaultDecimals
+}
+
+// RoundAmount rounds `amount` to the decimal places of `currency`.
+func RoundAmount(amount float64, currency string) float64 {
+	scale := math.Pow(10, float64(CurrencyDecimals(currency)))
+	return math.Round(amount*scale) / scale
+}
+
+// Localizer formats user-facing messages and amounts for a locale.
+type Localizer struct {
+	language string
+	format   numberFormat
+}
+
+// NewLocalizer returns a Localizer for a locale such as "en", "fr" or "pt_BR". Only the language part is used.
+func NewLocalizer(locale string) *Localizer {
+	language := strings.ToLower(locale)
+	if i := strings.IndexAny(language, "_-."); i >= 0 {
+		language = language[:i]
+	}
+	if language == "" {
+		language = defaultLanguage
+	}
+	format, ok := numberFormats[language]
+	if !ok {
+		format = numberFormats[defaultLanguage]
+	}
+	return &Localizer{language: language, format: format}
+}
+
+// Sprintf formats the message `id` in the localizer's language.
+func (l *Localizer) Sprintf(id MessageID, args ...interface{}) string {
+	catalogsMu.RLock()
+	msg, ok := catalogs[l.language][id]
+	if !ok {
+		msg, ok = catalogs[defaultLanguage][id]
+	}
+	catalogsMu.RUnlock()
+	if !ok {
+		return string(id)
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// FormatNumber writes `amount` with `decimals` decimal places and the locale's separators.
+func (l *Localizer) FormatNumber(amount float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(amount), 'f', decimals, 64)
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	var b strings.Builder
+	if amount < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.format.group)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(l.format.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+// FormatMoney writes an amount of a fiat currency with its symbol, e.g. "₦1,250.00". Currencies without a
+// symbol are written with their code, e.g. "XBT 0.00125000".
+func (l *Localizer) FormatMoney(amount float64, currency string) string {
+	number, sign := l.FormatNumber(math.Abs(amount), CurrencyDecimals(currency)), ""
+	if amount < 0 && strings.Trim(number, "0.,  ") != "" {
+		sign = "-"
+	}
+	symbol, ok := currencySymbols[strings.ToUpper(currency)]
+	switch {
+	case !ok:
+		return sign + strings.ToUpper(currency) + " " + number
+	case l.format.symbolAfter:
+		return sign + number + " " + symbol
+	}
+	return sign + symbol + number
+}
+
+// FormatAmount writes an amount of an asset with its code after it, e.g. "0.00125000 XBT" or "12.500000 XRP".
+func (l *Localizer) FormatAmount(amount float64, currency string) string {
+	return l.FormatNumber(amount, CurrencyDecimals(currency)) + " " + strings.ToUpper(currency)
+}
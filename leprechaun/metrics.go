@@ -0,0 +1,43 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+// Metrics are counters of a session's internals, for monitoring its health. They can be encoded as JSON.
+type Metrics struct {
+	Requests     map[RequestClass]int // Exchange requests made, by class.
+	RequestQueue RequestQueueStats
+	// AnalyzerOverruns are the analyses abandoned because the analyzer didn't emit a signal within
+	// AnalyzerTimeout, by asset.
+	AnalyzerOverruns map[string]int
+	Heartbeats       map[string]Heartbeats // Last times each loop did its work, by asset.
+	Progress         []Progress            // Long operations in progress, e.g. downloads of historical candles.
+	// Ready tells, by asset, whether the data is fresh enough for orders to be placed. See Session.Ready.
+	Ready map[string]bool
+	// Latency are the latency percentiles of the recent requests to the exchange, by endpoint.
+	Latency map[Endpoint]LatencyStats
+}
+
+// Metrics returns the session's current metrics.
+func (s *Session) Metrics() (m Metrics) {
+	pf := s.portfolio
+	m.Requests, m.RequestQueue = pf.budget.Used(), pf.budget.Stats()
+	m.Progress = pf.progress.running()
+	m.Latency = pf.latency.stats()
+	m.Ready = make(map[string]bool, len(pf.assets))
+	for asset := range pf.assets {
+		m.Ready[asset] = pf.coldStart.ready(asset, pf.analysisInterval(asset))
+	}
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	m.AnalyzerOverruns = make(map[string]int, len(pf.overruns))
+	for asset, n := range pf.overruns {
+		m.AnalyzerOverruns[asset] = n
+	}
+	m.Heartbeats = make(map[string]Heartbeats, len(pf.assets))
+	for asset := range pf.assets {
+		m.Heartbeats[asset] = pf.market[asset].Heartbeats
+	}
+	return
+}
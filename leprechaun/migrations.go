@@ -0,0 +1,257 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ledgerMigrations upgrade an existing ledger database to the current schema. The number
+// of migrations already applied to a database is stored in sqlite's user_version pragma,
+// so new migrations must always be appended to the end of the list.
+var ledgerMigrations = []func(tx *sql.Tx) error{
+	migrateRecordsColumns,
+	migrateTimestamps,
+	migrateRecordVersions,
+	migrateAnalyzerTables,
+	migrateOrderBookTable,
+	migrateOrderRoundingTable,
+	migrateDustTable,
+	migrateWithdrawalTable,
+	migrateClosedProfits,
+}
+
+// migrateLedger applies any migrations the database hasn't seen yet.
+func migrateLedger(db *sql.DB) (err error) {
+	var version int
+	if err = db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return
+	}
+	for ; version < len(ledgerMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err = ledgerMigrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ledger migration %d failed: %w", version+1, err)
+		}
+		if err = setLedgerVersion(tx, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setLedgerVersion(tx *sql.Tx, version int) (err error) {
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	return
+}
+
+// legacyRecordColumns are the columns of the current RECORDS table, each with the expression that fills it from a
+// row of the original table. The original table kept a single COST, PRICE and VOLUME, which belong to the sale of a
+// short position (TYPE 1, OpenShortTrade) and to the purchase of a long one.
+var legacyRecordColumns = []struct{ name, legacy string }{
+	{"ASSET", "ASSET"},
+	{"PURCHASE_COST", "CASE WHEN TYPE = 1 THEN 0 ELSE COST END"},
+	{"SALE_COST", "CASE WHEN TYPE = 1 THEN COST ELSE 0 END"},
+	{"ID", "ID"},
+	{"PURCHASE_PRICE", "CASE WHEN TYPE = 1 THEN 0 ELSE PRICE END"},
+	{"SALE_PRICE", "CASE WHEN TYPE = 1 THEN PRICE ELSE 0 END"},
+	{"SALE_ID", "SALE_ID"},
+	{"STATUS", "STATUS"},
+	{"TIMESTAMP", "TIMESTAMP"},
+	{"PURCHASE_VOLUME", "CASE WHEN TYPE = 1 THEN 0 ELSE VOLUME END"},
+	{"SALE_VOLUME", "CASE WHEN TYPE = 1 THEN VOLUME ELSE 0 END"},
+	{"PROFIT", "0"},
+	{"TYPE", "TYPE"},
+	{"TRIGGER_PRICE", "TRIGGER_PRICE"},
+	{"UPDATED", "0"},
+}
+
+// migrateRecordsColumns replaces the original RECORDS table, whose columns never matched the fields of `Entry`,
+// with the current schema, and copies its records over so open positions are still closed. The old table is kept
+// as RECORDS_LEGACY.
+func migrateRecordsColumns(tx *sql.Tx) (err error) {
+	rows, err := tx.Query("PRAGMA table_info(RECORDS)")
+	if err != nil {
+		return
+	}
+	numColumns := 0
+	for rows.Next() {
+		numColumns++
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil || numColumns == len(legacyRecordColumns) {
+		return
+	}
+	if _, err = tx.Exec("ALTER TABLE RECORDS RENAME TO RECORDS_LEGACY"); err != nil {
+		return
+	}
+	names := make([]string, len(legacyRecordColumns))
+	legacy := make([]string, len(legacyRecordColumns))
+	for i, column := range legacyRecordColumns {
+		names[i], legacy[i] = column.name, column.legacy
+	}
+	if _, err = tx.Exec(fmt.Sprintf("CREATE TABLE RECORDS (%s)", strings.Join(names, ", "))); err != nil {
+		return
+	}
+	res, err := tx.Exec(fmt.Sprintf("INSERT INTO RECORDS (%s) SELECT %s FROM RECORDS_LEGACY", strings.Join(names, ", "),
+		strings.Join(legacy, ", ")))
+	if err != nil {
+		return
+	}
+	if copied, err := res.RowsAffected(); err == nil && copied > 0 {
+		log.Printf("Copied %d records to the new ledger schema.", copied)
+	}
+	return nil
+}
+
+// migrateTimestamps rewrites every record's timestamp in the RFC3339 format. Timestamps
+// written with the old "15:08:14" layout only ever held the hour and cannot be recovered.
+func migrateTimestamps(tx *sql.Tx) (err error) {
+	rows, err := tx.Query("SELECT rowid, TIMESTAMP FROM RECORDS")
+	if err != nil {
+		return
+	}
+	updates := map[int64]string{}
+	lost := 0
+	for rows.Next() {
+		var rowID int64
+		var ts sql.NullString
+		if err = rows.Scan(&rowID, &ts); err != nil {
+			rows.Close()
+			return
+		}
+		t, err := parseTimestamp(ts.String)
+		if err != nil {
+			lost++
+			continue
+		}
+		if formatted := formatTimestamp(t); formatted != ts.String {
+			updates[rowID] = formatted
+		}
+	}
+	rows.Close()
+	for rowID, ts := range updates {
+		if _, err = tx.Exec("UPDATE RECORDS SET TIMESTAMP = ? WHERE rowid = ?", ts, rowID); err != nil {
+			return
+		}
+	}
+	if lost > 0 {
+		log.Printf("Could not recover the timestamps of %d ledger records.", lost)
+	}
+	return nil
+}
+
+// migrateRecordVersions adds the VERSION column used to detect conflicting updates, see Ledger2.UpdateRecord.
+func migrateRecordVersions(tx *sql.Tx) (err error) {
+	_, err = tx.Exec("ALTER TABLE RECORDS ADD COLUMN VERSION INTEGER DEFAULT 0")
+	return
+}
+
+// migrateAnalyzerTables adds the tables that keep the signals and trades of each analyzer, see AnalyzerPerformance.
+func migrateAnalyzerTables(tx *sql.Tx) (err error) {
+	for _, create := range analyzerTablesInit {
+		if _, err = tx.Exec(create); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// migrateOrderBookTable adds the table that keeps the order book snapshots taken as orders were placed.
+func migrateOrderBookTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(orderBookTableInit)
+	return
+}
+
+// migrateOrderRoundingTable adds the table that keeps the orders whose volume was rounded.
+func migrateOrderRoundingTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(orderRoundingTableInit)
+	return
+}
+
+// migrateDustTable adds the table that keeps the sales of residual balances.
+func migrateDustTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(dustTableInit)
+	return
+}
+
+// migrateWithdrawalTable adds the table that keeps the withdrawals of realized profits.
+func migrateWithdrawalTable(tx *sql.Tx) (err error) {
+	_, err = tx.Exec(withdrawalTableInit)
+	return
+}
+
+// migrateClosedProfits recounts the profit of the closed positions from their prices and volumes, since it used to
+// be recorded with the wrong sign. The fees paid weren't recorded, so they are left out. STATUS 1 is Closed and
+// TYPE 1 is OpenShortTrade.
+func migrateClosedProfits(tx *sql.Tx) (err error) {
+	_, err = tx.Exec("UPDATE RECORDS SET PROFIT = CASE WHEN TYPE = 1 THEN SALE_COST - PURCHASE_PRICE * SALE_VOLUME " +
+		"ELSE SALE_PRICE * PURCHASE_VOLUME - PURCHASE_COST END WHERE STATUS = 1")
+	return
+}
+
+// configMigrations upgrade saved settings to the current version of `Configuration`. They work on the
+// raw JSON fields, so a renamed field can be moved before the settings are decoded instead of being
+// dropped. The version of saved settings is the number of migrations applied to them, so new
+// migrations must always be appended to the end of the list.
+var configMigrations = []func(c *Configuration, fields map[string]json.RawMessage) error{
+	migrateConfigUnversioned,
+}
+
+// migrateConfig applies any migrations the saved settings haven't seen yet. `c` locates the keystore.
+func (c *Configuration) migrateConfig(fields map[string]json.RawMessage) (err error) {
+	var version int
+	if raw, ok := fields["Version"]; ok {
+		if err = json.Unmarshal(raw, &version); err != nil {
+			return
+		}
+	}
+	if version > len(configMigrations) {
+		return fmt.Errorf("the settings were saved by a newer version of Leprechaun (version %d)", version)
+	}
+	for ; version < len(configMigrations); version++ {
+		if err = configMigrations[version](c, fields); err != nil {
+			return fmt.Errorf("settings migration %d failed: %w", version+1, err)
+		}
+	}
+	fields["Version"], err = json.Marshal(version)
+	return
+}
+
+// migrateConfigUnversioned upgrades settings saved before they had a version. The API key secret is
+// kept in the keystore and no longer read from the settings file, so a copy left there is moved to the keystore.
+// It is only dropped from the settings once the keystore has it.
+func migrateConfigUnversioned(c *Configuration, fields map[string]json.RawMessage) error {
+	var keyID, keySecret string
+	if raw, ok := fields["APIKeySecret"]; ok {
+		if err := json.Unmarshal(raw, &keySecret); err != nil {
+			return err
+		}
+	}
+	if keySecret != "" {
+		if raw, ok := fields["APIKeyID"]; ok {
+			if err := json.Unmarshal(raw, &keyID); err != nil {
+				return err
+			}
+		}
+		if err := c.SaveAPIKeys(keyID, keySecret); err != nil {
+			return fmt.Errorf("could not move the API key secret to the keystore: %w", err)
+		}
+	}
+	delete(fields, "APIKeySecret")
+	return nil
+}
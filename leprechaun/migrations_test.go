@@ -0,0 +1,124 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateClosedProfits(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	ledger := GetLedger2(path)
+	records := []Entry{
+		{Asset: "BITCOIN", ID: "long", Type: OpenLongTrade, Status: int64(Closed), PurchaseCost: 100, PurchaseVolume: 1,
+			PurchasePrice: 100, SaleCost: 120, SaleVolume: 1, SalePrice: 120, Profit: -20},
+		{Asset: "BITCOIN", ID: "short", Type: OpenShortTrade, Status: int64(Closed), SaleCost: 100, SaleVolume: 1,
+			SalePrice: 100, PurchaseCost: 80, PurchaseVolume: 1, PurchasePrice: 80, Profit: -20},
+		{Asset: "BITCOIN", ID: "open", Type: OpenLongTrade, Status: int64(Open), PurchaseCost: 100, PurchaseVolume: 1,
+			PurchasePrice: 100},
+	}
+	for _, rec := range records {
+		if err := ledger.AddRecord(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Roll the ledger back to before the migration.
+	if _, err := ledger.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(ledgerMigrations)-1)); err != nil {
+		t.Fatal(err)
+	}
+	ledger.Close()
+
+	ledger = GetLedger2(path)
+	defer ledger.Close()
+	for id, want := range map[string]float64{"long": 20, "short": 20, "open": 0} {
+		rec, err := ledger.GetRecordByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Profit != want {
+			t.Errorf("profit of %s = %v, want %v", id, rec.Profit, want)
+		}
+	}
+}
+
+// writeUnversionedConfig saves settings as they were written before they had a version, with the API key secret
+// in the settings file.
+func writeUnversionedConfig(t *testing.T, c *Configuration) {
+	t.Helper()
+	data := []byte(`{"Exchange": "luno", "APIKeyID": "key-id", "APIKeySecret": "key-secret", "PurchaseUnit": 5000}`)
+	if err := os.MkdirAll(filepath.Dir(c.configFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.configFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateConfigUnversioned(t *testing.T) {
+	c := &Configuration{}
+	c.SetAppDir(t.TempDir())
+	writeUnversionedConfig(t, c)
+	if err := c.LoadConfig(""); err != nil {
+		t.Fatal(err)
+	}
+	if c.APIKeyID != "key-id" || c.PurchaseUnit != 5000 {
+		t.Errorf("loaded key ID %q and purchase unit %v, want the saved settings", c.APIKeyID, c.PurchaseUnit)
+	}
+	keyID, keySecret, err := c.LoadAPIKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "key-id" || keySecret != "key-secret" {
+		t.Errorf("keystore holds %q, %q, want the keys of the settings file", keyID, keySecret)
+	}
+
+	// Once saved, the settings file no longer holds the secret.
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(c.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["APIKeySecret"]; ok {
+		t.Error("the settings file still holds the API key secret")
+	}
+}
+
+func TestMigrateConfigKeepsSecretUntilSaved(t *testing.T) {
+	c := &Configuration{}
+	c.SetAppDir(t.TempDir())
+	writeUnversionedConfig(t, c)
+	// The keystore can't be created under a file.
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.keyStore = filepath.Join(blocker, "keystore.db")
+	if err := c.LoadConfig(""); err == nil {
+		t.Fatal("loaded the settings although their API key secret couldn't be moved to the keystore")
+	}
+	data, err := os.ReadFile(c.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["APIKeySecret"]; !ok {
+		t.Error("the API key secret was dropped from the settings file")
+	}
+}
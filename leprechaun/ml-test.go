@@ -1,7 +1,6 @@
 package leprechaun
 
 import (
-	"github.com/gonum/stat"
 	luno "github.com/luno/luno-go"
 )
 
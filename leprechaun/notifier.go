@@ -0,0 +1,23 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+)
+
+// Notifier delivers important messages to the user, e.g. by email or through the UI.
+type Notifier interface {
+	Notify(subject, message string) error
+}
+
+// LogNotifier is a Notifier that writes messages to the log. It is used when no other notifier has been set.
+type LogNotifier struct{}
+
+// Notify writes the message to the log.
+func (LogNotifier) Notify(subject, message string) error {
+	log.Printf("[%s] %s", subject, message)
+	return nil
+}
@@ -0,0 +1,44 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import "context"
+
+// SessionOption customizes a Session created by NewSession, e.g. to swap the exchange or the ledger for
+// fakes in tests, or to run several sessions side by side in one program.
+type SessionOption func(*Session)
+
+// ExchangeFactory creates the handler that trades `asset` on an exchange. `config` holds the session's settings,
+// including the API key, and `clock` is the clock the handler should tell the time and wait on.
+type ExchangeFactory func(ctx context.Context, config *Configuration, asset *Asset, clock Clock) (ExchangeHandler, error)
+
+// WithLedger makes the session record its trades in `ledger` instead of the LedgerDatabase setting.
+func WithLedger(ledger LedgerStore) SessionOption {
+	return func(s *Session) {
+		s.ledger = ledger
+	}
+}
+
+// WithExchange registers `factory` to create the handlers of the exchange called `name`. The session uses it
+// when `name` is its Exchange setting, instead of the built-in Luno handlers.
+func WithExchange(name string, factory ExchangeFactory) SessionOption {
+	return func(s *Session) {
+		s.portfolio.exchanges[name] = factory
+	}
+}
+
+// WithNotifier sets how important messages are delivered to the user. See Session.SetNotifier.
+func WithNotifier(notifier Notifier) SessionOption {
+	return func(s *Session) {
+		s.SetNotifier(notifier)
+	}
+}
+
+// WithClock makes the session tell the time and wait on `clock`. See Session.SetClock.
+func WithClock(clock Clock) SessionOption {
+	return func(s *Session) {
+		s.SetClock(clock)
+	}
+}
@@ -0,0 +1,198 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	luno "github.com/luno/luno-go"
+	"github.com/luno/luno-go/streaming"
+)
+
+// ErrOrderBookNotReady is returned when the order book stream hasn't received a snapshot yet.
+var ErrOrderBookNotReady = errors.New("the order book stream is not ready yet")
+
+const (
+	// orderBookCheckInterval is how often the order book streams are checked for updates.
+	orderBookCheckInterval = time.Minute
+	// orderBookStaleAfter is how long an order book stream may go without updates before it is redialed. The
+	// streaming client reconnects on its own after read errors, so this only catches a stream that has stopped.
+	orderBookStaleAfter = 10 * time.Minute
+)
+
+// OrderBookFeatures are values computed from the top of an asset's order book.
+type OrderBookFeatures struct {
+	Time    time.Time // When the features were computed.
+	BestBid float64
+	BestAsk float64
+	Spread  float64
+	// MicroPrice is the mid price weighted by the volume at the best bid and ask. It leans towards
+	// the side with less volume, i.e. the side the price is more likely to move to next.
+	MicroPrice float64
+	// Imbalance is (bid volume - ask volume) / (bid volume + ask volume) over the top levels of the book.
+	// It ranges from -1 (only sellers) to 1 (only buyers).
+	Imbalance float64
+}
+
+// OrderBookAnalyzer is implemented by analyzer plugins that use the order book in addition to price data.
+type OrderBookAnalyzer interface {
+	Analyzer
+	// SetOrderBookFeatures passes the latest order book features of the asset to the analysis plugin.
+	SetOrderBookFeatures(features OrderBookFeatures) error
+}
+
+// OrderBookFeed keeps a live copy of an asset's order book using the exchange's streaming API.
+type OrderBookFeed struct {
+	keyID, keySecret, pair string
+	depth                  int
+	clock                  Clock
+
+	mu         sync.RWMutex
+	conn       *streaming.Conn
+	lastUpdate time.Time
+	connected  bool
+	// gapSince is the time of the last update before the stream reconnected. It is zero when
+	// no data has been missed, see Reconnected.
+	gapSince time.Time
+}
+
+// DialOrderBookFeed connects to the order book stream of `pair`. Features are computed over the top `depth` levels.
+func DialOrderBookFeed(keyID, keySecret, pair string, depth int, clock Clock) (feed *OrderBookFeed, err error) {
+	feed = &OrderBookFeed{keyID: keyID, keySecret: keySecret, pair: pair, depth: depth, clock: clock}
+	if feed.conn, err = feed.dial(); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (feed *OrderBookFeed) dial() (*streaming.Conn, error) {
+	return streaming.Dial(feed.keyID, feed.keySecret, feed.pair, streaming.WithUpdateCallback(feed.updated),
+		streaming.WithConnectCallback(feed.connect))
+}
+
+// stream returns the current connection to the stream.
+func (feed *OrderBookFeed) stream() *streaming.Conn {
+	feed.mu.RLock()
+	defer feed.mu.RUnlock()
+	return feed.conn
+}
+
+// Redial replaces the connection to the stream with a new one. The data missed in between is backfilled
+// like after a reconnect, see Reconnected.
+func (feed *OrderBookFeed) Redial() error {
+	conn, err := feed.dial()
+	if err != nil {
+		return err
+	}
+	feed.mu.Lock()
+	old := feed.conn
+	feed.conn = conn
+	feed.mu.Unlock()
+	old.Close()
+	return nil
+}
+
+// updated is called by the stream after each change to the order book.
+func (feed *OrderBookFeed) updated(streaming.Update) {
+	feed.mu.Lock()
+	feed.lastUpdate = feed.clock.Now()
+	feed.mu.Unlock()
+}
+
+// connect is called by the stream each time it (re)connects and receives a snapshot of the order book.
+func (feed *OrderBookFeed) connect(*streaming.Conn) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if feed.connected && feed.gapSince.IsZero() {
+		feed.gapSince = feed.lastUpdate
+	}
+	feed.connected = true
+	feed.lastUpdate = feed.clock.Now()
+}
+
+// Reconnected returns the time of the last update received before the stream reconnected, if it has reconnected
+// since the data it missed was last backfilled. See Backfilled.
+func (feed *OrderBookFeed) Reconnected() (since time.Time, ok bool) {
+	feed.mu.RLock()
+	defer feed.mu.RUnlock()
+	return feed.gapSince, !feed.gapSince.IsZero()
+}
+
+// Backfilled marks the data missed while the stream was disconnected as recovered.
+func (feed *OrderBookFeed) Backfilled() {
+	feed.mu.Lock()
+	feed.gapSince = time.Time{}
+	feed.mu.Unlock()
+}
+
+// LastUpdate returns the time the order book last changed.
+func (feed *OrderBookFeed) LastUpdate() time.Time {
+	feed.mu.RLock()
+	defer feed.mu.RUnlock()
+	return feed.lastUpdate
+}
+
+// Features computes the order book features from the current state of the book.
+func (feed *OrderBookFeed) Features() (features OrderBookFeatures, err error) {
+	snapshot := feed.stream().Snapshot()
+	if len(snapshot.Bids) == 0 || len(snapshot.Asks) == 0 {
+		return features, ErrOrderBookNotReady
+	}
+	features = computeOrderBookFeatures(snapshot.Bids, snapshot.Asks, feed.depth)
+	features.Time = feed.clock.Now()
+	return features, nil
+}
+
+// Close disconnects from the stream.
+func (feed *OrderBookFeed) Close() {
+	feed.stream().Close()
+}
+
+// monitorOrderBook redials the order book stream of `asset` whenever it stops updating, until the session ends.
+func (s *Session) monitorOrderBook(asset string) {
+	feed := s.portfolio.orderBooks[asset]
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(orderBookCheckInterval):
+		}
+		idle := s.portfolio.clock.Now().Sub(feed.LastUpdate())
+		if idle < orderBookStaleAfter {
+			continue
+		}
+		log.Printf("The %s order book stream hasn't updated in %s. Reconnecting.", asset, idle.Round(time.Second))
+		s.errs.report(asset, "redial order book", feed.Redial())
+	}
+}
+
+// computeOrderBookFeatures computes features from bids sorted best (highest) first and asks sorted best (lowest) first.
+func computeOrderBookFeatures(bids, asks []luno.OrderBookEntry, depth int) (features OrderBookFeatures) {
+	bestBid, bestAsk := bids[0], asks[0]
+	features.BestBid, features.BestAsk = bestBid.Price.Float64(), bestAsk.Price.Float64()
+	features.Spread = features.BestAsk - features.BestBid
+
+	bidVol, askVol := bestBid.Volume.Float64(), bestAsk.Volume.Float64()
+	if bidVol+askVol > 0 {
+		features.MicroPrice = (features.BestBid*askVol + features.BestAsk*bidVol) / (bidVol + askVol)
+	} else {
+		features.MicroPrice = (features.BestBid + features.BestAsk) / 2
+	}
+
+	bidDepth, askDepth := 0.0, 0.0
+	for i := 0; i < depth && i < len(bids); i++ {
+		bidDepth += bids[i].Volume.Float64()
+	}
+	for i := 0; i < depth && i < len(asks); i++ {
+		askDepth += asks[i].Volume.Float64()
+	}
+	if bidDepth+askDepth > 0 {
+		features.Imbalance = (bidDepth - askDepth) / (bidDepth + askDepth)
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+)
+
+// ErrNoConversionRate is returned when an amount can't be converted to the reporting currency (CurrencyCode)
+// because no price of the currency it is in is known.
+var ErrNoConversionRate = errors.New("no conversion rate to the reporting currency")
+
+// conversionRate returns the price of `currency` in CurrencyCode. It is the last price fetched of the asset in
+// the portfolio that is traded as `currency` against CurrencyCode, so trading ETHXBT while reporting in NGN needs
+// XBTNGN in the portfolio too; it may be on the watchlist. pf.mu must be held.
+func (pf *Portfolio) conversionRate(currency string) (float64, error) {
+	if strings.EqualFold(currency, pf.config.CurrencyCode) {
+		return 1, nil
+	}
+	for name, info := range pf.assetInfo {
+		if !strings.EqualFold(info.code, currency) || !strings.EqualFold(info.currency, pf.config.CurrencyCode) {
+			continue
+		}
+		if price := pf.market[name].Price; price > 0 {
+			return price, nil
+		}
+		return 0, fmt.Errorf("%w: the %s price hasn't been fetched yet", ErrNoConversionRate, info.Pair)
+	}
+	return 0, fmt.Errorf("%w: %s%s isn't in the portfolio", ErrNoConversionRate, strings.ToUpper(currency),
+		pf.config.CurrencyCode)
+}
+
+// reportingRateLocked returns the factor that converts amounts in the currency `asset` is traded against,
+// e.g. its prices and profits, to CurrencyCode. pf.mu must be held.
+func (pf *Portfolio) reportingRateLocked(asset string) (float64, error) {
+	return pf.conversionRate(pf.currency(asset))
+}
+
+// reportingRate returns the factor that converts amounts in the currency `asset` is traded against to CurrencyCode.
+func (pf *Portfolio) reportingRate(asset string) (float64, error) {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	return pf.reportingRateLocked(asset)
+}
+
+// discoverPairs lists the pairs traded on the exchange through `handler`, by pair, and checks the assets in the
+// settings against them. It returns nil if they couldn't be listed, in which case assets are traded as configured.
+func (pf *Portfolio) discoverPairs(handler ExchangeHandler) map[string]PairInfo {
+	pairs, err := handler.Pairs(pf.ctx)
+	if err != nil {
+		log.Printf("Could not list the pairs traded on %s, using the built-in order limits: %v", pf.config.Exchange, err)
+		return nil
+	}
+	byPair := make(map[string]PairInfo, len(pairs))
+	traded := map[string]bool{}
+	for _, pair := range pairs {
+		byPair[strings.ToUpper(pair.Pair)] = pair
+		traded[strings.ToUpper(pair.Base)] = true
+	}
+	for _, code := range pf.config.AssetsToTrade {
+		if !traded[strings.ToUpper(code)] {
+			log.Printf("%s is in AssetsToTrade, but no pair on %s trades it", code, pf.config.Exchange)
+		}
+	}
+	return byPair
+}
+
+// completeAsset fills in the order limits of `asset` from the pairs listed by the exchange. It returns false if the
+// exchange doesn't list the asset's pair, so it can't be traded. If the pairs couldn't be listed (nil), the asset
+// keeps its built-in limits.
+func completeAsset(asset *Asset, pairs map[string]PairInfo) bool {
+	if pairs == nil {
+		return true
+	}
+	pair, ok := pairs[asset.Pair]
+	if !ok {
+		log.Printf("The exchange doesn't list the %s pair. %s won't be traded.", asset.Pair, asset.name)
+		return false
+	}
+	asset.minOrderVol = pair.MinVolume
+	asset.volumeStep = math.Pow10(-pair.VolumeScale)
+	asset.makerFee, asset.takerFee = pair.MakerFee, pair.TakerFee
+	if !pair.Active {
+		log.Printf("Trading %s is suspended on the exchange", asset.Pair)
+	}
+	return true
+}
+
+// Pairs lists every pair traded on the session's exchange, with its order limits and the account's fees.
+// The session must have been initialized.
+func (s *Session) Pairs(ctx context.Context) ([]PairInfo, error) {
+	// All handlers talk to the same exchange, so any one of them will do.
+	for _, handler := range s.portfolio.assets {
+		return handler.Pairs(ctx)
+	}
+	return nil, errors.New("the session has no exchange handlers, it hasn't been initialized")
+}
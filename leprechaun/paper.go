@@ -0,0 +1,213 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	luno "github.com/luno/luno-go"
+	luno_decimal "github.com/luno/luno-go/decimal"
+)
+
+// sandboxURLs are the API addresses of exchanges' sandbox (testnet) environments, by exchange name.
+// In sandbox mode, exchanges without one are traded on by a PaperExchangeHandler instead.
+var sandboxURLs = map[string]string{
+	// Luno does not offer a public sandbox.
+}
+
+// ErrPaperInsufficientBalance is returned by a PaperExchangeHandler when the simulated account can't pay for an order.
+var ErrPaperInsufficientBalance = errors.New("ErrInsufficientBalance: the simulated balance is too low for this order")
+
+// paperOrder is an order filled by a PaperExchangeHandler.
+type paperOrder struct {
+	base, counter, fee float64
+	completed          time.Time
+}
+
+// PaperAccount holds the simulated balances shared by the PaperExchangeHandlers of a session.
+type PaperAccount struct {
+	mu     sync.Mutex
+	fiat   float64
+	assets map[string]float64 // by asset code
+	orders map[string]paperOrder
+	nextID int
+}
+
+// NewPaperAccount returns a simulated account holding `fiat` and none of any asset.
+func NewPaperAccount(fiat float64) *PaperAccount {
+	return &PaperAccount{fiat: fiat, assets: make(map[string]float64), orders: make(map[string]paperOrder)}
+}
+
+// PaperExchangeHandler trades on a simulated account instead of placing orders, filling them at the exchange's
+// current prices and fees. Prices, candles and the exchange's clock still come from the exchange.
+type PaperExchangeHandler struct {
+	*LunoExchangeHandler
+	account *PaperAccount
+}
+
+// NewPaperExchangeHandler wraps `handler` so that its orders are simulated on `account`.
+func NewPaperExchangeHandler(handler *LunoExchangeHandler, account *PaperAccount) *PaperExchangeHandler {
+	return &PaperExchangeHandler{LunoExchangeHandler: handler, account: account}
+}
+
+// fill simulates a market order for `volume` of the asset. Buy orders are filled at the ask and sell orders at
+// the bid, adjusted by the taker fee, like ExpectedEntryPrice.
+func (handler *PaperExchangeHandler) fill(buy bool, volume float64) (order *OrderEntry, err error) {
+	kind := SignalShort
+	if buy {
+		kind = SignalLong
+	}
+	effective, err := handler.ExpectedEntryPrice(kind)
+	if err != nil {
+		return nil, err
+	}
+	price := effective / (1 + handler.takerFee)
+	if !buy {
+		price = effective / (1 - handler.takerFee)
+	}
+	now := handler.clock.Now()
+	code := handler.asset.code
+
+	account := handler.account
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	if buy {
+		if account.fiat < effective*volume {
+			return nil, ErrPaperInsufficientBalance
+		}
+		account.fiat -= effective * volume
+		account.assets[code] += volume
+	} else {
+		if account.assets[code] < volume {
+			return nil, ErrPaperInsufficientBalance
+		}
+		account.assets[code] -= volume
+		account.fiat += effective * volume
+	}
+	account.nextID++
+	id := fmt.Sprintf("PAPER-%d", account.nextID)
+	account.orders[id] = paperOrder{base: volume, counter: price * volume, fee: price * volume * handler.takerFee, completed: now}
+	log.Printf("Simulated %s order %s for %s at %s", kind, id, handler.messages.FormatAmount(volume, code),
+		handler.messages.FormatMoney(price, handler.asset.currency))
+	return &OrderEntry{handler.asset.name, id, formatTimestamp(now), price, volume}, nil
+}
+
+// GoLong simulates buying `volume` of the asset.
+func (handler *PaperExchangeHandler) GoLong(volume float64) (*OrderEntry, error) {
+	return handler.fill(true, volume)
+}
+
+// StopLong simulates selling the volume bought by a long position.
+func (handler *PaperExchangeHandler) StopLong(entry *Entry) (*StopOrderEntry, error) {
+	order, err := handler.fill(false, entry.PurchaseVolume)
+	if err != nil {
+		return nil, err
+	}
+	return &StopOrderEntry{*order}, nil
+}
+
+// GoShort simulates selling `volume` of the asset.
+func (handler *PaperExchangeHandler) GoShort(volume float64) (*OrderEntry, error) {
+	return handler.fill(false, volume)
+}
+
+// StopShort simulates buying back the volume sold by a short position.
+func (handler *PaperExchangeHandler) StopShort(entry *Entry) (*StopOrderEntry, error) {
+	order, err := handler.fill(true, entry.SaleVolume)
+	if err != nil {
+		return nil, err
+	}
+	return &StopOrderEntry{*order}, nil
+}
+
+// Withdraw simulates taking `amount` of fiat off the account.
+func (handler *PaperExchangeHandler) Withdraw(ctx context.Context, amount float64, destination, id string) (string, error) {
+	account := handler.account
+	account.mu.Lock()
+	defer account.mu.Unlock()
+	if account.fiat < amount {
+		return "", ErrPaperInsufficientBalance
+	}
+	account.fiat -= amount
+	log.Printf("Simulated withdrawing %s to %s", handler.messages.FormatMoney(amount, handler.asset.currency), destination)
+	return "PAPER-" + id, nil
+}
+
+// GetOrderDetails returns a simulated order. Simulated orders are filled immediately.
+func (handler *PaperExchangeHandler) GetOrderDetails(orderID string) (*luno.GetOrderResponse, error) {
+	handler.account.mu.Lock()
+	order, ok := handler.account.orders[orderID]
+	handler.account.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no simulated order %s", orderID)
+	}
+	// Volumes of a few satoshis need more places than decimal() keeps.
+	return &luno.GetOrderResponse{OrderId: orderID, State: luno.OrderStateComplete, Base: luno_decimal.NewFromFloat64(order.base, 8),
+		Counter: decimal(order.counter), FeeCounter: decimal(order.fee), CompletedTimestamp: luno.Time(order.completed)}, nil
+}
+
+// WaitForOrder returns a simulated order right away, since simulated orders are filled immediately.
+func (handler *PaperExchangeHandler) WaitForOrder(orderID string, timeout time.Duration) (*luno.GetOrderResponse, error) {
+	return handler.GetOrderDetails(orderID)
+}
+
+// PendingOrders returns no orders, since simulated orders are filled immediately.
+func (handler *PaperExchangeHandler) PendingOrders(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+// StopPendingOrder does nothing, since there are no pending simulated orders.
+func (handler *PaperExchangeHandler) StopPendingOrder(ctx context.Context, orderID string) bool {
+	return false
+}
+
+// ConfirmOrder marks the record's sale as done, since simulated orders are filled immediately.
+func (handler *PaperExchangeHandler) ConfirmOrder(rec *Entry) (done bool, err error) {
+	if rec.Status == 0 {
+		if _, err = handler.GetOrderDetails(rec.SaleID); err != nil {
+			return false, err
+		}
+		rec.Status = 1
+		done = true
+	}
+	return
+}
+
+// GetBalance returns the simulated balance of `asset`.
+func (handler *PaperExchangeHandler) GetBalance(asset *Asset) (float64, error) {
+	handler.account.mu.Lock()
+	defer handler.account.mu.Unlock()
+	asset.assetBalance, asset.fiatBalance = handler.account.assets[asset.code], handler.account.fiat
+	return asset.assetBalance, nil
+}
+
+// FiatBalance returns the simulated fiat balance.
+func (handler *PaperExchangeHandler) FiatBalance() (float64, error) {
+	handler.account.mu.Lock()
+	defer handler.account.mu.Unlock()
+	handler.asset.fiatBalance = handler.account.fiat
+	return handler.account.fiat, nil
+}
+
+// CheckBalanceSufficiency reports whether the simulated fiat balance covers a purchase.
+func (handler *PaperExchangeHandler) CheckBalanceSufficiency(asset *Asset, purchaseUnit float64) (bool, error) {
+	balance, err := handler.FiatBalance()
+	return balance >= purchaseUnit, err
+}
+
+// MarketStatus is always true, since simulated orders don't depend on the exchange trading.
+func (handler *PaperExchangeHandler) MarketStatus() (bool, error) {
+	return true, nil
+}
+
+// CanTrade is always true, since no orders reach the exchange.
+func (handler *PaperExchangeHandler) CanTrade() (bool, error) {
+	return true, nil
+}
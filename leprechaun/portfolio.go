@@ -2,10 +2,17 @@ package leprechaun
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
 	"time"
 
-	"github.com/luno/luno-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Order int
@@ -16,8 +23,80 @@ const (
 	SignalLong SIGNAL = iota
 	SignalShort
 	SignalWait
+	// SignalCloseLong closes the open long positions of the asset without opening a short one.
+	SignalCloseLong
+	// SignalCloseShort closes the open short positions of the asset without opening a long one.
+	SignalCloseShort
 )
 
+func (s SIGNAL) String() string {
+	switch s {
+	case SignalLong:
+		return "long"
+	case SignalShort:
+		return "short"
+	case SignalWait:
+		return "wait"
+	case SignalCloseLong:
+		return "close long"
+	case SignalCloseShort:
+		return "close short"
+	}
+	return fmt.Sprintf("SIGNAL(%d)", int(s))
+}
+
+// opens returns true for the signals that open a position.
+func (s SIGNAL) opens() bool {
+	return s == SignalLong || s == SignalShort
+}
+
+// analysisDays is the number of days of candles passed to analyzers.
+const analysisDays int64 = 5
+
+// ErrAnalyzerTimeout is returned when an analyzer doesn't emit a signal within AnalyzerTimeout seconds.
+var ErrAnalyzerTimeout = errors.New("the analyzer took too long to emit a signal")
+
+// defaultAnalysisWorkers is the number of assets analyzed at the same time when none is configured.
+const defaultAnalysisWorkers = 4
+
+// ripeCheckInterval is how often open positions are checked for ripeness, see Entry.IsRipe.
+const ripeCheckInterval = time.Minute
+
+// Bounds of the snooze between two trading rounds. A round that follows the last one immediately would
+// act on signals as fast as they arrive.
+const (
+	minSnooze = 10 * time.Second
+	maxSnooze = time.Hour
+)
+
+// Signal is a market signal for a single asset, as sent from the analysis loop to the trade loop.
+type Signal struct {
+	Kind  SIGNAL
+	Asset string
+	Time  time.Time // When the signal was generated.
+	// EntryPrice is the price a trade opened on this signal is expected to execute at, including the
+	// spread and the exchange's fees. It is zero for `SignalWait`. See ExchangeHandler.ExpectedEntryPrice.
+	EntryPrice float64
+	// Inputs are what the analyzer based the signal on. They are saved with the trade opened on it, see DecisionRecord.
+	Inputs *DecisionInputs
+	// Size is the share of the purchase unit a position opened on the signal is sized at, when the asset is traded
+	// by several strategies. Zero means the whole unit. See Portfolio.SetStrategies.
+	Size float64
+	// Confidence is how sure the analyzer is of the signal, from 0 to 1. Zero if it doesn't say, see ConfidentAnalyzer.
+	Confidence float64
+	span       trace.SpanContext // of the analysis the signal came from, see WithTracerProvider
+}
+
+// sizeOf returns the share of the purchase unit a position opened on the signal is sized at, by the weight of
+// its strategy and its confidence. See Configuration.ConfidenceTiers.
+func (s Signal) sizeOf(config *Configuration) float64 {
+	size, _ := config.confidenceSize(s.Confidence)
+	if s.Size > 0 {
+		size *= s.Size
+	}
+	return size
+}
+
 const (
 	OpenLongTrade Order = iota
 	OpenShortTrade
@@ -45,8 +124,8 @@ type Asset struct {
 	name           string
 	code           string
 	Pair           string
-	accountID      string
-	fiatAccountID  string
+	accountID      AccountID
+	fiatAccountID  AccountID
 	assetBalance   float64
 	fiatBalance    float64
 	sessionBalance float64 // Fiat balance locked to complete short orders
@@ -54,8 +133,29 @@ type Asset struct {
 	currency       string
 	spread         float64 // Bid-Ask spread
 	minOrderVol    float64 // Minimum volume that can be traded on the exchange
+	volumeStep     float64 // Order volumes are rounded down to a multiple of it. Zero if unknown, see completeAsset.
+	makerFee       float64 // as a fraction, when listed by the exchange. See completeAsset.
+	takerFee       float64
 }
 
+// NewAsset returns an asset traded against `currency` on the pair `code`+`currency`, e.g. XBTNGN.
+// `minOrderVolume` is the smallest volume of the asset the exchange accepts in an order.
+func NewAsset(name, code, currency string, minOrderVolume float64) *Asset {
+	return &Asset{name: name, code: code, currency: currency, Pair: code + currency, minOrderVol: minOrderVolume}
+}
+
+// Name returns the name of the asset, e.g. BITCOIN.
+func (a *Asset) Name() string { return a.name }
+
+// Code returns the code of the asset on the exchange, e.g. XBT.
+func (a *Asset) Code() string { return a.code }
+
+// Currency returns the fiat currency the asset is traded against, e.g. NGN.
+func (a *Asset) Currency() string { return a.currency }
+
+// MinOrderVolume returns the smallest volume of the asset the exchange accepts in an order.
+func (a *Asset) MinOrderVolume() float64 { return a.minOrderVol }
+
 type Entry struct {
 	Asset          string
 	PurchaseCost   float64
@@ -65,13 +165,14 @@ type Entry struct {
 	SalePrice      float64
 	SaleID         string
 	Status         int64
-	Timestamp      string
+	Timestamp      string // RFC3339, see formatTimestamp
 	PurchaseVolume float64
 	SaleVolume     float64
 	Profit         float64
 	Type           Order
 	TriggerPrice   float64
-	Updated        bool // order details have been updated with server side values
+	Updated        bool  // order details have been updated with server side values
+	Version        int64 // incremented each time the record is updated, see LedgerStore.UpdateRecord
 
 	// Update legder code first to reflect new struct fields.
 	LunoAssetFee float64
@@ -79,46 +180,144 @@ type Entry struct {
 	// PPercent  float64 // Profit Percentage
 }
 
+// ProfitAt returns the profit of closing the position at `exitPrice`, which should include the exchange's
+// fees (see ExchangeHandler.ExpectedEntryPrice). Fees paid when the position was opened are deducted too.
+func (rec Entry) ProfitAt(exitPrice float64) float64 {
+	fees := rec.LunoFiatFee + rec.LunoAssetFee*exitPrice
+	switch rec.Type {
+	case OpenLongTrade:
+		return exitPrice*rec.PurchaseVolume - rec.PurchaseCost - fees
+	case OpenShortTrade:
+		return rec.SaleCost - exitPrice*rec.SaleVolume - fees
+	}
+	return 0
+}
+
+// ClosedProfit returns the profit of a closed position: ProfitAt the price it was closed at, i.e. the sale price of
+// a long position or the purchase price of a short one.
+func (rec Entry) ClosedProfit() float64 {
+	if rec.Type == OpenShortTrade {
+		return rec.ProfitAt(rec.PurchasePrice)
+	}
+	return rec.ProfitAt(rec.SalePrice)
+}
+
+// Time returns the time at which the record's order was executed.
+func (rec Entry) Time() (time.Time, error) {
+	return parseTimestamp(rec.Timestamp)
+}
+
 // IsRipe checks whether a record is ready for sale per the user specified proift margin,.
-func (rec Entry) IsRipe(currentPrice float64, updateProfitMargin bool) bool {
+// The trigger price is recalculated with `profitMargin` if it is positive, since the user may have changed it.
+func (rec Entry) IsRipe(currentPrice, profitMargin float64) bool {
 	// checks whether an asset is ready for sale
 	if rec.Type == OpenLongTrade {
 		// to be sold at a higher price than it was purchased
-		if updateProfitMargin {
+		if profitMargin > 0 {
 			// user may have changed desired profitMargin. Recalculate
-			rec.TriggerPrice = rec.PurchasePrice + (rec.PurchasePrice * globalConfig.ProfitMargin)
+			rec.TriggerPrice = rec.PurchasePrice + (rec.PurchasePrice * profitMargin)
 		}
 		return currentPrice >= rec.TriggerPrice
 	} else if rec.Type == OpenShortTrade {
 		// to be repurchased at a lower price than it was sold
-		if updateProfitMargin {
+		if profitMargin > 0 {
 			// user may have changed desired profitMargin. Recalculate
-			rec.TriggerPrice = rec.PurchasePrice - (rec.PurchasePrice * globalConfig.ProfitMargin)
+			rec.TriggerPrice = rec.SalePrice - (rec.SalePrice * profitMargin)
 		}
-		return currentPrice >= rec.TriggerPrice
+		return currentPrice <= rec.TriggerPrice
 	}
 	return false
 }
 
 type Portfolio struct {
+	mu           sync.RWMutex // guards `market` and the recording of trades
 	assets       map[string]ExchangeHandler
+	assetInfo    map[string]*Asset
+	exchanges    map[string]ExchangeFactory // handler factories registered with WithExchange, by exchange name
+	market       map[string]AssetSnapshot   // last known prices and balances
+	events       *eventLog
 	config       *Configuration
-	ledger       *Ledger2
-	signalChan   chan SIGNAL
-	errChan      chan error
+	ledger       LedgerStore
+	candles      *CandleCache
+	tickFilters  map[string]*TickFilter
+	budget       *RateBudget // shared by the handlers of all assets
+	orderBooks   map[string]*OrderBookFeed
+	analyzers    map[string]Analyzer
+	intervals    map[string]time.Duration // interval each asset is analyzed at, if not the fetched one
+	signalChan   chan Signal
+	errs         *errorHandler
 	debugChan    chan string
 	waitLock     chan struct{}
-	waitInterval time.Duration
+	waitInterval time.Duration // snooze before the current round, see acquireWaitLock
+	messages     *Localizer
+	lastEntries  map[string]time.Time // when a position was last opened on each asset
+	lastEntry    time.Time            // when a position was last opened on any asset
+	fees         *feeBudget
+	feeTier      feeTierState // fee tier reached by the 30-day volume, see updateFeeTier
+	breaker      *circuitBreaker
+	balances     *balanceBook                       // balances predicted by the ledger, see checkBalances
+	exits        *exitGuard                         // which rule is closing each position
+	coldStart    *coldStart                         // keeps orders from being placed on stale data
+	progress     *progressBoard                     // progress of long operations, see Session.SetProgressReporter
+	maintenance  maintenance                        // whether trading is paused while the exchange is down for maintenance
+	httpClient   *http.Client                       // used by the exchange handlers' API clients, see Session.SetHTTPClient
+	latency      *latencyTracker                    // latency of the requests made through httpClient
+	tracer       *apiTracer                         // writes the requests made through httpClient to TraceFile, if set
+	spans        trace.Tracer                       // creates the spans of the trade pipeline, see WithTracerProvider
+	analyses     map[string]trace.SpanContext       // span of each asset's last analysis, until its signal is emitted
+	paper        *PaperAccount                      // the simulated account in sandbox mode, if the exchange has no sandbox
+	realized     map[string]float64                 // profit of the positions closed this session, by asset
+	emitting     map[string]bool                    // assets whose analyzer is computing a signal
+	overruns     map[string]int                     // analyses abandoned because the analyzer took too long, by asset
+	decisions    map[string]*DecisionInputs         // inputs of each asset's last signal, until it is emitted
+	onTrade      func(orderType Order, entry Entry) // called after a trade is recorded in the ledger
+	watchOnly    bool                               // Signals are logged but no orders are placed, e.g. because the API key is read-only.
+	watchlist    map[string]bool                    // assets that are tracked but not traded, see Session.SetWatching
+	clock        *skewClock
 	ctx          context.Context
 }
 
-func GetPortfolio(ctx context.Context) *Portfolio {
+// GetPortfolio returns an empty portfolio that trades with the settings in `config`.
+func GetPortfolio(ctx context.Context, config *Configuration) *Portfolio {
+	gapPolicy, err := parseGapPolicy(config.CandleGapPolicy)
+	if err != nil {
+		log.Printf("%v. Using %q instead.", err, gapPolicy)
+	}
 	return &Portfolio{
-		assets:     make(map[string]ExchangeHandler),
-		config:     globalConfig,
-		signalChan: make(chan SIGNAL),
-		waitLock:   make(chan struct{}, 1),
-		ctx:        ctx,
+		candles:     NewCandleCache(candleDuration, gapPolicy, config.HistoryLength),
+		assets:      make(map[string]ExchangeHandler),
+		assetInfo:   make(map[string]*Asset),
+		exchanges:   make(map[string]ExchangeFactory),
+		progress:    newProgressBoard(),
+		market:      make(map[string]AssetSnapshot),
+		lastEntries: make(map[string]time.Time),
+		realized:    make(map[string]float64),
+		emitting:    make(map[string]bool),
+		overruns:    make(map[string]int),
+		decisions:   make(map[string]*DecisionInputs),
+		spans:       trace.NewNoopTracerProvider().Tracer(instrumentationName),
+		analyses:    make(map[string]trace.SpanContext),
+		fees:        newFeeBudget(config.DailyFeeBudget),
+		balances:    newBalanceBook(config.BalanceTolerance),
+		exits:       newExitGuard(),
+		feeTier:     feeTierState{current: -1, notified: -1},
+		coldStart:   newColdStart(),
+		latency:     newLatencyTracker(),
+		tracer:      newAPITracer(config.TraceFile, config.APIKeyID, config.APIKeySecret),
+		breaker: newCircuitBreaker(config.CircuitBreakerMove, time.Duration(config.CircuitBreakerWindow)*time.Second,
+			time.Duration(config.CircuitBreakerCooldown)*time.Second),
+		tickFilters: make(map[string]*TickFilter),
+		budget:      NewRateBudget(config.RequestsPerMinute),
+		orderBooks:  make(map[string]*OrderBookFeed),
+		analyzers:   make(map[string]Analyzer),
+		intervals:   make(map[string]time.Duration),
+		watchlist:   make(map[string]bool),
+		config:      config,
+		signalChan:  make(chan Signal),
+		waitLock:    make(chan struct{}, 1),
+		messages:    NewLocalizer(config.Locale),
+		clock:       newSkewClock(),
+		ctx:         ctx,
 	}
 }
 
@@ -127,87 +326,821 @@ func (pf *Portfolio) Init() (err error) {
 	if len(pf.config.APIKeyID) == 0 || len(pf.config.APIKeySecret) == 0 {
 		return ErrInvalidAPICredentials
 	}
+	var pairs map[string]PairInfo // listed by the exchange, see discoverPairs
+	discovered := false
 	for _, asset := range DEFAULT_ASSETS { // TODO: LET USER DETERMINE ASSETS TO BE TRADED
-		asset.Pair = asset.code + DEFAULT_CURRENCY // E.g. XBTNGN
-		client := luno.NewClient()
-		client.SetAuth(pf.config.APIKeyID, pf.config.APIKeySecret)
+		pf.watchlist[asset.name] = pf.config.Watching(asset.code)
+		asset.currency = pf.config.CurrencyFor(asset.code)
+		asset.Pair = asset.code + asset.currency // E.g. XBTNGN or ETHXBT
+		// Used when the exchange's pairs can't be listed.
 		if asset.code == "XRP" {
 			asset.minOrderVol = 1
 		} else {
 			asset.minOrderVol = 0.0005
 		}
-		if err != nil {
-			return
+		if factory, ok := pf.exchanges[pf.config.Exchange]; ok {
+			handler, err := factory(pf.ctx, pf.config, asset, pf.clock)
+			if err != nil {
+				return err
+			}
+			if !discovered {
+				pairs, discovered = pf.discoverPairs(handler), true
+			}
+			if !completeAsset(asset, pairs) {
+				continue
+			}
+			pf.assets[asset.name] = handler
+			pf.assetInfo[asset.name] = asset
+			if err := pf.refreshBalance(asset.name); err != nil {
+				log.Printf("Could not get the %s balance: %v", asset.name, err)
+			}
+			continue
+		}
+		client := newLunoClient(pf.config.APIKeyID, pf.config.APIKeySecret, gatewayClient(pf.tracer.client(pf.latency.client(pf.httpClient, pf.clock), pf.clock)))
+		sandboxURL, hasSandbox := sandboxURLs[pf.config.Exchange]
+		if pf.config.Sandbox && hasSandbox {
+			client.SetBaseURL(sandboxURL)
+		}
+		handler := NewLunoExchangeHandler(client, asset, pf.clock, pf.ctx)
+		handler.messages = pf.messages
+		handler.budget = pf.budget
+		handler.progress = pf.progress
+		if !discovered {
+			pairs, discovered = pf.discoverPairs(handler), true
+		}
+		if !completeAsset(asset, pairs) {
+			continue
+		}
+		pf.tickFilters[asset.name] = NewTickFilter(asset.name, pf.config.MaxTickDeviation)
+		handler.tickFilter = pf.tickFilters[asset.name]
+		pf.assets[asset.name] = handler
+		if pf.config.Sandbox && !hasSandbox {
+			if pf.paper == nil {
+				log.Printf("%s has no sandbox. Orders will be simulated on an account holding %s.",
+					pf.config.Exchange, pf.messages.FormatMoney(pf.config.PaperBalance, pf.config.CurrencyCode))
+				pf.paper = NewPaperAccount(pf.config.PaperBalance)
+			}
+			pf.assets[asset.name] = NewPaperExchangeHandler(handler, pf.paper)
+		}
+		pf.assetInfo[asset.name] = asset
+		if err := pf.refreshBalance(asset.name); err != nil {
+			log.Printf("Could not get the %s balance: %v", asset.name, err)
+		}
+		if pf.config.StreamOrderBook {
+			feed, err := DialOrderBookFeed(pf.config.APIKeyID, pf.config.APIKeySecret, asset.Pair, pf.config.OrderBookDepth, pf.clock)
+			if err != nil {
+				log.Printf("Could not stream the %s order book: %v", asset.Pair, err)
+				continue
+			}
+			pf.orderBooks[asset.name] = feed
 		}
-		pf.assets[asset.name] = NewLunoExchangeHandler(client, asset, pf.ctx)
 	}
 	// init waitlock to allow initial round
 	pf.waitLock <- struct{}{}
 	return nil
 }
 
+// updateCandles retrieves the past `numDays` of candles for an asset from the exchange and adds them to the candle cache.
+func (pf *Portfolio) updateCandles(asset string, numDays int64) (candles []OHLC, err error) {
+	handler, ok := pf.assets[asset]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the portfolio", asset)
+	}
+	interval := pf.candles.Interval(asset)
+	data, err := handler.PreviousTrades(numDays, interval)
+	if err != nil {
+		return
+	}
+	pf.candles.Add(asset, pf.tickFilters[asset].FilterWicks(candlesToOHLC(data, interval))...)
+	return pf.candles.Candles(asset), nil
+}
+
+// SetAnalysisOptions passes options to the analyzer of `asset`. If the exchange doesn't supply candles of
+// `opts.Interval`, they are resampled from the longest supported interval it is a multiple of.
+// An error is returned if there is no such interval.
+func (pf *Portfolio) SetAnalysisOptions(asset string, opts *AnalysisOptions) error {
+	handler, ok := pf.assets[asset]
+	if !ok {
+		return fmt.Errorf("%s is not in the portfolio", asset)
+	}
+	analyzer, ok := pf.analyzers[asset]
+	if !ok {
+		return fmt.Errorf("no analyzer has been set for %s", asset)
+	}
+	if opts.Interval != 0 {
+		fetch, err := chooseInterval(handler.SupportedIntervals(), opts.Interval)
+		if err != nil {
+			return err
+		}
+		if fetch != opts.Interval {
+			log.Printf("%s candles aren't available at %s intervals. Resampling %s candles instead.", asset, opts.Interval, fetch)
+		}
+		pf.candles.SetInterval(asset, fetch)
+		pf.intervals[asset] = opts.Interval
+	}
+	return analyzer.SetOptions(opts)
+}
+
+// analysisInterval returns the interval the candles of `asset` are analyzed at.
+func (pf *Portfolio) analysisInterval(asset string) time.Duration {
+	if interval, ok := pf.intervals[asset]; ok {
+		return interval
+	}
+	return pf.candles.Interval(asset)
+}
+
+// cancelPendingOrders cancels the orders of every asset that haven't been filled yet. It returns how many
+// were cancelled and how many couldn't be. Orders that couldn't be listed count as failed.
+func (pf *Portfolio) cancelPendingOrders(ctx context.Context) (cancelled, failed int) {
+	for asset, handler := range pf.assets {
+		orderIDs, err := handler.PendingOrders(ctx)
+		if err != nil {
+			log.Printf("Could not list the pending %s orders: %v", asset, err)
+			failed++
+			continue
+		}
+		for _, orderID := range orderIDs {
+			if handler.StopPendingOrder(ctx, orderID) {
+				log.Printf("Cancelled pending %s order %s", asset, orderID)
+				cancelled++
+			} else {
+				log.Printf("Could not cancel pending %s order %s", asset, orderID)
+				failed++
+			}
+		}
+	}
+	return
+}
+
+// closeOrderBooks disconnects from the order book streams.
+func (pf *Portfolio) closeOrderBooks() {
+	for _, feed := range pf.orderBooks {
+		feed.Close()
+	}
+}
+
+// SetAnalyzer sets the analysis plugin that decides when to trade `asset`.
+// The state of a StatefulAnalyzer saved by a previous session is restored.
+func (pf *Portfolio) SetAnalyzer(asset string, analyzer Analyzer) {
+	pf.analyzers[asset] = analyzer
+	if stateful, ok := analyzer.(StatefulAnalyzer); ok {
+		if err := loadAnalyzerState(pf.config.AnalyzerStateDir(), asset, stateful); err != nil {
+			log.Printf("Could not restore the %s analyzer's state, it will start cold: %v", asset, err)
+		}
+	}
+}
+
+// saveAnalyzerStates persists the state of every StatefulAnalyzer in the portfolio.
+func (pf *Portfolio) saveAnalyzerStates() {
+	for asset, analyzer := range pf.analyzers {
+		stateful, ok := analyzer.(StatefulAnalyzer)
+		if !ok {
+			continue
+		}
+		if err := saveAnalyzerState(pf.config.AnalyzerStateDir(), asset, stateful); err != nil {
+			log.Printf("Could not save the %s analyzer's state: %v", asset, err)
+		}
+	}
+}
+
+// analyze passes the latest market data of an asset to its analyzer and returns the analyzer's signal.
+func (pf *Portfolio) analyze(ctx context.Context, asset string) (signal SIGNAL, err error) {
+	analyzer, ok := pf.analyzers[asset]
+	if !ok {
+		return SignalWait, fmt.Errorf("no analyzer has been set for %s", asset)
+	}
+	if pf.analyzerBusy(asset) {
+		pf.countOverrun(asset)
+		return SignalWait, fmt.Errorf("%w: it is still computing the signal of an earlier round", ErrAnalyzerTimeout)
+	}
+	if err = pf.backfill(asset); err != nil {
+		return SignalWait, err
+	}
+	_, fetch := pf.spans.Start(ctx, "fetch candles")
+	candles, err := pf.updateCandles(asset, analysisDays)
+	endSpan(fetch, err)
+	if err != nil {
+		return SignalWait, err
+	}
+	if interval := pf.analysisInterval(asset); interval != pf.candles.Interval(asset) {
+		if candles, err = pf.candles.Resampled(asset, interval); err != nil {
+			return SignalWait, err
+		}
+	}
+	if len(candles) < analyzer.Lookback() {
+		return SignalWait, fmt.Errorf("%s analyzer needs %d candles but only %d are available", asset, analyzer.Lookback(), len(candles))
+	}
+	closingPrices := make([]float64, 0, len(candles))
+	for _, candle := range candles {
+		closingPrices = append(closingPrices, candle.Close)
+	}
+	_, fetch = pf.spans.Start(ctx, "fetch price")
+	price, err := pf.assets[asset].CurrentPrice()
+	endSpan(fetch, err)
+	if err != nil {
+		return SignalWait, err
+	}
+	pf.updatePrice(asset, price)
+	pf.checkCircuitBreaker(asset, price)
+	inputs := captureInputs(asset, analyzer, pf.analysisInterval(asset), candles, closingPrices, price)
+	if err = analyzer.SetOHLC(candles); err != nil {
+		return SignalWait, err
+	}
+	if err = analyzer.SetClosingPrices(closingPrices); err != nil {
+		return SignalWait, err
+	}
+	if err = analyzer.SetCurrentPrice(price); err != nil {
+		return SignalWait, err
+	}
+	if mtAnalyzer, ok := analyzer.(MultiTimeframeAnalyzer); ok {
+		timeframes := map[time.Duration][]OHLC{}
+		for _, interval := range mtAnalyzer.Timeframes() {
+			if timeframes[interval], err = pf.candles.Resampled(asset, interval); err != nil {
+				return SignalWait, err
+			}
+		}
+		if err = mtAnalyzer.SetTimeframes(timeframes); err != nil {
+			return SignalWait, err
+		}
+		inputs.Timeframes = timeframes
+	}
+	if obAnalyzer, ok := analyzer.(OrderBookAnalyzer); ok && pf.orderBooks[asset] != nil {
+		features, err := pf.orderBooks[asset].Features()
+		if err == nil {
+			err = obAnalyzer.SetOrderBookFeatures(features)
+		}
+		if err == nil {
+			inputs.OrderBook = &features
+		}
+		if err != nil {
+			log.Printf("Order book features for %s are not available: %v", asset, err)
+		}
+	}
+	if cxAnalyzer, ok := analyzer.(ContextAnalyzer); ok {
+		market, err := pf.marketContext(asset, candles, price)
+		if err == nil {
+			err = cxAnalyzer.SetContext(market)
+		}
+		if err != nil {
+			return SignalWait, err
+		}
+		inputs.Market = &market
+	}
+	_, emit := pf.spans.Start(ctx, "emit signal")
+	signal, err = pf.emitSignal(asset, analyzer)
+	if err == nil {
+		emit.SetAttributes(attribute.String("signal", signal.String()))
+	}
+	endSpan(emit, err)
+	if err != nil {
+		return
+	}
+	completeInputs(inputs, analyzer, signal, pf.clock.Now())
+	if signal != SignalWait {
+		pf.countSignal(asset, analyzer)
+	}
+	pf.mu.Lock()
+	pf.decisions[asset] = inputs
+	pf.mu.Unlock()
+	return
+}
+
+// emitSignal returns the signal of an asset's analyzer. If the analyzer takes longer than AnalyzerTimeout, the round
+// is skipped for the asset with ErrAnalyzerTimeout instead of holding up the other assets. The analyzer's context is
+// cancelled, but a plugin that ignores it is left running and isn't asked for another signal until it returns.
+func (pf *Portfolio) emitSignal(asset string, analyzer Analyzer) (SIGNAL, error) {
+	timeout := time.Duration(pf.config.AnalyzerTimeout) * time.Second
+	if timeout <= 0 {
+		return analyzer.Emit(pf.ctx)
+	}
+	ctx, cancel := context.WithCancel(pf.ctx)
+	defer cancel()
+	type result struct {
+		signal SIGNAL
+		err    error
+	}
+	done := make(chan result, 1)
+	pf.mu.Lock()
+	pf.emitting[asset] = true
+	pf.mu.Unlock()
+	go func() {
+		signal, err := analyzer.Emit(ctx)
+		pf.mu.Lock()
+		pf.emitting[asset] = false
+		pf.mu.Unlock()
+		done <- result{signal, err}
+	}()
+	select {
+	case r := <-done:
+		return r.signal, r.err
+	case <-pf.clock.After(timeout):
+		pf.countOverrun(asset)
+		return SignalWait, fmt.Errorf("%w (%s)", ErrAnalyzerTimeout, timeout)
+	}
+}
+
+// analyzerBusy returns true if the analyzer of `asset` is still computing a signal.
+func (pf *Portfolio) analyzerBusy(asset string) bool {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	return pf.emitting[asset]
+}
+
+// countOverrun counts an analysis of `asset` abandoned because its analyzer took too long.
+func (pf *Portfolio) countOverrun(asset string) {
+	pf.mu.Lock()
+	pf.overruns[asset]++
+	pf.mu.Unlock()
+}
+
+// currency returns the code of the currency `asset` is traded against.
+func (pf *Portfolio) currency(asset string) string {
+	if info, ok := pf.assetInfo[asset]; ok && info.currency != "" {
+		return info.currency
+	}
+	return pf.config.CurrencyCode
+}
+
+// formatPrice writes a price of `asset` in the currency it is traded against, for the user to read.
+func (pf *Portfolio) formatPrice(asset string, price float64) string {
+	return pf.messages.FormatMoney(price, pf.currency(asset))
+}
+
+// updatePrice records the latest price (and spread, when the order book is streamed) of an asset for snapshots.
+func (pf *Portfolio) updatePrice(asset string, price float64) {
+	var spread float64
+	if feed, ok := pf.orderBooks[asset]; ok {
+		if features, err := feed.Features(); err == nil {
+			spread = features.Spread
+		}
+	}
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	state := pf.market[asset]
+	state.Price, state.Spread, state.Updated = price, spread, pf.clock.Now()
+	state.Heartbeats.PricePoll = state.Updated
+	pf.market[asset] = state
+}
+
+// heartbeat records that a loop did its work for `asset` now. `beat` picks the loop's heartbeat.
+// It must be called with pf.mu held.
+func (pf *Portfolio) heartbeat(asset string, beat func(*Heartbeats) *time.Time) {
+	state := pf.market[asset]
+	*beat(&state.Heartbeats) = pf.clock.Now()
+	pf.market[asset] = state
+}
+
+func analysisBeat(h *Heartbeats) *time.Time    { return &h.Analysis }
+func ledgerWriteBeat(h *Heartbeats) *time.Time { return &h.LedgerWrite }
+
+// refreshBalance fetches the asset and fiat balances of an asset's wallets for snapshots.
+func (pf *Portfolio) refreshBalance(asset string) error {
+	balance, err := pf.assets[asset].GetBalance(pf.assetInfo[asset])
+	if err != nil {
+		return err
+	}
+	fiat, err := pf.assets[asset].FiatBalance()
+	if err != nil {
+		return err
+	}
+	pf.checkBalances(asset, balance, fiat)
+	pf.coldStart.balanceChecked(asset)
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	state := pf.market[asset]
+	state.Balance, state.FiatBalance = balance, fiat
+	pf.market[asset] = state
+	return nil
+}
+
+// warmUp fetches enough past candles to satisfy the lookback of each asset's analyzer.
+func (pf *Portfolio) warmUp() {
+	for asset, analyzer := range pf.analyzers {
+		if _, ok := pf.assets[asset]; !ok {
+			continue
+		}
+		lookback := time.Duration(analyzer.Lookback()) * pf.analysisInterval(asset)
+		pf.candles.Reserve(asset, int(lookback/pf.candles.Interval(asset)))
+		numDays := int64((lookback + H24 - 1) / H24)
+		if numDays < analysisDays {
+			continue // fetched by the first analysis anyway
+		}
+		candles, err := pf.updateCandles(asset, numDays)
+		if err != nil {
+			log.Printf("Could not fetch past candles for the %s analyzer: %v", asset, err)
+			continue
+		}
+		log.Printf("Fetched %d past candles for the %s analyzer (needs %d)", len(candles), asset, analyzer.Lookback())
+	}
+}
+
+// backfill fetches the candles missed while the order book stream of `asset` was disconnected, so analyzers don't
+// see a discontinuity when it reconnects. Until it succeeds, no signals are emitted for the asset.
+func (pf *Portfolio) backfill(asset string) error {
+	feed, ok := pf.orderBooks[asset]
+	if !ok {
+		return nil
+	}
+	since, reconnected := feed.Reconnected()
+	if !reconnected {
+		return nil
+	}
+	numDays := int64(pf.clock.Now().Sub(since)/H24) + 1
+	if numDays <= analysisDays {
+		// The candles fetched for every analysis cover the gap.
+		feed.Backfilled()
+		return nil
+	}
+	log.Printf("The %s stream reconnected after a gap since %s. Backfilling %d days of candles.", asset, since.Format(timeFormat), numDays)
+	if _, err := pf.updateCandles(asset, numDays); err != nil {
+		return fmt.Errorf("could not backfill %s candles: %w", asset, err)
+	}
+	feed.Backfilled()
+	return nil
+}
+
 func (pf *Portfolio) analyzeMarkets() {
-	// for asset, handler := range pf.assets {
-	// 	currentPrice, err := handler.CurrentPrice()
-	// 	if err != nil {
-	// 		raise(err)
-	// 		continue
-	// 	}
-	// 	historicPrices, err := handler.PreviousPrices(108, M45)
-
-	// }
+	if len(pf.analyzers) == 0 {
+		pf.testSignals()
+		return
+	}
+	pf.warmUp()
+	for pf.ctx.Err() == nil {
+		assets := pf.assetOrder()
+		signals := pf.analyzeAll(assets)
+		pf.rankSignals(assets, signals)
+		for i, signal := range signals {
+			pf.emit(assets[i], signal)
+		}
+	}
+}
+
+// analyzeAll analyzes `assets` using a pool of AnalysisWorkers goroutines and returns their signals in the
+// same order. Assets being skipped because of an error, and assets whose analysis fails, get SignalWait.
+func (pf *Portfolio) analyzeAll(assets []string) []SIGNAL {
+	workers := pf.config.AnalysisWorkers
+	if workers == 0 {
+		workers = defaultAnalysisWorkers
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(assets) {
+		workers = len(assets)
+	}
+	signals := make([]SIGNAL, len(assets))
+	if pf.maintenance.active() {
+		for i := range signals {
+			signals[i] = SignalWait
+		}
+		select {
+		case <-pf.ctx.Done():
+		case <-pf.clock.After(maintenanceCheckInterval):
+		}
+		return signals
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				asset := assets[i]
+				if pf.errs.isSkipped(asset) {
+					signals[i] = SignalWait
+					continue
+				}
+				ctx, span := pf.spans.Start(pf.ctx, "analyze", trace.WithAttributes(attribute.String("asset", asset)))
+				signal, err := pf.analyze(ctx, asset)
+				endSpan(span, err)
+				pf.mu.Lock()
+				pf.analyses[asset] = span.SpanContext()
+				pf.mu.Unlock()
+				if err != nil {
+					pf.errs.report(asset, "analyze", err)
+					signal = SignalWait
+				} else {
+					pf.errs.resolve(asset, "analyze")
+					pf.mu.Lock()
+					pf.heartbeat(asset, analysisBeat)
+					pf.mu.Unlock()
+					pf.coldStart.analyzed(asset, pf.clock.Now(), pf.analysisInterval(asset))
+				}
+				signals[i] = signal
+			}
+		}()
+	}
+	for i := range assets {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return signals
+}
+
+// testSignals emits a fixed sequence of signals. It is used when no analyzers have been set.
+func (pf *Portfolio) testSignals() {
 	testSigs := []SIGNAL{SignalLong, SignalShort, SignalWait, SignalWait, SignalShort, SignalLong}
 	for _, sig := range testSigs {
-		pf.signalChan <- sig
-		time.Sleep(15 * time.Second)
+		for _, asset := range pf.assetOrder() {
+			// There is no market data to go stale, so each test signal counts as an analysis.
+			pf.coldStart.analyzed(asset, pf.clock.Now(), pf.analysisInterval(asset))
+			pf.emit(asset, sig)
+		}
+		<-pf.clock.After(15 * time.Second)
 	}
 }
 
+// emit sends a signal for an asset to the trade loop. Long and short signals are sent along with
+// the price a trade is expected to execute at right now, so the trade loop doesn't rely on a stale price.
+func (pf *Portfolio) emit(asset string, kind SIGNAL) {
+	signal := Signal{Kind: kind, Asset: asset, Time: pf.clock.Now()}
+	pf.mu.Lock()
+	if inputs := pf.decisions[asset]; inputs != nil && inputs.Signal == kind {
+		signal.Inputs, signal.Confidence = inputs, inputs.Confidence
+	}
+	delete(pf.decisions, asset)
+	signal.span = pf.analyses[asset]
+	delete(pf.analyses, asset)
+	pf.mu.Unlock()
+	if kind.opens() {
+		price, err := pf.assets[asset].ExpectedEntryPrice(kind)
+		if err != nil {
+			log.Printf("Could not estimate the entry price for %s. Will wait. Reason: %v", asset, err)
+			signal.Kind = SignalWait
+		}
+		signal.EntryPrice = price
+	}
+	if signal.Kind.opens() {
+		pf.allocate(&signal)
+	}
+	pf.signalChan <- signal
+}
+
+// acquireWaitLock lets the trade loop start its next round after snoozing. See snooze.
 func (pf *Portfolio) acquireWaitLock() {
-	time.Sleep(pf.waitInterval)
+	pf.waitInterval = pf.snooze()
+	log.Printf("Snoozing for %s before the next trading round", pf.waitInterval)
+	<-pf.clock.After(pf.waitInterval)
 	pf.waitLock <- struct{}{}
 }
 
+// snooze returns how long to wait between two trading rounds: a random pick of SnoozeTimes if RandomSnooze is
+// set, otherwise SnoozePeriod, in minutes. Snoozes outside minSnooze and maxSnooze are brought within them.
+func (pf *Portfolio) snooze() time.Duration {
+	minutes := pf.config.SnoozePeriod
+	if times := pf.config.SnoozeTimes; pf.config.RandomSnooze && len(times) > 0 {
+		minutes = times[rand.Intn(len(times))]
+	}
+	snooze := time.Duration(minutes) * time.Minute
+	if snooze < minSnooze || snooze > maxSnooze {
+		bounded := time.Duration(math.Min(math.Max(float64(snooze), float64(minSnooze)), float64(maxSnooze)))
+		log.Printf("A snooze of %s is out of bounds, snoozing for %s instead", snooze, bounded)
+		snooze = bounded
+	}
+	return snooze
+}
+
 func (pf *Portfolio) Trade() {
 	for {
 		<-pf.waitLock
+		pf.settleFees()
 
-		for _, handler := range pf.assets {
+		for range pf.assets {
 			signal := <-pf.signalChan
 			fmt.Printf("Received signal: %v\n", signal)
-			switch signal {
-			case SignalLong:
-				purchase, err := handler.GoLong(pf.config.AdjustedPurchaseUnit)
-				if err != nil {
-					// TODO: HANDLE ERRORS BETTER
-					fmt.Printf("Trading error: %s. Will skip\n", err)
-					continue
-				}
-				pf.openTrade(purchase, OpenLongTrade)
-			case SignalShort:
-				sale, err := handler.GoShort(pf.config.AdjustedPurchaseUnit)
-				if err != nil {
-					// TODO: HANDLE ERRORS BETTER
-					fmt.Printf("Trading error: %s. Will skip\n", err)
-					continue
-				}
-				pf.openTrade(sale, OpenShortTrade)
-			case SignalWait:
-				go pf.acquireWaitLock()
+			pf.act(signal)
+		}
+		// One round at a time.
+		go pf.acquireWaitLock()
+	}
+}
 
+// act opens or closes positions on `signal`, unless something stops it from being acted on.
+func (pf *Portfolio) act(signal Signal) {
+	ctx, span := pf.spans.Start(trace.ContextWithSpanContext(pf.ctx, signal.span), "decide",
+		trace.WithAttributes(attribute.String("asset", signal.Asset), attribute.String("signal", signal.Kind.String())))
+	defer span.End()
+	if pf.watchOnly && signal.Kind != SignalWait {
+		log.Printf("Watch-only mode: not acting on the %v signal for %s at %s", signal.Kind, signal.Asset,
+			pf.formatPrice(signal.Asset, signal.EntryPrice))
+		return
+	}
+	if pf.watching(signal.Asset) && signal.Kind != SignalWait {
+		log.Printf("%s is on the watchlist: not acting on the %v signal at %s", signal.Asset, signal.Kind,
+			pf.formatPrice(signal.Asset, signal.EntryPrice))
+		pf.events.record("watchlist", signal.Asset, fmt.Sprintf("%v signal at %s", signal.Kind,
+			pf.formatPrice(signal.Asset, signal.EntryPrice)))
+		return
+	}
+	if wait := pf.entryThrottle(signal.Asset); signal.Kind.opens() && wait > 0 {
+		log.Printf("Skipping the %v signal for %s: the next position may be opened in %s", signal.Kind, signal.Asset, wait.Round(time.Second))
+		pf.events.record("skipped", signal.Asset, fmt.Sprintf("Skipped a %v signal to avoid overtrading", signal.Kind))
+		return
+	}
+	if signal.Kind != SignalWait && pf.maintenance.active() {
+		log.Printf("Skipping the %v signal for %s: the exchange is down for maintenance", signal.Kind, signal.Asset)
+		return
+	}
+	if wait := pf.breaker.paused(signal.Asset, pf.clock.Now()); signal.Kind.opens() && wait > 0 {
+		log.Printf("Skipping the %v signal for %s: the circuit breaker has paused new positions for %s", signal.Kind, signal.Asset, wait.Round(time.Second))
+		return
+	}
+	if signal.Kind != SignalWait && !pf.warm(signal.Asset) {
+		log.Printf("Skipping the %v signal for %s: waiting for fresh market data and balances", signal.Kind, signal.Asset)
+		return
+	}
+	if signal.Kind.opens() && pf.balances.isPaused() {
+		log.Printf("Skipping the %v signal for %s: trading is paused until the balance anomaly is acknowledged", signal.Kind, signal.Asset)
+		return
+	}
+	if signal.Kind.opens() && !pf.confident(signal) {
+		return
+	}
+	if signal.Kind.opens() && pf.feeBudgetExhausted() {
+		log.Printf("Skipping the %v signal for %s: the daily fee budget has been used up", signal.Kind, signal.Asset)
+		return
+	}
+	handler := pf.assets[signal.Asset]
+	if signal.Kind.opens() && !pf.revalidate(handler, &signal) {
+		return
+	}
+	switch signal.Kind {
+	case SignalLong:
+		unit, err := pf.purchaseUnit(signal.Asset)
+		if err != nil {
+			pf.errs.report(signal.Asset, "size long trade", err)
+			return
+		}
+		unit, ok := pf.withinBudget(signal.Asset, unit*signal.sizeOf(pf.config))
+		if !ok {
+			return
+		}
+		intended := unit / signal.EntryPrice
+		volume, err := pf.orderVolume(signal.Asset, intended)
+		if err != nil {
+			pf.errs.report(signal.Asset, "size long trade", err)
+			return
+		}
+		book := pf.bookBefore(signal.Asset)
+		_, order := pf.spans.Start(ctx, "place order")
+		purchase, err := handler.GoLong(volume)
+		endSpan(order, err)
+		if err != nil {
+			pf.errs.report(signal.Asset, "open long trade", err)
+			return
+		}
+		_, record := pf.spans.Start(ctx, "record trade")
+		pf.openTrade(purchase, OpenLongTrade, signal.EntryPrice)
+		record.End()
+		pf.recordDecision(purchase.OrderID, OpenLongTrade, signal)
+		pf.tagTrade(purchase.OrderID, signal)
+		pf.recordEntry(signal.Asset)
+		pf.trackFee(signal.Asset, purchase.OrderID, purchase.Price)
+		pf.saveBook(book, purchase.OrderID)
+		pf.recordRounding(purchase, intended, signal.EntryPrice)
+	case SignalShort:
+		unit, err := pf.purchaseUnit(signal.Asset)
+		if err != nil {
+			pf.errs.report(signal.Asset, "size short trade", err)
+			return
+		}
+		unit *= signal.sizeOf(pf.config)
+		intended := unit / signal.EntryPrice
+		volume, err := pf.orderVolume(signal.Asset, intended)
+		if err != nil {
+			pf.errs.report(signal.Asset, "size short trade", err)
+			return
+		}
+		book := pf.bookBefore(signal.Asset)
+		_, order := pf.spans.Start(ctx, "place order")
+		sale, err := handler.GoShort(volume)
+		endSpan(order, err)
+		if err != nil {
+			pf.errs.report(signal.Asset, "open short trade", err)
+			return
+		}
+		_, record := pf.spans.Start(ctx, "record trade")
+		pf.openTrade(sale, OpenShortTrade, signal.EntryPrice)
+		record.End()
+		pf.recordDecision(sale.OrderID, OpenShortTrade, signal)
+		pf.tagTrade(sale.OrderID, signal)
+		pf.recordEntry(signal.Asset)
+		pf.trackFee(signal.Asset, sale.OrderID, sale.Price)
+		pf.saveBook(book, sale.OrderID)
+		pf.recordRounding(sale, intended, signal.EntryPrice)
+	case SignalCloseLong, SignalCloseShort:
+		pf.closeOnSignal(signal)
+	}
+}
+
+// revalidate checks the price of a signal generated more than SignalExpiry seconds ago, e.g. because
+// trading was held up by rate limits. It returns false if the price has since moved beyond PriceTolerance,
+// otherwise the signal's entry price is updated to the current one.
+func (pf *Portfolio) revalidate(handler ExchangeHandler, signal *Signal) (ok bool) {
+	age := pf.clock.Now().Sub(signal.Time)
+	if age <= time.Duration(pf.config.SignalExpiry)*time.Second || signal.EntryPrice <= 0 {
+		return true
+	}
+	price, err := handler.ExpectedEntryPrice(signal.Kind)
+	if err != nil {
+		pf.errs.report(signal.Asset, "revalidate signal", err)
+		return false
+	}
+	if move := math.Abs(price-signal.EntryPrice) / signal.EntryPrice; move > pf.config.PriceTolerance {
+		log.Printf("Dropping the %v signal for %s from %s ago: the price has moved %.2f%% from %s to %s",
+			signal.Kind, signal.Asset, age.Round(time.Second), move*100, pf.formatPrice(signal.Asset, signal.EntryPrice),
+			pf.formatPrice(signal.Asset, price))
+		pf.events.record("expired", signal.Asset, fmt.Sprintf("Dropped a stale %v signal", signal.Kind))
+		return false
+	}
+	signal.EntryPrice = price
+	return true
+}
+
+// purchaseUnit returns the amount to spend on a new position in `asset`. If PurchasePercent is set, it is that
+// share of the last known fiat balance, so positions grow and shrink with the account. A purchase unit
+// set for the asset itself takes precedence.
+//
+// In Compound mode the profit realized on an asset this session is added to its next position. Otherwise
+// the stake stays fixed: realized profits are set aside and left out of the balance positions are sized from.
+//
+// The amount is in the currency the asset is traded against. Purchase units are set in CurrencyCode, so they are
+// converted at the current rate for pairs such as ETHXBT, see conversionRate.
+func (pf *Portfolio) purchaseUnit(asset string) (float64, error) {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	rate, err := pf.reportingRateLocked(asset)
+	if err != nil {
+		return 0, err
+	}
+	if pf.config.PurchasePercent <= 0 || pf.config.assetSettings(asset).PurchaseUnit > 0 {
+		unit := pf.config.PurchaseUnitFor(asset)
+		if pf.config.Compound {
+			unit = math.Max(unit+pf.realized[asset], 0)
+		}
+		return unit / rate, nil
+	}
+	balance := pf.market[asset].FiatBalance
+	if !pf.config.Compound {
+		// Only the profits of the pairs traded against the same currency were paid into this balance.
+		currency := pf.currency(asset)
+		for other, profit := range pf.realized {
+			if pf.currency(other) == currency {
+				balance -= profit / rate
 			}
 		}
 	}
+	return math.Max(balance, 0) * pf.config.PurchasePercent / 100, nil
+}
+
+// RealizedProfit returns the profit of the positions closed this session, in CurrencyCode.
+func (pf *Portfolio) RealizedProfit() (profit float64) {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	for _, p := range pf.realized {
+		profit += p
+	}
+	return
 }
 
-func (pf *Portfolio) openTrade(order *OrderEntry, orderType Order) (entry Entry) {
+// entryThrottle returns how long to wait before a new position may be opened on `asset`, so a noisy analyzer
+// can't churn the account. It is zero when a position may be opened now.
+func (pf *Portfolio) entryThrottle(asset string) (wait time.Duration) {
+	now := pf.clock.Now()
+	if last, ok := pf.lastEntries[asset]; ok {
+		wait = last.Add(time.Duration(pf.config.MinEntryInterval) * time.Second).Sub(now)
+	}
+	if !pf.lastEntry.IsZero() {
+		if global := pf.lastEntry.Add(time.Duration(pf.config.MinGlobalInterval) * time.Second).Sub(now); global > wait {
+			wait = global
+		}
+	}
+	return wait
+}
+
+// recordEntry notes that a position has just been opened on `asset`.
+func (pf *Portfolio) recordEntry(asset string) {
+	now := pf.clock.Now()
+	pf.lastEntries[asset], pf.lastEntry = now, now
+}
+
+// openTrade records a newly opened position in the ledger. The position's trigger price is
+// computed from `entryPrice`, the expected execution price including spread and fees.
+func (pf *Portfolio) openTrade(order *OrderEntry, orderType Order, entryPrice float64) (entry Entry) {
+	entry.Asset, entry.ID, entry.Type = order.AssetName, order.OrderID, orderType
+	entry.Timestamp = order.Timestamp
+	if entryPrice <= 0 {
+		entryPrice = order.Price
+	}
 	switch orderType {
 	case OpenLongTrade:
 		// new position. added to ledger
 		entry.PurchasePrice = order.Price
 		entry.PurchaseCost = order.Price * order.Volume
 		entry.PurchaseVolume = order.Volume
-		entry.TriggerPrice = order.Price + (order.Price * globalConfig.ProfitMargin)
+		entry.TriggerPrice = entryPrice + (entryPrice * pf.config.ProfitMarginFor(entry.Asset))
 		// save to ledger
 
 	case OpenShortTrade:
@@ -215,17 +1148,30 @@ func (pf *Portfolio) openTrade(order *OrderEntry, orderType Order) (entry Entry)
 		entry.SalePrice = order.Price
 		entry.SaleVolume = order.Volume
 		entry.SaleCost = order.Price * order.Volume
-		entry.TriggerPrice = order.Price - (order.Price * globalConfig.ProfitMargin)
+		entry.TriggerPrice = entryPrice - (entryPrice * pf.config.ProfitMarginFor(entry.Asset))
 	}
 
 	if !entry.Updated {
 	}
 	pf.updateOrderDetails(&entry)
-	if !pf.ledger.isOpen {
-		pf.ledger.loadDatabase()
+	pf.mu.Lock()
+	err := pf.ledger.AddRecord(pf.ctx, entry)
+	if err == nil {
+		pf.heartbeat(entry.Asset, ledgerWriteBeat)
+	}
+	pf.mu.Unlock()
+	pf.balances.traded(assetCode(entry.Asset), pf.currency(entry.Asset), orderType, entry)
+	if err != nil {
+		pf.errs.report(entry.Asset, "record trade", err)
+	}
+	pf.events.record("trade", entry.Asset, fmt.Sprintf("Opened a %s position at %s", orderTypeName(orderType),
+		pf.formatPrice(entry.Asset, entryPrice)))
+	if pf.onTrade != nil {
+		pf.onTrade(orderType, entry)
+	}
+	if err := pf.refreshBalance(entry.Asset); err != nil {
+		log.Printf("Could not get the %s balance: %v", entry.Asset, err)
 	}
-	defer pf.ledger.Save()
-	pf.ledger.AddRecord(entry)
 
 	return entry
 }
@@ -236,70 +1182,163 @@ func (pf *Portfolio) closeTrade(entry *Entry, asset string, price float64, times
 		entry.SalePrice = price
 		entry.SaleVolume = volume
 		entry.SaleCost = price * volume
-		entry.Profit = entry.PurchaseCost - entry.SaleCost
-		entry.Status = 1
+		entry.SaleID = id
 
 	case CloseShortTrade:
 		entry.PurchasePrice = price
 		entry.PurchaseVolume = volume
 		entry.PurchaseCost = price * volume
-		entry.Profit = entry.PurchaseCost - entry.SaleCost
 
 	}
-	if !pf.ledger.isOpen {
-		pf.ledger.loadDatabase()
+	entry.Profit = entry.ClosedProfit()
+	pf.mu.Lock()
+	err := pf.ledger.CloseRecord(pf.ctx, entry)
+	var rateErr error
+	if err == nil {
+		var rate float64
+		if rate, rateErr = pf.reportingRateLocked(entry.Asset); rateErr == nil {
+			pf.realized[entry.Asset] += entry.Profit * rate
+		}
+		pf.heartbeat(entry.Asset, ledgerWriteBeat)
+	}
+	pf.mu.Unlock()
+	pf.errs.report(entry.Asset, "count realized profit", rateErr)
+	pf.balances.traded(assetCode(entry.Asset), pf.currency(entry.Asset), orderType, *entry)
+	if err != nil {
+		pf.errs.report(entry.Asset, "record trade", err)
+	}
+	pf.events.record("trade", entry.Asset, fmt.Sprintf("Closed a position at %s", pf.formatPrice(entry.Asset, price)))
+	if err == nil {
+		pf.scoreStrategy(*entry)
+	}
+	if pf.onTrade != nil {
+		pf.onTrade(orderType, *entry)
+	}
+}
+
+// minProfitReached reports whether closing `order` now would make at least the minimum profit set for its
+// asset, after fees. It is checked on top of the profit margin so that small positions don't close for
+// gains the fees eat up.
+func (pf *Portfolio) minProfitReached(handler ExchangeHandler, order Entry) bool {
+	minProfit := pf.config.MinProfitFor(order.Asset)
+	if minProfit <= 0 {
+		return true
+	}
+	exitPrice, err := pf.exitPrice(handler, order)
+	if err != nil {
+		log.Printf("Could not get the exit price of %s: %v", order.ID, err)
+		return false
+	}
+	rate, err := pf.reportingRate(order.Asset)
+	if err != nil {
+		log.Printf("Could not tell whether %s reached the minimum profit: %v", order.ID, err)
+		return false
+	}
+	return order.ProfitAt(exitPrice)*rate >= minProfit
+}
+
+// watching returns true if `asset` is on the watchlist, so no orders are placed for it.
+func (pf *Portfolio) watching(asset string) bool {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	return pf.watchlist[asset]
+}
+
+// orderTypeName returns a short description of an order type for messages.
+func orderTypeName(orderType Order) string {
+	switch orderType {
+	case OpenLongTrade, CloseLongTrade:
+		return "long"
+	case OpenShortTrade, CloseShortTrade:
+		return "short"
 	}
-	defer pf.ledger.Save()
-	pf.ledger.AddRecord(*entry)
+	return "unknown"
 }
 
+// CloseLongPositions closes the open long positions that are ripe, in a single pass over the assets.
 func (pf *Portfolio) CloseLongPositions() (err error) {
-	// TODO: Make async i.e. an infinite loop. sleep between each round
-	for asset, handler := range pf.assets {
-		longOrders, err := pf.ledger.GetRecordsByType(asset, OpenLongTrade)
+	if pf.watchOnly || pf.maintenance.active() {
+		return nil
+	}
+	for _, asset := range pf.assetOrder() {
+		handler := pf.assets[asset]
+		if pf.watching(asset) {
+			continue
+		}
+		longOrders, err := pf.ledger.OpenPositions(pf.ctx, asset)
 		if err != nil {
 			return err
 		}
 		for _, order := range longOrders {
+			if order.Type != OpenLongTrade {
+				continue
+			}
 			currentPrice, err := handler.CurrentPrice()
 			if err != nil {
 				return err
 			}
-			if order.IsRipe(currentPrice, true) {
-				// Sell Long Assets
-				handler.StopLong(&order)
+			if order.IsRipe(currentPrice, pf.config.ProfitMarginFor(order.Asset)) && pf.minProfitReached(handler, order) {
+				if _, _, err := pf.exit(asset, &order, exitProfitMargin); err != nil && !errors.Is(err, errExitClaimed) {
+					pf.errs.report(asset, "close ripe position", err)
+				}
 			}
 		}
 	}
 	return nil
 }
 
+// CloseShortPositions closes the open short positions that are ripe, in a single pass over the assets.
 func (pf *Portfolio) CloseShortPositions() (err error) {
-	for asset, handler := range pf.assets {
-		longOrders, err := pf.ledger.GetRecordsByType(asset, OpenShortTrade)
+	if pf.watchOnly || pf.maintenance.active() {
+		return nil
+	}
+	for _, asset := range pf.assetOrder() {
+		handler := pf.assets[asset]
+		if pf.watching(asset) {
+			continue
+		}
+		longOrders, err := pf.ledger.OpenPositions(pf.ctx, asset)
 		if err != nil {
 			return err
 		}
 		for _, order := range longOrders {
+			if order.Type != OpenShortTrade {
+				continue
+			}
 			currentPrice, err := handler.CurrentPrice()
 			if err != nil {
 				return err
 			}
-			if order.IsRipe(currentPrice, true) {
-				// Sell Long Assets
-				handler.StopLong(&order)
+			if order.IsRipe(currentPrice, pf.config.ProfitMarginFor(order.Asset)) && pf.minProfitReached(handler, order) {
+				if _, _, err := pf.exit(asset, &order, exitProfitMargin); err != nil && !errors.Is(err, errExitClaimed) {
+					pf.errs.report(asset, "close ripe position", err)
+				}
 			}
 		}
 	}
 	return nil
 }
 
+// monitorRipePositions closes the positions `closeRipe` finds ripe every ripeCheckInterval until the session ends.
+// `op` describes what it does when it fails.
+func (s *Session) monitorRipePositions(op string, closeRipe func() error) {
+	for {
+		s.errs.report("", op, closeRipe())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(ripeCheckInterval):
+		}
+	}
+}
+
 // UpdateOrderDetails updates order details
 func (pf *Portfolio) updateOrderDetails(entry *Entry) (updated bool) {
 	handler := pf.assets[entry.Asset]
-	orderDetails, err := handler.GetOrderDetails(entry.ID)
+	orderDetails, err := handler.WaitForOrder(entry.ID, time.Duration(pf.config.OrderTimeout)*time.Second)
 	if err != nil {
 		// return record unchanged
+		log.Printf("Could not get the details of order %s: %v", entry.ID, err)
 		return false
 	}
 	copy := *entry
@@ -308,16 +1347,16 @@ func (pf *Portfolio) updateOrderDetails(entry *Entry) (updated bool) {
 		copy.LunoFiatFee = orderDetails.FeeCounter.Float64()
 		copy.PurchaseCost = orderDetails.Counter.Float64()
 		copy.PurchaseVolume = orderDetails.Base.Float64()
-		copy.PurchasePrice = entry.PurchaseCost / entry.PurchaseVolume
+		copy.PurchasePrice = copy.PurchaseCost / copy.PurchaseVolume
 		copy.LunoAssetFee = orderDetails.FeeBase.Float64()
-		copy.Timestamp = orderDetails.CompletedTimestamp.String()
+		copy.Timestamp = formatTimestamp(time.Time(orderDetails.CompletedTimestamp))
 	case OpenShortTrade:
 		copy.LunoFiatFee = orderDetails.FeeCounter.Float64()
 		copy.SaleCost = orderDetails.Counter.Float64()
 		copy.SaleVolume = orderDetails.Base.Float64()
-		copy.SalePrice = entry.SaleCost / entry.SaleVolume
+		copy.SalePrice = copy.SaleCost / copy.SaleVolume
 		copy.LunoAssetFee = orderDetails.FeeBase.Float64()
-		copy.Timestamp = orderDetails.CompletedTimestamp.String()
+		copy.Timestamp = formatTimestamp(time.Time(orderDetails.CompletedTimestamp))
 
 	case CloseLongTrade:
 
@@ -328,9 +1367,9 @@ func (pf *Portfolio) updateOrderDetails(entry *Entry) (updated bool) {
 	fmt.Printf("%#v\n", entry)
 	fmt.Println("To:")
 	fmt.Printf("%#v\n", copy)
-	entry = &copy
+	*entry = copy
 	entry.Updated = true
-	return
+	return true
 }
 
 func (pf *Portfolio) compileReport() {
@@ -0,0 +1,132 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"testing"
+)
+
+// newTestPortfolio returns a portfolio trading in NGN on a temporary ledger, without exchange handlers.
+func newTestPortfolio(t *testing.T, config *Configuration) *Portfolio {
+	t.Helper()
+	if config.CurrencyCode == "" {
+		config.CurrencyCode = "NGN"
+	}
+	pf := GetPortfolio(context.Background(), config)
+	ledger := GetLedger2(TempLedger)
+	t.Cleanup(func() { ledger.Close() })
+	pf.ledger = ledger
+	pf.events = newEventLog(pf.clock)
+	pf.errs = newErrorHandler(LogNotifier{}, pf.messages, func() {}, pf.clock)
+	return pf
+}
+
+// openTestPosition records an open position of `orderType` on BITCOIN of `volume` at `price`.
+func openTestPosition(t *testing.T, pf *Portfolio, id string, orderType Order, price, volume float64) *Entry {
+	t.Helper()
+	entry := Entry{Asset: "BITCOIN", ID: id, Type: orderType, Status: int64(Open),
+		Timestamp: formatTimestamp(pf.clock.Now())}
+	if orderType == OpenLongTrade {
+		entry.PurchasePrice, entry.PurchaseVolume, entry.PurchaseCost = price, volume, price*volume
+	} else {
+		entry.SalePrice, entry.SaleVolume, entry.SaleCost = price, volume, price*volume
+	}
+	if err := pf.ledger.AddRecord(pf.ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	return &entry
+}
+
+func TestCloseTradeCompoundsProfit(t *testing.T) {
+	pf := newTestPortfolio(t, &Configuration{PurchaseUnit: 1000, Compound: true})
+
+	long := openTestPosition(t, pf, "long", OpenLongTrade, 100, 10)
+	long.LunoFiatFee = 5
+	pf.closeTrade(long, "BITCOIN", 120, "", 10, "long-exit", CloseLongTrade)
+	if want := 120*10 - 100*10 - 5.0; long.Profit != want {
+		t.Errorf("profit of the long position = %v, want %v", long.Profit, want)
+	}
+
+	short := openTestPosition(t, pf, "short", OpenShortTrade, 100, 10)
+	pf.closeTrade(short, "BITCOIN", 90, "", 10, "short-exit", CloseShortTrade)
+	if want := 100*10 - 90*10.0; short.Profit != want {
+		t.Errorf("profit of the short position = %v, want %v", short.Profit, want)
+	}
+
+	if got, want := pf.RealizedProfit(), 195+100.0; got != want {
+		t.Errorf("realized profit = %v, want %v", got, want)
+	}
+	unit, err := pf.purchaseUnit("BITCOIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1000 + 295.0; unit != want {
+		t.Errorf("compounded purchase unit = %v, want %v", unit, want)
+	}
+
+	for _, id := range []string{"long", "short"} {
+		rec, err := pf.ledger.GetRecordByID(pf.ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Profit <= 0 {
+			t.Errorf("winning position %s was recorded with a profit of %v", rec.ID, rec.Profit)
+		}
+	}
+}
+
+func TestCloseTradeKeepsStakeFixed(t *testing.T) {
+	pf := newTestPortfolio(t, &Configuration{PurchaseUnit: 1000})
+	long := openTestPosition(t, pf, "long", OpenLongTrade, 100, 10)
+	pf.closeTrade(long, "BITCOIN", 150, "", 10, "long-exit", CloseLongTrade)
+	unit, err := pf.purchaseUnit("BITCOIN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit != 1000 {
+		t.Errorf("purchase unit = %v without Compound, want the configured 1000", unit)
+	}
+}
+
+func TestClosedProfitMatchesProfitAt(t *testing.T) {
+	long := Entry{Type: OpenLongTrade, PurchaseVolume: 2, PurchaseCost: 200, LunoFiatFee: 1}
+	short := Entry{Type: OpenShortTrade, SaleVolume: 2, SaleCost: 200, LunoFiatFee: 1}
+	unrealizedLong, unrealizedShort := long.ProfitAt(110), short.ProfitAt(90)
+	long.SalePrice, short.PurchasePrice = 110, 90
+	if got := long.ClosedProfit(); got != unrealizedLong || got != 19 {
+		t.Errorf("ClosedProfit() of a long position = %v, want %v", got, unrealizedLong)
+	}
+	if got := short.ClosedProfit(); got != unrealizedShort || got != 19 {
+		t.Errorf("ClosedProfit() of a short position = %v, want %v", got, unrealizedShort)
+	}
+}
+
+func TestIsRipe(t *testing.T) {
+	long := Entry{Type: OpenLongTrade, PurchasePrice: 100, TriggerPrice: 110}
+	short := Entry{Type: OpenShortTrade, SalePrice: 100, TriggerPrice: 90}
+	tests := []struct {
+		entry  Entry
+		price  float64
+		margin float64
+		want   bool
+	}{
+		{long, 109, 0, false},
+		{long, 110, 0, true},
+		{long, 104, 0.05, false},
+		{long, 105, 0.05, true},
+		{short, 91, 0, false},
+		{short, 90, 0, true},
+		{short, 96, 0.05, false},
+		{short, 95, 0.05, true},
+		{short, 120, 0.05, false},
+	}
+	for _, test := range tests {
+		if got := test.entry.IsRipe(test.price, test.margin); got != test.want {
+			t.Errorf("IsRipe(%v, %v) of a %s position = %v, want %v", test.price, test.margin,
+				orderTypeName(test.entry.Type), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AssetOrder decides the order assets are traded in each round. It matters when the balance can't fund a position
+// in every asset: the assets traded first get it.
+type AssetOrder string
+
+const (
+	// OrderByPriority trades the assets in the order of Configuration.AssetPriority.
+	OrderByPriority AssetOrder = "priority"
+	// OrderByConfidence trades the assets whose signals the analyzers are most confident of first. Signals of
+	// equal confidence, e.g. from analyzers that don't say, are traded in the order of Configuration.AssetPriority.
+	OrderByConfidence AssetOrder = "confidence"
+)
+
+// Valid returns true if `o` is a known asset order.
+func (o AssetOrder) Valid() bool {
+	switch o {
+	case OrderByPriority, OrderByConfidence:
+		return true
+	}
+	return false
+}
+
+func parseAssetOrder(name string) (AssetOrder, error) {
+	if name == "" {
+		return OrderByPriority, nil
+	}
+	if o := AssetOrder(name); o.Valid() {
+		return o, nil
+	}
+	return OrderByPriority, fmt.Errorf("unknown asset order %q", name)
+}
+
+// priorityRank returns the position of `asset` in AssetPriority, which lists assets by name or code.
+// Assets that aren't listed rank after all those that are.
+func (c *Configuration) priorityRank(asset string) int {
+	for i, ranked := range c.AssetPriority {
+		if strings.EqualFold(ranked, asset) || strings.EqualFold(ranked, assetCode(asset)) {
+			return i
+		}
+	}
+	return len(c.AssetPriority)
+}
+
+// assetOrder returns the names of the portfolio's assets in the order of AssetPriority, then by name, so every
+// round and every loop over the assets visits them in the same order.
+func (pf *Portfolio) assetOrder() []string {
+	assets := make([]string, 0, len(pf.assets))
+	for asset := range pf.assets {
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		ri, rj := pf.config.priorityRank(assets[i]), pf.config.priorityRank(assets[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return assets[i] < assets[j]
+	})
+	return assets
+}
+
+// rankSignals reorders `assets` and their `signals`, which are in the order of assetOrder, by the confidence of
+// each signal if AssetOrder is "confidence". The sort is stable, so ties keep their priority order.
+func (pf *Portfolio) rankSignals(assets []string, signals []SIGNAL) {
+	if order, _ := parseAssetOrder(pf.config.AssetOrder); order != OrderByConfidence {
+		return
+	}
+	confidence := make(map[string]float64, len(assets))
+	pf.mu.Lock()
+	for i, asset := range assets {
+		if inputs := pf.decisions[asset]; inputs != nil && inputs.Signal == signals[i] {
+			confidence[asset] = inputs.Confidence
+		}
+	}
+	pf.mu.Unlock()
+	sort.Stable(rankedSignals{assets, signals, confidence})
+}
+
+// rankedSignals sorts assets and their signals together, most confident first.
+type rankedSignals struct {
+	assets     []string
+	signals    []SIGNAL
+	confidence map[string]float64
+}
+
+func (r rankedSignals) Len() int { return len(r.assets) }
+
+func (r rankedSignals) Less(i, j int) bool {
+	return r.confidence[r.assets[i]] > r.confidence[r.assets[j]]
+}
+
+func (r rankedSignals) Swap(i, j int) {
+	r.assets[i], r.assets[j] = r.assets[j], r.assets[i]
+	r.signals[i], r.signals[j] = r.signals[j], r.signals[i]
+}
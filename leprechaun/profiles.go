@@ -0,0 +1,99 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile is a named preset of trading settings, so new users don't have to tune each of them.
+// Selecting a profile with the -profile flag overwrites these settings; flags given explicitly
+// still take precedence over the profile.
+type Profile struct {
+	Description  string
+	ProfitMargin float64 // as a fraction, like Configuration.ProfitMargin
+	PurchaseUnit float64 // in DefaultCurrencyCode
+	// StopLossPercentage is the loss at which long and short positions are stopped out. Zero disables stop losses.
+	StopLossPercentage float64
+	MinEntryInterval   int32 // See Configuration.MinEntryInterval.
+	MinGlobalInterval  int32 // See Configuration.MinGlobalInterval.
+	Shortsell          bool
+	MinHitRate         float64 // See TradeSettings.AnalysisPlugin.
+}
+
+// Profiles are the presets that can be selected with the -profile flag.
+var Profiles = map[string]Profile{
+	"conservative": {
+		Description:  "Small positions, wide margins and tight stop losses. Long trades only.",
+		ProfitMargin: 0.05, PurchaseUnit: 2000, StopLossPercentage: 2,
+		MinEntryInterval: 60 * 60, MinGlobalInterval: 10 * 60,
+		MinHitRate: 0.55,
+	},
+	"balanced": {
+		Description:  "The default sizing and margins, with stop losses.",
+		ProfitMargin: 0.03, PurchaseUnit: 10000, StopLossPercentage: 5,
+		MinEntryInterval: 15 * 60, MinGlobalInterval: 60,
+		MinHitRate: 0.45,
+	},
+	"aggressive": {
+		Description:  "Large positions and thin margins, traded often. Short sells too.",
+		ProfitMargin: 0.01, PurchaseUnit: 25000, StopLossPercentage: 10,
+		MinEntryInterval: 5 * 60, MinGlobalInterval: 30,
+		Shortsell: true, MinHitRate: 0.4,
+	},
+}
+
+var profile = flag.String("profile", "", fmt.Sprintf("Use a preset of trading settings: %s. Other flags override the preset's settings.", strings.Join(ProfileNames(), ", ")))
+
+// ProfileNames returns the names of the available profiles in alphabetical order.
+func ProfileNames() (names []string) {
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// ApplyProfile overwrites the settings bundled in the profile called `name`.
+func (c *Configuration) ApplyProfile(name string) error {
+	p, ok := Profiles[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, choose one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	c.Profile = strings.ToLower(name)
+	c.ProfitMargin, c.PurchaseUnit = p.ProfitMargin, p.PurchaseUnit
+	c.Trade.ProfitMargin = p.ProfitMargin
+	c.Trade.LongTrade.StopLoss, c.Trade.LongTrade.StopLossPercentage = p.StopLossPercentage > 0, p.StopLossPercentage
+	c.Trade.ShortTrade.StopLoss, c.Trade.ShortTrade.StopLossPercentage = p.StopLossPercentage > 0, p.StopLossPercentage
+	c.MinEntryInterval, c.MinGlobalInterval = p.MinEntryInterval, p.MinGlobalInterval
+	c.Trade.Shortsell = p.Shortsell
+	c.Trade.AnalysisPlugin.MinHitRate = p.MinHitRate
+	return nil
+}
+
+// applyProfileFlag applies the profile selected with the -profile flag, if any, and then the
+// flags given explicitly so they override the profile.
+func (c *Configuration) applyProfileFlag() error {
+	if *profile == "" {
+		return nil
+	}
+	if err := c.ApplyProfile(*profile); err != nil {
+		return err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "profit-margin":
+			c.ProfitMargin = *profitMargin / 100
+		case "purchase-unit":
+			c.PurchaseUnit = *purchaseUnit
+		case "purchase-percent":
+			c.PurchasePercent = *purchasePercent
+		}
+	})
+	return nil
+}
@@ -0,0 +1,181 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Progress is how far a long operation, e.g. downloading historical candles or a hyperparameter search, has got.
+type Progress struct {
+	Operation string // e.g. "Downloading XBTNGN candles"
+	Step      string // What is being done now.
+	Done      int    // Steps completed.
+	Total     int    // Steps in the operation.
+	Stopped   bool   // The operation ended before all its steps were completed, e.g. because of an error.
+	Started   time.Time
+	Updated   time.Time
+}
+
+// Percent returns how much of the operation is complete, from 0 to 100.
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return 100 * float64(p.Done) / float64(p.Total)
+}
+
+// ETA estimates how long the rest of the operation takes, assuming the remaining steps take as long as the
+// completed ones did. It is zero until a step has been completed.
+func (p Progress) ETA() time.Duration {
+	if p.Done <= 0 || p.Done >= p.Total {
+		return 0
+	}
+	perStep := p.Updated.Sub(p.Started) / time.Duration(p.Done)
+	return perStep * time.Duration(p.Total-p.Done)
+}
+
+// Finished returns true once every step of the operation is complete, or it has stopped.
+func (p Progress) Finished() bool {
+	return p.Stopped || p.Done >= p.Total
+}
+
+// String describes the progress in a line, e.g. "Downloading XBTNGN candles: 40% (4/10), about 12s left".
+func (p Progress) String() string {
+	line := fmt.Sprintf("%s: %.0f%% (%d/%d)", p.Operation, p.Percent(), p.Done, p.Total)
+	if p.Stopped && p.Done < p.Total {
+		return line + ", stopped"
+	}
+	if eta := p.ETA().Round(time.Second); eta > 0 {
+		line += fmt.Sprintf(", about %s left", eta)
+	}
+	if p.Step != "" && !p.Finished() {
+		line += " - " + p.Step
+	}
+	return line
+}
+
+// ProgressReporter is told how far long operations have got, so users know the bot isn't hung.
+// Report is called as each step starts and once the operation has finished. It must not block.
+type ProgressReporter interface {
+	Report(p Progress)
+}
+
+// ProgressFunc is a function used as a ProgressReporter.
+type ProgressFunc func(p Progress)
+
+// Report calls f.
+func (f ProgressFunc) Report(p Progress) { f(p) }
+
+// ProgressWriter writes the progress of operations to `Out`, e.g. the terminal, a line as each step starts.
+type ProgressWriter struct {
+	Out io.Writer
+}
+
+// Report implements ProgressReporter.
+func (w ProgressWriter) Report(p Progress) {
+	fmt.Fprintln(w.Out, p)
+}
+
+// progressBoard keeps the latest progress of the operations in a session, logs it every tenth of the way and
+// passes it on to the session's ProgressReporter.
+type progressBoard struct {
+	mu         sync.Mutex
+	operations map[string]Progress // unfinished operations, by name
+	reporter   ProgressReporter    // set with Session.SetProgressReporter
+}
+
+func newProgressBoard() *progressBoard {
+	return &progressBoard{operations: make(map[string]Progress)}
+}
+
+// Report implements ProgressReporter.
+func (b *progressBoard) Report(p Progress) {
+	b.mu.Lock()
+	last, ok := b.operations[p.Operation]
+	if p.Finished() {
+		delete(b.operations, p.Operation)
+	} else {
+		b.operations[p.Operation] = p
+	}
+	reporter := b.reporter
+	b.mu.Unlock()
+	if !ok || p.Finished() || int(p.Percent()/10) > int(last.Percent()/10) {
+		log.Println(p)
+	}
+	if reporter != nil {
+		reporter.Report(p)
+	}
+}
+
+// setReporter sets the reporter the progress is passed on to.
+func (b *progressBoard) setReporter(reporter ProgressReporter) {
+	b.mu.Lock()
+	b.reporter = reporter
+	b.mu.Unlock()
+}
+
+// running returns the progress of the unfinished operations, oldest first.
+func (b *progressBoard) running() []Progress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	running := make([]Progress, 0, len(b.operations))
+	for _, p := range b.operations {
+		running = append(running, p)
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].Started.Before(running[j].Started) })
+	return running
+}
+
+// progressTracker counts the steps of one operation and reports each of them.
+type progressTracker struct {
+	reporter ProgressReporter
+	clock    Clock
+	progress Progress
+}
+
+// trackProgress starts tracking an operation of `total` steps. `reporter` may be nil, in which case nothing
+// is reported.
+func trackProgress(reporter ProgressReporter, clock Clock, operation string, total int) *progressTracker {
+	now := clock.Now()
+	return &progressTracker{reporter: reporter, clock: clock,
+		progress: Progress{Operation: operation, Total: total, Started: now, Updated: now}}
+}
+
+// step records that the step described by `next` is about to start, and reports the progress so far.
+func (t *progressTracker) step(next string) {
+	t.progress.Step, t.progress.Updated = next, t.clock.Now()
+	t.report()
+}
+
+// done records that a step has been completed. Progress is reported when the next step starts, or here
+// after the last step.
+func (t *progressTracker) done() {
+	t.progress.Done++
+	t.progress.Updated = t.clock.Now()
+	if t.progress.Finished() {
+		t.report()
+	}
+}
+
+// finish ends the operation. If not every step was completed, it is reported as stopped.
+func (t *progressTracker) finish() {
+	if t.progress.Finished() {
+		return
+	}
+	t.progress.Stopped, t.progress.Updated = true, t.clock.Now()
+	t.report()
+}
+
+func (t *progressTracker) report() {
+	if t.reporter != nil {
+		t.reporter.Report(t.progress)
+	}
+}
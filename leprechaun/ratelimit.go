@@ -0,0 +1,237 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// RequestClass groups exchange requests by how important they are. When the rate limit is under pressure, less
+// important classes wait so that more important ones are never starved.
+type RequestClass int
+
+const (
+	// ClassOrder is for placing new orders.
+	ClassOrder RequestClass = iota
+	// ClassStop is for closing positions, cancelling orders and checking on placed orders.
+	ClassStop
+	// ClassPricePolling is for fetching prices, candles and the order book.
+	ClassPricePolling
+	// ClassReporting is for balances, fees and other bookkeeping.
+	ClassReporting
+)
+
+func (class RequestClass) String() string {
+	switch class {
+	case ClassOrder:
+		return "order"
+	case ClassStop:
+		return "stop"
+	case ClassPricePolling:
+		return "price polling"
+	case ClassReporting:
+		return "reporting"
+	}
+	return "unknown"
+}
+
+// classReserve is the share of the budget each class must leave for more important classes. E.g. reporting
+// requests are only made while more than half of the budget is left.
+var classReserve = map[RequestClass]float64{
+	ClassOrder:        0,
+	ClassStop:         0.1,
+	ClassPricePolling: 0.25,
+	ClassReporting:    0.5,
+}
+
+// defaultRequestsPerMinute is the rate limit used when none is configured.
+const defaultRequestsPerMinute = 100
+
+// RateBudget shares the exchange's rate limit between all the modules of the bot (price polling, order
+// monitoring, balance refreshes...). It is a token bucket that refills at the allowed request rate.
+// Requests waiting for the budget are queued by class, so stop and close orders jump ahead of routine polling.
+type RateBudget struct {
+	mu       sync.Mutex
+	clock    Clock
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+	used     map[RequestClass]int
+	queue    requestQueue
+	seq      uint64
+	timer    ClockTimer // wakes the queue up once enough budget is available
+	stats    RequestQueueStats
+}
+
+// RequestQueueStats are metrics of the queue of requests waiting for the rate limit budget.
+type RequestQueueStats struct {
+	Depth    int // Requests waiting right now.
+	MaxDepth int // Most requests ever waiting at once.
+	// Waited, TotalWait and MaxWait are the number of requests of each class that were served and how long they waited.
+	Waited    map[RequestClass]int
+	TotalWait map[RequestClass]time.Duration
+	MaxWait   map[RequestClass]time.Duration
+}
+
+// waitingRequest is a request in the queue.
+type waitingRequest struct {
+	class    RequestClass
+	seq      uint64 // requests of the same class are served in order of arrival
+	enqueued time.Time
+	ready    chan struct{}
+	index    int
+}
+
+// requestQueue is a heap of waiting requests, most important first.
+type requestQueue []*waitingRequest
+
+func (q requestQueue) Len() int { return len(q) }
+func (q requestQueue) Less(i, j int) bool {
+	if q[i].class != q[j].class {
+		return q[i].class < q[j].class
+	}
+	return q[i].seq < q[j].seq
+}
+func (q requestQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *requestQueue) Push(x interface{}) {
+	r := x.(*waitingRequest)
+	r.index = len(*q)
+	*q = append(*q, r)
+}
+func (q *requestQueue) Pop() interface{} {
+	old := *q
+	r := old[len(old)-1]
+	*q, r.index = old[:len(old)-1], -1
+	return r
+}
+
+// NewRateBudget returns a budget allowing `requestsPerMinute` requests per minute, with bursts of up to a
+// tenth of that.
+func NewRateBudget(requestsPerMinute int) *RateBudget {
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = defaultRequestsPerMinute
+	}
+	capacity := float64(requestsPerMinute) / 10
+	if capacity < 1 {
+		capacity = 1
+	}
+	clock := realClock{}
+	return &RateBudget{clock: clock, capacity: capacity, tokens: capacity, rate: float64(requestsPerMinute) / 60,
+		last: clock.Now(), used: map[RequestClass]int{}, stats: RequestQueueStats{Waited: map[RequestClass]int{},
+			TotalWait: map[RequestClass]time.Duration{}, MaxWait: map[RequestClass]time.Duration{}}}
+}
+
+// setClock makes the budget refill and wait on `clock`.
+func (b *RateBudget) setClock(clock Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock, b.last = clock, clock.Now()
+}
+
+// refill must be called with b.mu held.
+func (b *RateBudget) refill() {
+	now := b.clock.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Acquire waits until a request of `class` may be made, or until the context is done.
+func (b *RateBudget) Acquire(ctx context.Context, class RequestClass) error {
+	b.mu.Lock()
+	b.seq++
+	r := &waitingRequest{class: class, seq: b.seq, enqueued: b.clock.Now(), ready: make(chan struct{})}
+	heap.Push(&b.queue, r)
+	if len(b.queue) > b.stats.MaxDepth {
+		b.stats.MaxDepth = len(b.queue)
+	}
+	b.dispatch()
+	b.mu.Unlock()
+
+	select {
+	case <-r.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if r.index < 0 {
+			// Served while the context was being cancelled.
+			return nil
+		}
+		heap.Remove(&b.queue, r.index)
+		b.dispatch()
+		return ctx.Err()
+	}
+}
+
+// dispatch serves waiting requests, most important first, while the budget allows. If the most important
+// request has to wait, so do all others. It must be called with b.mu held.
+func (b *RateBudget) dispatch() {
+	b.refill()
+	for len(b.queue) > 0 {
+		r := b.queue[0]
+		// A request may use the budget down to its class's reserve, but always at least one token.
+		floor := classReserve[r.class] * b.capacity
+		if floor > b.capacity-1 {
+			floor = b.capacity - 1
+		}
+		if b.tokens-1 < floor {
+			wait := time.Duration((floor + 1 - b.tokens) / b.rate * float64(time.Second))
+			if b.timer != nil {
+				b.timer.Stop()
+			}
+			b.timer = b.clock.AfterFunc(wait, func() {
+				b.mu.Lock()
+				defer b.mu.Unlock()
+				b.dispatch()
+			})
+			return
+		}
+		heap.Pop(&b.queue)
+		b.tokens--
+		b.used[r.class]++
+		waited := b.clock.Now().Sub(r.enqueued)
+		b.stats.Waited[r.class]++
+		b.stats.TotalWait[r.class] += waited
+		if waited > b.stats.MaxWait[r.class] {
+			b.stats.MaxWait[r.class] = waited
+		}
+		close(r.ready)
+	}
+}
+
+// Stats returns the metrics of the request queue.
+func (b *RateBudget) Stats() RequestQueueStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := RequestQueueStats{Depth: len(b.queue), MaxDepth: b.stats.MaxDepth, Waited: map[RequestClass]int{},
+		TotalWait: map[RequestClass]time.Duration{}, MaxWait: map[RequestClass]time.Duration{}}
+	for class, n := range b.stats.Waited {
+		stats.Waited[class] = n
+		stats.TotalWait[class] = b.stats.TotalWait[class]
+		stats.MaxWait[class] = b.stats.MaxWait[class]
+	}
+	return stats
+}
+
+// Used returns the number of requests made by each class.
+func (b *RateBudget) Used() map[RequestClass]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	used := make(map[RequestClass]int, len(b.used))
+	for class, n := range b.used {
+		used[class] = n
+	}
+	return used
+}
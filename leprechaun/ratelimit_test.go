@@ -0,0 +1,146 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestBudget returns a budget of 60 requests per minute, i.e. bursts of 6 and a token a second, on a
+// virtual clock.
+func newTestBudget() (*RateBudget, *VirtualClock) {
+	clock := NewVirtualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	budget := NewRateBudget(60)
+	budget.setClock(clock)
+	return budget, clock
+}
+
+// acquireAsync queues a request of `class` and returns the channel its result is sent on.
+func acquireAsync(ctx context.Context, budget *RateBudget, class RequestClass) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- budget.Acquire(ctx, class) }()
+	return done
+}
+
+// waitForDepth waits until `n` requests are queued.
+func waitForDepth(t *testing.T, budget *RateBudget, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for budget.Stats().Depth != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", budget.Stats().Depth, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateBudgetBurstAndRefill(t *testing.T) {
+	budget, clock := newTestBudget()
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		if err := budget.Acquire(ctx, ClassOrder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := acquireAsync(ctx, budget, ClassOrder)
+	waitForDepth(t, budget, 1)
+	select {
+	case <-done:
+		t.Fatal("a request was served beyond the burst")
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	stats := budget.Stats()
+	if stats.Waited[ClassOrder] != 7 || stats.MaxWait[ClassOrder] != time.Second {
+		t.Errorf("%d orders served, waiting up to %v, want 7 up to 1s", stats.Waited[ClassOrder], stats.MaxWait[ClassOrder])
+	}
+}
+
+func TestRateBudgetReserve(t *testing.T) {
+	budget, _ := newTestBudget()
+	ctx := context.Background()
+	// Reporting requests leave half of the budget to the others.
+	for i := 0; i < 3; i++ {
+		if err := budget.Acquire(ctx, ClassReporting); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report := acquireAsync(ctx, budget, ClassReporting)
+	waitForDepth(t, budget, 1)
+	// The reserve is only held back from less important requests.
+	if err := budget.Acquire(ctx, ClassStop); err != nil {
+		t.Fatal(err)
+	}
+	if used := budget.Used(); used[ClassReporting] != 3 || used[ClassStop] != 1 {
+		t.Errorf("used %v, want 3 reporting and 1 stop request", used)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := budget.Acquire(cancelled, ClassReporting); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() = %v on a cancelled context, want %v", err, context.Canceled)
+	}
+	select {
+	case err := <-report:
+		t.Errorf("a reporting request was served out of the reserve: %v", err)
+	default:
+	}
+}
+
+func TestRateBudgetPriority(t *testing.T) {
+	budget, clock := newTestBudget()
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		if err := budget.Acquire(ctx, ClassOrder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	polling := acquireAsync(ctx, budget, ClassPricePolling)
+	waitForDepth(t, budget, 1)
+	stop := acquireAsync(ctx, budget, ClassStop)
+	waitForDepth(t, budget, 2)
+
+	// The stop, which may use more of the budget, jumps ahead of the polling that was queued first.
+	clock.Advance(2 * time.Second)
+	if err := <-stop; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-polling:
+		t.Fatal("the polling request was served before the budget refilled past its reserve")
+	default:
+	}
+	clock.Advance(3 * time.Second)
+	if err := <-polling; err != nil {
+		t.Fatal(err)
+	}
+	if stats := budget.Stats(); stats.Depth != 0 || stats.MaxDepth != 2 {
+		t.Errorf("queue depth %d, at most %d, want 0 and 2", stats.Depth, stats.MaxDepth)
+	}
+}
+
+func TestRateBudgetCancelledWhileQueued(t *testing.T) {
+	budget, _ := newTestBudget()
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 6; i++ {
+		if err := budget.Acquire(ctx, ClassOrder); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := acquireAsync(ctx, budget, ClassOrder)
+	waitForDepth(t, budget, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() = %v, want %v", err, context.Canceled)
+	}
+	if depth := budget.Stats().Depth; depth != 0 {
+		t.Errorf("%d requests still queued after they were cancelled", depth)
+	}
+}
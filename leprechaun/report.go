@@ -0,0 +1,382 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dateLayout is how dates are given to and shown by the report command.
+const dateLayout = "2006-01-02"
+
+// ReportFormats are the output formats of the report command.
+var ReportFormats = []string{"text", "html", "pdf"}
+
+// AssetPerformance sums up the closed trades of one asset in a TradeReport.
+type AssetPerformance struct {
+	Asset  string
+	Trades int
+	Wins   int
+	Profit float64
+}
+
+// AssetPrices are the cached candles of an asset, see TradeReport.AddPrices.
+type AssetPrices struct {
+	Asset   string
+	Candles []OHLC // ordered by time
+}
+
+// EquityPoint is the total profit of the trades closed up to Time.
+type EquityPoint struct {
+	Time   time.Time
+	Profit float64
+}
+
+// TradeReport sums up the trades closed between two dates, read from the ledger and its archive.
+type TradeReport struct {
+	From, To time.Time // To is exclusive.
+	Currency string
+	Trades   []Entry // ordered by time
+	Assets   []AssetPerformance
+	// Analyzers compare the analyzers the trades were opened on. Their positions are counted from the day they
+	// were opened, so they may include positions that are still open.
+	Analyzers []AnalyzerPerformance
+	// Dust is the volume of each asset lost to rounding order volumes to the exchange's volume step, from the
+	// orders placed between the dates. It explains why balances drift from what the trades add up to.
+	Dust   []AssetDust
+	Profit float64
+	Wins   int
+	Equity []EquityPoint
+	// Prices are the candles of each asset in Assets, for the assets whose candles were cached.
+	Prices []AssetPrices
+	// TemplateDir is the folder whose report.html replaces the built-in template of the HTML report.
+	// See Configuration.TemplateDir.
+	TemplateDir string
+}
+
+// BuildReport collects the trades closed from `from` up to (but not including) `to` in `ledgers`.
+func BuildReport(ctx context.Context, ledgers []LedgerStore, from, to time.Time, currency string) (report TradeReport, err error) {
+	report.From, report.To, report.Currency = from, to, currency
+	type timedEntry struct {
+		Entry
+		time time.Time
+	}
+	var trades []timedEntry
+	for _, ledger := range ledgers {
+		records, err := ledger.AllRecords(ctx)
+		if err != nil {
+			return report, err
+		}
+		for _, rec := range records {
+			if rec.Status != int64(Closed) {
+				continue
+			}
+			t, err := rec.Time()
+			if err != nil || t.Before(from) || !t.Before(to) {
+				continue
+			}
+			trades = append(trades, timedEntry{rec, t})
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].time.Before(trades[j].time) })
+
+	byAsset := map[string]*AssetPerformance{}
+	for _, trade := range trades {
+		report.Trades = append(report.Trades, trade.Entry)
+		perf, ok := byAsset[trade.Asset]
+		if !ok {
+			perf = &AssetPerformance{Asset: trade.Asset}
+			byAsset[trade.Asset] = perf
+		}
+		perf.Trades++
+		perf.Profit += trade.Profit
+		if trade.Profit > 0 {
+			perf.Wins++
+			report.Wins++
+		}
+		report.Profit += trade.Profit
+		report.Equity = append(report.Equity, EquityPoint{Time: trade.time, Profit: report.Profit})
+	}
+	for _, perf := range byAsset {
+		report.Assets = append(report.Assets, *perf)
+	}
+	sort.Slice(report.Assets, func(i, j int) bool { return report.Assets[i].Asset < report.Assets[j].Asset })
+	if report.Analyzers, err = analyzerPerformance(ctx, ledgers, from, to); err != nil {
+		return report, err
+	}
+	report.Dust, err = dustDrift(ctx, ledgers, from, to)
+	return report, err
+}
+
+// AddPrices adds the candles of the report's assets that fall between its dates, from `candles` by asset
+// (see LoadCandles). Candles standing in for missing data are left out.
+func (r *TradeReport) AddPrices(candles map[string][]OHLC) {
+	r.Prices = nil
+	for _, perf := range r.Assets {
+		prices := AssetPrices{Asset: perf.Asset}
+		for _, candle := range candles[perf.Asset] {
+			if !candle.Missing && !candle.Time.Before(r.From) && candle.Time.Before(r.To) {
+				prices.Candles = append(prices.Candles, candle)
+			}
+		}
+		if len(prices.Candles) > 0 {
+			r.Prices = append(r.Prices, prices)
+		}
+	}
+}
+
+// WriteText writes the report as plain text.
+func (r TradeReport) WriteText(w io.Writer, messages *Localizer) error {
+	money := func(v float64) string { return messages.FormatMoney(v, r.Currency) }
+	fmt.Fprintln(w, messages.Sprintf(MsgReportTitle, r.From.Format(dateLayout), r.To.AddDate(0, 0, -1).Format(dateLayout)))
+	fmt.Fprintln(w, messages.Sprintf(MsgReportTotal, money(r.Profit), len(r.Trades), r.Wins))
+	fmt.Fprintln(w)
+	for _, perf := range r.Assets {
+		fmt.Fprintf(w, "%-14s %4d %4d %16s\n", perf.Asset, perf.Trades, perf.Wins, money(perf.Profit))
+	}
+	fmt.Fprintln(w)
+	if len(r.Analyzers) > 0 {
+		fmt.Fprintf(w, "%-24s %7s %6s %8s %16s %16s %16s\n", "Analyzer", "Signals", "Trades", "Hit rate", "Average win",
+			"Average loss", "Expectancy")
+		for _, perf := range r.Analyzers {
+			fmt.Fprintf(w, "%-24s %7d %6d %7.1f%% %16s %16s %16s\n", perf.Analyzer, perf.Signals, perf.Trades,
+				perf.HitRate()*100, money(perf.AverageWin()), money(perf.AverageLoss()), money(perf.Expectancy()))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(r.Dust) > 0 {
+		fmt.Fprintf(w, "%-14s %6s %20s %16s\n", "Rounding dust", "Orders", "Volume", "Value")
+		for _, dust := range r.Dust {
+			fmt.Fprintf(w, "%-14s %6d %20s %16s\n", dust.Asset, dust.Orders,
+				messages.FormatAmount(dust.Volume, assetCode(dust.Asset)), money(dust.Value))
+		}
+		fmt.Fprintln(w)
+	}
+	for _, trade := range r.Trades {
+		fmt.Fprintf(w, "%s  %-14s %-6s %16s\n", trade.Timestamp, trade.Asset, orderTypeName(trade.Type), money(trade.Profit))
+	}
+	return nil
+}
+
+// WriteHTML writes the report as a self-contained HTML page, with the equity curve, the profit of each
+// asset and the price of each asset drawn inline.
+func (r TradeReport) WriteHTML(w io.Writer, messages *Localizer) error {
+	tmpl, err := parseTemplate(r.TemplateDir, "report.html")
+	if err != nil {
+		return err
+	}
+	money := func(v float64) string { return messages.FormatMoney(v, r.Currency) }
+	type row struct{ Asset, Trades, Wins, Profit string }
+	type tradeRow struct{ Time, Asset, Position, Profit string }
+	type analyzerRow struct {
+		Analyzer, Signals, Trades, HitRate, AverageWin, AverageLoss, Expectancy string
+	}
+	type dustRow struct{ Asset, Orders, Volume, Value string }
+	type priceChart struct {
+		Asset string
+		Chart template.HTML
+	}
+	data := struct {
+		Title, Total string
+		EquityChart  template.HTML
+		AssetChart   template.HTML
+		PriceCharts  []priceChart
+		Assets       []row
+		Analyzers    []analyzerRow
+		Dust         []dustRow
+		Trades       []tradeRow
+	}{
+		Title:       messages.Sprintf(MsgReportTitle, r.From.Format(dateLayout), r.To.AddDate(0, 0, -1).Format(dateLayout)),
+		Total:       messages.Sprintf(MsgReportTotal, money(r.Profit), len(r.Trades), r.Wins),
+		EquityChart: equityCurveSVG(r.Equity, 720, 240),
+	}
+	var labels []string
+	var profits []float64
+	for _, perf := range r.Assets {
+		data.Assets = append(data.Assets, row{perf.Asset, fmt.Sprint(perf.Trades), fmt.Sprint(perf.Wins), money(perf.Profit)})
+		labels, profits = append(labels, perf.Asset), append(profits, perf.Profit)
+	}
+	data.AssetChart = barChartSVG(labels, profits, 720, 200)
+	for _, prices := range r.Prices {
+		data.PriceCharts = append(data.PriceCharts, priceChart{prices.Asset, priceChartSVG(prices.Candles, 720, 200)})
+	}
+	for _, perf := range r.Analyzers {
+		data.Analyzers = append(data.Analyzers, analyzerRow{perf.Analyzer, fmt.Sprint(perf.Signals), fmt.Sprint(perf.Trades),
+			fmt.Sprintf("%.1f%%", perf.HitRate()*100), money(perf.AverageWin()), money(perf.AverageLoss()), money(perf.Expectancy())})
+	}
+	for _, dust := range r.Dust {
+		data.Dust = append(data.Dust, dustRow{dust.Asset, fmt.Sprint(dust.Orders),
+			messages.FormatAmount(dust.Volume, assetCode(dust.Asset)), money(dust.Value)})
+	}
+	for _, trade := range r.Trades {
+		data.Trades = append(data.Trades, tradeRow{trade.Timestamp, trade.Asset, orderTypeName(trade.Type), money(trade.Profit)})
+	}
+	return tmpl.Execute(w, data)
+}
+
+// RunReport runs the `leprechaun report` command, e.g. `report --from 2024-01-01 --to 2024-03-31 --format html`,
+// and writes a report of the trades closed between the two dates (inclusive). It reads the ledger and its
+// archive directly, so no session needs to be running.
+func RunReport(ctx context.Context, args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	flags.SetOutput(out)
+	fromFlag := flags.String("from", "", "First day of the report, e.g. 2024-01-01. Defaults to 30 days ago.")
+	toFlag := flags.String("to", "", "Last day of the report, e.g. 2024-03-31. Defaults to today.")
+	format := flags.String("format", "text", fmt.Sprintf("Output format: %s. PDF reports need wkhtmltopdf or Chromium to be installed.",
+		strings.Join(ReportFormats, ", ")))
+	output := flags.String("output", "", "File to write the report to. Defaults to the screen.")
+	templates := flags.String("templates", "", "Folder whose report.html replaces the built-in template of HTML and PDF reports. Defaults to TemplateDir in the settings.")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *format == "pdf" {
+		// Checked before the ledger is read, so a missing converter doesn't surface after the work is done.
+		if *output == "" {
+			return errors.New("a PDF report needs a file to be written to, see -output")
+		}
+		if _, _, err = findPDFConverter(); err != nil {
+			return
+		}
+	}
+	c := &Configuration{}
+	if err = c.TestConfig(""); err != nil {
+		return
+	}
+	loc := c.Location()
+	today := wallClock.Now().In(loc)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	from, to := today.AddDate(0, 0, -30), today
+	if *fromFlag != "" {
+		if from, err = time.ParseInLocation(dateLayout, *fromFlag, loc); err != nil {
+			return
+		}
+	}
+	if *toFlag != "" {
+		if to, err = time.ParseInLocation(dateLayout, *toFlag, loc); err != nil {
+			return
+		}
+	}
+	if to.Before(from) {
+		return errors.New("the report must end after it starts")
+	}
+
+	ledger := GetLedger2(c.LedgerDatabase)
+	defer ledger.Close()
+	ledgers := []LedgerStore{ledger}
+	if path := ledger.ArchivePath(); path != "" && exists(path) {
+		archive := GetLedger2(path)
+		defer archive.Close()
+		ledgers = append(ledgers, archive)
+	}
+	report, err := BuildReport(ctx, ledgers, from, to.AddDate(0, 0, 1), c.CurrencyCode)
+	if err != nil {
+		return
+	}
+	candles, err := LoadCandles(c.CandleCacheFile())
+	if err != nil {
+		return
+	}
+	report.AddPrices(candles)
+	report.TemplateDir = c.TemplateDir
+	if *templates != "" {
+		report.TemplateDir = *templates
+	}
+
+	messages := NewLocalizer(c.Locale)
+	if *format == "pdf" {
+		return report.WritePDF(ctx, *output, messages)
+	}
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	switch *format {
+	case "text":
+		return report.WriteText(out, messages)
+	case "html":
+		return report.WriteHTML(out, messages)
+	}
+	return fmt.Errorf("unknown report format %q, choose one of %s", *format, strings.Join(ReportFormats, ", "))
+}
+
+// pdfConverter is a program that turns an HTML report into a PDF. It is given the HTML file and the PDF file
+// to write.
+type pdfConverter struct {
+	name string
+	args func(html, pdf string) []string
+}
+
+// pdfConverters are the programs tried, in order, to turn an HTML report into a PDF.
+var pdfConverters = []pdfConverter{
+	{"wkhtmltopdf", func(html, pdf string) []string { return []string{"--quiet", html, pdf} }},
+	{"chromium", chromePDFArgs},
+	{"chromium-browser", chromePDFArgs},
+	{"google-chrome", chromePDFArgs},
+}
+
+func chromePDFArgs(html, pdf string) []string {
+	return []string{"--headless", "--disable-gpu", "--print-to-pdf=" + pdf, "file://" + html}
+}
+
+// ErrNoPDFConverter is returned when no program that can print the HTML report to PDF is installed.
+var ErrNoPDFConverter = errors.New("PDF reports need wkhtmltopdf or Chromium to be installed; use -format html instead")
+
+// findPDFConverter returns the first of pdfConverters that is installed, and its path.
+// ErrNoPDFConverter is returned, listing the programs looked for, if none is.
+func findPDFConverter() (converter pdfConverter, program string, err error) {
+	var names []string
+	for _, converter := range pdfConverters {
+		if program, err = exec.LookPath(converter.name); err == nil {
+			return converter, program, nil
+		}
+		names = append(names, converter.name)
+	}
+	return converter, "", fmt.Errorf("%w (looked for %s in PATH)", ErrNoPDFConverter, strings.Join(names, ", "))
+}
+
+// WritePDF writes the report, charts included, to the PDF file at `path`. Leprechaun has no PDF renderer of
+// its own: the HTML report is printed to PDF by wkhtmltopdf or a headless Chromium, whichever is installed
+// (see pdfConverters). ErrNoPDFConverter is returned if neither is.
+func (r TradeReport) WritePDF(ctx context.Context, path string, messages *Localizer) (err error) {
+	converter, program, err := findPDFConverter()
+	if err != nil {
+		return
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return
+	}
+	html, err := os.CreateTemp("", "leprechaun-report-*.html")
+	if err != nil {
+		return
+	}
+	defer os.Remove(html.Name())
+	if err = r.WriteHTML(html, messages); err != nil {
+		html.Close()
+		return
+	}
+	if err = html.Close(); err != nil {
+		return
+	}
+	output, err := exec.CommandContext(ctx, program, converter.args(html.Name(), path)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s could not print the report: %w: %s", converter.name, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	pf := newTestPortfolio(t, &Configuration{PurchaseUnit: 1000})
+	long := openTestPosition(t, pf, "long", OpenLongTrade, 100, 2)
+	pf.closeTrade(long, "BITCOIN", 110, "", 2, "long-exit", CloseLongTrade)
+	short := openTestPosition(t, pf, "short", OpenShortTrade, 100, 2)
+	pf.closeTrade(short, "BITCOIN", 105, "", 2, "short-exit", CloseShortTrade)
+	openTestPosition(t, pf, "open", OpenLongTrade, 100, 2)
+
+	now := pf.clock.Now()
+	report, err := BuildReport(pf.ctx, []LedgerStore{pf.ledger}, now.Add(-time.Hour), now.Add(time.Hour), "NGN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Trades) != 2 || report.Wins != 1 {
+		t.Errorf("%d trades with %d wins, want 2 with 1 win", len(report.Trades), report.Wins)
+	}
+	if report.Profit != 10 {
+		t.Errorf("profit = %v, want 20 won on the long position less 10 lost on the short one", report.Profit)
+	}
+	if len(report.Assets) != 1 || report.Assets[0].Profit != report.Profit {
+		t.Errorf("profit by asset = %+v, want the whole profit on BITCOIN", report.Assets)
+	}
+	if last := report.Equity[len(report.Equity)-1]; last.Profit != report.Profit {
+		t.Errorf("equity curve ends at %v, want %v", last.Profit, report.Profit)
+	}
+}
@@ -0,0 +1,112 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"math"
+
+	"unit2/leprechaun/internal/rolling"
+)
+
+// RollingStats keeps the minimum, maximum, mean and standard deviation of the last `window` values pushed to it,
+// updating them in constant (amortized) time per value instead of rescanning the window.
+type RollingStats = rolling.Stats
+
+// NewRollingStats returns empty statistics over a window of `window` values (at least one).
+func NewRollingStats(window int) *RollingStats {
+	return rolling.New(window)
+}
+
+// MinMax64 returns the smallest and largest values in a list in a single pass, or zeros if it is empty.
+func MinMax64(a []float64) (min, max float64) {
+	if len(a) == 0 {
+		return
+	}
+	min, max = a[0], a[0]
+	for _, v := range a[1:] {
+		if v < min {
+			min = v
+		} else if v > max {
+			max = v
+		}
+	}
+	return
+}
+
+// candleExtremes returns the smallest and largest `price` of a list of candles, e.g. the lowest low and
+// highest high when `price` returns a candle's Low or High.
+func candleExtremes(candles []OHLC, price func(OHLC) float64) (min, max float64) {
+	for i, candle := range candles {
+		v := price(candle)
+		if i == 0 || v < min {
+			min = v
+		}
+		if i == 0 || v > max {
+			max = v
+		}
+	}
+	return
+}
+
+// SimpleMovingAverage returns the mean of each `period` consecutive prices, the first for the window ending at
+// prices[period-1].
+func SimpleMovingAverage(prices []float64, period int) (averages []float64) {
+	stats := NewRollingStats(period)
+	for _, price := range prices {
+		stats.Push(price)
+		if stats.Full() {
+			averages = append(averages, stats.Mean())
+		}
+	}
+	return
+}
+
+// BollingerBands returns the `period` simple moving average of the prices (the middle band) and the bands `k`
+// standard deviations above and below it, the first for the window ending at prices[period-1].
+func BollingerBands(prices []float64, period int, k float64) (middle, upper, lower []float64) {
+	stats := NewRollingStats(period)
+	for _, price := range prices {
+		stats.Push(price)
+		if !stats.Full() {
+			continue
+		}
+		mean, sd := stats.Mean(), stats.StdDev()
+		middle, upper, lower = append(middle, mean), append(upper, mean+k*sd), append(lower, mean-k*sd)
+	}
+	return
+}
+
+// RollingMinMax returns the smallest and largest of each `window` consecutive values, the first for the window
+// ending at values[window-1].
+func RollingMinMax(values []float64, window int) (mins, maxs []float64) {
+	stats := NewRollingStats(window)
+	for _, v := range values {
+		stats.Push(v)
+		if stats.Full() {
+			mins, maxs = append(mins, stats.Min()), append(maxs, stats.Max())
+		}
+	}
+	return
+}
+
+// AverageTrueRange returns the mean true range of the last `period` candles, a measure of how much the price
+// moves per candle. The true range of a candle is its high-low range stretched to cover any gap from the previous
+// close. It is zero if there are fewer than two candles.
+func AverageTrueRange(candles []OHLC, period int) float64 {
+	if period < 1 || len(candles) < 2 {
+		return 0
+	}
+	start := len(candles) - period
+	if start < 1 {
+		start = 1
+	}
+	sum := 0.0
+	for i := start; i < len(candles); i++ {
+		prevClose := candles[i-1].Close
+		high, low := math.Max(candles[i].High, prevClose), math.Min(candles[i].Low, prevClose)
+		sum += high - low
+	}
+	return sum / float64(len(candles)-start)
+}
@@ -0,0 +1,141 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// benchChart returns `n` prices of a random walk, about as long as a few months of minute candles.
+func benchChart(n int) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	prices := make([]float64, n)
+	price := 50000.0
+	for i := range prices {
+		price *= 1 + rng.NormFloat64()*0.002
+		prices[i] = price
+	}
+	return prices
+}
+
+// naiveRollingMinMax recomputes the minimum and maximum of every window from scratch, as pattern detection did
+// before RollingStats.
+func naiveRollingMinMax(values []float64, window int) (mins, maxs []float64) {
+	for end := window; end <= len(values); end++ {
+		mins, maxs = append(mins, Min64(values[end-window:end])), append(maxs, Max64(values[end-window:end]))
+	}
+	return
+}
+
+// naiveBollingerBands recomputes the mean and standard deviation of every window from scratch.
+func naiveBollingerBands(prices []float64, period int, k float64) (middle, upper, lower []float64) {
+	for end := period; end <= len(prices); end++ {
+		window := prices[end-period : end]
+		var sum float64
+		for _, price := range window {
+			sum += price
+		}
+		mean := sum / float64(period)
+		var sumSq float64
+		for _, price := range window {
+			sumSq += (price - mean) * (price - mean)
+		}
+		sd := math.Sqrt(sumSq / float64(period))
+		middle, upper, lower = append(middle, mean), append(upper, mean+k*sd), append(lower, mean-k*sd)
+	}
+	return
+}
+
+func sameFloats(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d values, want %d", name, len(got), len(want))
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-6*math.Max(math.Abs(want[i]), 1) {
+			t.Fatalf("%s[%d] = %v, want %v", name, i, got[i], want[i])
+		}
+	}
+}
+
+func TestRollingStatsMatchesRecomputation(t *testing.T) {
+	prices := benchChart(2000)
+	for _, window := range []int{1, 2, 20, 200} {
+		mins, maxs := RollingMinMax(prices, window)
+		wantMins, wantMaxs := naiveRollingMinMax(prices, window)
+		sameFloats(t, fmt.Sprintf("min/%d", window), mins, wantMins)
+		sameFloats(t, fmt.Sprintf("max/%d", window), maxs, wantMaxs)
+
+		middle, upper, lower := BollingerBands(prices, window, 2)
+		wantMiddle, wantUpper, wantLower := naiveBollingerBands(prices, window, 2)
+		sameFloats(t, fmt.Sprintf("middle/%d", window), middle, wantMiddle)
+		sameFloats(t, fmt.Sprintf("upper/%d", window), upper, wantUpper)
+		sameFloats(t, fmt.Sprintf("lower/%d", window), lower, wantLower)
+	}
+}
+
+func TestRollingStatsEmpty(t *testing.T) {
+	stats := NewRollingStats(0)
+	if stats.Min() != 0 || stats.Max() != 0 || stats.Mean() != 0 || stats.StdDev() != 0 {
+		t.Error("empty statistics should be zero")
+	}
+	stats.Push(3)
+	stats.Push(5)
+	if stats.Len() != 1 || stats.Min() != 5 || stats.Max() != 5 {
+		t.Errorf("a window below 1 should hold 1 value, got %d from %v to %v", stats.Len(), stats.Min(), stats.Max())
+	}
+}
+
+var benchWindows = []int{20, 200}
+
+func BenchmarkRollingMinMax(b *testing.B) {
+	prices := benchChart(50000)
+	for _, window := range benchWindows {
+		b.Run(fmt.Sprintf("rolling/window=%d", window), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				RollingMinMax(prices, window)
+			}
+		})
+		b.Run(fmt.Sprintf("naive/window=%d", window), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				naiveRollingMinMax(prices, window)
+			}
+		})
+	}
+}
+
+func BenchmarkBollingerBands(b *testing.B) {
+	prices := benchChart(50000)
+	for _, window := range benchWindows {
+		b.Run(fmt.Sprintf("rolling/window=%d", window), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				BollingerBands(prices, window, 2)
+			}
+		})
+		b.Run(fmt.Sprintf("naive/window=%d", window), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				naiveBollingerBands(prices, window, 2)
+			}
+		})
+	}
+}
+
+func BenchmarkMinMax64(b *testing.B) {
+	prices := benchChart(50000)
+	b.Run("single pass", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			MinMax64(prices)
+		}
+	})
+	b.Run("Min64 and Max64", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Min64(prices)
+			Max64(prices)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Table of the ledger that keeps the orders whose volume was rounded to the exchange's volume step, see
+// OrderRounding. It is keyed by order ID, like the order book snapshots.
+var orderRoundingTableInit = "CREATE TABLE ORDER_ROUNDING (ID PRIMARY KEY, ASSET, TIME, INTENDED, ACTUAL, PRICE)"
+
+const (
+	orderRoundingSaveOp = "INSERT OR REPLACE INTO ORDER_ROUNDING VALUES(?, ?, ?, ?, ?, ?)"
+	orderRoundingGetOp  = "SELECT ID, ASSET, TIME, INTENDED, ACTUAL, PRICE FROM ORDER_ROUNDING WHERE TIME >= ? AND TIME < ?"
+)
+
+// OrderRounding is an order whose volume was rounded down to the exchange's volume step. The difference, the
+// "dust", is why balances drift from what the purchase units add up to.
+type OrderRounding struct {
+	TradeID  string
+	Asset    string
+	Time     time.Time
+	Intended float64 // volume the position was sized at
+	Actual   float64 // volume ordered
+	Price    float64 // entry price the volume was sized at
+}
+
+// Dust returns the volume lost to rounding.
+func (r OrderRounding) Dust() float64 { return r.Intended - r.Actual }
+
+// AssetDust sums up the volume of one asset lost to rounding in a TradeReport.
+type AssetDust struct {
+	Asset  string
+	Orders int
+	Volume float64
+	Value  float64 // the volume at the prices it was sized at
+}
+
+// roundVolume rounds `volume` down to the asset's volume step, so the order doesn't spend more than the
+// purchase unit. It is returned as is if the step isn't known.
+func (a *Asset) roundVolume(volume float64) float64 {
+	if a.volumeStep <= 0 {
+		return volume
+	}
+	// The tolerance keeps volumes already on the step, e.g. 0.3/0.1, from being rounded a step down.
+	steps := math.Floor(volume/a.volumeStep + 1e-9)
+	return steps * a.volumeStep
+}
+
+// orderVolume returns the volume to order for a position in `asset` sized at `intended`, rounded to the exchange's
+// volume step. It fails if nothing is left of it.
+func (pf *Portfolio) orderVolume(asset string, intended float64) (float64, error) {
+	pf.mu.RLock()
+	info, ok := pf.assetInfo[asset]
+	pf.mu.RUnlock()
+	if !ok {
+		return intended, nil
+	}
+	volume := info.roundVolume(intended)
+	if volume <= 0 {
+		return 0, fmt.Errorf("a volume of %s is smaller than the exchange's volume step of %s",
+			pf.messages.FormatAmount(intended, info.code), pf.messages.FormatAmount(info.volumeStep, info.code))
+	}
+	return volume, nil
+}
+
+// recordRounding stores the intended volume of `order` in the ledger if it was rounded.
+func (pf *Portfolio) recordRounding(order *OrderEntry, intended, price float64) {
+	if order == nil || order.OrderID == "" || order.Volume == intended {
+		return
+	}
+	rounding := OrderRounding{TradeID: order.OrderID, Asset: order.AssetName, Time: pf.clock.Now(),
+		Intended: intended, Actual: order.Volume, Price: price}
+	pf.mu.Lock()
+	err := pf.ledger.RecordRounding(pf.ctx, rounding)
+	pf.mu.Unlock()
+	pf.errs.report(order.AssetName, "record rounding", err)
+}
+
+// RecordRounding stores an order whose volume was rounded.
+func (l *Ledger2) RecordRounding(ctx context.Context, rounding OrderRounding) (err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	_, err = l.db.ExecContext(ctx, orderRoundingSaveOp, rounding.TradeID, rounding.Asset, formatTimestamp(rounding.Time),
+		rounding.Intended, rounding.Actual, rounding.Price)
+	return
+}
+
+// Roundings returns the orders placed from `from` up to (but not including) `to` whose volume was rounded.
+func (l *Ledger2) Roundings(ctx context.Context, from, to time.Time) (roundings []OrderRounding, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	rows, err := l.db.QueryContext(ctx, orderRoundingGetOp, formatTimestamp(from), formatTimestamp(to))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rounding OrderRounding
+		var timestamp string
+		if err = rows.Scan(&rounding.TradeID, &rounding.Asset, &timestamp, &rounding.Intended, &rounding.Actual,
+			&rounding.Price); err != nil {
+			return
+		}
+		if rounding.Time, err = parseTimestamp(timestamp); err != nil {
+			return
+		}
+		roundings = append(roundings, rounding)
+	}
+	return roundings, rows.Err()
+}
+
+// dustDrift sums up the volume of each asset lost to rounding from `from` up to (but not including) `to`.
+func dustDrift(ctx context.Context, ledgers []LedgerStore, from, to time.Time) ([]AssetDust, error) {
+	byAsset := map[string]*AssetDust{}
+	seen := map[string]bool{}
+	for _, ledger := range ledgers {
+		roundings, err := ledger.Roundings(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, rounding := range roundings {
+			if seen[rounding.TradeID] {
+				continue
+			}
+			seen[rounding.TradeID] = true
+			dust, ok := byAsset[rounding.Asset]
+			if !ok {
+				dust = &AssetDust{Asset: rounding.Asset}
+				byAsset[rounding.Asset] = dust
+			}
+			dust.Orders++
+			dust.Volume += rounding.Dust()
+			dust.Value += rounding.Dust() * rounding.Price
+		}
+	}
+	var drift []AssetDust
+	for _, dust := range byAsset {
+		drift = append(drift, *dust)
+	}
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Asset < drift[j].Asset })
+	return drift, nil
+}
@@ -0,0 +1,120 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxFilteredTicks is the number of filtered events kept for inspection.
+const maxFilteredTicks = 100
+
+// FilteredTick records a price that was rejected as erroneous.
+type FilteredTick struct {
+	Asset     string
+	Time      time.Time
+	Price     float64 // The rejected price.
+	Reference float64 // The last price that was accepted before it.
+	Wick      bool    // The price was the high or low of a candle rather than a ticker price.
+}
+
+// TickFilter guards against bad ticks, i.e. prices that jump by more than `maxDeviation`
+// (as a fraction of the last accepted price) and immediately jump back. Such a price is held
+// back as suspect: if the next price confirms the move it is accepted, otherwise it is dropped.
+// Until the move is confirmed the last accepted price is reported instead, so a single bad
+// tick can never trigger a trade or a stop loss.
+type TickFilter struct {
+	mu           sync.Mutex
+	asset        string
+	maxDeviation float64
+	last         float64
+	suspect      float64
+	events       []FilteredTick
+}
+
+// NewTickFilter returns a filter for an asset's prices. A `maxDeviation` of zero disables filtering.
+func NewTickFilter(asset string, maxDeviation float64) *TickFilter {
+	return &TickFilter{asset: asset, maxDeviation: maxDeviation}
+}
+
+// Filter returns the price that should be used in place of `price`. `filtered` is true
+// if `price` is currently being held back as suspect.
+func (f *TickFilter) Filter(price float64, at time.Time) (accepted float64, filtered bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxDeviation <= 0 || f.last == 0 {
+		f.last = price
+		return price, false
+	}
+	if f.suspect != 0 {
+		suspect := f.suspect
+		f.suspect = 0
+		if !f.deviates(price, suspect) {
+			// The move has been confirmed by a second price.
+			f.last = price
+			return price, false
+		}
+		f.record(FilteredTick{Asset: f.asset, Time: at, Price: suspect, Reference: f.last})
+	}
+	if f.deviates(price, f.last) {
+		f.suspect = price
+		return f.last, true
+	}
+	f.last = price
+	return price, false
+}
+
+// Events returns the prices that have been rejected so far, oldest first.
+func (f *TickFilter) Events() []FilteredTick {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FilteredTick{}, f.events...)
+}
+
+// FilterWicks clamps the high and low of candles whose wicks reach further than `maxDeviation`
+// beyond the candle's body. Such wicks are almost always caused by a bad tick.
+func (f *TickFilter) FilterWicks(candles []OHLC) []OHLC {
+	if f.maxDeviation <= 0 {
+		return candles
+	}
+	for i, candle := range candles {
+		top, bottom := math.Max(candle.Open, candle.Close), math.Min(candle.Open, candle.Close)
+		if candle.High > top*(1+f.maxDeviation) {
+			f.recordWick(candle, candle.High, top)
+			candle.High = top
+		}
+		if candle.Low < bottom*(1-f.maxDeviation) {
+			f.recordWick(candle, candle.Low, bottom)
+			candle.Low = bottom
+		}
+		candle.setTrend()
+		candles[i] = candle
+	}
+	return candles
+}
+
+func (f *TickFilter) recordWick(candle OHLC, price, reference float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(FilteredTick{Asset: f.asset, Time: candle.Time, Price: price, Reference: reference, Wick: true})
+}
+
+// record must be called with f.mu held.
+func (f *TickFilter) record(event FilteredTick) {
+	log.Printf("Filtered a bad %s price of %.4f at %s (reference price %.4f).", event.Asset, event.Price,
+		event.Time.Format(time.RFC3339), event.Reference)
+	if len(f.events) >= maxFilteredTicks {
+		f.events = f.events[1:]
+	}
+	f.events = append(f.events, event)
+}
+
+func (f *TickFilter) deviates(price, reference float64) bool {
+	change := (price - reference) / reference
+	return change > f.maxDeviation || change < -f.maxDeviation
+}
@@ -4,20 +4,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	// clockSyncInterval is how often the local clock is checked against the exchange's.
+	clockSyncInterval = 30 * time.Minute
+	// cancelOrdersTimeout is how long the session may spend cancelling pending orders when it ends.
+	cancelOrdersTimeout = 30 * time.Second
 )
 
 var (
-	globalConfig             *Configuration
 	ErrInvalidAPICredentials error = errors.New("invalid api uid")
 )
 
 // Session defines parameters for a single trading session
 type Session struct {
+	mu           sync.RWMutex // guards the session stats and channels
 	startTime    time.Time
-	ledger       *Ledger2
+	ledger       LedgerStore
 	elapsed      time.Duration
 	sold         float64
 	purchased    float64
@@ -26,22 +38,53 @@ type Session struct {
 	config       *Configuration
 	exc          *Exchange
 	analysisFunc *Analyzer
+	notifier     Notifier
+	channels     *Channels // set with SetChannels
+	messages     *Localizer
+	events       *eventLog
 	debugChan    chan string
-	errChan      chan error
-	done         chan struct{}
+	errs         *errorHandler
+	cancel       context.CancelFunc
+	logFile      *os.File
+	telemetry    *sdktrace.TracerProvider // exports the spans of the trade pipeline, see startTelemetry
+	ctx          context.Context
 }
 
-func NewSession(ctx context.Context) *Session {
-	globalConfig = new(Configuration)
-	globalConfig.TestConfig(".") // test
+// NewSession returns a session that trades with the settings in `config`, customized by `opts`.
+func NewSession(ctx context.Context, config *Configuration, opts ...SessionOption) *Session {
+	ctx, cancel := context.WithCancel(ctx)
 	session := &Session{
-		portfolio: GetPortfolio(ctx),
-		config:    globalConfig,
+		portfolio: GetPortfolio(ctx, config),
+		config:    config,
+		notifier:  LogNotifier{},
+		messages:  NewLocalizer(config.Locale),
+		cancel:    cancel,
+		ctx:       ctx,
 	}
-	session.errChan = make(chan error)
+	session.portfolio.clock.setLocation(config.Location())
 	session.debugChan = make(chan string)
-	session.portfolio.errChan = session.errChan
+	session.errs = newErrorHandler(session.notifier, session.messages, cancel, session.portfolio.clock)
+	session.portfolio.errs = session.errs
+	session.errs.pause = session.portfolio.pauseForMaintenance
+	session.portfolio.messages = session.messages
+	session.events = newEventLog(session.portfolio.clock)
+	session.portfolio.events = session.events
+	session.errs.events = session.events
 	session.portfolio.debugChan = session.debugChan
+	session.portfolio.onTrade = session.recordTrade
+	session.startTelemetry()
+	for _, opt := range opts {
+		opt(session)
+	}
+	if logFile, err := config.openLogFile(); err != nil {
+		log.Printf("Could not open the log file: %v", err)
+	} else {
+		session.logFile = logFile
+		log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	}
+	log.Printf("Settings: %s", config.ConfigFile())
+	log.Printf("Data: %s", config.DataDir)
+	log.Printf("Logs: %s", config.LogDir)
 	return session
 }
 
@@ -50,8 +93,26 @@ func (s *Session) Initialize() (err error) {
 	if len(s.config.APIKeyID) == 0 || len(s.config.APIKeySecret) == 0 {
 		return ErrInvalidAPICredentials
 	}
-	s.ledger = GetLedger2()
+	if s.ledger == nil {
+		s.ledger = GetLedger2(s.config.LedgerDatabase)
+	}
 	s.portfolio.ledger = s.ledger
+	if ledger, ok := s.ledger.(*Ledger2); ok && s.config.ArchiveAfterDays > 0 {
+		before := s.portfolio.clock.Now().AddDate(0, 0, -int(s.config.ArchiveAfterDays))
+		if n, err := ledger.Archive(s.ctx, before); err != nil {
+			log.Printf("Could not archive old trades: %v", err)
+		} else if n > 0 {
+			log.Printf("Moved %d trades closed before %s to %s", n, before.Format("2006-01-02"), ledger.ArchivePath())
+		}
+	}
+	if ledger, ok := s.ledger.(*Ledger2); ok && s.config.OrderBookRetentionDays > 0 {
+		before := s.portfolio.clock.Now().AddDate(0, 0, -int(s.config.OrderBookRetentionDays))
+		if n, err := ledger.PruneOrderBooks(s.ctx, before); err != nil {
+			log.Printf("Could not delete old order book snapshots: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d order book snapshots taken before %s", n, before.Format("2006-01-02"))
+		}
+	}
 
 	err = s.portfolio.Init()
 	if err != nil {
@@ -77,24 +138,200 @@ func (s *Session) Initialize() (err error) {
 		log.Println("Could not initialize client. Reason: ", err)
 		return err
 	}
+	if err = s.syncClock(); err != nil {
+		log.Println("Could not compare the system clock with the exchange's. Reason: ", err)
+	}
+	s.checkTradingPermission()
+	return nil
+}
+
+// checkTradingPermission switches the session to watch-only mode if the API key can't place orders,
+// instead of failing on the first order.
+func (s *Session) checkTradingPermission() {
+	// All handlers use the same API key, so any one of them will do.
+	for _, handler := range s.portfolio.assets {
+		canTrade, err := handler.CanTrade()
+		if err != nil {
+			log.Println("Could not check the API key's permissions. Reason: ", err)
+			return
+		}
+		if !canTrade {
+			s.portfolio.watchOnly = true
+			s.events.record("watch-only", "", s.messages.Sprintf(MsgReadOnlyKeySubject))
+			msg := s.messages.Sprintf(MsgReadOnlyKey)
+			if err = s.notifier.Notify(s.messages.Sprintf(MsgReadOnlyKeySubject), msg); err != nil {
+				log.Println(msg)
+			}
+		}
+		return
+	}
+}
+
+// WatchOnly returns true if the session only watches the markets without trading.
+func (s *Session) WatchOnly() bool {
+	return s.portfolio.watchOnly
+}
+
+// SetWatching puts `asset` on the watchlist, or promotes it from the watchlist to trading if `watch` is false.
+// It takes effect from the next signal. The settings aren't changed; set AssetSettings.Watch to keep the change
+// in later sessions.
+func (s *Session) SetWatching(asset string, watch bool) error {
+	pf := s.portfolio
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if _, ok := pf.assets[asset]; !ok {
+		return fmt.Errorf("%s is not in the portfolio", asset)
+	}
+	if pf.watchlist[asset] != watch {
+		pf.watchlist[asset] = watch
+		msg := fmt.Sprintf("Promoted %s from the watchlist to trading", asset)
+		if watch {
+			msg = fmt.Sprintf("Moved %s to the watchlist. No orders will be placed for it", asset)
+		}
+		log.Println(msg)
+		s.events.record("watchlist", asset, msg)
+	}
 	return nil
 }
 
+// syncClock checks the local clock against the exchange's clock.
+func (s *Session) syncClock() (err error) {
+	// All handlers talk to the same exchange, so any one of them will do.
+	for _, handler := range s.portfolio.assets {
+		threshold := time.Duration(s.config.MaxClockSkew) * time.Second
+		_, err = syncClock(s.portfolio.clock, handler, threshold, s.config.CompensateClockSkew)
+		return
+	}
+	return
+}
+
+// monitorClock periodically re-checks the clock skew until the session ends.
+func (s *Session) monitorClock() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(clockSyncInterval):
+			s.errs.report("", "sync clock", s.syncClock())
+		}
+	}
+}
+
 func (s *Session) Start() {
-	s.startTime = time.Now()
-	go s.portfolio.analyzeMarkets()
-	go s.portfolio.Trade()
-	go s.portfolio.CloseLongPositions()
-	go s.portfolio.CloseShortPositions()
-	<-s.done
-	s.elapsed = time.Since(s.startTime)
-	fmt.Printf("Session duration: %s/n", s.elapsed)
-	fmt.Printf("Total sold: %.2f/n", s.sold)
-	fmt.Printf("Total purchased: %.2f/n", s.purchased)
+	if err := s.loadStats(); err != nil {
+		log.Printf("Could not load the statistics of the previous session: %v", err)
+	}
+	s.mu.Lock()
+	s.startTime = s.portfolio.clock.Now()
+	s.mu.Unlock()
+	fmt.Println(s.messages.Sprintf(MsgSessionStarted, s.startTime.Format("Mon Jan 2 15:04:05 MST 2006")))
+	go s.supervise("error handler", func() { s.errs.run(s.ctx, s.debugChan) })
+	go s.supervise("market analysis loop", s.portfolio.analyzeMarkets)
+	go s.supervise("trade loop", s.portfolio.Trade)
+	go s.supervise("long position monitor", func() {
+		s.monitorRipePositions("close long positions", s.portfolio.CloseLongPositions)
+	})
+	go s.supervise("short position monitor", func() {
+		s.monitorRipePositions("close short positions", s.portfolio.CloseShortPositions)
+	})
+	go s.supervise("clock monitor", s.monitorClock)
+	go s.supervise("holding period monitor", s.monitorHoldingPeriods)
+	go s.supervise("stale position monitor", s.monitorStalePositions)
+	go s.supervise("balance monitor", s.monitorBalances)
+	go s.supervise("latency monitor", s.monitorLatency)
+	go s.supervise("fee tier monitor", s.monitorFeeTiers)
+	go s.supervise("profit withdrawals", s.monitorWithdrawals)
+	go s.supervise("exchange status monitor", s.monitorExchange)
+	go s.supervise("daily summary", s.dailySummary)
+	for asset := range s.portfolio.orderBooks {
+		asset := asset
+		go s.supervise(asset+" order book feed", func() { s.monitorOrderBook(asset) })
+	}
+	<-s.ctx.Done()
+	if s.config.CancelOrdersOnExit {
+		s.cancelPendingOrders()
+	}
+	s.portfolio.closeOrderBooks()
+	s.portfolio.tracer.close()
+	s.stopTelemetry()
+	s.portfolio.saveAnalyzerStates()
+	if err := s.portfolio.candles.Save(s.config.CandleCacheFile()); err != nil {
+		log.Printf("Could not save the candle cache: %v", err)
+	}
+	if err := s.saveStats(); err != nil {
+		log.Printf("Could not save the session statistics: %v", err)
+	}
+	s.elapsed = s.portfolio.clock.Now().Sub(s.startTime)
+	fmt.Println(s.messages.Sprintf(MsgSessionDuration, s.elapsed))
+	fmt.Println(s.messages.Sprintf(MsgTotalSold, s.messages.FormatMoney(s.sold, s.config.CurrencyCode)))
+	fmt.Println(s.messages.Sprintf(MsgTotalPurchased, s.messages.FormatMoney(s.purchased, s.config.CurrencyCode)))
+	profitMsg := MsgProfitSetAside
+	if s.config.Compound {
+		profitMsg = MsgProfitReinvested
+	}
+	fmt.Println(s.messages.Sprintf(profitMsg, s.messages.FormatMoney(s.portfolio.RealizedProfit(), s.config.CurrencyCode)))
+	if s.ledger != nil {
+		if err := s.ledger.Close(); err != nil {
+			log.Printf("Could not close the ledger: %v", err)
+		}
+	}
+	if s.logFile != nil {
+		log.SetOutput(os.Stderr)
+		s.logFile.Close()
+	}
 }
 
+// cancelPendingOrders cancels the orders left unfilled when the session ends and reports how it went.
+// The session's context is done by then, so a fresh one is used.
+func (s *Session) cancelPendingOrders() {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelOrdersTimeout)
+	defer cancel()
+	cancelled, failed := s.portfolio.cancelPendingOrders(ctx)
+	fmt.Println(s.messages.Sprintf(MsgOrdersCancelled, cancelled))
+	if failed > 0 {
+		fmt.Println(s.messages.Sprintf(MsgOrdersNotCancelled, failed))
+	}
+}
+
+// Stop ends the session. Errors with the PolicyHaltSession policy stop the session too.
 func (s *Session) Stop() {
-	s.done <- struct{}{}
+	s.cancel()
+}
+
+// SetClock makes the session tell the time and wait on `clock`, e.g. a VirtualClock so tests can run the
+// trading pipeline without sleeping. It must be called before the session is initialized.
+func (s *Session) SetClock(clock Clock) {
+	s.portfolio.clock.setBase(clock)
+	s.portfolio.budget.setClock(clock)
+}
+
+// SetProgressReporter sets the reporter told how far long operations, e.g. downloads of historical candles,
+// have got. The progress is logged too, and the unfinished operations are listed in the session's Metrics.
+func (s *Session) SetProgressReporter(reporter ProgressReporter) {
+	s.portfolio.progress.setReporter(reporter)
+}
+
+// SetNotifier sets how important messages are delivered to the user.
+// It must be called before the session is started.
+func (s *Session) SetNotifier(notifier Notifier) {
+	s.notifier = notifier
+	s.errs.notifier = notifier
+}
+
+// GuardAnalyzer wraps a machine learning analyzer in a DriftGuard configured by the session's settings,
+// falling back to `fallback` when the model's hit rate degrades.
+func (s *Session) GuardAnalyzer(model, fallback Analyzer) *DriftGuard {
+	plugin := s.config.Trade.AnalysisPlugin
+	window, minHitRate := plugin.HitRateWindow, plugin.MinHitRate
+	if window <= 0 {
+		window = 50
+	}
+	if minHitRate <= 0 {
+		minHitRate = 0.45
+	}
+	guard := NewDriftGuard(model, fallback, window, minHitRate, s.notifier)
+	guard.messages = s.messages
+	return guard
 }
 
 func (s *Session) debug(v ...interface{}) {
@@ -102,15 +339,25 @@ func (s *Session) debug(v ...interface{}) {
 }
 
 func (s *Session) GetPrices() {
-	for asset, handler := range s.portfolio.assets {
-		data, err := handler.PreviousTrades(5)
+	for asset := range s.portfolio.assets {
+		candles, err := s.portfolio.updateCandles(asset, 5)
 		if err != nil {
 			log.Printf("%v", err)
 			continue
 		}
 		fmt.Println("OHLC DATA FOR ", asset)
-		fmt.Println(data)
+		fmt.Println(candles)
+	}
+}
+
+// ExportFeatures writes the machine learning features of an asset's cached candles to `w` as CSV,
+// so they can be experimented with offline.
+func (s *Session) ExportFeatures(asset string, w io.Writer) error {
+	pipeline, err := NewFeaturePipeline(s.config.Trade.AnalysisPlugin.Features)
+	if err != nil {
+		return err
 	}
+	return pipeline.WriteCSV(w, s.portfolio.candles.Candles(asset))
 }
 
 func raise(err error) {
@@ -0,0 +1,137 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"unit2/leprechaun/internal/atomicfile"
+)
+
+// SessionStatsFile returns the file the session counters are kept in between runs.
+func (c *Configuration) SessionStatsFile() string {
+	return filepath.Join(c.DataDir, "session.json")
+}
+
+// savedStats are the session counters of one day, as saved to the session stats file.
+type savedStats struct {
+	Day       string // See dayKey.
+	Sold      float64
+	Purchased float64
+	Profit    float64
+}
+
+// recordTrade adds a trade recorded by the portfolio to the session counters, in CurrencyCode, and saves them,
+// so a restart later in the day carries on from them.
+func (s *Session) recordTrade(orderType Order, entry Entry) {
+	defer s.sendTradeEvent(orderType, entry)
+	rate, err := s.portfolio.reportingRate(entry.Asset)
+	if err != nil {
+		s.errs.report(entry.Asset, "count trade", err)
+		return
+	}
+	s.mu.Lock()
+	switch orderType {
+	case OpenLongTrade:
+		s.purchased += entry.PurchaseCost * rate
+	case OpenShortTrade:
+		s.sold += entry.SaleCost * rate
+	case CloseLongTrade:
+		s.sold += entry.SaleCost * rate
+		s.profit += entry.ClosedProfit() * rate
+	case CloseShortTrade:
+		s.purchased += entry.PurchaseCost * rate
+		s.profit += entry.ClosedProfit() * rate
+	}
+	s.mu.Unlock()
+	s.errs.report(entry.Asset, "save session stats", s.saveStats())
+}
+
+// sendTradeEvent tells the UI about a trade through the purchase or sale channel set with SetChannels.
+// Trading doesn't wait for the UI: the event is dropped if the UI isn't ready to receive it.
+func (s *Session) sendTradeEvent(orderType Order, entry Entry) {
+	s.mu.RLock()
+	channels := s.channels
+	s.mu.RUnlock()
+	if channels == nil {
+		return
+	}
+	event := TradeEvent{Type: orderType, Asset: entry.Asset, Entry: entry, Time: s.portfolio.clock.Now()}
+	channel := channels.SaleChan
+	event.Price = entry.SalePrice
+	if event.IsPurchase() {
+		channel, event.Price = channels.PurchaseChan, entry.PurchasePrice
+	}
+	if orderType == CloseLongTrade || orderType == CloseShortTrade {
+		event.ProfitDelta = entry.ClosedProfit()
+	}
+	if channel == nil {
+		return
+	}
+	select {
+	case channel <- event:
+	default:
+		log.Printf("The UI missed a %s trade event", entry.Asset)
+	}
+}
+
+// SetChannels sets the channels the session communicates with the UI through. Trades are sent on
+// PurchaseChan and SaleChan as TradeEvents.
+func (s *Session) SetChannels(channels *Channels) {
+	s.mu.Lock()
+	s.channels = channels
+	s.mu.Unlock()
+}
+
+// saveStats writes the session counters to the session stats file. The counters are written to a
+// temporary file first so a crash while saving doesn't leave a truncated file behind.
+func (s *Session) saveStats() error {
+	s.mu.RLock()
+	stats := savedStats{Day: dayKey(s.portfolio.clock.Now()), Sold: s.sold, Purchased: s.purchased, Profit: s.profit}
+	s.mu.RUnlock()
+	return atomicfile.Write(s.config.SessionStatsFile(), 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(stats)
+	})
+}
+
+// loadStats restores the counters saved earlier today, so daily summaries cover the whole day
+// across restarts. Counters saved on another day are ignored.
+func (s *Session) loadStats() (err error) {
+	file, err := os.Open(s.config.SessionStatsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	var stats savedStats
+	if err = json.NewDecoder(file).Decode(&stats); err != nil {
+		return
+	}
+	if stats.Day != dayKey(s.portfolio.clock.Now()) {
+		return nil
+	}
+	s.mu.Lock()
+	s.sold, s.purchased, s.profit = stats.Sold, stats.Purchased, stats.Profit
+	s.mu.Unlock()
+	return nil
+}
+
+// ResetStats sets the session counters back to zero, also for later runs on the same day.
+func (s *Session) ResetStats() error {
+	s.mu.Lock()
+	s.sold, s.purchased, s.profit = 0, 0, 0
+	s.mu.Unlock()
+	err := os.Remove(s.config.SessionStatsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
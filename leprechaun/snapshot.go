@@ -0,0 +1,143 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRecentEvents is the number of events kept for snapshots.
+const maxRecentEvents = 50
+
+// Event is something noteworthy that happened during a session, e.g. a trade or an error.
+type Event struct {
+	Time    time.Time
+	Kind    string // e.g. "trade" or "error"
+	Asset   string // Empty when the event isn't about a single asset.
+	Message string
+}
+
+// eventLog keeps the most recent events of a session.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	clock  Clock
+}
+
+func newEventLog(clock Clock) *eventLog {
+	return &eventLog{clock: clock}
+}
+
+// record adds an event, dropping the oldest one if the log is full.
+func (l *eventLog) record(kind, asset, message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) >= maxRecentEvents {
+		l.events = l.events[1:]
+	}
+	l.events = append(l.events, Event{Time: l.clock.Now(), Kind: kind, Asset: asset, Message: message})
+}
+
+// recent returns a copy of the logged events, oldest first.
+func (l *eventLog) recent() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event{}, l.events...)
+}
+
+// ConfigSummary holds the settings shown in a Snapshot. It leaves out secrets.
+type ConfigSummary struct {
+	Exchange      string
+	AssetsToTrade []string
+	Currency      string
+	PurchaseUnit  float64
+	ProfitMargin  float64
+	Compound      bool
+	Locale        string
+	WatchOnly     bool
+}
+
+// Heartbeats are the last times the loops of a session did their work for an asset. A heartbeat that stops
+// moving while the others carry on points to a stuck loop. Zero times mean the loop hasn't run yet.
+type Heartbeats struct {
+	PricePoll   time.Time // Last time the price was fetched.
+	Analysis    time.Time // Last time an analyzer emitted a signal.
+	LedgerWrite time.Time // Last time a trade was written to the ledger.
+}
+
+// AssetSnapshot is the state of one asset in a Snapshot. Prices and balances are the last ones fetched
+// from the exchange; `Updated` says when the price was fetched.
+type AssetSnapshot struct {
+	Name          string
+	Pair          string
+	Price         float64
+	Spread        float64
+	Balance       float64
+	FiatBalance   float64
+	Updated       time.Time
+	OpenPositions []Entry
+	Heartbeats    Heartbeats
+	Watching      bool // The asset is on the watchlist, so no orders are placed for it.
+}
+
+// SessionStats are the running totals of a session.
+type SessionStats struct {
+	StartTime time.Time
+	Elapsed   time.Duration
+	Sold      float64
+	Purchased float64
+	Profit    float64
+}
+
+// Snapshot is the state of a trading session at one point in time, for user interfaces (the dashboard, TUI or
+// remote clients) to display. It can be encoded as JSON.
+type Snapshot struct {
+	Time   time.Time
+	Config ConfigSummary
+	Assets []AssetSnapshot
+	Stats  SessionStats
+	Events []Event
+	// Latency are the latency percentiles of the recent requests to the exchange, by endpoint.
+	Latency map[Endpoint]LatencyStats
+}
+
+// Snapshot returns the current state of the session. It is taken under the portfolio's lock, so no
+// trade is recorded halfway through it.
+func (s *Session) Snapshot() (snap Snapshot, err error) {
+	s.mu.RLock()
+	snap.Stats = SessionStats{StartTime: s.startTime, Sold: s.sold, Purchased: s.purchased, Profit: s.profit}
+	s.mu.RUnlock()
+
+	pf := s.portfolio
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	snap.Time = pf.clock.Now()
+	if !snap.Stats.StartTime.IsZero() {
+		snap.Stats.Elapsed = snap.Time.Sub(snap.Stats.StartTime)
+	}
+	snap.Config = ConfigSummary{Exchange: s.config.Exchange, AssetsToTrade: s.config.AssetsToTrade,
+		Currency: s.config.CurrencyCode, PurchaseUnit: s.config.PurchaseUnit, ProfitMargin: s.config.ProfitMargin,
+		Compound: s.config.Compound, Locale: s.config.Locale, WatchOnly: pf.watchOnly}
+	for name := range pf.assets {
+		asset := pf.market[name]
+		asset.Name = name
+		asset.Watching = pf.watchlist[name]
+		if info, ok := pf.assetInfo[name]; ok {
+			asset.Pair = info.Pair
+		}
+		if pf.ledger != nil {
+			records, err := pf.ledger.OpenPositions(pf.ctx, name)
+			if err != nil {
+				return snap, err
+			}
+			asset.OpenPositions = records
+		}
+		snap.Assets = append(snap.Assets, asset)
+	}
+	snap.Events = s.events.recent()
+	snap.Latency = pf.latency.stats()
+	return snap, nil
+}
@@ -0,0 +1,377 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// soakYield is the real time given to the session's goroutines each time the soak test advances the virtual clock.
+const soakYield = 100 * time.Microsecond
+
+// ErrSoakGrowth is returned by RunSoak when memory or goroutines kept growing during the soak test.
+var ErrSoakGrowth = errors.New("resource usage kept growing during the soak test")
+
+// LoadTicks reads ticks recorded as CSV rows of `timestamp,pair,bid,ask[,volume]`, grouped by pair and sorted by time.
+// Timestamps may be RFC3339 or unix milliseconds. A header row is skipped.
+func LoadTicks(r io.Reader) (ticks map[string][]Tick, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return
+	}
+	ticks = make(map[string][]Tick)
+	for i, row := range rows {
+		if len(row) < 4 {
+			return nil, fmt.Errorf("line %d: expected timestamp,pair,bid,ask[,volume]", i+1)
+		}
+		t, err := parseTimestamp(row[0])
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		tick := Tick{Time: t, Pair: strings.ToUpper(row[1])}
+		if tick.Bid, err = strconv.ParseFloat(row[2], 64); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if tick.Ask, err = strconv.ParseFloat(row[3], 64); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if len(row) > 4 && row[4] != "" {
+			if tick.Volume, err = strconv.ParseFloat(row[4], 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+		ticks[tick.Pair] = append(ticks[tick.Pair], tick)
+	}
+	if len(ticks) == 0 {
+		return nil, errors.New("no ticks were recorded")
+	}
+	for _, series := range ticks {
+		sort.SliceStable(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+	}
+	return ticks, nil
+}
+
+// replayMarket is an http.RoundTripper that answers the exchange's price requests from recorded ticks at the time
+// of its clock, so a session can trade on recorded data. The recording is looped when the clock runs past its end.
+// Orders aren't answered, so the session must trade on a PaperAccount.
+type replayMarket struct {
+	clock    Clock
+	ticks    map[string][]Tick
+	start    time.Time     // time of the first recorded tick
+	span     time.Duration // length of the recording
+	takerFee float64
+}
+
+func newReplayMarket(clock Clock, ticks map[string][]Tick, takerFee float64) *replayMarket {
+	market := &replayMarket{clock: clock, ticks: ticks, takerFee: takerFee}
+	var end time.Time
+	for _, series := range ticks {
+		if first := series[0].Time; market.start.IsZero() || first.Before(market.start) {
+			market.start = first
+		}
+		if last := series[len(series)-1].Time; last.After(end) {
+			end = last
+		}
+	}
+	market.span = end.Sub(market.start) + time.Second
+	return market
+}
+
+// recorded maps `t` to the time in the recording that is replayed at `t`.
+func (market *replayMarket) recorded(t time.Time) time.Time {
+	offset := t.Sub(market.start) % market.span
+	if offset < 0 {
+		offset += market.span
+	}
+	return market.start.Add(offset)
+}
+
+// tickAt returns the last tick of `pair` replayed at or before `t`.
+func (market *replayMarket) tickAt(pair string, t time.Time) (tick Tick, ok bool) {
+	series := market.ticks[pair]
+	if len(series) == 0 {
+		return
+	}
+	at := market.recorded(t)
+	i := sort.Search(len(series), func(i int) bool { return series[i].Time.After(at) }) - 1
+	if i < 0 {
+		i = len(series) - 1 // still the last tick of the previous loop
+	}
+	return series[i], true
+}
+
+// ticksBetween returns the ticks of `pair` replayed from `from` until `to`.
+func (market *replayMarket) ticksBetween(pair string, from, to time.Time) (ticks []Tick) {
+	series := market.ticks[pair]
+	for from.Before(to) {
+		at := market.recorded(from)
+		until := to.Sub(from)
+		if rest := market.start.Add(market.span).Sub(at); rest < until {
+			until = rest
+		}
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(at) })
+		j := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(at.Add(until)) })
+		ticks = append(ticks, series[i:j]...)
+		from = from.Add(until)
+	}
+	return
+}
+
+// RoundTrip answers a request to the exchange's API.
+func (market *replayMarket) RoundTrip(req *http.Request) (*http.Response, error) {
+	now := market.clock.Now()
+	query := req.URL.Query()
+	pair := strings.ToUpper(query.Get("pair"))
+	switch req.URL.Path {
+	case "/api/1/ticker":
+		tick, ok := market.tickAt(pair, now)
+		if !ok {
+			return market.respond(req, http.StatusNotFound, apiError{"ErrMarketNotFound", "no ticks were recorded for " + pair})
+		}
+		return market.respond(req, http.StatusOK, map[string]interface{}{"pair": pair, "timestamp": now.UnixMilli(),
+			"ask": decimal(tick.Ask), "bid": decimal(tick.Bid), "last_trade": decimal(tick.Ask), "status": "ACTIVE"})
+	case "/api/exchange/1/candles":
+		since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+		if err != nil {
+			return market.respond(req, http.StatusBadRequest, apiError{"ErrInvalidParameters", "invalid since"})
+		}
+		seconds, err := strconv.ParseInt(query.Get("duration"), 10, 64)
+		if err != nil || seconds <= 0 {
+			return market.respond(req, http.StatusBadRequest, apiError{"ErrInvalidParameters", "invalid duration"})
+		}
+		return market.respond(req, http.StatusOK, map[string]interface{}{"pair": pair, "duration": seconds,
+			"candles": market.candles(pair, time.UnixMilli(since), time.Duration(seconds)*time.Second, now)})
+	case "/api/exchange/1/markets":
+		markets := []map[string]interface{}{}
+		for pair := range market.ticks {
+			if len(pair) <= 3 {
+				continue
+			}
+			markets = append(markets, map[string]interface{}{"market_id": pair, "base_currency": pair[:3],
+				"counter_currency": pair[3:], "min_volume": "0.0005", "max_volume": "100", "price_scale": 2,
+				"volume_scale": 6, "trading_status": "ACTIVE"})
+		}
+		return market.respond(req, http.StatusOK, map[string]interface{}{"markets": markets})
+	case "/api/1/fee_info":
+		fee := strconv.FormatFloat(market.takerFee, 'f', -1, 64)
+		return market.respond(req, http.StatusOK, map[string]string{"maker_fee": fee, "taker_fee": fee, "thirty_day_volume": "0"})
+	}
+	return market.respond(req, http.StatusNotFound, apiError{"ErrNotFound", req.URL.Path + " is not replayed"})
+}
+
+// candles aggregates the ask prices of `pair` into candles of `period` from `since` until `now`, like the exchange
+// (at most 1000, oldest first).
+func (market *replayMarket) candles(pair string, since time.Time, period time.Duration, now time.Time) (candles []map[string]interface{}) {
+	for start := since; start.Before(now) && len(candles) < 1000; start = start.Add(period) {
+		end := start.Add(period)
+		if end.After(now) {
+			end = now
+		}
+		ticks := market.ticksBetween(pair, start, end)
+		if len(ticks) == 0 {
+			continue
+		}
+		open, high, low, volume := ticks[0].Ask, ticks[0].Ask, ticks[0].Ask, 0.0
+		for _, tick := range ticks {
+			high, low = math.Max(high, tick.Ask), math.Min(low, tick.Ask)
+			volume += tick.Volume
+		}
+		candles = append(candles, map[string]interface{}{"timestamp": start.UnixMilli(), "open": decimal(open),
+			"high": decimal(high), "low": decimal(low), "close": decimal(ticks[len(ticks)-1].Ask), "volume": decimal(volume)})
+	}
+	return
+}
+
+// apiError is the body of the exchange's error responses.
+type apiError struct {
+	Code    string `json:"error_code"`
+	Message string `json:"error"`
+}
+
+func (market *replayMarket) respond(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(bytes.NewReader(data)),
+		Header: http.Header{"Content-Type": {"application/json"}}, ContentLength: int64(len(data)), Request: req}, nil
+}
+
+// SoakSample is a measurement of the resources used by a soak test.
+type SoakSample struct {
+	Simulated  time.Duration // simulated time since the start of the test
+	HeapAlloc  uint64        // bytes of live heap objects
+	Goroutines int
+	LedgerSize int64 // bytes
+	Requests   int   // exchange requests made so far
+}
+
+// sampleSoak measures the session's resource usage.
+func sampleSoak(s *Session, simulated time.Duration) (sample SoakSample) {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	sample = SoakSample{Simulated: simulated, HeapAlloc: mem.HeapAlloc, Goroutines: runtime.NumGoroutine()}
+	for _, suffix := range []string{"", "-wal"} {
+		if info, err := os.Stat(s.config.LedgerDatabase + suffix); err == nil {
+			sample.LedgerSize += info.Size()
+		}
+	}
+	for _, n := range s.portfolio.budget.Used() {
+		sample.Requests += n
+	}
+	return
+}
+
+// soakGrowth returns what kept growing between the samples taken after the warm-up (the first quarter of the test).
+// The ledger is expected to grow with the number of trades, so only memory and goroutines are checked.
+func soakGrowth(samples []SoakSample) (growth []string) {
+	if len(samples) < 4 {
+		return nil
+	}
+	first, last := samples[len(samples)/4], samples[len(samples)-1]
+	if last.Goroutines > first.Goroutines+10 {
+		growth = append(growth, fmt.Sprintf("goroutines grew from %d to %d", first.Goroutines, last.Goroutines))
+	}
+	if last.HeapAlloc > 2*first.HeapAlloc {
+		growth = append(growth, fmt.Sprintf("the heap grew from %.1f MiB to %.1f MiB",
+			float64(first.HeapAlloc)/(1<<20), float64(last.HeapAlloc)/(1<<20)))
+	}
+	return
+}
+
+// RunSoak runs the `leprechaun soak` command, e.g. `soak --data ticks.csv --days 14`. It runs a paper trading session
+// against recorded ticks for days of simulated time on a VirtualClock, sampling memory, goroutines and the ledger's size
+// to catch leaks before the bot is left running unattended for weeks. ErrSoakGrowth is returned if they keep growing.
+func RunSoak(ctx context.Context, args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("soak", flag.ContinueOnError)
+	flags.SetOutput(out)
+	data := flags.String("data", "", "CSV file of recorded ticks: timestamp,pair,bid,ask[,volume]. It is looped if the test runs longer.")
+	days := flags.Float64("days", 7, "Days of simulated time to run for.")
+	every := flags.Duration("sample", time.Hour, "Simulated time between measurements.")
+	balance := flags.Float64("balance", 100000, "Fiat balance of the simulated account.")
+	fee := flags.Float64("fee", 0.001, "Taker fee of simulated orders.")
+	policyFile := flags.String("policy", "", "Linear policy to trade with, as saved by LinearPolicy.Save. Defaults to trading on the last return.")
+	window := flags.Int("window", 10, "Candles the policy looks at.")
+	verbose := flags.Bool("verbose", false, "Print the session's log.")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *data == "" {
+		return errors.New("--data is required")
+	}
+	file, err := os.Open(*data)
+	if err != nil {
+		return
+	}
+	ticks, err := LoadTicks(file)
+	file.Close()
+	if err != nil {
+		return
+	}
+	policy := &LinearPolicy{Features: []string{"returns"}, Weights: []float64{1}, Threshold: 0.002}
+	if *policyFile != "" {
+		if policy, err = LoadLinearPolicy(*policyFile); err != nil {
+			return
+		}
+	}
+	dir, err := os.MkdirTemp("", "leprechaun-soak-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Configuration{}
+	if err = c.TestConfig(""); err != nil {
+		return
+	}
+	c.DataDir, c.LedgerDatabase = dir, filepath.Join(dir, "ledger.db")
+	c.Sandbox, c.PaperBalance, c.StreamOrderBook = true, *balance, false
+	if c.APIKeyID == "" || c.APIKeySecret == "" {
+		c.APIKeyID, c.APIKeySecret = "soak", "soak" // no request reaches the exchange
+	}
+	market := newReplayMarket(nil, ticks, *fee)
+	clock := NewVirtualClock(market.start)
+	market.clock = clock
+	s := NewSession(ctx, c, WithClock(clock))
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	s.SetHTTPClient(&http.Client{Transport: market})
+	if err = s.Initialize(); err != nil {
+		return
+	}
+	for asset := range s.portfolio.assets {
+		s.portfolio.SetAnalyzer(asset, NewPolicyAnalyzer(policy, *window))
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.Start()
+		close(stopped)
+	}()
+
+	start := clock.Now()
+	end := start.Add(time.Duration(*days * float64(H24)))
+	fmt.Fprintf(out, "%12s %12s %10s %12s %10s\n", "simulated", "heap (MiB)", "goroutines", "ledger (KiB)", "requests")
+	var samples []SoakSample
+	for next := start; ctx.Err() == nil; {
+		if now := clock.Now(); !now.Before(next) || !now.Before(end) {
+			sample := sampleSoak(s, now.Sub(start))
+			samples = append(samples, sample)
+			fmt.Fprintf(out, "%12s %12.1f %10d %12.1f %10d\n", sample.Simulated.Round(time.Minute),
+				float64(sample.HeapAlloc)/(1<<20), sample.Goroutines, float64(sample.LedgerSize)/(1<<10), sample.Requests)
+			if !now.Before(end) {
+				break
+			}
+			next = next.Add(*every)
+		}
+		if !clock.AdvanceToNext() {
+			<-wallClock.After(soakYield) // everything is busy
+			continue
+		}
+		<-wallClock.After(soakYield)
+	}
+	s.Stop()
+	// The session may be waiting on the clock on its way out.
+	for done := false; !done; {
+		select {
+		case <-stopped:
+			done = true
+		default:
+			clock.AdvanceToNext()
+			<-wallClock.After(soakYield)
+		}
+	}
+	if growth := soakGrowth(samples); len(growth) > 0 {
+		fmt.Fprintln(out, strings.Join(growth, "\n"))
+		return ErrSoakGrowth
+	}
+	fmt.Fprintln(out, "No leaks found.")
+	return ctx.Err()
+}
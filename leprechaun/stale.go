@@ -0,0 +1,124 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"time"
+)
+
+// staleCheckInterval is how often open positions are checked for being stale.
+const staleCheckInterval = 15 * time.Minute
+
+// staleReason is why an open position needs the user's attention.
+type staleReason string
+
+const (
+	staleAge        staleReason = "age"        // open longer than StaleAfterHours
+	staleUnderwater staleReason = "underwater" // losing more than its stop loss allows
+)
+
+// exitPrice returns the price a position would be closed at now, including the exchange's fees.
+func (pf *Portfolio) exitPrice(handler ExchangeHandler, position Entry) (float64, error) {
+	// Closing a long position sells, closing a short position buys.
+	exitKind := SignalShort
+	if position.Type == OpenShortTrade {
+		exitKind = SignalLong
+	}
+	return handler.ExpectedEntryPrice(exitKind)
+}
+
+// stopLossPercentage returns the loss (in percent) at which positions of type `orderType` should be stopped out,
+// or zero if they have no stop loss.
+func (c *Configuration) stopLossPercentage(orderType Order) float64 {
+	stop := c.Trade.LongTrade
+	if orderType == OpenShortTrade {
+		stop = c.Trade.ShortTrade
+	}
+	if !stop.StopLoss {
+		return 0
+	}
+	return stop.StopLossPercentage
+}
+
+// lossPercentage returns how much of the position's cost would be lost by closing it at `exitPrice`, in percent.
+// It is negative for a position in profit.
+func (rec Entry) lossPercentage(exitPrice float64) float64 {
+	cost := rec.PurchaseCost
+	if rec.Type == OpenShortTrade {
+		cost = rec.SaleCost
+	}
+	if cost <= 0 {
+		return 0
+	}
+	return -100 * rec.ProfitAt(exitPrice) / cost
+}
+
+// checkStalePositions notifies the user about each open position that has been open longer than StaleAfterHours,
+// or that is losing more than its stop loss should have allowed, with a suggested action. Either means the loop that
+// closes positions may have failed silently. `alerted` holds the positions already reported, so each is reported
+// once per reason.
+func (pf *Portfolio) checkStalePositions(alerted map[string]bool) {
+	staleAfter := time.Duration(pf.config.StaleAfterHours) * time.Hour
+	now := pf.clock.Now()
+	for _, asset := range pf.assetOrder() {
+		handler := pf.assets[asset]
+		positions, err := pf.ledger.OpenPositions(pf.ctx, asset)
+		if err != nil {
+			pf.errs.report(asset, "check stale positions", err)
+			continue
+		}
+		if staleAfter > 0 {
+			for _, position := range expiredPositions(positions, staleAfter, now) {
+				opened, _ := position.Time()
+				pf.alertStale(alerted, position, staleAge, pf.messages.Sprintf(MsgStalePosition, asset,
+					orderTypeName(position.Type), position.ID, now.Sub(opened).Round(time.Minute)))
+			}
+		}
+		for _, position := range positions {
+			stopLoss := pf.config.stopLossPercentage(position.Type)
+			if stopLoss <= 0 || alerted[position.ID+string(staleUnderwater)] {
+				continue
+			}
+			price, err := pf.exitPrice(handler, position)
+			if err != nil {
+				log.Printf("Could not get the exit price of %s: %v", position.ID, err)
+				continue
+			}
+			if loss := position.lossPercentage(price); loss > stopLoss {
+				pf.alertStale(alerted, position, staleUnderwater, pf.messages.Sprintf(MsgUnderwaterPosition, asset,
+					orderTypeName(position.Type), position.ID, loss, stopLoss))
+			}
+		}
+	}
+}
+
+// alertStale notifies the user about a stale position, unless it has been reported for `reason` already.
+func (pf *Portfolio) alertStale(alerted map[string]bool, position Entry, reason staleReason, msg string) {
+	key := position.ID + string(reason)
+	if alerted[key] {
+		return
+	}
+	alerted[key] = true
+	msg += " " + pf.messages.Sprintf(MsgStaleAction, position.Asset)
+	log.Println(msg)
+	pf.events.record("stale-position", position.Asset, msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgStalePositionSubject, position.Asset), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+}
+
+// monitorStalePositions checks the open positions for stale ones until the session ends.
+func (s *Session) monitorStalePositions() {
+	alerted := map[string]bool{}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(staleCheckInterval):
+		}
+		s.portfolio.checkStalePositions(alerted)
+	}
+}
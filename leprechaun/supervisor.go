@@ -0,0 +1,66 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// minRestartDelay is how long a crashed goroutine waits before its first restart. The delay doubles
+	// after each crash, up to maxRestartDelay.
+	minRestartDelay = time.Second
+	maxRestartDelay = 5 * time.Minute
+	// stableRunTime is how long a goroutine must run without crashing for its restart delay to be reset.
+	stableRunTime = 10 * time.Minute
+	// crashesBeforeNotify is the number of crashes in a row after which the user is notified.
+	crashesBeforeNotify = 3
+)
+
+// supervise runs `fn` until it returns normally or the session ends. If `fn` panics, the panic and its stack
+// trace are logged and `fn` is restarted after a delay that grows with each crash. The user is notified when
+// a goroutine keeps crashing. It blocks, so start it with `go`.
+func (s *Session) supervise(name string, fn func()) {
+	delay, crashes := minRestartDelay, 0
+	for s.ctx.Err() == nil {
+		started := s.portfolio.clock.Now()
+		if !runRecovered(name, fn) {
+			return
+		}
+		if s.portfolio.clock.Now().Sub(started) >= stableRunTime {
+			delay, crashes = minRestartDelay, 0
+		}
+		crashes++
+		if crashes == crashesBeforeNotify {
+			msg := s.messages.Sprintf(MsgCrashing, name, crashes)
+			if err := s.notifier.Notify(s.messages.Sprintf(MsgCrashingSubject), msg); err != nil {
+				log.Printf("Could not send notification: %v", err)
+			}
+		}
+		log.Printf("Restarting the %s in %s", name, delay)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(delay):
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// runRecovered runs `fn` and returns true if it panicked.
+func runRecovered(name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC:: %s: %v\n%s", name, r, debug.Stack())
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
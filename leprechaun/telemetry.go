@@ -0,0 +1,94 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer the trade pipeline's spans are created with.
+const instrumentationName = "unit2/leprechaun"
+
+// telemetryShutdownTimeout is how long the spans still buffered may take to be exported when the session ends.
+const telemetryShutdownTimeout = 5 * time.Second
+
+// WithTracerProvider makes the session create the spans of its trade pipeline with `provider`, e.g. the program's
+// own OpenTelemetry provider, instead of exporting them to the TelemetryEndpoint setting.
+//
+// Each round of an asset is a trace: an "analyze" span, with the candles and price fetched and the signal
+// emitted as its children, followed by a "decide" span for what was done on the signal, with the order placed
+// and the trade recorded as its children.
+func WithTracerProvider(provider trace.TracerProvider) SessionOption {
+	return func(s *Session) {
+		s.portfolio.spans = provider.Tracer(instrumentationName)
+	}
+}
+
+// newSpanExporter returns an exporter sending spans over OTLP/HTTP to `endpoint`, e.g. "http://localhost:4318"
+// for a local Jaeger. Endpoints without a scheme are reached over HTTPS.
+func newSpanExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		// No scheme, e.g. "collector:4318".
+		if u, err = url.Parse("https://" + endpoint); err != nil {
+			return nil, fmt.Errorf("invalid telemetry endpoint %q: %w", endpoint, err)
+		}
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// startTelemetry exports the spans of the trade pipeline to TelemetryEndpoint, if it is set.
+func (s *Session) startTelemetry() {
+	if s.config.TelemetryEndpoint == "" {
+		return
+	}
+	exporter, err := newSpanExporter(s.ctx, s.config.TelemetryEndpoint)
+	if err != nil {
+		log.Printf("Could not set up the export of traces: %v", err)
+		return
+	}
+	s.telemetry = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "leprechaun"))))
+	s.portfolio.spans = s.telemetry.Tracer(instrumentationName)
+}
+
+// stopTelemetry exports the spans still buffered.
+func (s *Session) stopTelemetry() {
+	if s.telemetry == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+	defer cancel()
+	if err := s.telemetry.Shutdown(ctx); err != nil {
+		log.Printf("Could not export the last traces: %v", err)
+	}
+}
+
+// endSpan ends `span`, marking it as failed if `err` isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,27 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"embed"
+	"html/template"
+	"path/filepath"
+)
+
+// builtinTemplates are the pages the bot renders, built into the binary so it runs without any files next to it.
+//
+//go:embed templates/*.html
+var builtinTemplates embed.FS
+
+// parseTemplate parses the HTML template `name`, e.g. "report.html". A file of that name in `dir` replaces the
+// built-in template, so pages can be customized without rebuilding the bot. An empty `dir` uses the built-in one.
+func parseTemplate(dir, name string) (*template.Template, error) {
+	if dir != "" {
+		if path := filepath.Join(dir, name); exists(path) {
+			return template.ParseFiles(path)
+		}
+	}
+	return template.ParseFS(builtinTemplates, "templates/"+name)
+}
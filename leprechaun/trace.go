@@ -0,0 +1,214 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTracedBody is the most of a request or response body written to the trace. Candle downloads can be much larger.
+const maxTracedBody = 64 << 10
+
+// redacted replaces secrets in the trace.
+const redacted = "REDACTED"
+
+// sensitiveFields are the headers, query parameters and form fields whose values are never traced.
+var sensitiveFields = []string{"authorization", "cookie", "set-cookie", "secret", "password", "token", "api_key",
+	"api_key_secret", "pin"}
+
+// APITrace is a record of the trace file, see Configuration.TraceFile: an exchange request and its response.
+type APITrace struct {
+	Time      time.Time
+	Duration  time.Duration
+	Endpoint  Endpoint
+	Method    string
+	URL       string
+	Request   string              `json:",omitempty"`
+	Status    int                 `json:",omitempty"`
+	Headers   map[string][]string `json:",omitempty"` // of the response
+	Response  string              `json:",omitempty"`
+	Truncated bool                `json:",omitempty"` // a body was longer than maxTracedBody
+	Error     string              `json:",omitempty"`
+}
+
+// apiTracer writes every exchange request and its response as a JSON line to a file, with secrets redacted, so
+// what the exchange was asked and answered can be checked when a trade goes wrong.
+type apiTracer struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	secrets []string // values redacted wherever they appear, e.g. the API key
+	failed  bool     // the file couldn't be opened, so tracing is off
+}
+
+// newAPITracer returns a tracer writing to `path`, or nil if `path` is empty. The file is opened on the first
+// request and appended to.
+func newAPITracer(path string, secrets ...string) *apiTracer {
+	if path == "" {
+		return nil
+	}
+	tracer := &apiTracer{path: path}
+	for _, secret := range secrets {
+		if secret != "" {
+			tracer.secrets = append(tracer.secrets, secret)
+		}
+	}
+	return tracer
+}
+
+// redact removes the secrets from `text`.
+func (t *apiTracer) redact(text string) string {
+	for _, secret := range t.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// isSensitive returns true if the value of the header, parameter or field `name` is a secret.
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range sensitiveFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValues returns `values` with the sensitive ones replaced.
+func redactValues(values map[string][]string) map[string][]string {
+	clean := make(map[string][]string, len(values))
+	for name, vals := range values {
+		if isSensitive(name) {
+			vals = []string{redacted}
+		}
+		clean[name] = vals
+	}
+	return clean
+}
+
+// redactBody redacts a form encoded body field by field. Other bodies are only cleared of the tracer's secrets.
+func (t *apiTracer) redactBody(body []byte, contentType string) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return t.redact(url.Values(redactValues(values)).Encode())
+		}
+	}
+	return t.redact(string(body))
+}
+
+// readBody reads up to maxTracedBody bytes of `body` and returns a reader that replays all of it.
+func readBody(body io.ReadCloser) (traced []byte, truncated bool, replay io.ReadCloser, err error) {
+	traced, err = io.ReadAll(io.LimitReader(body, maxTracedBody+1))
+	replay = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(traced), body), body}
+	if len(traced) > maxTracedBody {
+		traced, truncated = traced[:maxTracedBody], true
+	}
+	return
+}
+
+// write appends `trace` to the trace file.
+func (t *apiTracer) write(trace APITrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed {
+		return
+	}
+	if t.file == nil {
+		file, err := os.OpenFile(t.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Printf("Could not open the trace file, exchange requests won't be traced: %v", err)
+			t.failed = true
+			return
+		}
+		t.file = file
+	}
+	if err := json.NewEncoder(t.file).Encode(trace); err != nil {
+		log.Printf("Could not write to the trace file: %v", err)
+	}
+}
+
+// close closes the trace file.
+func (t *apiTracer) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// traceTransport writes each request it makes and the response to the trace.
+type traceTransport struct {
+	next   http.RoundTripper
+	tracer *apiTracer
+	clock  Clock
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	traced := *req.URL
+	traced.User = nil
+	traced.RawQuery = url.Values(redactValues(query)).Encode()
+	trace := APITrace{Time: t.clock.Now(), Endpoint: endpointOf(req), Method: req.Method, URL: t.tracer.redact(traced.String())}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, truncated, replay, err := readBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = replay
+		trace.Request, trace.Truncated = t.tracer.redactBody(body, req.Header.Get("Content-Type")), truncated
+	}
+	resp, err := t.next.RoundTrip(req)
+	trace.Duration = t.clock.Now().Sub(trace.Time)
+	if err != nil {
+		trace.Error = t.tracer.redact(err.Error())
+		t.tracer.write(trace)
+		return resp, err
+	}
+	trace.Status, trace.Headers = resp.StatusCode, redactValues(resp.Header)
+	body, truncated, replay, readErr := readBody(resp.Body)
+	resp.Body = replay
+	trace.Response = t.tracer.redact(string(body))
+	trace.Truncated = trace.Truncated || truncated
+	if readErr != nil {
+		trace.Error = t.tracer.redact(readErr.Error())
+	}
+	t.tracer.write(trace)
+	return resp, nil
+}
+
+// client returns a copy of `base` whose requests are traced, and timed on `clock`, or `base` itself if tracing
+// is off.
+func (t *apiTracer) client(base *http.Client, clock Clock) *http.Client {
+	if t == nil {
+		return base
+	}
+	if base == nil {
+		base = &http.Client{Timeout: defaultClientTimeout}
+	}
+	client := *base
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &traceTransport{next: next, tracer: t, clock: clock}
+	return &client
+}
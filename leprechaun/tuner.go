@@ -0,0 +1,168 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Hyperparameters configure the training of a machine learning analyzer.
+type Hyperparameters struct {
+	HiddenUnits  int     // Width of the network's hidden layer.
+	LearningRate float64 // Step size of the solver.
+	Window       int     // Number of candles the model looks at for each prediction.
+}
+
+// ParameterGrid lists the values tried for each hyperparameter. Every combination is evaluated.
+type ParameterGrid struct {
+	HiddenUnits   []int
+	LearningRates []float64
+	Windows       []int
+}
+
+// combinations returns every combination of the values in the grid.
+func (grid ParameterGrid) combinations() (params []Hyperparameters) {
+	for _, units := range grid.HiddenUnits {
+		for _, rate := range grid.LearningRates {
+			for _, window := range grid.Windows {
+				params = append(params, Hyperparameters{HiddenUnits: units, LearningRate: rate, Window: window})
+			}
+		}
+	}
+	return
+}
+
+// Split is a pair of training and test data.
+type Split struct {
+	Train, Test []OHLC
+}
+
+// WalkForwardSplits divides time sorted candles into `folds`+1 blocks. The n-th split trains on the first
+// n blocks and tests on the block after them, so a model is never tested on data older than what it was trained on.
+// `folds` must be at least 1.
+func WalkForwardSplits(candles []OHLC, folds int) (splits []Split, err error) {
+	if folds < 1 {
+		return nil, fmt.Errorf("walk-forward validation needs at least 1 fold, not %d", folds)
+	}
+	size := len(candles) / (folds + 1)
+	if size == 0 {
+		return nil, nil
+	}
+	for n := 1; n <= folds; n++ {
+		splits = append(splits, Split{Train: candles[:n*size], Test: candles[n*size : (n+1)*size]})
+	}
+	return splits, nil
+}
+
+// KFoldSplits divides candles into `k` blocks. Each split tests on one block and trains on the rest.
+// `k` must be at least 2.
+func KFoldSplits(candles []OHLC, k int) (splits []Split, err error) {
+	if k < 2 {
+		return nil, fmt.Errorf("k-fold cross-validation needs at least 2 folds, not %d", k)
+	}
+	size := len(candles) / k
+	if size == 0 {
+		return nil, nil
+	}
+	for n := 0; n < k; n++ {
+		test := candles[n*size : (n+1)*size]
+		train := append(append([]OHLC{}, candles[:n*size]...), candles[(n+1)*size:]...)
+		splits = append(splits, Split{Train: train, Test: test})
+	}
+	return splits, nil
+}
+
+// EvaluateFunc trains a model with `params` on `train` and returns its score on `test`. Higher scores are better.
+type EvaluateFunc func(ctx context.Context, params Hyperparameters, train, test []OHLC) (score float64, err error)
+
+// TuningResult is the outcome of a hyperparameter search.
+type TuningResult struct {
+	Params Hyperparameters
+	Score  float64 // Mean score over all splits.
+	Splits int
+}
+
+// Tuner searches for the hyperparameters that give a machine learning analyzer the best score.
+type Tuner struct {
+	Grid     ParameterGrid
+	Splits   func(candles []OHLC) ([]Split, error) // e.g. func(c []OHLC) ([]Split, error) { return WalkForwardSplits(c, 5) }
+	Evaluate EvaluateFunc
+	Progress ProgressReporter // Optional. Told after every evaluation how far the search has got.
+}
+
+// Search evaluates every combination of hyperparameters on every split of `candles` and returns the best one.
+func (t *Tuner) Search(ctx context.Context, candles []OHLC) (best TuningResult, err error) {
+	splits, err := t.Splits(candles)
+	if err != nil {
+		return best, err
+	}
+	if len(splits) == 0 {
+		return best, errors.New("not enough candles to split into training and test data")
+	}
+	best.Score = math.Inf(-1)
+	combinations := t.Grid.combinations()
+	progress := trackProgress(t.Progress, realClock{}, "Tuning hyperparameters", len(combinations)*len(splits))
+	defer progress.finish()
+	for _, params := range combinations {
+		total := 0.0
+		for i, split := range splits {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			progress.step(fmt.Sprintf("%+v, split %d of %d", params, i+1, len(splits)))
+			score, err := t.Evaluate(ctx, params, split.Train, split.Test)
+			if err != nil {
+				return best, fmt.Errorf("evaluating %+v: %w", params, err)
+			}
+			total += score
+			progress.done()
+		}
+		mean := total / float64(len(splits))
+		log.Printf("Hyperparameters %+v scored %.4f", params, mean)
+		if mean > best.Score {
+			best = TuningResult{Params: params, Score: mean, Splits: len(splits)}
+		}
+	}
+	return best, nil
+}
+
+// ModelDir returns the folder where machine learning models and their settings are stored.
+func (c *Configuration) ModelDir() string {
+	return filepath.Join(c.DataDir, "models")
+}
+
+// hyperparametersFile returns the path the hyperparameters of the model named `model` are stored at.
+func hyperparametersFile(dir, model string) string {
+	return filepath.Join(dir, model+".params.json")
+}
+
+// SaveHyperparameters stores the result of a search next to the weights of the model named `model` in `dir`.
+func SaveHyperparameters(dir, model string, result TuningResult) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(hyperparametersFile(dir, model), data, 0644)
+}
+
+// LoadHyperparameters returns the result of the last search saved for the model named `model` in `dir`.
+func LoadHyperparameters(dir, model string) (result TuningResult, err error) {
+	data, err := os.ReadFile(hyperparametersFile(dir, model))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &result)
+	return
+}
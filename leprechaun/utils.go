@@ -6,15 +6,13 @@ package leprechaun
 
 import (
 	"fmt"
-	"math"
 	"os"
+	"strconv"
 	"time"
 )
 
-var (
-	stringToIntDict = map[rune]int64{'0': 0, '1': 1, '2': 2, '3': 3, '4': 4, '5': 5, '6': 6,
-		'7': 7, '8': 8, '9': 9}
-)
+// timeFormat is the layout of every timestamp stored in the ledger.
+const timeFormat = time.RFC3339
 
 // Channels for communicating with the UI.
 type Channels struct {
@@ -28,9 +26,25 @@ type Channels struct {
 	// Error is for sending bot-side errors to the UI.
 	ErrorChan chan error
 	// PurchaseChan channel notifies the UI that a purchase has been made so it can update its displayed records
-	PurchaseChan chan struct{}
+	PurchaseChan chan TradeEvent
 	// SaleChan channel notifies the UI that a sale has been made so it can update its displayed records.
-	SaleChan chan struct{}
+	SaleChan chan TradeEvent
+}
+
+// TradeEvent describes a trade recorded in the ledger, so the UI can update the affected records
+// without querying everything again.
+type TradeEvent struct {
+	Type        Order
+	Asset       string
+	Entry       Entry   // The ledger record as it is after the trade.
+	Price       float64 // Price the asset was bought or sold at.
+	ProfitDelta float64 // Profit realized by the trade. Zero for trades that open a position.
+	Time        time.Time
+}
+
+// IsPurchase returns true if the asset was bought in the trade, i.e. a long position was opened or a short one closed.
+func (e TradeEvent) IsPurchase() bool {
+	return e.Type == OpenLongTrade || e.Type == CloseShortTrade
 }
 
 // Log sets the log channel
@@ -54,7 +68,7 @@ func (c *Channels) Error(channel chan error) {
 }
 
 // Purchase sets the channel through which the bot alerts the UI to new Purchase events.
-func (c *Channels) Purchase(channel chan struct{}) {
+func (c *Channels) Purchase(channel chan TradeEvent) {
 	c.PurchaseChan = channel
 }
 func spinner(delay time.Duration) {
@@ -67,7 +81,7 @@ func spinner(delay time.Duration) {
 }
 
 // Sale sets the channel through which the bot alerts the UI to new Sale events.
-func (c *Channels) Sale(channel chan struct{}) {
+func (c *Channels) Sale(channel chan TradeEvent) {
 	c.SaleChan = channel
 }
 
@@ -79,28 +93,45 @@ func exists(path string) bool {
 	return true
 }
 
-// sleep delays the bot between each request in order to avoid exceeding the rate limit.
-func sleep() {
-	time.Sleep(600 * time.Millisecond)
-}
-
-// sleep2 delays the bot for slightly longer than sleep b/c sometimes sleep still triggers Error 429.
-func sleep2() {
-	time.Sleep(700 * time.Millisecond)
-}
+// AccountID identifies a wallet on the exchange. IDs are kept as the strings the exchange returns them as;
+// they are only converted to numbers for the API requests that need them, see Int64.
+type AccountID string
 
-// stringToInt converts a string of numbers to its numerical value
-// without loss of precision or conversion errors up until math.MaxInt64
-func stringToInt(s string) (num int64) {
-	for i, v := range s {
-		n := stringToIntDict[v]
-		x := len(s) - i
-		c := math.Pow(1e1, float64(x-1))
-		num += int64(n) * int64(c)
+// Int64 parses the ID for API requests that take numeric account IDs. An empty ID is zero, which tells the
+// exchange to use the default account.
+func (id AccountID) Int64() (int64, error) {
+	if id == "" {
+		return 0, nil
 	}
-	return
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid account ID %q: %w", string(id), err)
+	}
+	return n, nil
 }
 
+// toMidnight returns the start of the day `t0` falls on, in the time zone of `t0`.
+// Pass a time from the portfolio's Clock so days follow the user's reporting time zone.
 func toMidnight(t0 time.Time) time.Time {
 	return time.Date(t0.Year(), t0.Month(), t0.Day(), 0, 0, 0, 0, t0.Location())
 }
+
+// formatTimestamp renders `t` as an RFC3339 timestamp in UTC, so that stored timestamps sort correctly.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// parseTimestamp parses a timestamp written by formatTimestamp. For older records it also
+// accepts the default time.Time.String() layout and unix timestamps in milliseconds.
+func parseTimestamp(ts string) (t time.Time, err error) {
+	layouts := []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999 -0700 MST"}
+	for _, layout := range layouts {
+		if t, err = time.Parse(layout, ts); err == nil {
+			return t, nil
+		}
+	}
+	if ms, e := strconv.ParseInt(ts, 10, 64); e == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+}
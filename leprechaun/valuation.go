@@ -0,0 +1,127 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// topPositions is the number of open positions listed in the daily summary.
+const topPositions = 3
+
+// PositionValue is an open position marked to market.
+type PositionValue struct {
+	Entry      Entry
+	Price      float64 // the price the position would be closed at
+	Unrealized float64 // the profit (or loss, if negative) of closing the position at Price, in CurrencyCode
+}
+
+// Valuation is the value of the open positions at the last prices fetched from the exchange.
+type Valuation struct {
+	Time       time.Time
+	Unrealized float64
+	// Positions are sorted by the size of their unrealized gain or loss, largest first.
+	Positions []PositionValue
+}
+
+// Value marks the open positions of every asset to market. Long positions are valued at the bid, short
+// positions at the ask, of the last prices fetched. Assets whose price, or that of the currency they are
+// traded against, hasn't been fetched yet are left out.
+func (pf *Portfolio) Value() (v Valuation, err error) {
+	// The market data is copied so that the lock isn't held while the ledger is queried.
+	type quote struct {
+		market AssetSnapshot
+		rate   float64
+	}
+	quotes := map[string]quote{}
+	pf.mu.RLock()
+	v.Time = pf.clock.Now()
+	for asset := range pf.assets {
+		market := pf.market[asset]
+		rate, err := pf.reportingRateLocked(asset)
+		if market.Price <= 0 || err != nil {
+			continue
+		}
+		quotes[asset] = quote{market, rate}
+	}
+	pf.mu.RUnlock()
+
+	for asset, quote := range quotes {
+		market, rate := quote.market, quote.rate
+		positions, err := pf.ledger.OpenPositions(pf.ctx, asset)
+		if err != nil {
+			return v, err
+		}
+		for _, position := range positions {
+			price := market.Price
+			if position.Type == OpenLongTrade {
+				price -= market.Spread
+			}
+			value := PositionValue{Entry: position, Price: price, Unrealized: position.ProfitAt(price) * rate}
+			v.Unrealized += value.Unrealized
+			v.Positions = append(v.Positions, value)
+		}
+	}
+	sort.Slice(v.Positions, func(i, j int) bool {
+		return math.Abs(v.Positions[i].Unrealized) > math.Abs(v.Positions[j].Unrealized)
+	})
+	return v, nil
+}
+
+// dailySummary notifies the user of the day's realized profit and the unrealized profit of open positions
+// at the end of each day, then starts the counters of the next day.
+func (s *Session) dailySummary() {
+	for {
+		now := s.portfolio.clock.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(midnight.Sub(now)):
+		}
+		// The summary is sent after midnight, so it is dated with the day that just ended.
+		if err := s.sendDailySummary(midnight.Add(-time.Nanosecond)); err != nil {
+			s.errs.report("", "send daily summary", err)
+		}
+		s.mu.Lock()
+		s.sold, s.purchased, s.profit = 0, 0, 0
+		s.mu.Unlock()
+		s.errs.report("", "save session stats", s.saveStats())
+	}
+}
+
+// sendDailySummary sends the summary of `day` so far.
+func (s *Session) sendDailySummary(day time.Time) error {
+	valuation, err := s.portfolio.Value()
+	if err != nil {
+		return err
+	}
+	money := func(amount float64) string { return s.messages.FormatMoney(amount, s.config.CurrencyCode) }
+	s.mu.RLock()
+	realized := s.profit
+	s.mu.RUnlock()
+
+	var msg strings.Builder
+	fmt.Fprintln(&msg, s.messages.Sprintf(MsgRealizedProfit, money(realized)))
+	fmt.Fprintln(&msg, s.messages.Sprintf(MsgUnrealizedProfit, money(valuation.Unrealized), len(valuation.Positions)))
+	for i, position := range valuation.Positions {
+		if i == topPositions {
+			break
+		}
+		fmt.Fprintln(&msg, s.messages.Sprintf(MsgOpenPosition, position.Entry.Asset, orderTypeName(position.Entry.Type),
+			money(position.Unrealized)))
+	}
+	subject := s.messages.Sprintf(MsgDailySummarySubject, day.Format("2006-01-02"))
+	if err = s.notifier.Notify(subject, msg.String()); err != nil {
+		log.Print(msg.String())
+		return err
+	}
+	return nil
+}
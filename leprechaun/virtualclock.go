@@ -0,0 +1,121 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// VirtualClock is a Clock whose time only moves when it is advanced, for tests. Waits on it take no real
+// time, so the whole trading pipeline (rate limits, trade intervals and restarts included) can be run
+// through hours of trading in milliseconds. See Session.SetClock.
+type VirtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*virtualTimer // ordered by due time
+	seq    uint64
+}
+
+// virtualTimer is a wait scheduled on a VirtualClock.
+type virtualTimer struct {
+	clock *VirtualClock
+	due   time.Time
+	seq   uint64 // timers due at the same time fire in the order they were scheduled
+	fire  func(now time.Time)
+}
+
+// NewVirtualClock returns a clock that starts at `start`.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the clock's current time.
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel the time is sent on once the clock has been advanced by `d`.
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.schedule(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+// AfterFunc calls f in its own goroutine once the clock has been advanced by `d`.
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return c.schedule(d, func(time.Time) { go f() })
+}
+
+func (c *VirtualClock) schedule(d time.Duration, fire func(now time.Time)) *virtualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &virtualTimer{clock: c, due: c.now.Add(d), seq: c.seq, fire: fire}
+	if d <= 0 {
+		fire(c.now)
+		return t
+	}
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].due.After(t.due) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	return t
+}
+
+// Stop cancels the timer.
+func (t *virtualTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by `d`, firing the timers that fall due on the way in order.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].due.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.due
+		t.fire(c.now)
+	}
+	c.now = end
+}
+
+// AdvanceToNext moves the clock forward to the next timer and fires it, as if the waiting code had slept.
+// It returns false if nothing is waiting.
+func (c *VirtualClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return false
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	if t.due.After(c.now) {
+		c.now = t.due
+	}
+	t.fire(c.now)
+	return true
+}
+
+// Waiting returns the number of timers that haven't fired yet.
+func (c *VirtualClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
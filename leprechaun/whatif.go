@@ -0,0 +1,132 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/luno/luno-go"
+)
+
+// ErrInsufficientLiquidity is returned when the order book is too thin to fill a hypothetical order.
+var ErrInsufficientLiquidity = errors.New("not enough orders in the order book to fill the order")
+
+// WhatIfReport describes how a hypothetical market order would execute right now.
+type WhatIfReport struct {
+	Pair      string
+	Side      string  // "buy" or "sell"
+	FillPrice float64 // Average price the order would fill at.
+	Volume    float64 // Volume of the asset bought or sold.
+	Cost      float64 // Amount of the counter currency spent or received, before fees.
+	FeeRate   float64 // Taker fee as a fraction of the order.
+	Fee       float64 // Fee in the counter currency.
+	// BreakEvenPrice is the price the position must be closed at to recover the fees of opening and closing it.
+	BreakEvenPrice float64
+	// TargetPrice is the price the position must be closed at to make the configured profit margin.
+	TargetPrice float64
+}
+
+// fillOrder walks order book `levels` (best first) and returns the volume an order would fill and its average price.
+// Buy orders are sized in the counter currency (e.g. NGN to spend), sell orders in the asset.
+func fillOrder(levels []luno.OrderBookEntry, amount float64, sizedInCounter bool) (price, volume float64, err error) {
+	remaining, cost := amount, 0.0
+	for _, level := range levels {
+		p, v := level.Price.Float64(), level.Volume.Float64()
+		if sizedInCounter && p*v > remaining {
+			v = remaining / p
+		} else if !sizedInCounter && v > remaining {
+			v = remaining
+		}
+		volume += v
+		cost += p * v
+		if sizedInCounter {
+			remaining -= p * v
+		} else {
+			remaining -= v
+		}
+		if remaining <= amount*1e-9 {
+			return cost / volume, volume, nil
+		}
+	}
+	return 0, 0, ErrInsufficientLiquidity
+}
+
+// WhatIf estimates how a market order for `amount` on `pair` would execute against the current order book.
+// Buy orders are sized in the counter currency and sell orders in the asset, as on the exchange.
+func WhatIf(ctx context.Context, client *luno.Client, pair, side string, amount, margin float64) (report WhatIfReport, err error) {
+	report.Pair, report.Side = strings.ToUpper(pair), strings.ToLower(side)
+	if amount <= 0 {
+		return report, errors.New("the amount must be greater than zero")
+	}
+	book, err := client.GetOrderBook(ctx, &luno.GetOrderBookRequest{Pair: report.Pair})
+	if err != nil {
+		return
+	}
+	fees, err := client.GetFeeInfo(ctx, &luno.GetFeeInfoRequest{Pair: report.Pair})
+	if err != nil {
+		return
+	}
+	if report.FeeRate, err = strconv.ParseFloat(fees.TakerFee, 64); err != nil {
+		return
+	}
+	// A round trip pays the fee twice: on opening and on closing the position.
+	keep := (1 - report.FeeRate) * (1 - report.FeeRate)
+	switch report.Side {
+	case "buy":
+		if report.FillPrice, report.Volume, err = fillOrder(book.Asks, amount, true); err != nil {
+			return
+		}
+		report.BreakEvenPrice = report.FillPrice / keep
+		report.TargetPrice = report.BreakEvenPrice * (1 + margin)
+	case "sell":
+		if report.FillPrice, report.Volume, err = fillOrder(book.Bids, amount, false); err != nil {
+			return
+		}
+		report.BreakEvenPrice = report.FillPrice * keep
+		report.TargetPrice = report.BreakEvenPrice / (1 + margin)
+	default:
+		return report, fmt.Errorf("unknown side %q, use buy or sell", side)
+	}
+	report.Cost = report.FillPrice * report.Volume
+	report.Fee = report.Cost * report.FeeRate
+	return report, nil
+}
+
+// RunWhatIf runs the `leprechaun what-if` command, e.g. `what-if --pair XBTNGN --side buy --amount 5000`,
+// and prints the report to `out`.
+func RunWhatIf(ctx context.Context, args []string, out io.Writer) (err error) {
+	flags := flag.NewFlagSet("what-if", flag.ContinueOnError)
+	flags.SetOutput(out)
+	pair := flags.String("pair", "XBTNGN", "Currency pair to trade, e.g. XBTNGN.")
+	side := flags.String("side", "buy", `"buy" or "sell".`)
+	amount := flags.Float64("amount", 0, "Amount to spend when buying (e.g. NGN) or volume of the asset to sell.")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	c := &Configuration{}
+	if err = c.TestConfig(""); err != nil {
+		return
+	}
+	client := newLunoClient(c.APIKeyID, c.APIKeySecret, nil)
+	report, err := WhatIf(ctx, client, *pair, *side, *amount, c.ProfitMargin)
+	if err != nil {
+		return
+	}
+	messages := NewLocalizer(c.Locale)
+	base, counter := report.Pair[:len(report.Pair)-3], report.Pair[len(report.Pair)-3:]
+	money := func(v float64) string { return messages.FormatMoney(v, counter) }
+	fmt.Fprintln(out, messages.Sprintf(MsgWhatIfFill, report.Side, messages.FormatAmount(report.Volume, base),
+		money(report.FillPrice), money(report.Cost)))
+	fmt.Fprintln(out, messages.Sprintf(MsgWhatIfFee, report.FeeRate*100, money(report.Fee)))
+	fmt.Fprintln(out, messages.Sprintf(MsgWhatIfBreakEven, money(report.BreakEvenPrice)))
+	fmt.Fprintln(out, messages.Sprintf(MsgWhatIfTarget, c.ProfitMargin*100, money(report.TargetPrice)))
+	return nil
+}
@@ -0,0 +1,300 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	luno "github.com/luno/luno-go"
+)
+
+// Table of the ledger that keeps the realized profits withdrawn by withdrawProfits.
+var withdrawalTableInit = "CREATE TABLE WITHDRAWALS (ID PRIMARY KEY, TIME, CURRENCY, AMOUNT, DESTINATION, REFERENCE)"
+
+const (
+	withdrawalSaveOp = "INSERT OR REPLACE INTO WITHDRAWALS VALUES(?, ?, ?, ?, ?, ?)"
+	withdrawalGetOp  = "SELECT ID, TIME, CURRENCY, AMOUNT, DESTINATION, REFERENCE FROM WITHDRAWALS"
+)
+
+// ErrWithdrawalUnsupported is returned when profits can't be withdrawn to the configured destination.
+var ErrWithdrawalUnsupported = errors.New("the exchange handler can't withdraw to this destination")
+
+// lunoWithdrawalTypes are the types of bank withdrawals luno makes in each currency.
+var lunoWithdrawalTypes = map[string]string{
+	"ZAR": "ZAR_EFT", "NAD": "NAD_EFT", "KES": "KES_MPESA", "MYR": "MYR_IBG", "IDR": "IDR_LLG",
+}
+
+// ProfitWithdrawer is implemented by exchange handlers that can take funds off the account they trade, see
+// Configuration.WithdrawalDestination.
+type ProfitWithdrawer interface {
+	// Withdraw sends `amount` of the currency the handler's asset is traded against to `destination`.
+	// `id` is unique to the withdrawal, so the exchange can refuse to make it twice. It returns the exchange's
+	// reference of the withdrawal.
+	Withdraw(ctx context.Context, amount float64, destination, id string) (reference string, err error)
+}
+
+// Withdrawal is a transfer of realized profits off the trading account.
+type Withdrawal struct {
+	ID          string
+	Time        time.Time
+	Currency    string
+	Amount      float64
+	Destination string
+	Reference   string // the exchange's. Empty until the exchange has confirmed the withdrawal.
+}
+
+// Pending returns true if the withdrawal was recorded but the exchange hasn't confirmed it yet.
+func (w Withdrawal) Pending() bool {
+	return w.Reference == ""
+}
+
+// Withdraw moves `amount` of the handler's currency to another account of the exchange ("account:<id>") or to a
+// bank account of the user ("beneficiary:<id>").
+func (handler *LunoExchangeHandler) Withdraw(ctx context.Context, amount float64, destination, id string) (reference string, err error) {
+	kind, target, _ := strings.Cut(destination, ":")
+	targetID, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrWithdrawalUnsupported, destination)
+	}
+	if err = handler.budget.Acquire(ctx, ClassReporting); err != nil {
+		return
+	}
+	switch kind {
+	case "account":
+		counter, err := handler.asset.fiatAccountID.Int64()
+		if err != nil {
+			return "", err
+		}
+		res, err := handler.client.Move(ctx, &luno.MoveRequest{Amount: decimal(amount), DebitAccountId: counter,
+			CreditAccountId: targetID, ClientMoveId: id})
+		if err != nil {
+			return "", err
+		}
+		return res.Id, nil
+	case "beneficiary":
+		withdrawalType, ok := lunoWithdrawalTypes[handler.asset.currency]
+		if !ok {
+			return "", fmt.Errorf("%w: luno doesn't pay %s out to bank accounts", ErrWithdrawalUnsupported,
+				handler.asset.currency)
+		}
+		res, err := handler.client.CreateWithdrawal(ctx, &luno.CreateWithdrawalRequest{Amount: decimal(amount),
+			Type: withdrawalType, BeneficiaryId: targetID, ExternalId: id})
+		if err != nil {
+			return "", err
+		}
+		return res.Id, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrWithdrawalUnsupported, destination)
+}
+
+// RecordWithdrawal stores a withdrawal of realized profits.
+func (l *Ledger2) RecordWithdrawal(ctx context.Context, w Withdrawal) (err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	_, err = l.db.ExecContext(ctx, withdrawalSaveOp, w.ID, formatTimestamp(w.Time), w.Currency, w.Amount,
+		w.Destination, w.Reference)
+	return
+}
+
+// Withdrawals returns every withdrawal of realized profits.
+func (l *Ledger2) Withdrawals(ctx context.Context) (withdrawals []Withdrawal, err error) {
+	if !l.isOpen {
+		l.loadDatabase()
+	}
+	rows, err := l.db.QueryContext(ctx, withdrawalGetOp)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var w Withdrawal
+		var timestamp string
+		if err = rows.Scan(&w.ID, &timestamp, &w.Currency, &w.Amount, &w.Destination, &w.Reference); err != nil {
+			return
+		}
+		if w.Time, err = parseTimestamp(timestamp); err != nil {
+			return
+		}
+		withdrawals = append(withdrawals, w)
+	}
+	return withdrawals, rows.Err()
+}
+
+// closedProfit returns the profit (in CurrencyCode) of every position closed, including the ones archived.
+func (pf *Portfolio) closedProfit() (profit float64, err error) {
+	pf.mu.Lock()
+	records, err := pf.ledger.AllRecords(pf.ctx)
+	if ledger, ok := pf.ledger.(*Ledger2); ok && err == nil {
+		if path := ledger.ArchivePath(); path != "" && exists(path) {
+			archive := GetLedger2(path)
+			var archived []Entry
+			archived, err = archive.AllRecords(pf.ctx)
+			archive.Close()
+			records = append(records, archived...)
+		}
+	}
+	pf.mu.Unlock()
+	if err != nil {
+		return
+	}
+	for _, rec := range records {
+		if rec.Status != int64(Closed) {
+			continue
+		}
+		rate, err := pf.reportingRate(rec.Asset)
+		if err != nil {
+			return 0, err
+		}
+		profit += rec.Profit * rate
+	}
+	return profit, nil
+}
+
+// withdrawable returns the realized profit (in CurrencyCode) that hasn't been withdrawn yet, counting pending
+// withdrawals as made, and the first pending withdrawal if there is one.
+func (pf *Portfolio) withdrawable() (amount float64, pending *Withdrawal, err error) {
+	profit, err := pf.closedProfit()
+	if err != nil {
+		return
+	}
+	pf.mu.Lock()
+	withdrawals, err := pf.ledger.Withdrawals(pf.ctx)
+	pf.mu.Unlock()
+	if err != nil {
+		return
+	}
+	for i, w := range withdrawals {
+		profit -= w.Amount
+		if w.Pending() && pending == nil {
+			pending = &withdrawals[i]
+		}
+	}
+	return profit, pending, nil
+}
+
+// withdrawer returns the handler that withdraws from the account holding CurrencyCode, and its asset.
+func (pf *Portfolio) withdrawer() (ProfitWithdrawer, string, error) {
+	for _, asset := range pf.assetOrder() {
+		if pf.currency(asset) != pf.config.CurrencyCode {
+			continue
+		}
+		if withdrawer, ok := pf.assets[asset].(ProfitWithdrawer); ok {
+			return withdrawer, asset, nil
+		}
+	}
+	return nil, "", fmt.Errorf("%w: no handler of an asset traded against %s can withdraw", ErrWithdrawalUnsupported,
+		pf.config.CurrencyCode)
+}
+
+// withdrawProfits withdraws the realized profits that haven't been withdrawn yet to WithdrawalDestination once
+// they reach WithdrawalThreshold, and notifies the user. In WithdrawalDryRun mode the user is only told what
+// would have been withdrawn.
+//
+// A withdrawal is recorded as pending before the exchange is asked to make it, and confirmed once it has. A
+// pending withdrawal, e.g. because the exchange couldn't be reached or the confirmation couldn't be recorded, is
+// retried with the same ID before any new one, so the exchange refuses it if it was made already.
+func (pf *Portfolio) withdrawProfits() error {
+	if pf.watchOnly {
+		return ErrWatchOnly
+	}
+	amount, pending, err := pf.withdrawable()
+	if err != nil {
+		return err
+	}
+	amount = RoundAmount(amount, pf.config.CurrencyCode)
+	if pending != nil && !pf.config.WithdrawalDryRun {
+		withdrawer, _, err := pf.withdrawer()
+		if err != nil {
+			return err
+		}
+		return pf.withdraw(withdrawer, *pending)
+	}
+	if amount < pf.config.WithdrawalThreshold || amount <= 0 {
+		return nil
+	}
+	withdrawer, asset, err := pf.withdrawer()
+	if err != nil {
+		return err
+	}
+	// With Compound set, some of the profit may be tied up in open positions.
+	fiat, err := pf.assets[asset].FiatBalance()
+	if err != nil {
+		return err
+	}
+	amount = RoundAmount(math.Min(amount, fiat), pf.config.CurrencyCode)
+	if amount < pf.config.WithdrawalThreshold || amount <= 0 {
+		log.Printf("Not withdrawing profits: only %s of the balance is available",
+			pf.messages.FormatMoney(fiat, pf.config.CurrencyCode))
+		return nil
+	}
+	destination := pf.config.WithdrawalDestination
+	if pf.config.WithdrawalDryRun {
+		money := pf.messages.FormatMoney(amount, pf.config.CurrencyCode)
+		msg := pf.messages.Sprintf(MsgProfitWithdrawalDryRun, money, destination)
+		log.Println(msg)
+		pf.events.record("withdrawal", "", msg)
+		if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgProfitWithdrawnSubject), msg); err != nil {
+			log.Printf("Could not send notification: %v", err)
+		}
+		return nil
+	}
+	now := pf.clock.Now()
+	w := Withdrawal{ID: fmt.Sprintf("leprechaun-%d", now.UnixNano()), Time: now, Currency: pf.config.CurrencyCode,
+		Amount: amount, Destination: destination}
+	pf.mu.Lock()
+	err = pf.ledger.RecordWithdrawal(pf.ctx, w)
+	pf.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return pf.withdraw(withdrawer, w)
+}
+
+// withdraw asks the exchange to make the pending withdrawal `w`, and records its reference.
+func (pf *Portfolio) withdraw(withdrawer ProfitWithdrawer, w Withdrawal) (err error) {
+	if w.Reference, err = withdrawer.Withdraw(pf.ctx, w.Amount, w.Destination, w.ID); err != nil {
+		return err
+	}
+	// The withdrawal isn't a trade of the ledger, so the balance it changes must be predicted here.
+	pf.balances.withdrawn(w.Currency, w.Amount)
+	pf.mu.Lock()
+	err = pf.ledger.RecordWithdrawal(pf.ctx, w)
+	pf.mu.Unlock()
+	money := pf.messages.FormatMoney(w.Amount, w.Currency)
+	msg := pf.messages.Sprintf(MsgProfitWithdrawn, money, w.Destination, w.Reference)
+	log.Println(msg)
+	pf.events.record("withdrawal", "", msg)
+	if err := pf.errs.notifier.Notify(pf.messages.Sprintf(MsgProfitWithdrawnSubject), msg); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+	return err
+}
+
+// monitorWithdrawals withdraws realized profits every WithdrawalInterval hours until the session ends.
+func (s *Session) monitorWithdrawals() {
+	if s.config.WithdrawalThreshold <= 0 || s.config.WithdrawalDestination == "" || s.config.WithdrawalInterval <= 0 {
+		return
+	}
+	interval := time.Duration(s.config.WithdrawalInterval) * time.Hour
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.portfolio.clock.After(interval):
+		}
+		if s.portfolio.maintenance.active() {
+			continue
+		}
+		s.errs.report("", "withdraw profits", s.portfolio.withdrawProfits())
+	}
+}
@@ -0,0 +1,84 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"context"
+	"testing"
+)
+
+// fakeWithdrawer is an exchange handler that records the withdrawals asked of it. Only FiatBalance and Withdraw
+// are implemented.
+type fakeWithdrawer struct {
+	ExchangeHandler
+	fiat    float64
+	amounts []float64
+}
+
+func (f *fakeWithdrawer) FiatBalance() (float64, error) { return f.fiat, nil }
+
+func (f *fakeWithdrawer) Withdraw(ctx context.Context, amount float64, destination, id string) (string, error) {
+	f.amounts = append(f.amounts, amount)
+	return "ref-" + id, nil
+}
+
+// newWithdrawalPortfolio returns a portfolio that withdraws its profits through a fakeWithdrawer trading BITCOIN.
+func newWithdrawalPortfolio(t *testing.T) (*Portfolio, *fakeWithdrawer) {
+	t.Helper()
+	pf := newTestPortfolio(t, &Configuration{PurchaseUnit: 1000, WithdrawalThreshold: 50,
+		WithdrawalDestination: "account:1"})
+	withdrawer := &fakeWithdrawer{fiat: 1e6}
+	pf.assets["BITCOIN"] = withdrawer
+	return pf, withdrawer
+}
+
+func TestWithdrawProfits(t *testing.T) {
+	pf, withdrawer := newWithdrawalPortfolio(t)
+	long := openTestPosition(t, pf, "long", OpenLongTrade, 100, 10)
+	pf.closeTrade(long, "BITCOIN", 120, "", 10, "long-exit", CloseLongTrade)
+
+	if err := pf.withdrawProfits(); err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawer.amounts) != 1 || withdrawer.amounts[0] != 200 {
+		t.Fatalf("withdrawn %v, want the 200 realized", withdrawer.amounts)
+	}
+	// What has been withdrawn isn't withdrawn again.
+	if err := pf.withdrawProfits(); err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawer.amounts) != 1 {
+		t.Errorf("withdrawn %v, want a single withdrawal", withdrawer.amounts)
+	}
+}
+
+func TestWithdrawProfitsSkipsLosses(t *testing.T) {
+	pf, withdrawer := newWithdrawalPortfolio(t)
+	long := openTestPosition(t, pf, "long", OpenLongTrade, 100, 10)
+	pf.closeTrade(long, "BITCOIN", 80, "", 10, "long-exit", CloseLongTrade)
+	short := openTestPosition(t, pf, "short", OpenShortTrade, 100, 10)
+	pf.closeTrade(short, "BITCOIN", 110, "", 10, "short-exit", CloseShortTrade)
+
+	if err := pf.withdrawProfits(); err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawer.amounts) != 0 {
+		t.Errorf("withdrawn %v after losing trades, want nothing", withdrawer.amounts)
+	}
+}
+
+func TestWithdrawProfitsLimitedByBalance(t *testing.T) {
+	pf, withdrawer := newWithdrawalPortfolio(t)
+	withdrawer.fiat = 30
+	long := openTestPosition(t, pf, "long", OpenLongTrade, 100, 10)
+	pf.closeTrade(long, "BITCOIN", 120, "", 10, "long-exit", CloseLongTrade)
+
+	if err := pf.withdrawProfits(); err != nil {
+		t.Fatal(err)
+	}
+	if len(withdrawer.amounts) != 0 {
+		t.Errorf("withdrawn %v with only 30 available, want nothing below the threshold", withdrawer.amounts)
+	}
+}
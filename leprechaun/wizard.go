@@ -0,0 +1,178 @@
+package leprechaun
+
+/* This file is part of Leprechaun.
+*  @author: Michael Lormann
+ */
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luno/luno-go"
+)
+
+// SupportedExchanges lists the exchanges Leprechaun can trade on.
+var SupportedExchanges = []string{"luno"}
+
+// credentialCheckTimeout bounds the test API call made to validate new credentials.
+const credentialCheckTimeout = 30 * time.Second
+
+// wizard asks the user questions on the command line.
+type wizard struct {
+	in       *bufio.Scanner
+	out      io.Writer
+	messages *Localizer
+}
+
+// ask prints a question and returns the answer, or `def` if the user just pressed enter.
+func (w *wizard) ask(question, def string) (answer string, err error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	if !w.in.Scan() {
+		if err = w.in.Err(); err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	answer = strings.TrimSpace(w.in.Text())
+	if answer == "" {
+		answer = def
+	}
+	return
+}
+
+// askFloat asks for a positive number until one is given.
+func (w *wizard) askFloat(question string, def float64) (float64, error) {
+	for {
+		answer, err := w.ask(question, strconv.FormatFloat(def, 'f', -1, 64))
+		if err != nil {
+			return 0, err
+		}
+		val, err := strconv.ParseFloat(answer, 64)
+		if err == nil && val > 0 {
+			return val, nil
+		}
+		fmt.Fprintln(w.out, w.messages.Sprintf(MsgWizardPositive))
+	}
+}
+
+// RunConfigWizard interactively creates Leprechaun's settings, as run by `leprechaun config init`.
+// It asks for the exchange, API keys, assets, purchase unit and profit margin, checks the API keys with a test
+// call to the exchange, stores the keys in the keystore and writes the settings file in `appDir`
+// (the platform's default if empty).
+func RunConfigWizard(ctx context.Context, in io.Reader, out io.Writer, appDir string) (err error) {
+	c := &Configuration{}
+	if err = c.DefaultSettings(appDir); err != nil {
+		return
+	}
+	c.Locale = *locale
+	w := &wizard{in: bufio.NewScanner(in), out: out, messages: NewLocalizer(c.Locale)}
+	fmt.Fprintln(out, w.messages.Sprintf(MsgWizardIntro, c.ConfigFile()))
+
+	for {
+		c.Exchange, err = w.ask(w.messages.Sprintf(MsgWizardExchange, strings.Join(SupportedExchanges, ", ")), c.Exchange)
+		if err != nil {
+			return
+		}
+		c.Exchange = strings.ToLower(c.Exchange)
+		if isSupportedExchange(c.Exchange) {
+			break
+		}
+		fmt.Fprintln(out, w.messages.Sprintf(MsgWizardUnsupported, c.Exchange))
+	}
+
+	var keyID, keySecret string
+	for {
+		if keyID, err = w.ask(w.messages.Sprintf(MsgWizardKeyID), ""); err != nil {
+			return
+		}
+		if keySecret, err = w.ask(w.messages.Sprintf(MsgWizardKeySecret), ""); err != nil {
+			return
+		}
+		fmt.Fprintln(out, w.messages.Sprintf(MsgWizardCheckingKey))
+		if err = checkCredentials(ctx, keyID, keySecret); err == nil {
+			break
+		}
+		fmt.Fprintln(out, w.messages.Sprintf(MsgWizardKeyRejected, err))
+	}
+
+	for {
+		var answer string
+		answer, err = w.ask(w.messages.Sprintf(MsgWizardAssets), strings.Join(c.AssetsToTrade, "+"))
+		if err != nil {
+			return
+		}
+		var assets []string
+		assets, err = parseAssets(answer, c.SupportedAssets)
+		if err == nil {
+			c.AssetsToTrade = assets
+			break
+		}
+		fmt.Fprintln(out, err)
+	}
+	if c.PurchaseUnit, err = w.askFloat(w.messages.Sprintf(MsgWizardPurchaseUnit, c.CurrencyCode), c.PurchaseUnit); err != nil {
+		return
+	}
+	margin, err := w.askFloat(w.messages.Sprintf(MsgWizardMargin), c.ProfitMargin*100)
+	if err != nil {
+		return
+	}
+	c.ProfitMargin = margin / 100
+
+	if err = c.SaveAPIKeys(keyID, keySecret); err != nil {
+		return fmt.Errorf("could not save the API key: %w", err)
+	}
+	if err = c.Save(); err != nil {
+		return
+	}
+	fmt.Fprintln(out, w.messages.Sprintf(MsgWizardDone))
+	return nil
+}
+
+func isSupportedExchange(name string) bool {
+	for _, exchange := range SupportedExchanges {
+		if name == exchange {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAssets splits a "+" separated list of asset codes and checks that each is supported.
+func parseAssets(list string, supported []string) (assets []string, err error) {
+	for _, code := range strings.Split(list, "+") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		found := false
+		for _, s := range supported {
+			found = found || s == code
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not supported. Choose from %s", code, strings.Join(supported, ", "))
+		}
+		assets = append(assets, code)
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("choose at least one asset")
+	}
+	return
+}
+
+// checkCredentials makes a harmless API call to check that the exchange accepts the API key.
+func checkCredentials(ctx context.Context, keyID, keySecret string) error {
+	ctx, cancel := context.WithTimeout(ctx, credentialCheckTimeout)
+	defer cancel()
+	client := newLunoClient(keyID, keySecret, nil)
+	_, err := client.GetBalances(ctx, &luno.GetBalancesRequest{})
+	return err
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
 	"unit2/leprechaun"
 
 	"github.com/pkg/errors"
@@ -16,7 +18,55 @@ func main() {
 	// ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(12*time.Second))
 	// defer cancel()
 	ctx := context.Background()
-	sess := leprechaun.NewSession(ctx)
+	flag.Parse()
+	if args := flag.Args(); len(args) >= 2 && args[0] == "config" && args[1] == "init" {
+		if err := leprechaun.RunConfigWizard(ctx, os.Stdin, os.Stdout, ""); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) >= 1 && args[0] == "report" {
+		if err := leprechaun.RunReport(ctx, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) >= 1 && args[0] == "soak" {
+		if err := leprechaun.RunSoak(ctx, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) >= 1 && args[0] == "liquidate" {
+		if err := leprechaun.RunLiquidate(ctx, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) >= 1 && args[0] == "dust" {
+		if err := leprechaun.RunDust(ctx, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) >= 1 && args[0] == "replay-decision" {
+		if err := leprechaun.RunReplayDecision(ctx, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if args := flag.Args(); len(args) >= 1 && args[0] == "what-if" {
+		if err := leprechaun.RunWhatIf(ctx, args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	config := &leprechaun.Configuration{}
+	if err := config.TestConfig(""); err != nil {
+		log.Fatal(err)
+	}
+	sess := leprechaun.NewSession(ctx, config)
+	sess.SetProgressReporter(leprechaun.ProgressWriter{Out: os.Stdout})
 	sess.Initialize()
 	sess.GetPrices()
 	sess.Start()